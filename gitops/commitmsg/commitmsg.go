@@ -8,8 +8,27 @@ import (
 const (
 	begin = "--- gitops targets begin ---"
 	end   = "--- gitops targets end ---"
+
+	promotionBegin = "--- gitops promotion begin ---"
+	promotionEnd   = "--- gitops promotion end ---"
+
+	// sourcePrefix and imagePrefix tag the lines of a
+	// promotion section.
+	sourcePrefix = "source: "
+	imagePrefix  = "image: "
 )
 
+// Promotion records where the content of a promotion
+// commit came from.
+type Promotion struct {
+	// Lineage lists the "branch@commit" sources of the
+	// promoted build, oldest first. The last entry is
+	// the branch promoted from.
+	Lineage []string
+	// Images lists the pinned image references.
+	Images []string
+}
+
 // ExtractTargets extracts the list of gitops targets from
 // a commit message delimited by begin/end markers.
 func ExtractTargets(msg string) []string {
@@ -58,3 +77,70 @@ func Generate(targets []string) string {
 
 	return sb.String()
 }
+
+// GeneratePromotion produces a commit message section
+// recording p between begin/end markers.
+func GeneratePromotion(p Promotion) string {
+	var sb strings.Builder
+
+	sb.WriteByte('\n')
+	sb.WriteString(promotionBegin)
+	sb.WriteByte('\n')
+
+	for _, src := range p.Lineage {
+		sb.WriteString(sourcePrefix + src + "\n")
+	}
+
+	for _, img := range p.Images {
+		sb.WriteString(imagePrefix + img + "\n")
+	}
+
+	sb.WriteString(promotionEnd)
+	sb.WriteByte('\n')
+
+	return sb.String()
+}
+
+// ExtractPromotion parses the promotion section of a
+// commit message. The boolean is false when the
+// message has no complete promotion section.
+func ExtractPromotion(msg string) (Promotion, bool) {
+	var (
+		p              Promotion
+		found          bool
+		betweenMarkers bool
+	)
+
+	for _, line := range strings.Split(msg, "\n") {
+		switch {
+		case line == promotionBegin:
+			betweenMarkers = true
+			found = true
+		case line == promotionEnd:
+			betweenMarkers = false
+		case !betweenMarkers:
+			continue
+		case strings.HasPrefix(line, sourcePrefix):
+			p.Lineage = append(
+				p.Lineage,
+				strings.TrimPrefix(line, sourcePrefix),
+			)
+		case strings.HasPrefix(line, imagePrefix):
+			p.Images = append(
+				p.Images,
+				strings.TrimPrefix(line, imagePrefix),
+			)
+		}
+	}
+
+	if betweenMarkers {
+		log.Print(
+			"unable to find promotion end marker " +
+				"in commit message",
+		)
+
+		return Promotion{}, false
+	}
+
+	return p, found
+}
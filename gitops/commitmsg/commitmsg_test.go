@@ -47,3 +47,30 @@ func TestExtractTargets_missing_end_marker(t *testing.T) {
 
 	assert.Empty(t, got)
 }
+
+func TestPromotion_roundtrip(t *testing.T) {
+	t.Parallel()
+
+	p := commitmsg.Promotion{
+		Lineage: []string{"deploy/dev@abc", "deploy/staging@def"},
+		Images:  []string{"reg.io/web@sha256:1234"},
+	}
+	msg := "promote" + commitmsg.Generate([]string{"//app:prod.gitops"}) +
+		commitmsg.GeneratePromotion(p)
+
+	got, ok := commitmsg.ExtractPromotion(msg)
+	require.True(t, ok)
+	assert.Equal(t, p, got)
+	assert.Equal(
+		t, []string{"//app:prod.gitops"}, commitmsg.ExtractTargets(msg),
+	)
+}
+
+func TestExtractPromotion_absent(t *testing.T) {
+	t.Parallel()
+
+	_, ok := commitmsg.ExtractPromotion(
+		commitmsg.Generate([]string{"//app:dev.gitops"}),
+	)
+	assert.False(t, ok)
+}
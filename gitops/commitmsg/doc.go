@@ -1,4 +1,6 @@
 // Package commitmsg generates and parses gitops target lists embedded in git
 // commit messages. Targets are encoded between marker lines so that the prer
-// package can detect which gitops deployments a commit carries.
+// package can detect which gitops deployments a commit carries. Promotion
+// commits additionally record the branches and commits the promoted build
+// came from.
 package commitmsg
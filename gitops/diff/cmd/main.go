@@ -0,0 +1,145 @@
+// Command gitops_diff prints the semantic, per-resource
+// diff of rendered manifests between two git refs or
+// two directories, in the format create_gitops_prs adds
+// to pull request bodies.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/diff"
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/internal/cmdflag"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// exitChanges is the exit status when changes are
+// found and --fail_on_changes is set.
+const exitChanges = 2
+
+func main() {
+	changed, err := run()
+	if err != nil {
+		slog.Error("fatal", "error", err)
+		os.Exit(1)
+	}
+
+	if changed {
+		os.Exit(exitChanges)
+	}
+}
+
+// run prints the diff and reports whether the process
+// should exit with exitChanges.
+func run() (bool, error) {
+	const errCtx = "running gitops_diff"
+
+	repoDir := flag.String(
+		"repo_dir", ".",
+		"Local clone of the gitops repository",
+	)
+	baseRef := flag.String(
+		"base", "origin/main",
+		"Base ref, usually the primary branch",
+	)
+	headRef := flag.String(
+		"head", "HEAD",
+		"Head ref, usually the deployment branch",
+	)
+	baseDir := flag.String(
+		"base_dir", "",
+		"Compare this directory instead of --base",
+	)
+	headDir := flag.String(
+		"head_dir", "",
+		"Compare this directory instead of --head",
+	)
+
+	var gitopsPaths cmdflag.Slice
+
+	flag.Var(
+		&gitopsPaths,
+		"gitops_path",
+		"Subdirectory holding manifests (repeatable)",
+	)
+
+	format := flag.String(
+		"format", "text",
+		"Output format: text, markdown or json",
+	)
+	maxBytes := flag.Int(
+		"max_bytes", 0,
+		"Truncate markdown output to this size "+
+			"(0 means no limit)",
+	)
+	failOnChanges := flag.Bool(
+		"fail_on_changes", false,
+		fmt.Sprintf(
+			"Exit with status %d when resources differ",
+			exitChanges,
+		),
+	)
+
+	flag.Parse()
+
+	repo := &git.Repo{Dir: *repoDir, RemoteName: "origin"}
+
+	base, err := load(repo, *baseRef, *baseDir, gitopsPaths)
+	if err != nil {
+		return false, fmt.Errorf("%s: base: %w", errCtx, err)
+	}
+
+	head, err := load(repo, *headRef, *headDir, gitopsPaths)
+	if err != nil {
+		return false, fmt.Errorf("%s: head: %w", errCtx, err)
+	}
+
+	res := diff.Compare(base, head)
+
+	switch *format {
+	case "text":
+		err = res.WriteText(os.Stdout)
+	case "markdown":
+		_, err = fmt.Fprint(os.Stdout, res.Markdown(*maxBytes))
+	case "json":
+		var data []byte
+
+		data, err = json.MarshalIndent(res, "", "  ")
+		if err == nil {
+			_, err = fmt.Fprintln(os.Stdout, string(data))
+		}
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return *failOnChanges && res.HasChanges(), nil
+}
+
+// load reads the manifests below gitopsPaths from dir
+// when set, or from ref in repo.
+func load(
+	repo *git.Repo,
+	ref string,
+	dir string,
+	gitopsPaths []string,
+) ([]manifest.Resource, error) {
+	if dir != "" {
+		return manifest.LoadDir(dir, gitopsPaths...)
+	}
+
+	files, err := repo.ReadFiles(ref, gitopsPaths...)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest.ParseFiles(files)
+}
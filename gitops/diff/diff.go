@@ -0,0 +1,350 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// Status classifies a resource of a comparison.
+type Status string
+
+const (
+	// Added means the resource exists only in head.
+	Added Status = "added"
+	// Removed means the resource exists only in base.
+	Removed Status = "removed"
+	// Modified means the resource differs.
+	Modified Status = "modified"
+	// Unchanged means the resource is identical.
+	Unchanged Status = "unchanged"
+)
+
+// FieldChange is a single differing field.
+type FieldChange struct {
+	// Path locates the field, e.g.
+	// "spec.template.spec.containers[0].image".
+	Path string `json:"path"`
+	// Old is the base value; nil when HasOld is false.
+	Old any `json:"old,omitempty"`
+	// New is the head value; nil when HasNew is false.
+	New any `json:"new,omitempty"`
+	// HasOld reports whether the field exists in base.
+	HasOld bool `json:"hasOld"`
+	// HasNew reports whether the field exists in head.
+	HasNew bool `json:"hasNew"`
+}
+
+// IsImage reports whether the field is a container
+// image reference.
+func (c FieldChange) IsImage() bool {
+	return strings.HasSuffix(c.Path, ".image") ||
+		c.Path == "image"
+}
+
+// ResourceDiff is the comparison of one resource.
+type ResourceDiff struct {
+	// Key identifies the resource.
+	Key manifest.Key `json:"key"`
+	// Status classifies the resource.
+	Status Status `json:"status"`
+	// Fields lists the changes of a modified
+	// resource, sorted by path.
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// Images returns the image reference changes of the
+// resource.
+func (d ResourceDiff) Images() []FieldChange {
+	var images []FieldChange
+
+	for _, f := range d.Fields {
+		if f.IsImage() {
+			images = append(images, f)
+		}
+	}
+
+	return images
+}
+
+// Result is the semantic comparison of two manifest
+// sets.
+type Result struct {
+	// Resources holds every resource of both sets,
+	// sorted by namespace, kind, name and apiVersion.
+	Resources []ResourceDiff `json:"resources"`
+}
+
+// Compare matches the resources of base and head by
+// apiVersion, kind, namespace and name, and compares
+// the matching pairs field by field. When a key occurs
+// more than once in a set, the last occurrence wins.
+func Compare(base, head []manifest.Resource) *Result {
+	baseByKey := index(base)
+	headByKey := index(head)
+
+	keys := make([]manifest.Key, 0, len(baseByKey)+len(headByKey))
+
+	for k := range baseByKey {
+		keys = append(keys, k)
+	}
+
+	for k := range headByKey {
+		if _, ok := baseByKey[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	sortKeys(keys)
+
+	res := &Result{Resources: make([]ResourceDiff, 0, len(keys))}
+
+	for _, k := range keys {
+		b, inBase := baseByKey[k]
+		h, inHead := headByKey[k]
+
+		rd := ResourceDiff{Key: k}
+
+		switch {
+		case !inBase:
+			rd.Status = Added
+		case !inHead:
+			rd.Status = Removed
+		default:
+			rd.Fields = Fields(b, h, false)
+			rd.Status = Unchanged
+
+			if len(rd.Fields) > 0 {
+				rd.Status = Modified
+			}
+		}
+
+		res.Resources = append(res.Resources, rd)
+	}
+
+	return res
+}
+
+// Count returns the number of resources with status.
+func (r *Result) Count(status Status) int {
+	n := 0
+
+	for _, rd := range r.Resources {
+		if rd.Status == status {
+			n++
+		}
+	}
+
+	return n
+}
+
+// HasChanges reports whether any resource was added,
+// removed or modified.
+func (r *Result) HasChanges() bool {
+	return r.Count(Unchanged) != len(r.Resources)
+}
+
+// Images returns every image reference change, paired
+// with its resource.
+func (r *Result) Images() []ImageChange {
+	var images []ImageChange
+
+	for _, rd := range r.Resources {
+		for _, f := range rd.Images() {
+			images = append(images, ImageChange{
+				Key:         rd.Key,
+				FieldChange: f,
+			})
+		}
+	}
+
+	return images
+}
+
+// ImageChange is an image reference change of a
+// resource.
+type ImageChange struct {
+	Key manifest.Key
+	FieldChange
+}
+
+// Fields compares base and head recursively and
+// returns the changed fields sorted by path. Lists are
+// compared element by element. When subset is true,
+// fields missing from base are ignored, which compares
+// a manifest (base) with a defaulted live object
+// (head).
+func Fields(base, head any, subset bool) []FieldChange {
+	return walk("", base, head, subset, nil)
+}
+
+// walk appends to out the changes below prefix.
+func walk(
+	prefix string,
+	base any,
+	head any,
+	subset bool,
+	out []FieldChange,
+) []FieldChange {
+	switch o := base.(type) {
+	case map[string]any:
+		n, ok := head.(map[string]any)
+		if !ok {
+			return append(out, changed(prefix, base, head))
+		}
+
+		keys := make([]string, 0, len(o)+len(n))
+		for k := range o {
+			keys = append(keys, k)
+		}
+
+		if !subset {
+			for k := range n {
+				if _, ok := o[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			p := JoinPath(prefix, k)
+
+			switch {
+			case !inNew:
+				out = append(out, FieldChange{
+					Path: p, Old: ov, HasOld: true,
+				})
+			case !inOld:
+				out = append(out, FieldChange{
+					Path: p, New: nv, HasNew: true,
+				})
+			default:
+				out = walk(p, ov, nv, subset, out)
+			}
+		}
+
+		return out
+
+	case []any:
+		n, ok := head.([]any)
+		if !ok || len(n) != len(o) {
+			return append(out, changed(prefix, base, head))
+		}
+
+		for i := range o {
+			out = walk(
+				prefix+"["+strconv.Itoa(i)+"]",
+				o[i], n[i], subset, out,
+			)
+		}
+
+		return out
+
+	default:
+		if !reflect.DeepEqual(base, head) {
+			return append(out, changed(prefix, base, head))
+		}
+
+		return out
+	}
+}
+
+// JoinPath appends field k to a dotted path, quoting
+// keys that contain dots or slashes such as annotation
+// names.
+func JoinPath(prefix string, k string) string {
+	if strings.ContainsAny(k, "./") {
+		return prefix + "[" + strconv.Quote(k) + "]"
+	}
+
+	if prefix == "" {
+		return k
+	}
+
+	return prefix + "." + k
+}
+
+// changed returns a change of the field at p present on
+// both sides.
+func changed(p string, base, head any) FieldChange {
+	if p == "" {
+		p = "."
+	}
+
+	return FieldChange{
+		Path:   p,
+		Old:    base,
+		New:    head,
+		HasOld: true,
+		HasNew: true,
+	}
+}
+
+// index maps resources by key.
+func index(resources []manifest.Resource) map[manifest.Key]map[string]any {
+	byKey := make(map[manifest.Key]map[string]any, len(resources))
+
+	for _, r := range resources {
+		byKey[r.Key] = r.Object
+	}
+
+	return byKey
+}
+
+// sortKeys orders keys by namespace, kind, name and
+// apiVersion.
+func sortKeys(keys []manifest.Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+
+		switch {
+		case a.Namespace != b.Namespace:
+			return a.Namespace < b.Namespace
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		default:
+			return a.APIVersion < b.APIVersion
+		}
+	})
+}
+
+// CompareRefs compares the manifests below gitopsPaths
+// at two refs of repo, e.g. the primary branch and a
+// deployment branch.
+func CompareRefs(
+	repo *git.Repo,
+	baseRef string,
+	headRef string,
+	gitopsPaths ...string,
+) (*Result, error) {
+	const errCtx = "comparing refs"
+
+	var sets [2][]manifest.Resource
+
+	for i, ref := range []string{baseRef, headRef} {
+		files, err := repo.ReadFiles(ref, gitopsPaths...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		sets[i], err = manifest.ParseFiles(files)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: %s: %w", errCtx, ref, err,
+			)
+		}
+	}
+
+	return Compare(sets[0], sets[1]), nil
+}
@@ -0,0 +1,167 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/diff"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+const base = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: app
+  annotations:
+    example.com/owner: team-a
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: web
+        image: reg.io/web@sha256:aaaa
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: app
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: old
+  namespace: app
+`
+
+const head = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: app
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: app
+  annotations:
+    example.com/owner: team-b
+spec:
+  replicas: 5
+  paused: true
+  template:
+    spec:
+      containers:
+      - name: web
+        image: reg.io/web@sha256:bbbb
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: new
+  namespace: app
+`
+
+func TestCompare_semanticDiff(t *testing.T) {
+	t.Parallel()
+
+	res := diff.Compare(parse(t, base), parse(t, head))
+
+	require.Len(t, res.Resources, 4)
+	assert.Equal(t, 1, res.Count(diff.Added))
+	assert.Equal(t, 1, res.Count(diff.Removed))
+	assert.Equal(t, 1, res.Count(diff.Modified))
+	assert.Equal(t, 1, res.Count(diff.Unchanged))
+	assert.True(t, res.HasChanges())
+
+	images := res.Images()
+	require.Len(t, images, 1)
+	assert.Equal(t, "Deployment app/web", images[0].Key.String())
+	assert.Equal(t, "reg.io/web@sha256:aaaa", images[0].Old)
+	assert.Equal(t, "reg.io/web@sha256:bbbb", images[0].New)
+
+	assert.Equal(t, `+ ConfigMap app/new
+- ConfigMap app/old
+~ Deployment app/web
+    ~ metadata.annotations["example.com/owner"]: team-a -> team-b
+    + spec.paused: true
+    ~ spec.replicas: 3 -> 5
+    ~ spec.template.spec.containers[0].image: reg.io/web@sha256:aaaa -> reg.io/web@sha256:bbbb  (image)
+= 1 unchanged resources
+`, res.Text())
+}
+
+func TestCompare_noChanges(t *testing.T) {
+	t.Parallel()
+
+	res := diff.Compare(parse(t, base), parse(t, base))
+
+	assert.False(t, res.HasChanges())
+	assert.Equal(t, "= 3 unchanged resources\n", res.Text())
+	assert.Equal(
+		t,
+		"### Manifest changes\n\n"+
+			"0 added, 0 removed, 0 modified, 3 unchanged, "+
+			"0 image changes\n",
+		res.Markdown(0),
+	)
+}
+
+func TestResult_Markdown(t *testing.T) {
+	t.Parallel()
+
+	res := diff.Compare(parse(t, base), parse(t, head))
+
+	md := res.Markdown(0)
+	assert.Contains(t, md, "1 added, 1 removed, 1 modified, "+
+		"1 unchanged, 1 image changes")
+	assert.Contains(
+		t, md,
+		"| `Deployment app/web` | `reg.io/web` | "+
+			"`sha256:aaaa` | `sha256:bbbb` |",
+	)
+	assert.Contains(t, md, "<details>")
+	assert.Contains(t, md, "~ spec.replicas: 3 -> 5\n")
+
+	short := res.Markdown(len(md) - 20)
+	assert.LessOrEqual(t, len(short), len(md)-20)
+	assert.Contains(t, short, "... truncated\n```")
+}
+
+func TestFields_subset(t *testing.T) {
+	t.Parallel()
+
+	want := map[string]any{"a": "1", "b": map[string]any{"c": "2"}}
+	got := map[string]any{
+		"a": "1",
+		"b": map[string]any{"c": "3", "d": "defaulted"},
+		"e": "defaulted",
+	}
+
+	assert.Equal(t, []diff.FieldChange{{
+		Path: "b.c", Old: "2", New: "3", HasOld: true, HasNew: true,
+	}}, diff.Fields(want, got, true))
+	assert.Len(t, diff.Fields(want, got, false), 3)
+}
+
+// parse parses a manifest stream.
+func parse(tb testing.TB, data string) []manifest.Resource {
+	tb.Helper()
+
+	res, err := manifest.Parse(strings.NewReader(data), "app.yaml")
+	require.NoError(tb, err)
+
+	return res
+}
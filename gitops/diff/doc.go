@@ -0,0 +1,8 @@
+// Package diff compares two sets of rendered manifests resource by resource.
+// Resources are matched by apiVersion, kind, namespace and name and compared
+// field by field, so reviewers see which fields of which resources changed
+// instead of line diffs of large YAML files. Unchanged resources are
+// collapsed into a count and image reference changes are listed separately.
+// The Markdown rendering is used by prer in pull request bodies and the
+// gitops_diff command prints the same output.
+package diff
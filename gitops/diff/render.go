@@ -0,0 +1,189 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// maxValueLen bounds the rendered length of a field
+// value; longer values are truncated.
+const maxValueLen = 120
+
+// Summary returns a one-line count of the resources by
+// status and of the changed images.
+func (r *Result) Summary() string {
+	return fmt.Sprintf(
+		"%d added, %d removed, %d modified, "+
+			"%d unchanged, %d image changes",
+		r.Count(Added), r.Count(Removed),
+		r.Count(Modified), r.Count(Unchanged),
+		len(r.Images()),
+	)
+}
+
+// Text renders the changed resources, one line per
+// resource followed by its field changes, then a
+// single line counting the unchanged resources that
+// were collapsed.
+func (r *Result) Text() string {
+	var sb strings.Builder
+
+	for _, rd := range r.Resources {
+		switch rd.Status {
+		case Added:
+			fmt.Fprintf(&sb, "+ %s\n", rd.Key)
+		case Removed:
+			fmt.Fprintf(&sb, "- %s\n", rd.Key)
+		case Modified:
+			fmt.Fprintf(&sb, "~ %s\n", rd.Key)
+
+			for _, f := range rd.Fields {
+				sb.WriteString("    ")
+				sb.WriteString(formatField(f))
+				sb.WriteString("\n")
+			}
+		case Unchanged:
+			continue
+		}
+	}
+
+	if n := r.Count(Unchanged); n > 0 {
+		fmt.Fprintf(&sb, "= %d unchanged resources\n", n)
+	}
+
+	return sb.String()
+}
+
+// WriteText writes Text followed by the Summary.
+func (r *Result) WriteText(w io.Writer) error {
+	const errCtx = "writing diff"
+
+	if _, err := io.WriteString(
+		w, r.Text()+r.Summary()+"\n",
+	); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// Markdown renders the comparison for a pull request
+// body: the summary, a table of image changes and the
+// resource changes in a collapsed block. When the
+// result would exceed limit bytes, the resource changes
+// are truncated; limit <= 0 means no limit.
+func (r *Result) Markdown(limit int) string {
+	var head strings.Builder
+
+	head.WriteString("### Manifest changes\n\n")
+	head.WriteString(r.Summary())
+	head.WriteString("\n")
+
+	if images := r.Images(); len(images) > 0 {
+		head.WriteString("\n**Image changes**\n\n")
+		head.WriteString("| Resource | Image | From | To |\n")
+		head.WriteString("|---|---|---|---|\n")
+
+		for _, img := range images {
+			oldRef, _ := img.Old.(string)
+			newRef, _ := img.New.(string)
+
+			fmt.Fprintf(
+				&head, "| `%s` | `%s` | `%s` | `%s` |\n",
+				img.Key, manifest.ImageName(newRef),
+				imageVersion(oldRef), imageVersion(newRef),
+			)
+		}
+	}
+
+	if !r.HasChanges() {
+		return head.String()
+	}
+
+	const (
+		openBlock  = "\n<details>\n<summary>Resource changes</summary>\n\n```diff\n"
+		closeBlock = "```\n\n</details>\n"
+		cut        = "... truncated\n"
+	)
+
+	body := r.Text()
+
+	if limit > 0 {
+		room := limit - head.Len() - len(openBlock) - len(closeBlock)
+		if len(body) > room {
+			room -= len(cut)
+			if room < 0 {
+				return head.String()
+			}
+
+			// Cut at a line boundary.
+			body = body[:room]
+			body = body[:strings.LastIndex(body, "\n")+1] + cut
+		}
+	}
+
+	return head.String() + openBlock + body + closeBlock
+}
+
+// formatField renders a field change as
+// "path: old -> new", "+ path: new" or "- path: old".
+func formatField(f FieldChange) string {
+	suffix := ""
+	if f.IsImage() {
+		suffix = "  (image)"
+	}
+
+	switch {
+	case !f.HasOld:
+		return "+ " + f.Path + ": " +
+			formatValue(f.New, true) + suffix
+	case !f.HasNew:
+		return "- " + f.Path + ": " +
+			formatValue(f.Old, true) + suffix
+	default:
+		return "~ " + f.Path + ": " +
+			formatValue(f.Old, true) + " -> " +
+			formatValue(f.New, true) + suffix
+	}
+}
+
+// formatValue renders a value compactly: strings
+// verbatim, other values as JSON, truncated to
+// maxValueLen.
+func formatValue(v any, present bool) string {
+	if !present {
+		return ""
+	}
+
+	s, ok := v.(string)
+	if !ok || s == "" || strings.ContainsAny(s, "\n\"") {
+		data, err := json.Marshal(v)
+		if err != nil {
+			data = []byte(fmt.Sprint(v))
+		}
+
+		s = string(data)
+	}
+
+	if len(s) > maxValueLen {
+		s = s[:maxValueLen] + "..."
+	}
+
+	return s
+}
+
+// imageVersion returns the tag or digest part of an
+// image reference.
+func imageVersion(ref string) string {
+	name := manifest.ImageName(ref)
+	if len(name) == len(ref) {
+		return ref
+	}
+
+	return ref[len(name)+1:]
+}
@@ -0,0 +1,293 @@
+// Command gitops_digest audits the digests of a gitops
+// repository: verify checks that every manifest still
+// matches its stored digest, list-mismatches prints the
+// files that do not, and update stores fresh digests.
+// verify and list-mismatches exit with status 2 on a
+// mismatch, for use as a CI check.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/digester"
+)
+
+// exitMismatch is the exit status when a digest does
+// not match.
+const exitMismatch = 2
+
+// Subcommands.
+const (
+	cmdVerify         = "verify"
+	cmdUpdate         = "update"
+	cmdListMismatches = "list-mismatches"
+)
+
+func main() {
+	mismatch, err := run()
+	if err != nil {
+		slog.Error("fatal", "error", err)
+		os.Exit(1)
+	}
+
+	if mismatch {
+		os.Exit(exitMismatch)
+	}
+}
+
+// report is the JSON output of every subcommand.
+type report struct {
+	Command string                  `json:"command"`
+	Root    string                  `json:"root"`
+	Results []digester.Result       `json:"results"`
+	Counts  map[digester.Status]int `json:"counts"`
+	Updated []string                `json:"updated,omitempty"`
+}
+
+// run executes the subcommand and reports whether
+// the process should exit with exitMismatch.
+//
+//nolint:funlen // CLI flag setup is inherently long
+func run() (bool, error) {
+	const errCtx = "running gitops_digest"
+
+	store := flag.String(
+		"store", "sidecar",
+		"Digest storage: sidecar (a .digest file per "+
+			"manifest) or index (one "+digester.IndexName+
+			" file per directory)",
+	)
+	algorithm := flag.String(
+		"algorithm", "",
+		"Algorithm of the digests written by update and of "+
+			"missing digests: sha256 or sha256-yaml "+
+			"(default: unprefixed sha256)",
+	)
+	failOnMissing := flag.Bool(
+		"fail_on_missing", false,
+		"Treat manifests without digest as mismatches",
+	)
+	all := flag.Bool(
+		"all", false,
+		"update: rewrite every digest, e.g. to change the "+
+			"algorithm or store, not only the stale ones",
+	)
+	output := flag.String(
+		"output", "text",
+		"Output format: text or json",
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] verify|update|list-mismatches [dir]\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+
+		return false, fmt.Errorf(
+			"%s: expected a subcommand and an optional "+
+				"directory", errCtx,
+		)
+	}
+
+	command, root := flag.Arg(0), "."
+	if flag.NArg() == 2 {
+		root = flag.Arg(1)
+	}
+
+	d, err := newDigester(*store, *algorithm)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	results, err := digester.Scan(root, d)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	rep := report{Command: command, Root: root, Results: results}
+
+	failing := func(r digester.Result) bool {
+		return r.Status == digester.StatusMismatch ||
+			(*failOnMissing && r.Status == digester.StatusMissing)
+	}
+
+	switch command {
+	case cmdVerify:
+	case cmdListMismatches:
+		rep.Results = nil
+
+		for _, r := range results {
+			if failing(r) {
+				rep.Results = append(rep.Results, r)
+			}
+		}
+	case cmdUpdate:
+		rep.Updated, err = update(root, d, results, *all)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", errCtx, err)
+		}
+	default:
+		return false, fmt.Errorf(
+			"%s: unknown subcommand %q (expected %s, %s or %s)",
+			errCtx, command, cmdVerify, cmdUpdate, cmdListMismatches,
+		)
+	}
+
+	rep.Counts = make(map[digester.Status]int)
+
+	mismatch := false
+
+	for _, r := range results {
+		rep.Counts[r.Status]++
+		mismatch = mismatch || failing(r)
+	}
+
+	if rep.Results == nil {
+		rep.Results = []digester.Result{}
+	}
+
+	switch *output {
+	case "text":
+		err = writeText(os.Stdout, rep)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rep)
+	default:
+		err = fmt.Errorf("unknown output %q", *output)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return command != cmdUpdate && mismatch, nil
+}
+
+// update stores the digests of the files of results
+// that are not up to date, or of all of them, and
+// returns their names. Stamped files keep the digest
+// of their rendered content, so that prer still skips
+// unchanged renderings. Orphan digests are left alone.
+func update(
+	root string,
+	d digester.Digester,
+	results []digester.Result,
+	all bool,
+) ([]string, error) {
+	var updated []string
+
+	for _, r := range results {
+		switch {
+		case r.Status == digester.StatusOrphan:
+			continue
+		case r.Status == digester.StatusOK && !all:
+			continue
+		}
+
+		fp := filepath.Join(root, filepath.FromSlash(r.Path))
+
+		save := d.Save
+		if r.Rendered != "" {
+			save = func(path string) error {
+				return d.SaveStamped(path, r.Rendered)
+			}
+		}
+
+		if err := save(fp); err != nil {
+			return updated, err
+		}
+
+		updated = append(updated, r.Path)
+	}
+
+	return updated, nil
+}
+
+// writeText prints the report for humans: the files
+// of list-mismatches one per line, so that the output
+// can be piped, and otherwise the files that are not
+// up to date and a summary.
+func writeText(w io.Writer, rep report) error {
+	var err error
+
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	switch rep.Command {
+	case cmdListMismatches:
+		for _, r := range rep.Results {
+			printf("%s\n", r.Path)
+		}
+	case cmdUpdate:
+		for _, name := range rep.Updated {
+			printf("updated %s\n", name)
+		}
+
+		printf("%d digests updated\n", len(rep.Updated))
+	default:
+		for _, r := range rep.Results {
+			if r.Status != digester.StatusOK {
+				printf("%-8s %s\n", r.Status, r.Path)
+			}
+		}
+
+		printf(
+			"%d files: %d ok, %d mismatch, %d missing, %d orphan\n",
+			len(rep.Results),
+			rep.Counts[digester.StatusOK],
+			rep.Counts[digester.StatusMismatch],
+			rep.Counts[digester.StatusMissing],
+			rep.Counts[digester.StatusOrphan],
+		)
+	}
+
+	return err
+}
+
+// newDigester creates the Digester of the store and
+// algorithm names. Pattern: Factory -- selects digest
+// storage at runtime.
+func newDigester(store string, algorithm string) (digester.Digester, error) {
+	var d digester.Digester
+
+	switch store {
+	case "sidecar":
+		d.Store = digester.SidecarStore{}
+	case "index":
+		d.Store = &digester.IndexStore{}
+	default:
+		return d, fmt.Errorf(
+			"creating digest store: unknown store %q "+
+				"(expected sidecar or index)",
+			store,
+		)
+	}
+
+	if algorithm != "" {
+		alg, err := digester.ParseAlgorithm(algorithm)
+		if err != nil {
+			return d, fmt.Errorf("--algorithm: %w", err)
+		}
+
+		d.Algorithm = alg
+	}
+
+	return d, nil
+}
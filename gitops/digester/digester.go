@@ -7,6 +7,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
 )
 
 // CalculateDigest computes the SHA256 hex digest of the file at
@@ -60,38 +65,234 @@ func GetDigest(path string) (string, error) {
 }
 
 // VerifyDigest compares the calculated digest of the file
-// against its stored sidecar digest.
+// against its stored sidecar digest, using the algorithm
+// the stored digest names (SHA256 when unprefixed). For
+// a stamped manifest the stored digest is that of the
+// rendered file before stamping, so the stamped file
+// does not match.
 func VerifyDigest(path string) (bool, error) {
-	const errCtx = "verifying digest"
+	return Digester{}.Verify(path)
+}
 
-	calc, err := CalculateDigest(path)
-	if err != nil {
-		return false, fmt.Errorf("%s: %w", errCtx, err)
+// SaveDigest calculates the digest of a file and writes it
+// to a .digest sidecar file.
+func SaveDigest(path string) error {
+	return Digester{}.Save(path)
+}
+
+// Algorithm is a digest algorithm. Digests carry its
+// name as prefix, e.g. "sha256-yaml:<hex>".
+type Algorithm string
+
+const (
+	// SHA256 hashes the bytes of the file.
+	SHA256 Algorithm = "sha256"
+
+	// SHA256YAML hashes the canonical form of the YAML
+	// documents of the file: decoded values with
+	// sorted keys, without comments, formatting nor
+	// empty documents. Files that do not parse as YAML
+	// are hashed as is.
+	SHA256YAML Algorithm = "sha256-yaml"
+)
+
+// ParseAlgorithm returns the algorithm named name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch a := Algorithm(name); a {
+	case SHA256, SHA256YAML:
+		return a, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown digest algorithm %q (expected %s or %s)",
+			name, SHA256, SHA256YAML,
+		)
 	}
+}
+
+// Sum returns the digest of data as "algorithm:hex".
+func (a Algorithm) Sum(data []byte) (string, error) {
+	ha := sha256.New()
 
-	stored, err := GetDigest(path)
+	switch a {
+	case SHA256:
+		ha.Write(data)
+	case SHA256YAML:
+		docs, err := manifest.Documents(data)
+		if err != nil {
+			ha.Write(data)
+
+			break
+		}
+
+		for _, doc := range docs {
+			canonical, err := json.Marshal(doc)
+			if err != nil {
+				return "", fmt.Errorf("canonicalising YAML: %w", err)
+			}
+
+			ha.Write(canonical)
+			ha.Write([]byte("\n"))
+		}
+	default:
+		return "", fmt.Errorf("unknown digest algorithm %q", a)
+	}
+
+	return string(a) + ":" + hex.EncodeToString(ha.Sum(nil)), nil
+}
+
+// ParseDigest splits a stored digest into its
+// algorithm and hex value. Digests without prefix, as
+// written by SaveDigest, are SHA256.
+func ParseDigest(digest string) (Algorithm, string) {
+	if a, value, ok := strings.Cut(digest, ":"); ok {
+		return Algorithm(a), value
+	}
+
+	return SHA256, digest
+}
+
+// Digester calculates, saves and verifies digests
+// with an Algorithm in a Store. Its digests carry the
+// algorithm prefix, except with an empty Algorithm,
+// which keeps the unprefixed SHA256 digests of the
+// package functions. A nil Store means SidecarStore.
+type Digester struct {
+	Algorithm Algorithm
+	Store     Store
+}
+
+// store returns the Store of d.
+func (d Digester) store() Store {
+	if d.Store == nil {
+		return SidecarStore{}
+	}
+
+	return d.Store
+}
+
+// Calculate returns the digest of the file at path,
+// or an empty string with no error if it does not
+// exist.
+func (d Digester) Calculate(path string) (string, error) {
+	if d.Algorithm == "" {
+		return CalculateDigest(path)
+	}
+
+	return calculate(path, d.Algorithm)
+}
+
+// Save calculates the digest of the file at path and
+// stores it. Stamped manifests are digested before
+// stamping: the stored digest describes the rendered
+// file, not the stamps.
+func (d Digester) Save(path string) error {
+	const errCtx = "saving digest"
+
+	digest, err := d.Calculate(path)
 	if err != nil {
-		return false, fmt.Errorf("%s: %w", errCtx, err)
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := d.store().Set(path, digest); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
-	return calc == stored, nil
+	return nil
 }
 
-// SaveDigest calculates the digest of a file and writes it
-// to a .digest sidecar file.
-func SaveDigest(path string) error {
+// SaveStamped stores the digest rendered of the
+// content of the file at path before stamping,
+// followed by that of the stamped file when it
+// differs, separated by a space. Verify compares a
+// fresh rendering with the former, and Scan the file
+// with the latter.
+func (d Digester) SaveStamped(path string, rendered string) error {
 	const errCtx = "saving digest"
 
-	digest, err := CalculateDigest(path)
+	stamped, err := d.Calculate(path)
 	if err != nil {
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
-	dp := path + ".digest"
+	digest := rendered
+	if stamped != rendered {
+		digest += " " + stamped
+	}
 
-	if err := os.WriteFile(dp, []byte(digest), 0o600); err != nil {
+	if err := d.store().Set(path, digest); err != nil {
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
 	return nil
 }
+
+// SplitStamped splits a stored digest into the digest
+// of the rendered content and that of the stamped
+// file, empty when the digest was not saved by
+// SaveStamped or stamping changed nothing.
+func SplitStamped(stored string) (rendered, stamped string) {
+	rendered, stamped, _ = strings.Cut(strings.TrimSpace(stored), " ")
+
+	return rendered, stamped
+}
+
+// Verify reports whether the file at path matches its
+// stored digest. The digest is recalculated with the
+// algorithm of the stored one, so digests of any
+// algorithm, including unprefixed ones, are
+// understood. Stamped manifests verify before
+// stamping only, see SaveStamped.
+func (d Digester) Verify(path string) (bool, error) {
+	const errCtx = "verifying digest"
+
+	stored, err := d.store().Get(path)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return Match(path, stored)
+}
+
+// Match reports whether the file at path has the
+// digest stored, calculated with the algorithm stored
+// names. For a digest saved by SaveStamped the digest
+// of the rendered content is compared, so a stamped
+// file does not match. A missing file matches an empty
+// digest.
+func Match(path string, stored string) (bool, error) {
+	const errCtx = "matching digest"
+
+	rendered, _ := SplitStamped(stored)
+	alg, want := ParseDigest(rendered)
+
+	calc, err := calculate(path, alg)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	_, got := ParseDigest(calc)
+
+	return got == want, nil
+}
+
+// calculate returns the digest of the file at path
+// with alg, or an empty string if it does not exist.
+func calculate(path string, alg Algorithm) (string, error) {
+	const errCtx = "calculating digest"
+
+	data, err := os.ReadFile(path) //nolint:gosec // path is caller-provided by design
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	digest, err := alg.Sum(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return digest, nil
+}
@@ -104,3 +104,148 @@ func FuzzCalculateDigest(f *testing.F) {
 		assert.Len(t, dg, 64) // sha256 hex is always 64 chars
 	})
 }
+
+func TestDigester_yamlIgnoresFormatting(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	pa := filepath.Join(dir, "app.yaml")
+	require.NoError(t, os.WriteFile(pa, []byte(
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n"+
+			"data:\n  x: \"1\"\n  y: \"2\"\n",
+	), 0o600))
+
+	d := digester.Digester{Algorithm: digester.SHA256YAML}
+	require.NoError(t, d.Save(pa))
+
+	stored, err := digester.GetDigest(pa)
+	require.NoError(t, err)
+	assert.Regexp(t, "^sha256-yaml:[0-9a-f]{64}$", stored)
+
+	// Key order, quoting, comments, indentation and
+	// empty documents do not change the digest.
+	require.NoError(t, os.WriteFile(pa, []byte(
+		"---\n# rendered\nkind: ConfigMap\napiVersion: v1\n"+
+			"data: {y: '2', x: '1'}\nmetadata:\n    name: a\n---\n",
+	), 0o600))
+
+	ok, err := d.Verify(pa)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, os.WriteFile(pa, []byte(
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n"+
+			"data:\n  x: \"1\"\n  y: \"3\"\n",
+	), 0o600))
+
+	ok, err = d.Verify(pa)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestDigester_understandsEveryFormat(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	pa := filepath.Join(dir, "app.yaml")
+	require.NoError(t, os.WriteFile(pa, []byte("a: 1\n"), 0o600))
+
+	// An unprefixed digest from SaveDigest verifies
+	// with a normalising digester.
+	require.NoError(t, digester.SaveDigest(pa))
+
+	stored, err := digester.GetDigest(pa)
+	require.NoError(t, err)
+	assert.Len(t, stored, 64)
+
+	d := digester.Digester{Algorithm: digester.SHA256YAML}
+
+	ok, err := d.Verify(pa)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// A prefixed sha256 digest verifies with the
+	// package function.
+	require.NoError(t, digester.Digester{Algorithm: digester.SHA256}.Save(pa))
+
+	stored, err = digester.GetDigest(pa)
+	require.NoError(t, err)
+	plain, err := digester.CalculateDigest(pa)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+plain, stored)
+
+	ok, err = digester.VerifyDigest(pa)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Non-YAML content is hashed as is.
+	require.NoError(t, os.WriteFile(pa, []byte("a: [\n"), 0o600))
+	require.NoError(t, d.Save(pa))
+
+	ok, err = d.Verify(pa)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, os.WriteFile(pa+".digest", []byte("md5:00"), 0o600))
+
+	_, err = d.Verify(pa)
+	require.ErrorContains(t, err, `unknown digest algorithm "md5"`)
+}
+
+func TestDigester_SaveStamped(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	pa := filepath.Join(dir, "app.yaml")
+	require.NoError(t, os.WriteFile(pa, []byte("commit: '{{C}}'\n"), 0o600))
+
+	d := digester.Digester{Algorithm: digester.SHA256YAML}
+
+	rendered, err := d.Calculate(pa)
+	require.NoError(t, err)
+
+	// Unchanged by stamping: a single digest.
+	require.NoError(t, d.SaveStamped(pa, rendered))
+
+	stored, err := digester.GetDigest(pa)
+	require.NoError(t, err)
+	assert.Equal(t, rendered, stored)
+
+	require.NoError(t, os.WriteFile(pa, []byte("commit: 'abc'\n"), 0o600))
+	require.NoError(t, d.SaveStamped(pa, rendered))
+
+	stored, err = digester.GetDigest(pa)
+	require.NoError(t, err)
+
+	r, stamped := digester.SplitStamped(stored)
+	assert.Equal(t, rendered, r)
+	assert.Regexp(t, "^sha256-yaml:[0-9a-f]{64}$", stamped)
+	assert.NotEqual(t, rendered, stamped)
+
+	// Verify compares with the rendered content, so
+	// the stamped file does not match.
+	ok, err := d.Verify(pa)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = digester.VerifyDigest(pa)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, os.WriteFile(pa, []byte("commit: '{{C}}'\n"), 0o600))
+
+	ok, err = d.Verify(pa)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	a, err := digester.ParseAlgorithm("sha256-yaml")
+	require.NoError(t, err)
+	assert.Equal(t, digester.SHA256YAML, a)
+
+	_, err = digester.ParseAlgorithm("md5")
+	require.ErrorContains(t, err, "expected sha256 or sha256-yaml")
+}
@@ -1,4 +1,16 @@
 // Package digester calculates and verifies SHA256 file digests. It stores
 // digests in companion .digest files alongside the original, enabling
-// skip-if-unchanged optimizations for image pushes.
+// skip-if-unchanged optimizations for image pushes and manifest stamping.
+// A Digester can hash the canonical form of YAML documents instead of their
+// bytes, so that formatting, key order and comments do not change the
+// digest; its digests carry the algorithm as prefix, e.g. "sha256-yaml:",
+// and unprefixed digests are read as SHA256. A Store keeps the digests:
+// SidecarStore in .digest files, IndexStore in one .digests index per
+// directory, with Migrate moving sidecars into it. For stamped manifests a
+// stored digest is the hash of the rendered file before stamping: Verify and
+// VerifyDigest compare fresh renderings with it and return false for the
+// stamped file. SaveStamped stores the digest of a stamped file next to that
+// of its content before stamping. Scan reports the digest status of every
+// manifest of a tree, stamped files against their stamped digest, for the
+// gitops_digest command.
 package digester
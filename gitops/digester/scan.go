@@ -0,0 +1,194 @@
+package digester
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Status is the state of the digest of a file.
+type Status string
+
+const (
+	// StatusOK is a file matching its stored digest.
+	StatusOK Status = "ok"
+
+	// StatusMismatch is a file whose content changed
+	// since its digest was stored, e.g. by a manual
+	// edit.
+	StatusMismatch Status = "mismatch"
+
+	// StatusMissing is a manifest without stored
+	// digest.
+	StatusMissing Status = "missing"
+
+	// StatusOrphan is a stored digest of a file that
+	// no longer exists.
+	StatusOrphan Status = "orphan"
+)
+
+// Result is the digest state of a file.
+type Result struct {
+	// Path is slash-separated and relative to the
+	// scanned root.
+	Path string `json:"path"`
+
+	// Status compares Stored with Actual.
+	Status Status `json:"status"`
+
+	// Stored is the stored digest of the file as it
+	// is, empty when missing.
+	Stored string `json:"stored,omitempty"`
+
+	// Rendered is the stored digest of the content of
+	// a stamped file before stamping, which prer
+	// compares new renderings with.
+	Rendered string `json:"rendered,omitempty"`
+
+	// Actual is the digest of the content, in the
+	// format of Stored, or of the Digester when
+	// missing. It is empty for orphans.
+	Actual string `json:"actual"`
+}
+
+// Scan checks the digests of the files below root:
+// the YAML manifests and every file with a stored
+// digest, in sidecar files or indexes. Stamped files
+// are checked against the digest of their stamped
+// content saved by SaveStamped. Hidden directories
+// are skipped. Results are sorted by path.
+func Scan(root string, d Digester) ([]Result, error) {
+	const errCtx = "scanning digests"
+
+	files, err := digestedFiles(root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	results := make([]Result, 0, len(files))
+
+	for _, name := range files {
+		fp := filepath.Join(root, filepath.FromSlash(name))
+
+		stored, err := d.store().Get(fp)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		r := Result{Path: name, Status: StatusMissing}
+
+		stored, stamped := SplitStamped(stored)
+		if stamped != "" {
+			r.Rendered, stored = stored, stamped
+		}
+
+		r.Stored = stored
+
+		alg := d.Algorithm
+		if stored != "" {
+			alg = ""
+			if a, _, ok := strings.Cut(stored, ":"); ok {
+				alg = Algorithm(a)
+			}
+		}
+
+		if alg == "" {
+			r.Actual, err = CalculateDigest(fp)
+		} else {
+			r.Actual, err = calculate(fp, alg)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		switch {
+		case stored == "":
+		case r.Actual == "":
+			r.Status = StatusOrphan
+		case r.Actual == stored:
+			r.Status = StatusOK
+		default:
+			r.Status = StatusMismatch
+		}
+
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// digestedFiles returns the slash-separated names,
+// relative to root, of the manifests and of the files
+// named by sidecars and indexes below root, sorted.
+func digestedFiles(root string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	add := func(fp string) error {
+		rel, err := filepath.Rel(root, fp)
+		if err != nil {
+			return err
+		}
+
+		seen[filepath.ToSlash(rel)] = true
+
+		return nil
+	}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := d.Name()
+
+		switch {
+		case d.IsDir():
+			if p != root && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+		case name == IndexName:
+			idx, err := ReadIndex(p)
+			if err != nil {
+				return err
+			}
+
+			for entry := range idx {
+				if err := add(filepath.Join(filepath.Dir(p), entry)); err != nil {
+					return err
+				}
+			}
+		case strings.HasSuffix(name, sidecarExt):
+			return add(strings.TrimSuffix(p, sidecarExt))
+		case isManifest(name):
+			return add(p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(seen))
+	for name := range seen {
+		files = append(files, name)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// isManifest reports whether name is a YAML file.
+func isManifest(name string) bool {
+	switch path.Ext(name) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
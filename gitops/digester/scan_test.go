@@ -0,0 +1,70 @@
+package digester_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/byte4ever/rules_gitops/gitops/digester"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+
+		p := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o750))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+
+		return p
+	}
+
+	ok := write("app/ok.yaml", "a: 1\n")
+	changed := write("app/changed.yaml", "a: 1\n")
+	gone := write("app/gone.yaml", "a: 1\n")
+	write("app/new.yaml", "a: 1\n")
+	write(".git/ignored.yaml", "a: 1\n")
+	legacy := write("legacy/old.yml", "a: 1\n")
+
+	d := digester.Digester{
+		Algorithm: digester.SHA256YAML,
+		Store:     &digester.IndexStore{},
+	}
+
+	for _, p := range []string{ok, changed, gone} {
+		require.NoError(t, d.Save(p))
+	}
+
+	require.NoError(t, digester.SaveDigest(legacy))
+	write("app/changed.yaml", "a: 2\n")
+	require.NoError(t, os.Remove(gone))
+
+	results, err := digester.Scan(root, d)
+	require.NoError(t, err)
+
+	statuses := make(map[string]digester.Status)
+	for _, r := range results {
+		statuses[r.Path] = r.Status
+	}
+
+	assert.Equal(t, map[string]digester.Status{
+		"app/changed.yaml": digester.StatusMismatch,
+		"app/gone.yaml":    digester.StatusOrphan,
+		"app/new.yaml":     digester.StatusMissing,
+		"app/ok.yaml":      digester.StatusOK,
+		"legacy/old.yml":   digester.StatusOK,
+	}, statuses)
+
+	assert.Equal(t, "app/changed.yaml", results[0].Path, "sorted by path")
+
+	want, err := digester.CalculateDigest(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, want, results[4].Actual,
+		"unprefixed digests are compared unprefixed")
+}
@@ -0,0 +1,274 @@
+package digester
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IndexName is the name of the index file of an
+// IndexStore in every directory holding digests. It
+// is hidden and has no manifest extension, so that
+// Argo CD and kustomize do not read it as a manifest.
+const IndexName = ".digests"
+
+// sidecarExt is the extension of sidecar digest
+// files.
+const sidecarExt = ".digest"
+
+// Store keeps the digests of files. It is the strategy
+// interface of digest persistence: sidecar files or
+// one index per directory.
+type Store interface {
+	// Get returns the stored digest of the file at
+	// path, or an empty string with no error when
+	// there is none.
+	Get(path string) (string, error)
+
+	// Set stores digest as the digest of the file at
+	// path.
+	Set(path string, digest string) error
+}
+
+// SidecarStore stores the digest of every file in a
+// companion file with the .digest extension.
+type SidecarStore struct{}
+
+// Get implements Store.
+func (SidecarStore) Get(path string) (string, error) {
+	return GetDigest(path)
+}
+
+// Set implements Store.
+func (SidecarStore) Set(path string, digest string) error {
+	const errCtx = "storing digest"
+
+	if err := writeAtomic(path+sidecarExt, []byte(digest)); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// IndexStore stores the digests of the files of a
+// directory in a single IndexName file, one
+// "digest  name" line per file sorted by name. Every
+// Set rewrites the index atomically. Files missing
+// from the index fall back to their sidecar digest,
+// and Set removes the sidecar, so that sidecars
+// migrate as files change. The index is read on every
+// call, so that branch switches are seen. It is safe
+// for concurrent use.
+type IndexStore struct {
+	mu sync.Mutex
+}
+
+// Get implements Store.
+func (s *IndexStore) Get(path string) (string, error) {
+	const errCtx = "getting stored digest"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := ReadIndex(filepath.Join(filepath.Dir(path), IndexName))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if digest, ok := idx[filepath.Base(path)]; ok {
+		return digest, nil
+	}
+
+	return GetDigest(path)
+}
+
+// Set implements Store.
+func (s *IndexStore) Set(path string, digest string) error {
+	const errCtx = "storing digest"
+
+	name := filepath.Base(path)
+	if strings.ContainsAny(name, "\n\r") {
+		return fmt.Errorf("%s: invalid file name %q", errCtx, name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Dir(path)
+
+	idx, err := ReadIndex(filepath.Join(dir, IndexName))
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	idx[name] = digest
+
+	if err := writeAtomic(
+		filepath.Join(dir, IndexName), formatIndex(idx),
+	); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	err = os.Remove(path + sidecarExt)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: removing sidecar: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// ReadIndex returns the digests of an index file by
+// file name, none when it does not exist.
+func ReadIndex(path string) (map[string]string, error) {
+	const errCtx = "reading digest index"
+
+	idx := make(map[string]string)
+
+	data, err := os.ReadFile(path) //nolint:gosec // path is caller-provided by design
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		digest, name, ok := strings.Cut(line, "  ")
+		if !ok || digest == "" || name == "" {
+			return nil, fmt.Errorf(
+				"%s: %s:%d: expected \"digest  name\"",
+				errCtx, path, n,
+			)
+		}
+
+		idx[name] = digest
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return idx, nil
+}
+
+// formatIndex renders the lines of an index file,
+// sorted by file name.
+func formatIndex(idx map[string]string) []byte {
+	names := make([]string, 0, len(idx))
+	for name := range idx {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+
+	for _, name := range names {
+		buf.WriteString(idx[name] + "  " + name + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+// Migrate moves the sidecar digests below root into
+// store and removes them, and returns the number of
+// migrated digests. Sidecars of missing files are
+// left alone. store must not be a SidecarStore.
+func Migrate(root string, store Store) (int, error) {
+	const errCtx = "migrating digests"
+
+	if _, ok := store.(SidecarStore); ok {
+		return 0, fmt.Errorf("%s: target is the sidecar store", errCtx)
+	}
+
+	var sidecars []string
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case d.IsDir() && p != root && strings.HasPrefix(d.Name(), "."):
+			return filepath.SkipDir
+		case !d.IsDir() && strings.HasSuffix(p, sidecarExt):
+			sidecars = append(sidecars, p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	migrated := 0
+
+	for _, sidecar := range sidecars {
+		path := strings.TrimSuffix(sidecar, sidecarExt)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		digest, err := GetDigest(path)
+		if err != nil {
+			return migrated, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		if err := store.Set(path, strings.TrimSpace(digest)); err != nil {
+			return migrated, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		if err := os.Remove(sidecar); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return migrated, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// writeAtomic replaces the file at path with data
+// through a temporary file renamed over it, so that
+// readers never see a partial write.
+func writeAtomic(path string, data []byte) (retErr error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if retErr != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	//nolint:gosec // digests are not secret; matches git checkouts
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,107 @@
+package digester_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/byte4ever/rules_gitops/gitops/digester"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexStore(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.yaml")
+	b := filepath.Join(dir, "b.yaml")
+	require.NoError(t, os.WriteFile(a, []byte("a: 1\n"), 0o600))
+	require.NoError(t, os.WriteFile(b, []byte("b: 1\n"), 0o600))
+
+	// b has a sidecar from an earlier release.
+	require.NoError(t, digester.SaveDigest(b))
+
+	store := &digester.IndexStore{}
+	d := digester.Digester{Algorithm: digester.SHA256YAML, Store: store}
+
+	ok, err := d.Verify(b)
+	require.NoError(t, err)
+	assert.True(t, ok, "sidecar digests are read")
+
+	require.NoError(t, d.Save(b))
+	require.NoError(t, d.Save(a))
+
+	assert.NoFileExists(t, b+".digest", "saving migrates the sidecar")
+
+	da, err := d.Calculate(a)
+	require.NoError(t, err)
+	db, err := d.Calculate(b)
+	require.NoError(t, err)
+
+	index, err := os.ReadFile(filepath.Join(dir, digester.IndexName))
+	require.NoError(t, err)
+	assert.Equal(t, da+"  a.yaml\n"+db+"  b.yaml\n", string(index))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "no temporary file is left")
+
+	ok, err = d.Verify(a)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, os.WriteFile(a, []byte("a: 2\n"), 0o600))
+
+	ok, err = d.Verify(a)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, digester.IndexName), []byte("broken\n"), 0o600,
+	))
+
+	_, err = store.Get(a)
+	require.ErrorContains(t, err, digester.IndexName+`:1: expected "digest  name"`)
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := []string{"app/a.yaml", "app/b.yaml", "db/c.yaml"}
+
+	for _, name := range files {
+		fp := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+		require.NoError(t, os.WriteFile(fp, []byte(name), 0o600))
+		require.NoError(t, digester.SaveDigest(fp))
+	}
+
+	orphan := filepath.Join(dir, "db", "gone.yaml.digest")
+	require.NoError(t, os.WriteFile(orphan, []byte("00"), 0o600))
+
+	store := &digester.IndexStore{}
+
+	n, err := digester.Migrate(dir, store)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.FileExists(t, orphan)
+
+	for _, name := range files {
+		fp := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoFileExists(t, fp+".digest")
+
+		ok, err := digester.Digester{Store: store}.Verify(fp)
+		require.NoError(t, err)
+		assert.True(t, ok, name)
+	}
+
+	idx, err := digester.ReadIndex(filepath.Join(dir, "app", digester.IndexName))
+	require.NoError(t, err)
+	assert.Len(t, idx, 2)
+
+	_, err = digester.Migrate(dir, digester.SidecarStore{})
+	require.ErrorContains(t, err, "target is the sidecar store")
+}
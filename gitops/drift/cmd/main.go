@@ -0,0 +1,210 @@
+// Command gitops_drift compares the manifests of a
+// deployment branch with the objects running in a
+// Kubernetes cluster and reports added, removed and
+// changed resources per namespace.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/byte4ever/rules_gitops/gitops/drift"
+	"github.com/byte4ever/rules_gitops/gitops/internal/cmdflag"
+)
+
+// exitDrift is the exit status when drift is found and
+// --fail_on_drift is set.
+const exitDrift = 2
+
+func main() {
+	found, err := run()
+	if err != nil {
+		slog.Error("fatal", "error", err)
+		os.Exit(1)
+	}
+
+	if found {
+		os.Exit(exitDrift)
+	}
+}
+
+// run detects drift and reports whether the process
+// should exit with exitDrift.
+//
+//nolint:funlen // CLI flag setup is inherently long
+func run() (bool, error) {
+	const errCtx = "running gitops_drift"
+
+	gitRepo := flag.String(
+		"git_repo", "",
+		"Remote git repository URL",
+	)
+	gitMirror := flag.String(
+		"git_mirror", "",
+		"Local git mirror for reference clones",
+	)
+
+	var gitBackend cmdflag.GitBackend
+
+	gitBackend.Register(flag.CommandLine)
+
+	primaryBranch := flag.String(
+		"primary_branch", "main",
+		"Primary branch name",
+	)
+	branch := flag.String(
+		"branch", "",
+		"Deployment branch to compare with the cluster",
+	)
+	tmpDir := flag.String(
+		"tmp_dir", os.TempDir(),
+		"Temporary directory for the clone",
+	)
+
+	var gitopsPaths cmdflag.Slice
+
+	flag.Var(
+		&gitopsPaths,
+		"gitops_path",
+		"Subdirectory holding manifests (repeatable)",
+	)
+
+	kubeconfig := flag.String(
+		"kubeconfig", "",
+		"Path to kubeconfig (default: standard "+
+			"loading rules)",
+	)
+	kubeContext := flag.String(
+		"context", "",
+		"Kubeconfig context to use",
+	)
+	mode := flag.String(
+		"mode", string(drift.ModeNormalised),
+		"Comparison: normalised or dry-run "+
+			"(server-side apply with dryRun=All)",
+	)
+	namespace := flag.String(
+		"namespace", "default",
+		"Namespace of manifests without one",
+	)
+	selector := flag.String(
+		"selector", "",
+		"Label selector of objects managed from git; "+
+			"enables reporting of added objects",
+	)
+	output := flag.String(
+		"output", "text",
+		"Report format: text or json",
+	)
+	failOnDrift := flag.Bool(
+		"fail_on_drift", false,
+		fmt.Sprintf(
+			"Exit with status %d when drift is found",
+			exitDrift,
+		),
+	)
+
+	flag.Parse()
+
+	if *branch == "" {
+		return false, fmt.Errorf(
+			"%s: --branch is required", errCtx,
+		)
+	}
+
+	backend, err := gitBackend.New()
+	if err != nil {
+		return false, fmt.Errorf(
+			"%s: create git backend: %w", errCtx, err,
+		)
+	}
+
+	detector, err := newDetector(*kubeconfig, *kubeContext)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	detector.Mode = drift.Mode(*mode)
+	detector.Namespace = *namespace
+	detector.Selector = *selector
+
+	report, err := drift.Run(context.Background(), drift.Config{
+		GitRepo:       *gitRepo,
+		GitMirror:     *gitMirror,
+		GitBackend:    backend,
+		PrimaryBranch: *primaryBranch,
+		Branch:        *branch,
+		GitopsPaths:   gitopsPaths,
+		TmpDir:        *tmpDir,
+		Detector:      detector,
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	switch *output {
+	case "text":
+		err = report.WriteText(os.Stdout)
+	case "json":
+		err = report.WriteJSON(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown output %q", *output)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return *failOnDrift && report.HasDrift(), nil
+}
+
+// newDetector builds a Detector talking to the cluster
+// selected by kubeconfig and kubeContext.
+func newDetector(
+	kubeconfig string,
+	kubeContext string,
+) (*drift.Detector, error) {
+	const errCtx = "connecting to cluster"
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s: building kubeconfig: %w", errCtx, err,
+		)
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	disco, err := discovery.NewDiscoveryClientForConfig(
+		restConfig,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return &drift.Detector{
+		Client: client,
+		Mapper: restmapper.NewDeferredDiscoveryRESTMapper(
+			memory.NewMemCacheClient(disco),
+		),
+	}, nil
+}
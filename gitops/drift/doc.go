@@ -0,0 +1,8 @@
+// Package drift detects differences between the manifests of a deployment
+// branch and the objects running in a Kubernetes cluster. Run clones the
+// gitops repository with the git package, checks out the deployment branch
+// and hands the loaded manifests to a Detector, which reads live objects
+// through a dynamic client. Objects are compared either after normalisation
+// or through a server-side dry-run apply, and the Report lists added,
+// removed and changed resources per namespace.
+package drift
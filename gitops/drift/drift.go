@@ -0,0 +1,500 @@
+package drift
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	json "github.com/goccy/go-json"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/byte4ever/rules_gitops/gitops/diff"
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// Mode selects how a manifest is compared with its
+// live object.
+type Mode string
+
+const (
+	// ModeNormalised compares the manifest with the
+	// live object after dropping server-managed
+	// fields. Fields the manifest does not set are
+	// ignored, so defaults added by the API server do
+	// not count as drift.
+	ModeNormalised Mode = "normalised"
+
+	// ModeDryRun server-side applies the manifest with
+	// dryRun=All and compares the result with the live
+	// object. Defaulting, admission and quantity
+	// canonicalisation are performed by the API
+	// server, at the cost of one request per resource.
+	ModeDryRun Mode = "dry-run"
+)
+
+// defaultFieldManager is the field manager used for
+// dry-run applies when Detector.FieldManager is empty.
+const defaultFieldManager = "gitops-drift"
+
+// Config holds all settings for a drift detection run
+// against a deployment branch.
+type Config struct {
+	// GitRepo is the remote repository URL.
+	GitRepo string
+
+	// GitMirror is an optional local mirror path.
+	GitMirror string
+
+	// GitBackend performs git operations on the
+	// clone. Nil means git.CLIBackend.
+	GitBackend git.Backend
+
+	// PrimaryBranch is the branch cloned first
+	// (e.g. "main").
+	PrimaryBranch string
+
+	// Branch is the deployment branch whose
+	// manifests are compared with the cluster.
+	Branch string
+
+	// GitopsPaths restricts the checkout and the
+	// loaded manifests to these subdirectories
+	// (empty means root).
+	GitopsPaths []string
+
+	// TmpDir is the directory for the temporary
+	// clone.
+	TmpDir string
+
+	// Detector compares the manifests with the
+	// cluster.
+	Detector *Detector
+}
+
+// Detector compares manifests with live objects read
+// through a dynamic client.
+type Detector struct {
+	// Client reads and dry-run applies objects.
+	Client dynamic.Interface
+
+	// Mapper resolves kinds to API resources and
+	// scopes.
+	Mapper meta.RESTMapper
+
+	// Mode selects the comparison. Empty means
+	// ModeNormalised.
+	Mode Mode
+
+	// Namespace is used for namespaced manifests that
+	// do not set metadata.namespace. Empty means
+	// "default".
+	Namespace string
+
+	// Selector is a label selector identifying the
+	// objects managed from git. When set, live objects
+	// matching it that have no manifest are reported
+	// as added. When empty, added objects are not
+	// detected.
+	Selector string
+
+	// FieldManager is the field manager of dry-run
+	// applies. Empty means "gitops-drift".
+	FieldManager string
+}
+
+// Run clones cfg.GitRepo, checks out cfg.Branch, loads
+// the manifests below cfg.GitopsPaths and compares them
+// with the cluster.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	const errCtx = "detecting drift"
+
+	backend := cfg.GitBackend
+	if backend == nil {
+		backend = git.CLIBackend{}
+	}
+
+	repo, err := git.CloneWith(
+		backend,
+		cfg.GitRepo,
+		filepath.Join(cfg.TmpDir, "drift"),
+		cfg.GitMirror,
+		cfg.PrimaryBranch,
+		cfg.GitopsPaths...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s: clone repo: %w", errCtx, err,
+		)
+	}
+
+	defer func() { _ = repo.Clean() }()
+
+	if cfg.Branch != cfg.PrimaryBranch {
+		if err := repo.TryFetch(cfg.Branch); err != nil {
+			return nil, fmt.Errorf(
+				"%s: deployment branch %s not found: %w",
+				errCtx, cfg.Branch, err,
+			)
+		}
+
+		if repo.SwitchToBranch(
+			cfg.Branch, cfg.PrimaryBranch,
+		) {
+			return nil, fmt.Errorf(
+				"%s: deployment branch %s not found",
+				errCtx, cfg.Branch,
+			)
+		}
+	}
+
+	resources, err := manifest.LoadDir(
+		repo.Dir, cfg.GitopsPaths...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	report, err := cfg.Detector.Detect(ctx, resources)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return report, nil
+}
+
+// Detect compares every resource with its live object.
+// Resources missing from the cluster are reported as
+// removed, differing ones as changed, and, when
+// Selector is set, unmanaged live objects of the same
+// kinds and namespaces as added.
+func (d *Detector) Detect(
+	ctx context.Context,
+	resources []manifest.Resource,
+) (*Report, error) {
+	const errCtx = "comparing with cluster"
+
+	report := &Report{}
+	desired := make(map[manifest.Key]struct{}, len(resources))
+	scopes := make(map[listScope]struct{})
+
+	for _, res := range resources {
+		target, err := d.resolve(res.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		desired[target.key] = struct{}{}
+		scopes[listScope{
+			resource:  target.resource,
+			namespace: target.key.Namespace,
+			gvk:       target.gvk,
+		}] = struct{}{}
+
+		change, err := d.compare(ctx, res, target)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: %s: %w", errCtx, target.key, err,
+			)
+		}
+
+		if change != nil {
+			report.Changes = append(report.Changes, *change)
+		}
+	}
+
+	if d.Selector != "" {
+		for scope := range scopes {
+			added, err := d.unmanaged(ctx, scope, desired)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"%s: %w", errCtx, err,
+				)
+			}
+
+			report.Changes = append(
+				report.Changes, added...,
+			)
+		}
+	}
+
+	report.sort()
+
+	return report, nil
+}
+
+// target is a manifest resolved against the API.
+type target struct {
+	key      manifest.Key
+	gvk      schema.GroupVersionKind
+	resource schema.GroupVersionResource
+}
+
+// listScope is a resource type in one namespace, used
+// to look for unmanaged objects.
+type listScope struct {
+	resource  schema.GroupVersionResource
+	namespace string
+	gvk       schema.GroupVersionKind
+}
+
+// resolve maps key to its API resource and fills in the
+// effective namespace.
+func (d *Detector) resolve(key manifest.Key) (target, error) {
+	const errCtx = "resolving resource"
+
+	gvk := schema.FromAPIVersionAndKind(
+		key.APIVersion, key.Kind,
+	)
+
+	mapping, err := d.Mapper.RESTMapping(
+		gvk.GroupKind(), gvk.Version,
+	)
+	if err != nil {
+		return target{}, fmt.Errorf(
+			"%s: %s: %w", errCtx, key, err,
+		)
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if key.Namespace == "" {
+			key.Namespace = d.namespace()
+		}
+	} else {
+		key.Namespace = ""
+	}
+
+	return target{
+		key:      key,
+		gvk:      gvk,
+		resource: mapping.Resource,
+	}, nil
+}
+
+// compare returns the change between res and its live
+// object, or nil when they match.
+func (d *Detector) compare(
+	ctx context.Context,
+	res manifest.Resource,
+	tgt target,
+) (*Change, error) {
+	client := d.client(tgt.resource, tgt.key.Namespace)
+
+	live, err := client.Get(
+		ctx, tgt.key.Name, metav1.GetOptions{},
+	)
+	if apierrors.IsNotFound(err) {
+		return &Change{Key: tgt.key, Type: Removed}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+
+	got, err := normalise(live.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+
+	switch d.Mode {
+	case ModeNormalised, "":
+		fields = changedPaths(res.Object, got, true)
+
+	case ModeDryRun:
+		applied, err := d.dryRunApply(ctx, client, res, tgt)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = changedPaths(applied, got, false)
+
+	default:
+		return nil, fmt.Errorf("unknown mode %q", d.Mode)
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	return &Change{
+		Key:    tgt.key,
+		Type:   Changed,
+		Fields: fields,
+	}, nil
+}
+
+// dryRunApply server-side applies res with dryRun=All
+// and returns the normalised result.
+func (d *Detector) dryRunApply(
+	ctx context.Context,
+	client dynamic.ResourceInterface,
+	res manifest.Resource,
+	tgt target,
+) (map[string]any, error) {
+	data, err := json.Marshal(res.Object)
+	if err != nil {
+		return nil, fmt.Errorf("encode manifest: %w", err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	if tgt.key.Namespace != "" {
+		obj.SetNamespace(tgt.key.Namespace)
+	}
+
+	fieldManager := d.FieldManager
+	if fieldManager == "" {
+		fieldManager = defaultFieldManager
+	}
+
+	applied, err := client.Apply(
+		ctx, tgt.key.Name, obj, metav1.ApplyOptions{
+			DryRun:       []string{metav1.DryRunAll},
+			Force:        true,
+			FieldManager: fieldManager,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("dry-run apply: %w", err)
+	}
+
+	return normalise(applied.Object)
+}
+
+// unmanaged lists the live objects of scope matching
+// the selector and returns those without a manifest.
+func (d *Detector) unmanaged(
+	ctx context.Context,
+	scope listScope,
+	desired map[manifest.Key]struct{},
+) ([]Change, error) {
+	list, err := d.client(
+		scope.resource, scope.namespace,
+	).List(ctx, metav1.ListOptions{
+		LabelSelector: d.Selector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf(
+			"list %s: %w", scope.resource.Resource, err,
+		)
+	}
+
+	var changes []Change
+
+	for _, item := range list.Items {
+		key := manifest.Key{
+			APIVersion: scope.gvk.GroupVersion().String(),
+			Kind:       scope.gvk.Kind,
+			Namespace:  item.GetNamespace(),
+			Name:       item.GetName(),
+		}
+
+		if _, ok := desired[key]; ok {
+			continue
+		}
+
+		changes = append(changes, Change{
+			Key:  key,
+			Type: Added,
+		})
+	}
+
+	return changes, nil
+}
+
+// client returns the dynamic client for resource in
+// namespace, or cluster-wide when namespace is empty.
+func (d *Detector) client(
+	resource schema.GroupVersionResource,
+	namespace string,
+) dynamic.ResourceInterface {
+	if namespace == "" {
+		return d.Client.Resource(resource)
+	}
+
+	return d.Client.Resource(resource).Namespace(namespace)
+}
+
+// namespace returns the namespace for manifests that do
+// not set one.
+func (d *Detector) namespace() string {
+	if d.Namespace == "" {
+		return metav1.NamespaceDefault
+	}
+
+	return d.Namespace
+}
+
+// volatileMetadata lists metadata fields maintained by
+// the API server.
+var volatileMetadata = []string{
+	"creationTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// volatileAnnotations lists annotations written by
+// clients and controllers rather than by manifests.
+var volatileAnnotations = []string{
+	"deployment.kubernetes.io/revision",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// normalise converts a live object into the manifest
+// representation and drops server-managed fields and
+// status.
+func normalise(obj map[string]any) (map[string]any, error) {
+	v, err := manifest.Normalize(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	out, _ := v.(map[string]any)
+	delete(out, "status")
+
+	md, ok := out["metadata"].(map[string]any)
+	if !ok {
+		return out, nil
+	}
+
+	for _, f := range volatileMetadata {
+		delete(md, f)
+	}
+
+	if ann, ok := md["annotations"].(map[string]any); ok {
+		for _, a := range volatileAnnotations {
+			delete(ann, a)
+		}
+
+		if len(ann) == 0 {
+			delete(md, "annotations")
+		}
+	}
+
+	return out, nil
+}
+
+// changedPaths returns the paths of the fields where
+// want and got differ.
+func changedPaths(want, got any, subset bool) []string {
+	var paths []string
+
+	for _, f := range diff.Fields(want, got, subset) {
+		paths = append(paths, f.Path)
+	}
+
+	return paths
+}
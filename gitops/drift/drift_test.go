@@ -0,0 +1,409 @@
+package drift_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/byte4ever/rules_gitops/gitops/drift"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+const branchManifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: app
+  labels:
+    managed: gitops
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:v2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  namespace: app
+  labels:
+    managed: gitops
+data:
+  key: value
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+`
+
+var (
+	deployments = schema.GroupVersionResource{
+		Group: "apps", Version: "v1", Resource: "deployments",
+	}
+	configMaps = schema.GroupVersionResource{
+		Version: "v1", Resource: "configmaps",
+	}
+	namespaces = schema.GroupVersionResource{
+		Version: "v1", Resource: "namespaces",
+	}
+)
+
+func TestDetector_Detect_normalised(t *testing.T) {
+	t.Parallel()
+
+	client := newFakeClient(
+		// Drifted: replicas scaled and image changed,
+		// plus server defaults that must be ignored.
+		liveObject(map[string]any{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]any{
+				"name":            "web",
+				"namespace":       "app",
+				"labels":          map[string]any{"managed": "gitops"},
+				"uid":             "1234",
+				"resourceVersion": "42",
+			},
+			"spec": map[string]any{
+				"replicas":             int64(5),
+				"revisionHistoryLimit": int64(10),
+				"template": map[string]any{
+					"spec": map[string]any{
+						"containers": []any{map[string]any{
+							"name":  "web",
+							"image": "web:v1",
+						}},
+					},
+				},
+			},
+			"status": map[string]any{"replicas": int64(5)},
+		}),
+		// Unmanaged object created by hand.
+		liveObject(map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]any{
+				"name":      "manual",
+				"namespace": "app",
+				"labels":    map[string]any{"managed": "gitops"},
+			},
+		}),
+		liveObject(map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]any{"name": "app"},
+		}),
+	)
+
+	det := &drift.Detector{
+		Client:   client,
+		Mapper:   newMapper(),
+		Selector: "managed=gitops",
+	}
+
+	report, err := det.Detect(
+		context.Background(), parse(t, branchManifests),
+	)
+	require.NoError(t, err)
+
+	assert.True(t, report.HasDrift())
+	assert.Equal(t, []string{"app"}, report.Namespaces())
+	assert.Equal(t, []drift.Change{
+		{
+			Key:  key("v1", "ConfigMap", "app", "cfg"),
+			Type: drift.Removed,
+		},
+		{
+			Key:  key("v1", "ConfigMap", "app", "manual"),
+			Type: drift.Added,
+		},
+		{
+			Key:  key("apps/v1", "Deployment", "app", "web"),
+			Type: drift.Changed,
+			Fields: []string{
+				"spec.replicas",
+				"spec.template.spec.containers[0].image",
+			},
+		},
+	}, report.Changes)
+
+	var out bytes.Buffer
+	require.NoError(t, report.WriteText(&out))
+	assert.Equal(t, "namespace app:\n"+
+		"  removed  ConfigMap cfg\n"+
+		"  added    ConfigMap manual\n"+
+		"  changed  Deployment web: spec.replicas, "+
+		"spec.template.spec.containers[0].image\n"+
+		"1 added, 1 removed, 1 changed\n", out.String())
+}
+
+func TestDetector_Detect_noDrift(t *testing.T) {
+	t.Parallel()
+
+	const cm = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+data:
+  key: value
+`
+
+	client := newFakeClient(liveObject(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      "cfg",
+			"namespace": "team",
+		},
+		"data": map[string]any{"key": "value"},
+	}))
+
+	det := &drift.Detector{
+		Client:    client,
+		Mapper:    newMapper(),
+		Namespace: "team",
+	}
+
+	report, err := det.Detect(context.Background(), parse(t, cm))
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift())
+}
+
+func TestDetector_Detect_dryRun(t *testing.T) {
+	t.Parallel()
+
+	const cm = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  namespace: app
+data:
+  key: value
+`
+
+	client := newFakeClient(liveObject(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      "cfg",
+			"namespace": "app",
+		},
+		"data": map[string]any{
+			"key":   "value",
+			"extra": "added by hand",
+		},
+	}))
+
+	// The fake client does not implement server-side
+	// apply: answer the dry-run with the manifest as
+	// the API server would.
+	var dryRun []string
+
+	client.PrependReactor(
+		"patch", "configmaps",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			patch, _ := action.(k8stesting.PatchActionImpl)
+			dryRun = patch.PatchOptions.DryRun
+
+			obj := &unstructured.Unstructured{}
+			err := obj.UnmarshalJSON(patch.Patch)
+
+			return true, obj, err
+		},
+	)
+
+	det := &drift.Detector{
+		Client: client,
+		Mapper: newMapper(),
+		Mode:   drift.ModeDryRun,
+	}
+
+	report, err := det.Detect(context.Background(), parse(t, cm))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"All"}, dryRun)
+	assert.Equal(t, []drift.Change{{
+		Key:    key("v1", "ConfigMap", "app", "cfg"),
+		Type:   drift.Changed,
+		Fields: []string{"data.extra"},
+	}}, report.Changes)
+}
+
+func TestRun_checksOutDeploymentBranch(t *testing.T) {
+	t.Parallel()
+
+	if _, err := osexec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	remote := initRemote(t, branchManifests)
+
+	report, err := drift.Run(context.Background(), drift.Config{
+		GitRepo:       remote,
+		PrimaryBranch: "main",
+		Branch:        "deploy/dev",
+		GitopsPaths:   []string{"cloud/dev"},
+		TmpDir:        t.TempDir(),
+		Detector: &drift.Detector{
+			Client: newFakeClient(),
+			Mapper: newMapper(),
+		},
+	})
+	require.NoError(t, err)
+
+	// Nothing is deployed: every manifest of the
+	// branch is missing from the cluster.
+	require.Len(t, report.Changes, 3)
+	assert.Equal(t, []string{"", "app"}, report.Namespaces())
+
+	for _, c := range report.Changes {
+		assert.Equal(t, drift.Removed, c.Type)
+	}
+}
+
+func TestRun_missingDeploymentBranch(t *testing.T) {
+	t.Parallel()
+
+	if _, err := osexec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	remote := initRemote(t, branchManifests)
+
+	_, err := drift.Run(context.Background(), drift.Config{
+		GitRepo:       remote,
+		PrimaryBranch: "main",
+		Branch:        "deploy/missing",
+		GitopsPaths:   []string{"cloud/dev"},
+		TmpDir:        t.TempDir(),
+		Detector: &drift.Detector{
+			Client: newFakeClient(),
+			Mapper: newMapper(),
+		},
+	})
+	require.ErrorContains(
+		t, err, "deployment branch deploy/missing not found",
+	)
+}
+
+// newFakeClient returns a fake dynamic client holding
+// objects.
+func newFakeClient(
+	objects ...runtime.Object,
+) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			deployments: "DeploymentList",
+			configMaps:  "ConfigMapList",
+			namespaces:  "NamespaceList",
+		},
+		objects...,
+	)
+}
+
+// newMapper maps the kinds used by the tests.
+func newMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+
+	mapper.Add(
+		schema.GroupVersionKind{
+			Group: "apps", Version: "v1", Kind: "Deployment",
+		},
+		meta.RESTScopeNamespace,
+	)
+	mapper.Add(
+		schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		meta.RESTScopeNamespace,
+	)
+	mapper.Add(
+		schema.GroupVersionKind{Version: "v1", Kind: "Namespace"},
+		meta.RESTScopeRoot,
+	)
+
+	return mapper
+}
+
+// liveObject wraps obj as an unstructured object.
+func liveObject(obj map[string]any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: obj}
+}
+
+// parse parses a manifest stream.
+func parse(tb testing.TB, data string) []manifest.Resource {
+	tb.Helper()
+
+	res, err := manifest.Parse(
+		strings.NewReader(data), "test.yaml",
+	)
+	require.NoError(tb, err)
+
+	return res
+}
+
+// key builds a manifest.Key.
+func key(apiVersion, kind, namespace, name string) manifest.Key {
+	return manifest.Key{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       name,
+	}
+}
+
+// initRemote creates a bare repository whose
+// deploy/dev branch holds manifests under cloud/dev.
+func initRemote(tb testing.TB, manifests string) string {
+	tb.Helper()
+
+	work := tb.TempDir()
+	remote := filepath.Join(tb.TempDir(), "remote.git")
+
+	git := func(dir string, args ...string) {
+		tb.Helper()
+
+		cmd := osexec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(tb, err, string(out))
+	}
+
+	git(work, "init", "-b", "main")
+	git(work, "config", "user.email", "test@test.com")
+	git(work, "config", "user.name", "Test")
+	require.NoError(tb, os.WriteFile(
+		filepath.Join(work, "README.md"), []byte("gitops\n"), 0o600,
+	))
+	git(work, "add", ".")
+	git(work, "commit", "-m", "init")
+	git(work, "checkout", "-b", "deploy/dev")
+
+	dir := filepath.Join(work, "cloud", "dev")
+	require.NoError(tb, os.MkdirAll(dir, 0o750))
+	require.NoError(tb, os.WriteFile(
+		filepath.Join(dir, "app.yaml"), []byte(manifests), 0o600,
+	))
+	git(work, "add", ".")
+	git(work, "commit", "-m", "deploy")
+	git(work, "clone", "--bare", work, remote)
+
+	return remote
+}
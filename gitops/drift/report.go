@@ -0,0 +1,164 @@
+package drift
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// ChangeType classifies a difference between the
+// deployment branch and the cluster, seen from the
+// cluster.
+type ChangeType string
+
+const (
+	// Added means the object exists in the cluster but
+	// has no manifest on the branch.
+	Added ChangeType = "added"
+
+	// Removed means the manifest exists on the branch
+	// but the object is missing from the cluster.
+	Removed ChangeType = "removed"
+
+	// Changed means the live object differs from its
+	// manifest.
+	Changed ChangeType = "changed"
+)
+
+// Change is a single drifted resource.
+type Change struct {
+	// Key identifies the resource. Its namespace is
+	// the effective one, empty for cluster-scoped
+	// resources.
+	Key manifest.Key `json:"key"`
+
+	// Type classifies the change.
+	Type ChangeType `json:"type"`
+
+	// Fields lists the differing field paths of a
+	// changed resource, e.g. "spec.replicas".
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Report is the result of a drift detection.
+type Report struct {
+	// Changes are sorted by namespace, kind and name.
+	Changes []Change `json:"changes"`
+}
+
+// HasDrift reports whether any change was found.
+func (r *Report) HasDrift() bool {
+	return len(r.Changes) > 0
+}
+
+// Namespaces returns the sorted namespaces having
+// changes. Cluster-scoped resources are reported under
+// the empty namespace.
+func (r *Report) Namespaces() []string {
+	var namespaces []string
+
+	for i, c := range r.Changes {
+		if i == 0 ||
+			r.Changes[i-1].Key.Namespace != c.Key.Namespace {
+			namespaces = append(namespaces, c.Key.Namespace)
+		}
+	}
+
+	return namespaces
+}
+
+// InNamespace returns the changes of namespace.
+func (r *Report) InNamespace(namespace string) []Change {
+	var changes []Change
+
+	for _, c := range r.Changes {
+		if c.Key.Namespace == namespace {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes
+}
+
+// WriteText writes a human-readable report grouped by
+// namespace, followed by a summary line.
+func (r *Report) WriteText(w io.Writer) error {
+	const errCtx = "writing drift report"
+
+	var sb strings.Builder
+
+	counts := make(map[ChangeType]int)
+
+	for _, ns := range r.Namespaces() {
+		if ns == "" {
+			sb.WriteString("cluster-scoped:\n")
+		} else {
+			fmt.Fprintf(&sb, "namespace %s:\n", ns)
+		}
+
+		for _, c := range r.InNamespace(ns) {
+			counts[c.Type]++
+
+			fmt.Fprintf(
+				&sb, "  %-8s %s %s",
+				c.Type, c.Key.Kind, c.Key.Name,
+			)
+
+			if len(c.Fields) > 0 {
+				sb.WriteString(": ")
+				sb.WriteString(strings.Join(c.Fields, ", "))
+			}
+
+			sb.WriteString("\n")
+		}
+	}
+
+	fmt.Fprintf(
+		&sb, "%d added, %d removed, %d changed\n",
+		counts[Added], counts[Removed], counts[Changed],
+	)
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	const errCtx = "writing drift report"
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// sort orders changes by namespace, kind and name.
+func (r *Report) sort() {
+	sort.SliceStable(r.Changes, func(i, j int) bool {
+		a, b := r.Changes[i].Key, r.Changes[j].Key
+
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+
+		return a.Name < b.Name
+	})
+}
@@ -1,4 +1,5 @@
 // Package exec provides shell command execution helpers. Ex returns combined
 // output and an error; MustEx panics on failure for use in contexts where
-// errors are unrecoverable.
+// errors are unrecoverable. Output returns standard output only and Stream
+// passes it to a reader as it is produced.
 package exec
@@ -1,8 +1,10 @@
 package exec
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os/exec"
 	"strings"
@@ -43,6 +45,129 @@ func Ex(
 	return string(by), nil
 }
 
+// Output executes the named command in the given
+// directory and returns its standard output only, for
+// commands whose output is data rather than a log.
+// Standard error is included in the returned error.
+// The output itself is not logged.
+func Output(
+	dir string,
+	name string,
+	arg ...string,
+) ([]byte, error) {
+	return OutputFrom(dir, nil, name, arg...)
+}
+
+// OutputFrom is like Output but feeds stdin, when not
+// nil, to the standard input of the command.
+func OutputFrom(
+	dir string,
+	stdin io.Reader,
+	name string,
+	arg ...string,
+) ([]byte, error) {
+	const errCtx = "executing command"
+
+	slog.Info(
+		"executing",
+		"cmd", name,
+		"args", strings.Join(arg, " "),
+	)
+
+	cmd := exec.CommandContext(context.Background(), name, arg...)
+	if dir != "" {
+		cmd.Dir = dir
+	}
+
+	var stderr bytes.Buffer
+
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf(
+			"%s: %s %s: %w: %s",
+			errCtx, name, strings.Join(arg, " "), err,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	return out, nil
+}
+
+// Stream executes the named command in the given
+// directory and passes its standard output to read as
+// it is produced, so large outputs need not be held in
+// memory. Output left unread by read is discarded.
+// Standard error is included in the returned error.
+// The output itself is not logged.
+func Stream(
+	dir string,
+	read func(io.Reader) error,
+	name string,
+	arg ...string,
+) error {
+	const errCtx = "executing command"
+
+	slog.Info(
+		"executing",
+		"cmd", name,
+		"args", strings.Join(arg, " "),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, arg...)
+	if dir != "" {
+		cmd.Dir = dir
+	}
+
+	var stderr bytes.Buffer
+
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", errCtx, name, err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %s: %w", errCtx, name, err)
+	}
+
+	readErr := read(stdout)
+	if readErr != nil {
+		// Stop the command rather than waiting for
+		// output nobody reads.
+		cancel()
+	} else {
+		_, readErr = io.Copy(io.Discard, stdout)
+	}
+
+	waitErr := cmd.Wait()
+
+	switch {
+	case waitErr != nil && readErr == nil:
+		return fmt.Errorf(
+			"%s: %s %s: %w: %s",
+			errCtx, name, strings.Join(arg, " "), waitErr,
+			strings.TrimSpace(stderr.String()),
+		)
+	case readErr != nil:
+		// The command may have failed first; its
+		// stderr tells why the output was cut short.
+		return fmt.Errorf(
+			"%s: %s %s: read output: %w: %s",
+			errCtx, name, strings.Join(arg, " "), readErr,
+			strings.TrimSpace(stderr.String()),
+		)
+	default:
+		return nil
+	}
+}
+
 // MustEx executes the command and panics on failure.
 func MustEx(dir string, name string, arg ...string) {
 	if _, err := Ex(dir, name, arg...); err != nil {
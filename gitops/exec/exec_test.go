@@ -1,6 +1,10 @@
 package exec_test
 
 import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/byte4ever/rules_gitops/gitops/exec"
@@ -50,3 +54,66 @@ func TestMustEx_success(t *testing.T) {
 		exec.MustEx("", "echo", "ok")
 	})
 }
+
+func TestOutput_stdoutOnly(t *testing.T) {
+	t.Parallel()
+
+	out, err := exec.Output("", "sh", "-c", "echo data; echo noise >&2")
+
+	require.NoError(t, err)
+	assert.Equal(t, "data\n", string(out))
+}
+
+func TestOutput_failureIncludesStderr(t *testing.T) {
+	t.Parallel()
+
+	_, err := exec.Output("", "sh", "-c", "echo broken >&2; exit 3")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+}
+
+func TestOutputFrom_feedsStdin(t *testing.T) {
+	t.Parallel()
+
+	out, err := exec.OutputFrom(
+		"", strings.NewReader("a\nb\n"), "wc", "-l",
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, "2", strings.TrimSpace(string(out)))
+}
+
+func TestStream_readsIncrementally(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+
+	err := exec.Stream("", func(r io.Reader) error {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+
+		return sc.Err()
+	}, "printf", `a\nb\n`)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, lines)
+}
+
+func TestStream_errors(t *testing.T) {
+	t.Parallel()
+
+	err := exec.Stream("", func(io.Reader) error {
+		return nil
+	}, "sh", "-c", "echo boom >&2; exit 3")
+	require.ErrorContains(t, err, "boom")
+
+	// A reader error stops the command.
+	stop := errors.New("stop")
+	err = exec.Stream("", func(io.Reader) error {
+		return stop
+	}, "sh", "-c", "yes")
+	require.ErrorIs(t, err, stop)
+}
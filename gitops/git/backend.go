@@ -0,0 +1,95 @@
+package git
+
+// Pattern: Strategy -- swap the git implementation
+// without changing Repo callers.
+
+// Backend performs the operations behind Repo on the
+// clone located at dir. CLIBackend shells out to the
+// git binary; gitops/git/gogit provides an in-process
+// implementation.
+type Backend interface {
+	// Clone creates the clone described by opts.
+	Clone(opts CloneOptions) error
+	// Fetch adds pattern to the branches tracked from
+	// remote and fetches them.
+	Fetch(dir string, remote string, pattern string) error
+	// SwitchToBranch checks out branch, creating it
+	// from primaryBranch when neither a local nor a
+	// remote-tracking branch exists. Reports whether
+	// the branch was created.
+	SwitchToBranch(
+		dir string,
+		branch string,
+		primaryBranch string,
+	) (bool, error)
+	// RecreateBranch resets branch to primaryBranch
+	// and checks it out.
+	RecreateBranch(
+		dir string,
+		branch string,
+		primaryBranch string,
+	) error
+	// LastCommitMessage returns the message of the
+	// HEAD commit.
+	LastCommitMessage(dir string) (string, error)
+	// Commit stages paths, or every change when paths
+	// is empty, and commits them. Reports whether a
+	// commit was created.
+	Commit(
+		dir string,
+		message string,
+		paths []string,
+	) (bool, error)
+	// RestoreFile restores fileName to its
+	// last-committed content.
+	RestoreFile(dir string, fileName string) error
+	// ChangedFiles lists tracked files with unstaged
+	// changes.
+	ChangedFiles(dir string) ([]string, error)
+	// IsClean reports whether the working tree and
+	// index match HEAD.
+	IsClean(dir string) (bool, error)
+	// Push force-pushes branches to remote.
+	Push(dir string, remote string, branches []string) error
+	// ReadFiles returns the content of the files below
+	// paths, or of the whole tree when paths is empty,
+	// in the commit ref resolves to. Keys are
+	// slash-separated paths relative to the root.
+	ReadFiles(
+		dir string,
+		ref string,
+		paths []string,
+	) (map[string][]byte, error)
+	// ReadCommit returns the hash and message of the
+	// commit ref resolves to.
+	ReadCommit(dir string, ref string) (CommitInfo, error)
+}
+
+// CommitInfo identifies a commit returned by
+// Backend.ReadCommit.
+type CommitInfo struct {
+	// Hash is the full commit hash.
+	Hash string
+	// Message is the commit message.
+	Message string
+}
+
+// CloneOptions describes a clone created by
+// Backend.Clone.
+type CloneOptions struct {
+	// URL is the remote repository URL.
+	URL string
+	// Dir is the destination directory.
+	Dir string
+	// MirrorDir is an optional local mirror used as a
+	// reference clone.
+	MirrorDir string
+	// PrimaryBranch is the single branch cloned and
+	// checked out.
+	PrimaryBranch string
+	// RemoteName is the name given to the remote.
+	RemoteName string
+	// SparsePaths restricts the checkout to these
+	// normalised subtrees. Empty means the whole tree.
+	SparsePaths []string
+}
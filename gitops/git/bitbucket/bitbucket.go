@@ -9,6 +9,9 @@ import (
 	"net/http"
 
 	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/secret"
 )
 
 // Config holds the settings needed to create a
@@ -25,6 +28,11 @@ type Config struct {
 	// Password is the Bitbucket API password (or
 	// personal access token).
 	Password string
+	// PasswordSource reads the password instead, e.g.
+	// from a mounted secret or a credential helper. It
+	// is read again when the password expires or is
+	// rejected.
+	PasswordSource secret.Source
 }
 
 // Provider creates pull requests on Bitbucket Server.
@@ -32,8 +40,7 @@ type Config struct {
 // Pattern: Strategy -- implements git.GitProvider.
 type Provider struct {
 	endpoint string
-	user     string
-	password string
+	client   *http.Client
 }
 
 type project struct {
@@ -88,16 +95,31 @@ func NewProvider(cfg Config) (*Provider, error) {
 		)
 	}
 
-	if cfg.Password == "" {
+	src := secret.Or(cfg.PasswordSource, cfg.Password)
+	if src == nil {
 		return nil, fmt.Errorf(
 			"%s: password must be set", errCtx,
 		)
 	}
 
+	passwords := secret.NewCache(src, 0)
+
+	if _, err := passwords.Fetch(context.Background()); err != nil {
+		return nil, fmt.Errorf(
+			"%s: password: %w", errCtx, err,
+		)
+	}
+
 	return &Provider{
 		endpoint: cfg.APIEndpoint,
-		user:     cfg.User,
-		password: cfg.Password,
+		client: &http.Client{
+			Transport: &secret.Transport{
+				Cache: passwords,
+				Apply: func(req *http.Request, password string) {
+					req.SetBasicAuth(cfg.User, password)
+				},
+			},
+		},
 	}, nil
 }
 
@@ -110,6 +132,23 @@ func (p *Provider) CreatePR(
 	to string,
 	title string,
 	body string,
+) error {
+	return p.CreatePRWithOptions(
+		ctx, from, to, title, body, git.PROptions{},
+	)
+}
+
+// CreatePRWithOptions creates a pull request like
+// CreatePR with opts.Reviewers as reviewers. Bitbucket
+// Server has no pull request labels, so opts.Labels is
+// ignored with a warning.
+func (p *Provider) CreatePRWithOptions(
+	ctx context.Context,
+	from string,
+	to string,
+	title string,
+	body string,
+	opts git.PROptions,
 ) error {
 	const errCtx = "creating bitbucket pull request"
 
@@ -136,6 +175,20 @@ func (p *Provider) CreatePR(
 		Reviewers: []account{},
 	}
 
+	for _, name := range opts.Reviewers {
+		pr.Reviewers = append(
+			pr.Reviewers, account{User: user{Name: name}},
+		)
+	}
+
+	if len(opts.Labels) > 0 {
+		slog.Warn(
+			"bitbucket does not support pull request "+
+				"labels, ignoring them",
+			"labels", opts.Labels,
+		)
+	}
+
 	payload, err := json.Marshal(&pr)
 	if err != nil {
 		return fmt.Errorf(
@@ -159,9 +212,8 @@ func (p *Provider) CreatePR(
 		"Content-Type",
 		"application/json; charset=utf-8",
 	)
-	req.SetBasicAuth(p.user, p.password)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return fmt.Errorf(
 			"%s: send request: %w", errCtx, err,
@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/byte4ever/rules_gitops/gitops/git"
 	bb "github.com/byte4ever/rules_gitops/gitops/git/bitbucket"
 )
 
@@ -121,6 +122,48 @@ func TestProvider_CreatePR_created(t *testing.T) {
 	)
 }
 
+func TestProvider_CreatePRWithOptions_reviewers(t *testing.T) {
+	t.Parallel()
+
+	var gotBody []byte
+
+	ts := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				gotBody, _ = io.ReadAll(r.Body)
+
+				w.WriteHeader(http.StatusCreated)
+			},
+		),
+	)
+	defer ts.Close()
+
+	pv, err := bb.NewProvider(bb.Config{
+		APIEndpoint: ts.URL,
+		User:        "admin",
+		Password:    "secret",
+	})
+	require.NoError(t, err)
+
+	err = pv.CreatePRWithOptions(
+		context.Background(),
+		"deploy/prod",
+		"main",
+		"test",
+		"",
+		git.PROptions{
+			Reviewers: []string{"alice"},
+			Labels:    []string{"ignored"},
+		},
+	)
+
+	require.NoError(t, err)
+	assert.Contains(
+		t, string(gotBody),
+		`"reviewers":[{"user":{"name":"alice"}}]`,
+	)
+}
+
 func TestProvider_CreatePR_conflict(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,341 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/exec"
+)
+
+const (
+	// cacheBareDir is the bare repository inside a
+	// cache directory.
+	cacheBareDir = "repo.git"
+	// cacheWorktreesDir holds the per-run worktrees.
+	cacheWorktreesDir = "worktrees"
+	// cacheLockFile serialises runs sharing a cache.
+	cacheLockFile = "cache.lock"
+)
+
+// Cache is a persistent bare repository reused across
+// runs. Each run takes an exclusive lock on the cache,
+// fetches incrementally and checks out a fresh
+// worktree. Create a Repo with Checkout and call Clean
+// on it to remove the worktree and release the lock.
+type Cache struct {
+	// Dir is the cache root holding the bare
+	// repository, the lock file and the worktrees.
+	Dir string
+}
+
+// Checkout returns a Repo backed by a new worktree of
+// the cached repository, cloning it first if needed.
+// The arguments match Clone. Worktrees abandoned by
+// crashed runs are garbage-collected, and a cache that
+// cannot be used is deleted and recloned once.
+func (c *Cache) Checkout(
+	repo string,
+	mirrorDir string,
+	primaryBranch string,
+	gitopsPaths ...string,
+) (*Repo, error) {
+	const errCtx = "checking out cached repository"
+
+	if err := os.MkdirAll(c.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf(
+			"%s: create cache dir: %w", errCtx, err,
+		)
+	}
+
+	unlock, err := lockFile(
+		filepath.Join(c.Dir, cacheLockFile),
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s: lock cache: %w", errCtx, err,
+		)
+	}
+
+	rp, err := c.checkoutLocked(
+		repo, mirrorDir, primaryBranch, gitopsPaths,
+	)
+	if err != nil {
+		slog.Warn(
+			"cached repository unusable, recloning",
+			"dir", c.Dir,
+			"error", err,
+		)
+
+		if rmErr := c.reset(); rmErr != nil {
+			return nil, errors.Join(
+				fmt.Errorf("%s: %w", errCtx, rmErr),
+				unlock(),
+			)
+		}
+
+		rp, err = c.checkoutLocked(
+			repo, mirrorDir, primaryBranch, gitopsPaths,
+		)
+	}
+
+	if err != nil {
+		return nil, errors.Join(
+			fmt.Errorf("%s: %w", errCtx, err),
+			unlock(),
+		)
+	}
+
+	wt := rp.Dir
+	bare := c.bareDir()
+
+	rp.release = func() error {
+		_, rmErr := exec.Ex(
+			bare, "git",
+			"worktree", "remove", "--force", wt,
+		)
+
+		return errors.Join(rmErr, unlock())
+	}
+
+	return rp, nil
+}
+
+// checkoutLocked performs the checkout while the cache
+// lock is held.
+func (c *Cache) checkoutLocked(
+	repo string,
+	mirrorDir string,
+	primaryBranch string,
+	gitopsPaths []string,
+) (*Repo, error) {
+	const errCtx = "preparing cache"
+
+	bare := c.bareDir()
+	remoteName := "origin"
+
+	if err := c.ensureBare(
+		repo, mirrorDir, primaryBranch,
+	); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := c.collectWorktrees(); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	// Reset state left by previous runs: tracked
+	// branch patterns added by Repo.Fetch and local
+	// branches created on deployment trains.
+	steps := [][]string{
+		{"remote", "set-url", remoteName, repo},
+		{
+			"config", "--replace-all",
+			"remote." + remoteName + ".fetch",
+			"+refs/heads/" + primaryBranch +
+				":refs/remotes/" + remoteName +
+				"/" + primaryBranch,
+		},
+	}
+	for _, args := range steps {
+		if _, err := exec.Ex(
+			bare, "git", args...,
+		); err != nil {
+			return nil, fmt.Errorf(
+				"%s: %w", errCtx, err,
+			)
+		}
+	}
+
+	if err := deleteLocalBranches(bare); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if _, err := exec.Ex(
+		bare, "git",
+		"fetch", "--force", "--prune",
+		"--filter=blob:none", "--no-tags",
+		remoteName,
+	); err != nil {
+		return nil, fmt.Errorf(
+			"%s: fetch: %w", errCtx, err,
+		)
+	}
+
+	wtRoot := filepath.Join(c.Dir, cacheWorktreesDir)
+	if err := os.MkdirAll(wtRoot, 0o750); err != nil {
+		return nil, fmt.Errorf(
+			"%s: create worktrees dir: %w",
+			errCtx, err,
+		)
+	}
+
+	wt, err := os.MkdirTemp(wtRoot, "run-")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s: create worktree dir: %w",
+			errCtx, err,
+		)
+	}
+
+	if _, err := exec.Ex(
+		bare, "git",
+		"worktree", "add", "--no-checkout", "--detach",
+		wt, remoteName+"/"+primaryBranch,
+	); err != nil {
+		return nil, fmt.Errorf(
+			"%s: add worktree: %w", errCtx, err,
+		)
+	}
+
+	if paths := sparsePaths(gitopsPaths); len(paths) > 0 {
+		if _, err := exec.Ex(
+			wt, "git",
+			append(
+				[]string{"sparse-checkout", "set", "--cone"},
+				paths...,
+			)...,
+		); err != nil {
+			return nil, fmt.Errorf(
+				"%s: sparse-checkout: %w", errCtx, err,
+			)
+		}
+	}
+
+	if _, err := exec.Ex(
+		wt, "git", "checkout", primaryBranch,
+	); err != nil {
+		return nil, fmt.Errorf(
+			"%s: checkout: %w", errCtx, err,
+		)
+	}
+
+	return &Repo{
+		Dir:        wt,
+		RemoteName: remoteName,
+		Backend:    CLIBackend{},
+	}, nil
+}
+
+// ensureBare clones the bare repository unless a valid
+// one already exists in the cache.
+func (c *Cache) ensureBare(
+	repo string,
+	mirrorDir string,
+	primaryBranch string,
+) error {
+	const errCtx = "ensuring bare repository"
+
+	bare := c.bareDir()
+
+	if _, err := os.Stat(bare); err == nil {
+		out, err := exec.Ex(
+			bare, "git",
+			"rev-parse", "--is-bare-repository",
+		)
+		if err != nil ||
+			strings.TrimSpace(out) != "true" {
+			return fmt.Errorf(
+				"%s: %s is not a bare repository",
+				errCtx, bare,
+			)
+		}
+
+		return nil
+	}
+
+	args := []string{
+		"clone",
+		"--bare",
+		"--single-branch",
+		"--branch", primaryBranch,
+		"--filter=blob:none",
+		"--no-tags",
+	}
+
+	if mirrorDir != "" {
+		args = append(args, "--reference", mirrorDir)
+	}
+
+	args = append(args, repo, bare)
+
+	if _, err := exec.Ex("", "git", args...); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// collectWorktrees removes worktrees left behind by
+// runs that did not call Clean. Runs are serialised by
+// the cache lock, so every existing worktree is
+// abandoned.
+func (c *Cache) collectWorktrees() error {
+	const errCtx = "collecting worktrees"
+
+	wtRoot := filepath.Join(c.Dir, cacheWorktreesDir)
+
+	if err := os.RemoveAll(wtRoot); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if _, err := exec.Ex(
+		c.bareDir(), "git", "worktree", "prune",
+	); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// reset deletes the bare repository and worktrees so
+// the next checkout reclones from scratch.
+func (c *Cache) reset() error {
+	const errCtx = "resetting cache"
+
+	for _, name := range []string{
+		cacheBareDir, cacheWorktreesDir,
+	} {
+		if err := os.RemoveAll(
+			filepath.Join(c.Dir, name),
+		); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
+	return nil
+}
+
+// bareDir returns the path of the bare repository.
+func (c *Cache) bareDir() string {
+	return filepath.Join(c.Dir, cacheBareDir)
+}
+
+// deleteLocalBranches removes every local branch of the
+// bare repository so deployment branches are recreated
+// from the freshly fetched remote state.
+func deleteLocalBranches(bare string) error {
+	const errCtx = "deleting local branches"
+
+	out, err := exec.Ex(
+		bare, "git",
+		"for-each-ref", "--format=%(refname)",
+		"refs/heads/",
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	for _, ref := range strings.Fields(out) {
+		if _, err := exec.Ex(
+			bare, "git", "update-ref", "-d", ref,
+		); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
+	return nil
+}
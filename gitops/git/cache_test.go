@@ -0,0 +1,161 @@
+package git_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+)
+
+func TestCache_Checkout_reusesBareRepository(t *testing.T) {
+	t.Parallel()
+
+	remote := initRemoteRepo(
+		t, "cloud/dev/app.yaml", "cloud/prod/app.yaml",
+	)
+	cache := &git.Cache{Dir: t.TempDir()}
+
+	rp, err := cache.Checkout(
+		remote, "", "main", "cloud/dev",
+	)
+	require.NoError(t, err)
+
+	assert.FileExists(
+		t, filepath.Join(rp.Dir, "cloud/dev/app.yaml"),
+	)
+	assert.NoFileExists(
+		t, filepath.Join(rp.Dir, "cloud/prod/app.yaml"),
+	)
+
+	// Publish a deployment branch from the first
+	// worktree.
+	gitCmd(t, rp.Dir, "config", "user.email", "test@test.com")
+	gitCmd(t, rp.Dir, "config", "user.name", "Test")
+	rp.SwitchToBranch("deploy/dev", "main")
+	writeFile(t, rp.Dir, "cloud/dev/new.yaml")
+	require.True(t, rp.Commit("deploy", "cloud/dev"))
+	rp.Push([]string{"deploy/dev"})
+
+	firstDir := rp.Dir
+	require.NoError(t, rp.Clean())
+	assert.NoDirExists(t, firstDir)
+
+	// The second run reuses the bare repository and
+	// fetches the published branch.
+	rp, err = cache.Checkout(remote, "", "main")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = rp.Clean() })
+
+	assert.NotEqual(t, firstDir, rp.Dir)
+	assert.FileExists(
+		t, filepath.Join(rp.Dir, "cloud/prod/app.yaml"),
+	)
+
+	rp.Fetch("deploy/*")
+	assert.False(t, rp.SwitchToBranch("deploy/dev", "main"))
+	assert.FileExists(
+		t, filepath.Join(rp.Dir, "cloud/dev/new.yaml"),
+	)
+}
+
+func TestCache_Checkout_collectsAbandonedWorktrees(t *testing.T) {
+	t.Parallel()
+
+	remote := initRemoteRepo(t, "app.yaml")
+	cache := &git.Cache{Dir: t.TempDir()}
+
+	rp, err := cache.Checkout(remote, "", "main")
+	require.NoError(t, err)
+	require.NoError(t, rp.Clean())
+
+	stale := filepath.Join(
+		cache.Dir, "worktrees", "run-stale",
+	)
+	require.NoError(t, os.MkdirAll(stale, 0o750))
+
+	rp, err = cache.Checkout(remote, "", "main")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = rp.Clean() })
+
+	assert.NoDirExists(t, stale)
+}
+
+func TestCache_Checkout_recoversCorruptCache(t *testing.T) {
+	t.Parallel()
+
+	remote := initRemoteRepo(t, "app.yaml")
+	cache := &git.Cache{Dir: t.TempDir()}
+
+	// An empty directory is not a bare repository.
+	require.NoError(t, os.MkdirAll(
+		filepath.Join(cache.Dir, "repo.git"), 0o750,
+	))
+
+	rp, err := cache.Checkout(remote, "", "main")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = rp.Clean() })
+
+	assert.FileExists(
+		t, filepath.Join(rp.Dir, "app.yaml"),
+	)
+}
+
+func TestCache_Checkout_serialisesRuns(t *testing.T) {
+	t.Parallel()
+
+	remote := initRemoteRepo(t, "app.yaml")
+	cache := &git.Cache{Dir: t.TempDir()}
+
+	first, err := cache.Checkout(remote, "", "main")
+	require.NoError(t, err)
+
+	done := make(chan *git.Repo)
+
+	go func() {
+		second, checkoutErr := cache.Checkout(
+			remote, "", "main",
+		)
+		assert.NoError(t, checkoutErr)
+
+		done <- second
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("second checkout did not wait for lock")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, first.Clean())
+
+	second := <-done
+	require.NotNil(t, second)
+	require.NoError(t, second.Clean())
+}
+
+// writeFile creates the named file below dir.
+func writeFile(tb testing.TB, dir string, name string) {
+	tb.Helper()
+
+	fp := filepath.Join(dir, name)
+
+	if err := os.MkdirAll(
+		filepath.Dir(fp), 0o750,
+	); err != nil {
+		tb.Fatalf("mkdir %s: %v", fp, err)
+	}
+
+	if err := os.WriteFile(
+		fp, []byte(name+"\n"), 0o600,
+	); err != nil {
+		tb.Fatalf("write %s: %v", fp, err)
+	}
+}
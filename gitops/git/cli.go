@@ -0,0 +1,432 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/exec"
+)
+
+// CLIBackend implements Backend by running the git
+// binary found on PATH.
+//
+// Pattern: Strategy -- implements git.Backend.
+type CLIBackend struct{}
+
+// Clone runs a partial, single-branch clone and
+// applies cone-mode sparse-checkout to
+// opts.SparsePaths.
+func (CLIBackend) Clone(opts CloneOptions) error {
+	const errCtx = "cloning with git cli"
+
+	args := []string{
+		"clone",
+		"--no-checkout",
+		"--single-branch",
+		"--branch", opts.PrimaryBranch,
+		"--filter=blob:none",
+		"--no-tags",
+		"--origin", opts.RemoteName,
+	}
+
+	if opts.MirrorDir != "" {
+		args = append(args, "--reference", opts.MirrorDir)
+	}
+
+	args = append(args, opts.URL, opts.Dir)
+
+	steps := [][]string{args}
+
+	// Restrict the working tree to the requested
+	// subtrees.
+	if len(opts.SparsePaths) > 0 {
+		steps = append(steps, append(
+			[]string{"sparse-checkout", "set", "--cone"},
+			opts.SparsePaths...,
+		))
+	}
+
+	steps = append(
+		steps, []string{"checkout", opts.PrimaryBranch},
+	)
+
+	for i, step := range steps {
+		dir := opts.Dir
+		if i == 0 {
+			dir = ""
+		}
+
+		if _, err := exec.Ex(dir, "git", step...); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
+	return nil
+}
+
+// Fetch runs git remote set-branches --add followed by
+// a forced partial fetch.
+func (CLIBackend) Fetch(
+	dir string,
+	remote string,
+	pattern string,
+) error {
+	return runGit(
+		dir,
+		[]string{
+			"remote", "set-branches", "--add",
+			remote, pattern,
+		},
+		[]string{
+			"fetch", "--force",
+			"--filter=blob:none", "--no-tags",
+			remote,
+		},
+	)
+}
+
+// SwitchToBranch runs git checkout, which also creates
+// a local branch from a matching remote-tracking
+// branch, and falls back to branching off
+// primaryBranch.
+func (CLIBackend) SwitchToBranch(
+	dir string,
+	branch string,
+	primaryBranch string,
+) (bool, error) {
+	if _, err := exec.Ex(
+		dir, "git", "checkout", branch,
+	); err == nil {
+		return false, nil
+	}
+
+	// Branch does not exist yet: create and check
+	// out.
+	if err := runGit(
+		dir,
+		[]string{"branch", branch, primaryBranch},
+		[]string{"checkout", branch},
+	); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RecreateBranch force-moves branch to primaryBranch.
+func (CLIBackend) RecreateBranch(
+	dir string,
+	branch string,
+	primaryBranch string,
+) error {
+	return runGit(
+		dir,
+		[]string{"checkout", primaryBranch},
+		[]string{"branch", "-f", branch, primaryBranch},
+		[]string{"checkout", branch},
+	)
+}
+
+// LastCommitMessage runs git log -1.
+func (CLIBackend) LastCommitMessage(
+	dir string,
+) (string, error) {
+	const errCtx = "reading last commit message"
+
+	msg, err := exec.Ex(
+		dir, "git", "log", "-1", "--pretty=%B",
+	)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return msg, nil
+}
+
+// Commit stages paths with git add and commits the
+// index. With no paths every change is committed.
+func (b CLIBackend) Commit(
+	dir string,
+	message string,
+	paths []string,
+) (bool, error) {
+	const errCtx = "committing with git cli"
+
+	if len(paths) == 0 {
+		if err := runGit(
+			dir, []string{"add", "."},
+		); err != nil {
+			return false, err
+		}
+
+		clean, err := b.IsClean(dir)
+		if err != nil || clean {
+			return false, err
+		}
+
+		if err := runGit(
+			dir,
+			[]string{"commit", "-a", "-m", message},
+		); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	// Paths absent from both the working tree and
+	// the index make git add fail, so skip them.
+	paths = existingPaths(dir, paths)
+	if len(paths) > 0 {
+		if err := runGit(
+			dir,
+			append([]string{"add", "-A", "--"}, paths...),
+		); err != nil {
+			return false, err
+		}
+	}
+
+	staged, err := exec.Ex(
+		dir, "git", "diff", "--cached", "--name-only",
+	)
+	if err != nil {
+		return false, fmt.Errorf(
+			"%s: check staged changes: %w",
+			errCtx, err,
+		)
+	}
+
+	if strings.TrimSpace(staged) == "" {
+		return false, nil
+	}
+
+	if err := runGit(
+		dir, []string{"commit", "-m", message},
+	); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RestoreFile runs git checkout -- fileName.
+func (CLIBackend) RestoreFile(
+	dir string,
+	fileName string,
+) error {
+	return runGit(
+		dir, []string{"checkout", "--", fileName},
+	)
+}
+
+// ChangedFiles runs git diff --name-only.
+func (CLIBackend) ChangedFiles(
+	dir string,
+) ([]string, error) {
+	const errCtx = "listing changed files"
+
+	out, err := exec.Ex(
+		dir, "git", "diff", "--name-only",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var files []string
+
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		files = append(files, sc.Text())
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf(
+			"%s: scan: %w", errCtx, err,
+		)
+	}
+
+	return files, nil
+}
+
+// IsClean runs git status --porcelain.
+func (CLIBackend) IsClean(dir string) (bool, error) {
+	const errCtx = "checking repo status"
+
+	out, err := exec.Ex(
+		dir, "git", "status", "--porcelain",
+	)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return out == "", nil
+}
+
+// Push runs git push -f --set-upstream.
+func (CLIBackend) Push(
+	dir string,
+	remote string,
+	branches []string,
+) error {
+	return runGit(
+		dir,
+		append(
+			[]string{
+				"push", remote,
+				"-f", "--set-upstream",
+			},
+			branches...,
+		),
+	)
+}
+
+// ReadFiles lists the files with git ls-tree and reads
+// them all with a single git cat-file --batch. Blobs
+// missing from a partial clone are fetched on demand.
+// Submodules are skipped.
+func (CLIBackend) ReadFiles(
+	dir string,
+	ref string,
+	paths []string,
+) (map[string][]byte, error) {
+	const errCtx = "reading files with git cli"
+
+	out, err := exec.Output(
+		dir, "git",
+		append(
+			[]string{"ls-tree", "-r", "-z", ref, "--"},
+			paths...,
+		)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var (
+		names []string
+		ids   strings.Builder
+	)
+
+	// Entries are "<mode> <type> <object>\t<name>".
+	for _, entry := range strings.Split(string(out), "\x00") {
+		meta, name, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+
+		names = append(names, name)
+		ids.WriteString(fields[2] + "\n")
+	}
+
+	files := make(map[string][]byte, len(names))
+	if len(names) == 0 {
+		return files, nil
+	}
+
+	out, err = exec.OutputFrom(
+		dir, strings.NewReader(ids.String()),
+		"git", "cat-file", "--batch",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	rest := string(out)
+
+	// Each object is "<object> <type> <size>\n",
+	// its content and a newline.
+	for _, name := range names {
+		header, content, ok := strings.Cut(rest, "\n")
+
+		fields := strings.Fields(header)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf(
+				"%s: %s: unexpected cat-file output %q",
+				errCtx, name, header,
+			)
+		}
+
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size+1 > len(content) {
+			return nil, fmt.Errorf(
+				"%s: %s: unexpected cat-file output %q",
+				errCtx, name, header,
+			)
+		}
+
+		files[name] = []byte(content[:size])
+		rest = content[size+1:]
+	}
+
+	return files, nil
+}
+
+// ReadCommit runs git log -1 on ref.
+func (CLIBackend) ReadCommit(
+	dir string,
+	ref string,
+) (CommitInfo, error) {
+	const errCtx = "reading commit with git cli"
+
+	out, err := exec.Output(
+		dir, "git", "log", "-1", "--format=%H%n%B", ref, "--",
+	)
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	// --format terminates the output with a newline
+	// of its own.
+	hash, msg, _ := strings.Cut(
+		strings.TrimSuffix(string(out), "\n"), "\n",
+	)
+
+	return CommitInfo{Hash: hash, Message: msg}, nil
+}
+
+// runGit runs each git invocation in dir, stopping at
+// the first failure.
+func runGit(dir string, invocations ...[]string) error {
+	for _, args := range invocations {
+		if _, err := exec.Ex(dir, "git", args...); err != nil {
+			return fmt.Errorf("running git: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// existingPaths returns the entries of paths that exist
+// in the working tree of dir or are tracked in its
+// index.
+func existingPaths(dir string, paths []string) []string {
+	var found []string
+
+	for _, pa := range paths {
+		if _, err := os.Stat(
+			filepath.Join(dir, pa),
+		); err == nil {
+			found = append(found, pa)
+
+			continue
+		}
+
+		out, err := exec.Ex(
+			dir, "git", "ls-files", "--", pa,
+		)
+		if err == nil && strings.TrimSpace(out) != "" {
+			found = append(found, pa)
+		}
+	}
+
+	return found
+}
@@ -6,6 +6,8 @@
 // convenience adapter that lets plain functions satisfy the interface.
 //
 // Repo wraps a local git clone with methods for branching, committing, and
-// pushing. Clone creates a new Repo from a remote URL with optional mirror
-// reference.
+// pushing. The operations are delegated to a Backend: CLIBackend runs the git
+// binary, and the gogit sub-package provides an in-process implementation. Clone creates a new Repo from a remote URL with optional mirror
+// reference. Cache keeps a bare clone between runs and checks out a fresh,
+// locked worktree for each run.
 package git
@@ -2,3 +2,6 @@ package git
 
 // IsRootPathForTest exposes isRootPath for tests.
 var IsRootPathForTest = isRootPath
+
+// SparsePathsForTest exposes sparsePaths for tests.
+var SparsePathsForTest = sparsePaths
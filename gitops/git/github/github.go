@@ -6,8 +6,12 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	gh "github.com/google/go-github/v68/github"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/secret"
 )
 
 // Config holds the settings needed to create a GitHub
@@ -21,6 +25,11 @@ type Config struct {
 	// AccessToken is a personal access token or
 	// GitHub App token used for authentication.
 	AccessToken string
+	// AccessTokenSource reads the access token
+	// instead, e.g. from a mounted secret or a
+	// credential helper. It is read again when the
+	// token expires or is rejected.
+	AccessTokenSource secret.Source
 	// EnterpriseHost is an optional GitHub Enterprise
 	// hostname (e.g. "git.corp.example.com"). Leave
 	// empty for github.com.
@@ -53,14 +62,29 @@ func NewProvider(cfg Config) (*Provider, error) {
 		)
 	}
 
-	if cfg.AccessToken == "" {
+	src := secret.Or(cfg.AccessTokenSource, cfg.AccessToken)
+	if src == nil {
 		return nil, fmt.Errorf(
 			"%s: access token must be set", errCtx,
 		)
 	}
 
-	client := gh.NewClient(nil).
-		WithAuthToken(cfg.AccessToken)
+	tokens := secret.NewCache(src, 0)
+
+	if _, err := tokens.Fetch(context.Background()); err != nil {
+		return nil, fmt.Errorf(
+			"%s: access token: %w", errCtx, err,
+		)
+	}
+
+	client := gh.NewClient(&http.Client{
+		Transport: &secret.Transport{
+			Cache: tokens,
+			Apply: func(req *http.Request, token string) {
+				req.Header.Set("Authorization", "Bearer "+token)
+			},
+		},
+	})
 
 	if cfg.EnterpriseHost != "" {
 		baseURL := "https://" +
@@ -97,6 +121,24 @@ func (p *Provider) CreatePR(
 	to string,
 	title string,
 	body string,
+) error {
+	return p.CreatePRWithOptions(
+		ctx, from, to, title, body, git.PROptions{},
+	)
+}
+
+// CreatePRWithOptions creates a pull request like
+// CreatePR, then applies opts.Labels and requests
+// opts.Reviewers. Reviewers of the form "org/team" are
+// requested as teams. Options are not applied to an
+// already existing pull request.
+func (p *Provider) CreatePRWithOptions(
+	ctx context.Context,
+	from string,
+	to string,
+	title string,
+	body string,
+	opts git.PROptions,
 ) error {
 	const errCtx = "creating github pull request"
 
@@ -116,6 +158,12 @@ func (p *Provider) CreatePR(
 			"url", created.GetURL(),
 		)
 
+		if err := p.applyOptions(
+			ctx, created.GetNumber(), opts,
+		); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
 		return nil
 	}
 
@@ -149,3 +197,41 @@ func (p *Provider) CreatePR(
 
 	return fmt.Errorf("%s: %w", errCtx, err)
 }
+
+// applyOptions labels pull request number and requests
+// its reviewers.
+func (p *Provider) applyOptions(
+	ctx context.Context,
+	number int,
+	opts git.PROptions,
+) error {
+	if len(opts.Labels) > 0 {
+		if _, _, err := p.client.Issues.AddLabelsToIssue(
+			ctx, p.repoOwner, p.repo, number, opts.Labels,
+		); err != nil {
+			return fmt.Errorf("add labels: %w", err)
+		}
+	}
+
+	if len(opts.Reviewers) == 0 {
+		return nil
+	}
+
+	var req gh.ReviewersRequest
+
+	for _, r := range opts.Reviewers {
+		if _, team, ok := strings.Cut(r, "/"); ok {
+			req.TeamReviewers = append(req.TeamReviewers, team)
+		} else {
+			req.Reviewers = append(req.Reviewers, r)
+		}
+	}
+
+	if _, _, err := p.client.PullRequests.RequestReviewers(
+		ctx, p.repoOwner, p.repo, number, req,
+	); err != nil {
+		return fmt.Errorf("request reviewers: %w", err)
+	}
+
+	return nil
+}
@@ -8,8 +8,15 @@ import (
 	"net/http"
 
 	gl "gitlab.com/gitlab-org/api/client-go"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/secret"
 )
 
+// tokenHeader carries the access token of GitLab API
+// requests.
+const tokenHeader = "PRIVATE-TOKEN"
+
 // Config holds the settings needed to create a GitLab
 // merge request provider.
 type Config struct {
@@ -22,6 +29,11 @@ type Config struct {
 	// AccessToken is a personal or project access
 	// token used for authentication.
 	AccessToken string
+	// AccessTokenSource reads the access token
+	// instead, e.g. from a mounted secret or a
+	// credential helper. It is read again when the
+	// token expires or is rejected.
+	AccessTokenSource secret.Source
 }
 
 // Provider creates merge requests on GitLab.
@@ -37,7 +49,8 @@ type Provider struct {
 func NewProvider(cfg Config) (*Provider, error) {
 	const errCtx = "creating gitlab provider"
 
-	if cfg.AccessToken == "" {
+	src := secret.Or(cfg.AccessTokenSource, cfg.AccessToken)
+	if src == nil {
 		return nil, fmt.Errorf(
 			"%s: access token must be set", errCtx,
 		)
@@ -54,9 +67,27 @@ func NewProvider(cfg Config) (*Provider, error) {
 		host = "https://gitlab.com"
 	}
 
-	client, err := gl.NewClient(
-		cfg.AccessToken,
+	tokens := secret.NewCache(src, 0)
+
+	if _, err := tokens.Fetch(context.Background()); err != nil {
+		return nil, fmt.Errorf(
+			"%s: access token: %w", errCtx, err,
+		)
+	}
+
+	// The auth source sets the token on new requests;
+	// the transport refreshes it when it is rejected.
+	client, err := gl.NewAuthSourceClient(
+		tokenSource{tokens},
 		gl.WithBaseURL(host),
+		gl.WithHTTPClient(&http.Client{
+			Transport: &secret.Transport{
+				Cache: tokens,
+				Apply: func(req *http.Request, token string) {
+					req.Header.Set(tokenHeader, token)
+				},
+			},
+		}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -74,22 +105,53 @@ func NewProvider(cfg Config) (*Provider, error) {
 // into branch "to". If a MR already exists (HTTP 409)
 // the error is suppressed.
 func (p *Provider) CreatePR(
-	_ context.Context,
+	ctx context.Context,
+	from string,
+	to string,
+	title string,
+	body string,
+) error {
+	return p.CreatePRWithOptions(
+		ctx, from, to, title, body, git.PROptions{},
+	)
+}
+
+// CreatePRWithOptions creates a merge request like
+// CreatePR with opts.Labels and opts.Reviewers, whose
+// user names are resolved to user IDs first.
+func (p *Provider) CreatePRWithOptions(
+	ctx context.Context,
 	from string,
 	to string,
 	title string,
-	_ string,
+	body string,
+	opts git.PROptions,
 ) error {
 	const errCtx = "creating gitlab merge request"
 
-	opts := gl.CreateMergeRequestOptions{
+	mrOpts := gl.CreateMergeRequestOptions{
 		Title:        &title,
+		Description:  &body,
 		SourceBranch: &from,
 		TargetBranch: &to,
 	}
 
+	if len(opts.Labels) > 0 {
+		labels := gl.LabelOptions(opts.Labels)
+		mrOpts.Labels = &labels
+	}
+
+	if len(opts.Reviewers) > 0 {
+		ids, err := p.userIDs(ctx, opts.Reviewers)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		mrOpts.ReviewerIDs = &ids
+	}
+
 	created, resp, err := p.client.MergeRequests.CreateMergeRequest(
-		p.repo, &opts,
+		p.repo, &mrOpts, gl.WithContext(ctx),
 	)
 	if err == nil {
 		slog.Info(
@@ -131,3 +193,55 @@ func (p *Provider) CreatePR(
 
 	return fmt.Errorf("%s: %w", errCtx, err)
 }
+
+// userIDs resolves user names to GitLab user IDs.
+func (p *Provider) userIDs(
+	ctx context.Context,
+	names []string,
+) ([]int64, error) {
+	ids := make([]int64, 0, len(names))
+
+	for _, name := range names {
+		users, _, err := p.client.Users.ListUsers(
+			&gl.ListUsersOptions{Username: gl.Ptr(name)},
+			gl.WithContext(ctx),
+		)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"look up reviewer %s: %w", name, err,
+			)
+		}
+
+		if len(users) == 0 {
+			return nil, fmt.Errorf(
+				"reviewer %s not found", name,
+			)
+		}
+
+		ids = append(ids, users[0].ID)
+	}
+
+	return ids, nil
+}
+
+// tokenSource adapts a secret.Cache to gl.AuthSource.
+type tokenSource struct {
+	tokens *secret.Cache
+}
+
+// Init implements gl.AuthSource.
+func (tokenSource) Init(context.Context, *gl.Client) error {
+	return nil
+}
+
+// Header implements gl.AuthSource.
+func (s tokenSource) Header(
+	ctx context.Context,
+) (string, string, error) {
+	token, err := s.tokens.Secret(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokenHeader, token, nil
+}
@@ -1,11 +1,16 @@
 package gitlab_test
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/byte4ever/rules_gitops/gitops/git"
 	glprov "github.com/byte4ever/rules_gitops/gitops/git/gitlab"
 )
 
@@ -68,3 +73,62 @@ func TestNewProvider_missing_repo(t *testing.T) {
 	assert.Nil(t, pv)
 	assert.ErrorContains(t, err, "repo must be set")
 }
+
+func TestProvider_CreatePRWithOptions(t *testing.T) {
+	t.Parallel()
+
+	var gotBody []byte
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /api/v4/users",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("username") != "alice" {
+				_, _ = io.WriteString(w, `[]`)
+
+				return
+			}
+
+			_, _ = io.WriteString(w, `[{"id":42,"username":"alice"}]`)
+		},
+	)
+	mux.HandleFunc(
+		"POST /api/v4/projects/{project}/merge_requests",
+		func(w http.ResponseWriter, r *http.Request) {
+			gotBody, _ = io.ReadAll(r.Body)
+
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, `{"iid":1}`)
+		},
+	)
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	pv, err := glprov.NewProvider(glprov.Config{
+		Host:        ts.URL,
+		Repo:        "org/project",
+		AccessToken: "tok",
+	})
+	require.NoError(t, err)
+
+	err = pv.CreatePRWithOptions(
+		context.Background(), "deploy/prod", "main",
+		"title", "body",
+		git.PROptions{
+			Reviewers: []string{"alice"},
+			Labels:    []string{"prod", "gitops"},
+		},
+	)
+	require.NoError(t, err)
+	assert.Contains(t, string(gotBody), `"description":"body"`)
+	assert.Contains(t, string(gotBody), `"labels":"prod,gitops"`)
+	assert.Contains(t, string(gotBody), `"reviewer_ids":[42]`)
+
+	err = pv.CreatePRWithOptions(
+		context.Background(), "deploy/prod", "main",
+		"title", "body",
+		git.PROptions{Reviewers: []string{"bob"}},
+	)
+	assert.ErrorContains(t, err, "reviewer bob not found")
+}
@@ -0,0 +1,47 @@
+package gogit
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/byte4ever/rules_gitops/gitops/secret"
+)
+
+// TokenAuth authenticates HTTP fetches and pushes with
+// basic auth, User and the current secret of Cache as
+// password. The secret is read on every request, so
+// rotated tokens are picked up without a restart.
+//
+// Pattern: Adapter -- a secret.Cache as go-git
+// http.AuthMethod.
+type TokenAuth struct {
+	// User is the basic auth user name; hosts ignore it
+	// for tokens, but it must not be empty.
+	User string
+
+	// Cache supplies the token.
+	Cache *secret.Cache
+}
+
+// Name implements transport.AuthMethod.
+func (*TokenAuth) Name() string { return "http-token-auth" }
+
+// String implements transport.AuthMethod without
+// revealing the token.
+func (a *TokenAuth) String() string {
+	return a.Name() + " - " + a.User + ":<" + a.Cache.String() + ">"
+}
+
+// SetAuth implements http.AuthMethod. When the token
+// cannot be read the request is sent without
+// credentials, so the server's rejection is reported.
+func (a *TokenAuth) SetAuth(r *http.Request) {
+	token, err := a.Cache.Secret(r.Context())
+	if err != nil {
+		slog.Warn("git credentials unavailable", "error", err)
+
+		return
+	}
+
+	r.SetBasicAuth(a.User, token)
+}
@@ -0,0 +1,51 @@
+package gogit_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	gitbackend "github.com/byte4ever/rules_gitops/gitops/git/gogit"
+	"github.com/byte4ever/rules_gitops/gitops/secret"
+)
+
+func TestTokenAuth(t *testing.T) {
+	t.Parallel()
+
+	auth := &gitbackend.TokenAuth{
+		User:  "x-access-token",
+		Cache: secret.NewCache(secret.Literal("s3cr3t"), 0),
+	}
+
+	var _ transport.AuthMethod = auth
+
+	var _ githttp.AuthMethod = auth
+
+	r, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	require.NoError(t, err)
+
+	auth.SetAuth(r)
+
+	user, pass, ok := r.BasicAuth()
+	require.True(t, ok)
+	assert.Equal(t, "x-access-token", user)
+	assert.Equal(t, "s3cr3t", pass)
+	assert.NotContains(t, auth.String(), "s3cr3t")
+
+	empty := &gitbackend.TokenAuth{
+		User:  "git",
+		Cache: secret.NewCache(secret.Literal(""), 0),
+	}
+
+	r, err = http.NewRequest(http.MethodGet, "https://example.com", nil)
+	require.NoError(t, err)
+
+	empty.SetAuth(r)
+
+	_, _, ok = r.BasicAuth()
+	assert.False(t, ok)
+}
@@ -0,0 +1,9 @@
+// Package gogit implements git.Backend in process with go-git, so gitops
+// repositories can be cloned, committed and pushed in environments without a
+// git binary.
+//
+// Backend mirrors the behaviour of git.CLIBackend: partial single-branch
+// clones of the primary branch, cone-mode sparse checkout of the gitops
+// paths, branch switching that falls back to remote-tracking branches, and
+// forced pushes. Mirror reference clones are not supported and are ignored.
+package gogit
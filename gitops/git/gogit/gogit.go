@@ -0,0 +1,785 @@
+package gogit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	gg "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+)
+
+// sparseCheckoutFile is the cone-mode pattern file
+// shared with the git CLI.
+const sparseCheckoutFile = "info/sparse-checkout"
+
+// Backend implements git.Backend with go-git.
+//
+// Pattern: Strategy -- implements git.Backend.
+type Backend struct {
+	// Auth authenticates fetches and pushes. When nil,
+	// credentials embedded in the remote URL are used.
+	Auth transport.AuthMethod
+	// AuthorName and AuthorEmail sign commits. When
+	// empty the user section of the git configuration
+	// is used.
+	AuthorName  string
+	AuthorEmail string
+}
+
+// Clone clones the primary branch without tags and
+// checks it out, restricted to opts.SparsePaths.
+func (b *Backend) Clone(opts git.CloneOptions) error {
+	const errCtx = "cloning with go-git"
+
+	if opts.MirrorDir != "" {
+		slog.Warn(
+			"go-git backend ignores the mirror directory",
+			"mirror", opts.MirrorDir,
+		)
+	}
+
+	repo, err := gg.PlainClone(
+		opts.Dir, false, &gg.CloneOptions{
+			URL:        opts.URL,
+			Auth:       b.Auth,
+			RemoteName: opts.RemoteName,
+			ReferenceName: plumbing.NewBranchReferenceName(
+				opts.PrimaryBranch,
+			),
+			SingleBranch: true,
+			NoCheckout:   true,
+			Tags:         gg.NoTags,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if len(opts.SparsePaths) > 0 {
+		if err := writeSparsePaths(
+			repo, opts.Dir, opts.SparsePaths,
+		); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
+	if err := checkout(
+		repo, opts.Dir,
+		plumbing.NewBranchReferenceName(
+			opts.PrimaryBranch,
+		),
+		true,
+	); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// Fetch records a fetch refspec for pattern on remote,
+// as git remote set-branches --add does, and fetches.
+func (b *Backend) Fetch(
+	dir string,
+	remote string,
+	pattern string,
+) error {
+	const errCtx = "fetching with go-git"
+
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	rc, ok := cfg.Remotes[remote]
+	if !ok {
+		return fmt.Errorf(
+			"%s: unknown remote %q", errCtx, remote,
+		)
+	}
+
+	spec := config.RefSpec(
+		"+refs/heads/" + pattern +
+			":refs/remotes/" + remote + "/" + pattern,
+	)
+	if !containsRefSpec(rc.Fetch, spec) {
+		rc.Fetch = append(rc.Fetch, spec)
+
+		if err := repo.SetConfig(cfg); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
+	err = repo.Fetch(&gg.FetchOptions{
+		RemoteName: remote,
+		Auth:       b.Auth,
+		Force:      true,
+		Tags:       gg.NoTags,
+	})
+	if err != nil &&
+		!errors.Is(err, gg.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// SwitchToBranch checks out branch. A missing local
+// branch is created from the remote-tracking branch of
+// the same name, or else from primaryBranch.
+func (b *Backend) SwitchToBranch(
+	dir string,
+	branch string,
+	primaryBranch string,
+) (bool, error) {
+	const errCtx = "switching branch with go-git"
+
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	local := plumbing.NewBranchReferenceName(branch)
+	created := false
+
+	if _, err := repo.Reference(local, true); err != nil {
+		start, tracking, findErr := startPoint(
+			repo, branch, primaryBranch,
+		)
+		if findErr != nil {
+			return false, fmt.Errorf(
+				"%s: %w", errCtx, findErr,
+			)
+		}
+
+		if err := repo.Storer.SetReference(
+			plumbing.NewHashReference(local, start),
+		); err != nil {
+			return false, fmt.Errorf(
+				"%s: %w", errCtx, err,
+			)
+		}
+
+		created = !tracking
+	}
+
+	if err := checkout(
+		repo, dir, local, false,
+	); err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return created, nil
+}
+
+// RecreateBranch points branch at primaryBranch and
+// checks it out.
+func (b *Backend) RecreateBranch(
+	dir string,
+	branch string,
+	primaryBranch string,
+) error {
+	const errCtx = "recreating branch with go-git"
+
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	primary, err := repo.Reference(
+		plumbing.NewBranchReferenceName(primaryBranch),
+		true,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	local := plumbing.NewBranchReferenceName(branch)
+
+	if err := repo.Storer.SetReference(
+		plumbing.NewHashReference(local, primary.Hash()),
+	); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := checkout(repo, dir, local, true); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// LastCommitMessage returns the HEAD commit message.
+func (b *Backend) LastCommitMessage(
+	dir string,
+) (string, error) {
+	const errCtx = "reading last commit message"
+
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return commit.Message, nil
+}
+
+// Commit stages paths, or every change when paths is
+// empty, and commits the index when it differs from
+// HEAD.
+func (b *Backend) Commit(
+	dir string,
+	message string,
+	paths []string,
+) (bool, error) {
+	const errCtx = "committing with go-git"
+
+	_, wt, err := openWorktree(dir)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if len(paths) == 0 {
+		err = wt.AddWithOptions(&gg.AddOptions{
+			All: true,
+		})
+		if err != nil {
+			return false, fmt.Errorf(
+				"%s: add: %w", errCtx, err,
+			)
+		}
+	}
+
+	for _, pa := range paths {
+		err = wt.AddWithOptions(&gg.AddOptions{
+			All:  true,
+			Path: pa,
+		})
+		if err != nil &&
+			!errors.Is(err, gg.ErrGlobNoMatches) &&
+			!errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf(
+				"%s: add %s: %w", errCtx, pa, err,
+			)
+		}
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return false, fmt.Errorf(
+			"%s: status: %w", errCtx, err,
+		)
+	}
+
+	if !hasStagedChanges(status) {
+		return false, nil
+	}
+
+	opts := &gg.CommitOptions{}
+	if b.AuthorName != "" || b.AuthorEmail != "" {
+		opts.Author = b.signature()
+	}
+
+	if _, err := wt.Commit(message, opts); err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return true, nil
+}
+
+// RestoreFile restores fileName in the index and
+// working tree from HEAD.
+func (b *Backend) RestoreFile(
+	dir string,
+	fileName string,
+) error {
+	const errCtx = "restoring file with go-git"
+
+	_, wt, err := openWorktree(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := wt.Restore(&gg.RestoreOptions{
+		Staged:   true,
+		Worktree: true,
+		Files:    []string{filepath.ToSlash(fileName)},
+	}); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// ChangedFiles lists tracked files whose working tree
+// content differs from the index.
+func (b *Backend) ChangedFiles(
+	dir string,
+) ([]string, error) {
+	const errCtx = "listing changed files with go-git"
+
+	_, wt, err := openWorktree(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var files []string
+
+	for name, st := range status {
+		if st.Worktree == gg.Unmodified ||
+			st.Worktree == gg.Untracked {
+			continue
+		}
+
+		files = append(files, name)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// IsClean reports whether the status is empty.
+func (b *Backend) IsClean(dir string) (bool, error) {
+	const errCtx = "checking status with go-git"
+
+	_, wt, err := openWorktree(dir)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return status.IsClean(), nil
+}
+
+// Push force-pushes branches to remote and records
+// remote as their upstream.
+func (b *Backend) Push(
+	dir string,
+	remote string,
+	branches []string,
+) error {
+	const errCtx = "pushing with go-git"
+
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	specs := make([]config.RefSpec, 0, len(branches))
+
+	for _, br := range branches {
+		ref := plumbing.NewBranchReferenceName(br)
+		specs = append(specs, config.RefSpec(
+			"+"+ref.String()+":"+ref.String(),
+		))
+	}
+
+	err = repo.Push(&gg.PushOptions{
+		RemoteName: remote,
+		RefSpecs:   specs,
+		Auth:       b.Auth,
+		Force:      true,
+	})
+	if err != nil &&
+		!errors.Is(err, gg.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	for _, br := range branches {
+		cfg.Branches[br] = &config.Branch{
+			Name:   br,
+			Remote: remote,
+			Merge:  plumbing.NewBranchReferenceName(br),
+		}
+	}
+
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// ReadFiles resolves ref and walks the tree of its
+// commit.
+func (b *Backend) ReadFiles(
+	dir string,
+	ref string,
+	paths []string,
+) (map[string][]byte, error) {
+	const errCtx = "reading files with go-git"
+
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s: resolve %s: %w", errCtx, ref, err,
+		)
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	files := make(map[string][]byte)
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if !underPaths(f.Name, paths) {
+			return nil
+		}
+
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		files[f.Name] = []byte(content)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return files, nil
+}
+
+// ReadCommit resolves ref and reads its commit.
+func (b *Backend) ReadCommit(
+	dir string,
+	ref string,
+) (git.CommitInfo, error) {
+	const errCtx = "reading commit with go-git"
+
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return git.CommitInfo{}, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return git.CommitInfo{}, fmt.Errorf(
+			"%s: resolve %s: %w", errCtx, ref, err,
+		)
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return git.CommitInfo{}, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return git.CommitInfo{
+		Hash:    commit.Hash.String(),
+		Message: commit.Message,
+	}, nil
+}
+
+// signature builds the commit author from the
+// configured name and email.
+func (b *Backend) signature() *object.Signature {
+	return &object.Signature{
+		Name:  b.AuthorName,
+		Email: b.AuthorEmail,
+		When:  time.Now(),
+	}
+}
+
+// startPoint returns the commit a new local branch
+// starts from: the remote-tracking branch of the same
+// name when one exists (reported by the boolean), or
+// primaryBranch.
+func startPoint(
+	repo *gg.Repository,
+	branch string,
+	primaryBranch string,
+) (plumbing.Hash, bool, error) {
+	remotes, err := repo.Remotes()
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	for _, rm := range remotes {
+		ref, err := repo.Reference(
+			plumbing.NewRemoteReferenceName(
+				rm.Config().Name, branch,
+			),
+			true,
+		)
+		if err == nil {
+			return ref.Hash(), true, nil
+		}
+	}
+
+	primary, err := repo.Reference(
+		plumbing.NewBranchReferenceName(primaryBranch),
+		true,
+	)
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf(
+			"resolving %s: %w", primaryBranch, err,
+		)
+	}
+
+	return primary.Hash(), false, nil
+}
+
+// checkout switches the worktree of repo to branch,
+// keeping the sparse-checkout restriction recorded in
+// the repository.
+func checkout(
+	repo *gg.Repository,
+	dir string,
+	branch plumbing.ReferenceName,
+	force bool,
+) error {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	dirs, err := readSparsePaths(dir)
+	if err != nil {
+		return err
+	}
+
+	return wt.Checkout(&gg.CheckoutOptions{
+		Branch:                    branch,
+		Force:                     force,
+		SparseCheckoutDirectories: dirs,
+	})
+}
+
+// openWorktree opens the repository at dir and its
+// worktree.
+func openWorktree(
+	dir string,
+) (*gg.Repository, *gg.Worktree, error) {
+	repo, err := gg.PlainOpen(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return repo, wt, nil
+}
+
+// hasStagedChanges reports whether any status entry
+// has a staged change.
+func hasStagedChanges(status gg.Status) bool {
+	for _, st := range status {
+		if st.Staging != gg.Unmodified &&
+			st.Staging != gg.Untracked {
+			return true
+		}
+	}
+
+	return false
+}
+
+// underPaths reports whether name is one of paths or
+// below one of them. Empty paths match every name.
+func underPaths(name string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+
+	for _, pa := range paths {
+		if name == pa || strings.HasPrefix(name, pa+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsRefSpec reports whether specs contains spec.
+func containsRefSpec(
+	specs []config.RefSpec,
+	spec config.RefSpec,
+) bool {
+	for _, sp := range specs {
+		if sp == spec {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeSparsePaths enables cone-mode sparse checkout in
+// the repository configuration and writes the pattern
+// file the git CLI would write for paths.
+func writeSparsePaths(
+	repo *gg.Repository,
+	dir string,
+	paths []string,
+) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.Raw.Section("core").
+		SetOption("sparseCheckout", "true").
+		SetOption("sparseCheckoutCone", "true")
+
+	if err := repo.SetConfig(cfg); err != nil {
+		return err
+	}
+
+	fp := filepath.Join(dir, ".git", sparseCheckoutFile)
+	if err := os.MkdirAll(
+		filepath.Dir(fp), 0o750,
+	); err != nil {
+		return err
+	}
+
+	//nolint:gosec // pattern file is not secret
+	return os.WriteFile(
+		fp, []byte(conePatterns(paths)), 0o644,
+	)
+}
+
+// readSparsePaths returns the directories of the
+// cone-mode pattern file of dir, or nil when sparse
+// checkout is not in use.
+func readSparsePaths(dir string) ([]string, error) {
+	fp := filepath.Join(dir, ".git", sparseCheckoutFile)
+
+	data, err := os.ReadFile(fp) //nolint:gosec // path derived from the clone dir
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "/") ||
+			!strings.HasSuffix(line, "/") ||
+			strings.HasSuffix(line, "*/") {
+			continue
+		}
+
+		dirs = append(dirs, strings.Trim(line, "/"))
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return leafDirs(dirs), nil
+}
+
+// conePatterns renders the cone-mode pattern file for
+// paths: root files, each parent directory without its
+// subdirectories, and each path recursively.
+func conePatterns(paths []string) string {
+	var sb strings.Builder
+
+	sb.WriteString("/*\n!/*/\n")
+
+	seen := make(map[string]struct{})
+
+	for _, pa := range paths {
+		parts := strings.Split(pa, "/")
+
+		for i := 1; i < len(parts); i++ {
+			parent := strings.Join(parts[:i], "/")
+			if _, ok := seen[parent]; ok {
+				continue
+			}
+
+			seen[parent] = struct{}{}
+
+			fmt.Fprintf(&sb, "/%s/\n!/%s/*/\n", parent, parent)
+		}
+
+		fmt.Fprintf(&sb, "/%s/\n", pa)
+	}
+
+	return sb.String()
+}
+
+// leafDirs drops the directories that are parents of
+// another entry, leaving the recursively included ones.
+func leafDirs(dirs []string) []string {
+	var leaves []string
+
+	for _, dr := range dirs {
+		parent := false
+
+		for _, other := range dirs {
+			if strings.HasPrefix(other, dr+"/") {
+				parent = true
+
+				break
+			}
+		}
+
+		if !parent {
+			leaves = append(leaves, dr)
+		}
+	}
+
+	return leaves
+}
@@ -0,0 +1,324 @@
+package gogit_test
+
+import (
+	"os"
+	oe "os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gg "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	gitbackend "github.com/byte4ever/rules_gitops/gitops/git/gogit"
+)
+
+func TestMain(m *testing.M) {
+	// Serve file:// remotes in process so the go-git
+	// backend is exercised without a git binary.
+	client.InstallProtocol(
+		"file", server.NewClient(server.DefaultLoader),
+	)
+
+	os.Exit(m.Run())
+}
+
+func TestBackends_againstBareRepository(t *testing.T) {
+	t.Parallel()
+
+	backends := []struct {
+		name    string
+		backend git.Backend
+		needGit bool
+	}{
+		{
+			name:    "cli",
+			backend: git.CLIBackend{},
+			needGit: true,
+		},
+		{
+			name: "go-git",
+			backend: &gitbackend.Backend{
+				AuthorName:  "Test",
+				AuthorEmail: "test@test.com",
+			},
+		},
+	}
+
+	for _, tt := range backends {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := oe.LookPath("git"); tt.needGit &&
+				err != nil {
+				t.Skip("git binary not available")
+			}
+
+			bare := initBareRepo(t)
+			dir := filepath.Join(t.TempDir(), "clone")
+
+			rp, err := git.CloneWith(
+				tt.backend,
+				"file://"+bare, dir, "", "main",
+				"cloud/dev",
+			)
+			require.NoError(t, err)
+
+			setIdentity(t, dir, tt.needGit)
+
+			assert.FileExists(
+				t, filepath.Join(dir, "cloud/dev/app.yaml"),
+			)
+			assert.NoFileExists(
+				t, filepath.Join(dir, "cloud/prod/app.yaml"),
+			)
+			assert.True(t, rp.IsClean())
+
+			// Existing deployment branches are
+			// checked out from the remote.
+			rp.Fetch("deploy/*")
+			assert.False(
+				t, rp.SwitchToBranch("deploy/dev", "main"),
+			)
+			assert.Equal(
+				t, "deployed\n",
+				readFile(t, dir, "cloud/dev/app.yaml"),
+			)
+
+			// New deployment branches start from the
+			// primary branch.
+			assert.True(
+				t, rp.SwitchToBranch("deploy/new", "main"),
+			)
+			assert.Equal(
+				t, "v1\n",
+				readFile(t, dir, "cloud/dev/app.yaml"),
+			)
+
+			writeFile(t, dir, "cloud/dev/app.yaml", "v2\n")
+			assert.Equal(
+				t,
+				[]string{"cloud/dev/app.yaml"},
+				rp.GetChangedFiles(),
+			)
+
+			rp.RestoreFile("cloud/dev/app.yaml")
+			assert.Empty(t, rp.GetChangedFiles())
+
+			writeFile(t, dir, "cloud/dev/new.yaml", "new\n")
+			require.True(
+				t, rp.Commit("add new\n", "cloud/dev"),
+			)
+			assert.False(t, rp.Commit("noop\n", "cloud/dev"))
+			assert.Contains(
+				t, rp.GetLastCommitMessage(), "add new",
+			)
+
+			rp.Push([]string{"deploy/new"})
+
+			// Files are read from any ref, outside the
+			// sparse checkout too.
+			files, err := rp.ReadFiles("origin/main", "cloud")
+			require.NoError(t, err)
+			assert.Equal(t, map[string][]byte{
+				"cloud/dev/app.yaml":  []byte("v1\n"),
+				"cloud/prod/app.yaml": []byte("v1\n"),
+			}, files)
+
+			files, err = rp.ReadFiles("deploy/new", "cloud/dev")
+			require.NoError(t, err)
+			assert.Len(t, files, 2)
+			assert.Equal(
+				t, []byte("new\n"), files["cloud/dev/new.yaml"],
+			)
+
+			info, err := rp.ReadCommit("deploy/new")
+			require.NoError(t, err)
+			assert.Len(t, info.Hash, 40)
+			assert.Equal(t, "add new\n", info.Message)
+
+			remote, err := gg.PlainOpen(bare)
+			require.NoError(t, err)
+
+			pushed, err := remote.Reference(
+				plumbing.NewBranchReferenceName(
+					"deploy/new",
+				),
+				true,
+			)
+			require.NoError(t, err)
+
+			commit, err := remote.CommitObject(
+				pushed.Hash(),
+			)
+			require.NoError(t, err)
+			assert.Contains(t, commit.Message, "add new")
+
+			rp.RecreateBranch("deploy/new", "main")
+			assert.NoFileExists(
+				t, filepath.Join(dir, "cloud/dev/new.yaml"),
+			)
+			assert.True(t, rp.IsClean())
+		})
+	}
+}
+
+// initBareRepo creates a bare repository whose main
+// branch holds cloud/dev and cloud/prod manifests and
+// whose deploy/dev branch changes the dev manifest.
+func initBareRepo(tb testing.TB) string {
+	tb.Helper()
+
+	bare := filepath.Join(tb.TempDir(), "remote.git")
+	work := tb.TempDir()
+
+	_, err := gg.PlainInitWithOptions(
+		bare, &gg.PlainInitOptions{
+			InitOptions: gg.InitOptions{
+				DefaultBranch: plumbing.Main,
+			},
+			Bare: true,
+		},
+	)
+	require.NoError(tb, err)
+
+	repo, err := gg.PlainInitWithOptions(
+		work, &gg.PlainInitOptions{
+			InitOptions: gg.InitOptions{
+				DefaultBranch: plumbing.Main,
+			},
+		},
+	)
+	require.NoError(tb, err)
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{bare},
+	})
+	require.NoError(tb, err)
+
+	wt, err := repo.Worktree()
+	require.NoError(tb, err)
+
+	writeFile(tb, work, "cloud/dev/app.yaml", "v1\n")
+	writeFile(tb, work, "cloud/prod/app.yaml", "v1\n")
+	commitAll(tb, wt, "initial")
+
+	require.NoError(tb, wt.Checkout(&gg.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(
+			"deploy/dev",
+		),
+		Create: true,
+	}))
+	writeFile(tb, work, "cloud/dev/app.yaml", "deployed\n")
+	commitAll(tb, wt, "deploy dev")
+
+	require.NoError(tb, repo.Push(&gg.PushOptions{
+		RemoteName: "origin",
+		RefSpecs: []config.RefSpec{
+			"refs/heads/*:refs/heads/*",
+		},
+	}))
+
+	return bare
+}
+
+// commitAll stages and commits every change in wt.
+func commitAll(
+	tb testing.TB,
+	wt *gg.Worktree,
+	msg string,
+) {
+	tb.Helper()
+
+	require.NoError(tb, wt.AddWithOptions(
+		&gg.AddOptions{All: true},
+	))
+
+	_, err := wt.Commit(msg, &gg.CommitOptions{
+		Author: &object.Signature{
+			Name:  "Test",
+			Email: "test@test.com",
+			When:  time.Now(),
+		},
+	})
+	require.NoError(tb, err)
+}
+
+// setIdentity configures the commit author of the
+// clone at dir, with the git CLI when useCLI is set.
+// Clones made by the CLI use repository extensions
+// that go-git cannot open.
+func setIdentity(tb testing.TB, dir string, useCLI bool) {
+	tb.Helper()
+
+	if useCLI {
+		for _, kv := range [][2]string{
+			{"user.name", "Test"},
+			{"user.email", "test@test.com"},
+		} {
+			//nolint:gosec // test helper
+			cmd := oe.CommandContext(
+				tb.Context(),
+				"git", "config", kv[0], kv[1],
+			)
+			cmd.Dir = dir
+
+			out, err := cmd.CombinedOutput()
+			require.NoError(tb, err, string(out))
+		}
+
+		return
+	}
+
+	repo, err := gg.PlainOpen(dir)
+	require.NoError(tb, err)
+
+	cfg, err := repo.Config()
+	require.NoError(tb, err)
+
+	cfg.User.Name = "Test"
+	cfg.User.Email = "test@test.com"
+
+	require.NoError(tb, repo.SetConfig(cfg))
+}
+
+// writeFile creates name below dir with content.
+func writeFile(
+	tb testing.TB,
+	dir string,
+	name string,
+	content string,
+) {
+	tb.Helper()
+
+	fp := filepath.Join(dir, name)
+
+	require.NoError(tb, os.MkdirAll(
+		filepath.Dir(fp), 0o750,
+	))
+	require.NoError(tb, os.WriteFile(
+		fp, []byte(content), 0o600,
+	))
+}
+
+// readFile returns the content of name below dir.
+func readFile(
+	tb testing.TB,
+	dir string,
+	name string,
+) string {
+	tb.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(tb, err)
+
+	return string(data)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package git
+
+import "errors"
+
+// lockFile is not supported on this platform.
+func lockFile(string) (func() error, error) {
+	return nil, errors.New(
+		"locking file: not supported on this platform",
+	)
+}
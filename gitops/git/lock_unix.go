@@ -0,0 +1,51 @@
+//go:build unix
+
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on path,
+// blocking until it is available. The returned
+// function releases the lock.
+func lockFile(path string) (func() error, error) {
+	const errCtx = "locking file"
+
+	fi, err := os.OpenFile( //nolint:gosec // path is built from the cache dir
+		path, os.O_CREATE|os.O_RDWR, 0o600,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	//nolint:gosec // file descriptors fit in an int
+	if err := syscall.Flock(
+		int(fi.Fd()), syscall.LOCK_EX,
+	); err != nil {
+		return nil, errors.Join(
+			fmt.Errorf("%s: %w", errCtx, err),
+			fi.Close(),
+		)
+	}
+
+	return func() error {
+		//nolint:gosec // file descriptors fit in an int
+		unlockErr := syscall.Flock(
+			int(fi.Fd()), syscall.LOCK_UN,
+		)
+
+		if err := errors.Join(
+			unlockErr, fi.Close(),
+		); err != nil {
+			return fmt.Errorf(
+				"unlocking file: %w", err,
+			)
+		}
+
+		return nil
+	}, nil
+}
@@ -1,6 +1,9 @@
 package git
 
-import "context"
+import (
+	"context"
+	"log/slog"
+)
 
 // Pattern: Strategy -- swap git platform without
 // changing PR creation logic.
@@ -43,3 +46,63 @@ func (f GitProviderFunc) CreatePR(
 
 	return f(ctx, from, to, title, body)
 }
+
+// PROptions holds optional pull request metadata.
+type PROptions struct {
+	// Reviewers lists the user names, or
+	// "org/team" team slugs where the platform
+	// supports them, asked to review.
+	Reviewers []string
+	// Labels lists the labels applied to the pull
+	// request.
+	Labels []string
+}
+
+// IsZero reports whether no option is set.
+func (o PROptions) IsZero() bool {
+	return len(o.Reviewers) == 0 && len(o.Labels) == 0
+}
+
+// OptionsProvider is implemented by providers that can
+// request reviewers and apply labels when creating a
+// pull request.
+type OptionsProvider interface {
+	GitProvider
+	CreatePRWithOptions(
+		ctx context.Context,
+		from string,
+		to string,
+		title string,
+		body string,
+		opts PROptions,
+	) error
+}
+
+// CreatePR creates a pull request through p, passing
+// opts when p implements OptionsProvider. Other
+// providers ignore opts with a warning.
+func CreatePR(
+	ctx context.Context,
+	p GitProvider,
+	from string,
+	to string,
+	title string,
+	body string,
+	opts PROptions,
+) error {
+	if op, ok := p.(OptionsProvider); ok {
+		return op.CreatePRWithOptions(
+			ctx, from, to, title, body, opts,
+		)
+	}
+
+	if !opts.IsZero() {
+		slog.Warn(
+			"git provider does not support reviewers "+
+				"and labels, ignoring them",
+			"branch", from,
+		)
+	}
+
+	return p.CreatePR(ctx, from, to, title, body)
+}
@@ -117,3 +117,60 @@ func TestGitProviderFunc_CreatePR_returns_error(
 
 	assert.ErrorIs(t, err, errTest)
 }
+
+// optionsProvider records the options passed to
+// CreatePRWithOptions.
+type optionsProvider struct {
+	git.GitProviderFunc
+
+	got git.PROptions
+}
+
+func (p *optionsProvider) CreatePRWithOptions(
+	_ context.Context,
+	_ string,
+	_ string,
+	_ string,
+	_ string,
+	opts git.PROptions,
+) error {
+	p.got = opts
+
+	return nil
+}
+
+func TestCreatePR_passes_options(t *testing.T) {
+	t.Parallel()
+
+	opts := git.PROptions{
+		Reviewers: []string{"alice", "org/sre"},
+		Labels:    []string{"prod"},
+	}
+
+	op := &optionsProvider{}
+
+	require.NoError(t, git.CreatePR(
+		context.Background(), op, "a", "b", "t", "d", opts,
+	))
+	assert.Equal(t, opts, op.got)
+
+	called := false
+	fn := git.GitProviderFunc(
+		func(
+			_ context.Context,
+			_ string,
+			_ string,
+			_ string,
+			_ string,
+		) error {
+			called = true
+
+			return nil
+		},
+	)
+
+	require.NoError(t, git.CreatePR(
+		context.Background(), fn, "a", "b", "t", "d", opts,
+	))
+	assert.True(t, called)
+}
@@ -1,16 +1,13 @@
 package git
 
 import (
-	"bufio"
-	"context"
 	"fmt"
 	"log/slog"
 	"os"
-	oe "os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
-
-	"github.com/byte4ever/rules_gitops/gitops/exec"
 )
 
 // Repo is a local clone of a git repository. Create
@@ -20,22 +17,46 @@ type Repo struct {
 	Dir string
 	// RemoteName is the name of the upstream remote.
 	RemoteName string
+	// Backend performs the repository operations.
+	// Nil means CLIBackend.
+	Backend Backend
+
+	// release removes a cached worktree and unlocks
+	// its cache. Nil for plain clones.
+	release func() error
 }
 
-// Clone clones a repository into dir. Pass the full
-// repository URL as repo (e.g.
+// Clone clones a repository into dir with the git CLI.
+// Pass the full repository URL as repo (e.g.
 // "https://github.com/org/repo.git"). mirrorDir is an
 // optional local mirror used as a reference clone. When
-// gitopsPath is non-root only that subtree is checked
-// out via sparse-checkout.
-//
-//nolint:gosec // file paths originate from CLI flags
+// every gitopsPaths entry is non-root only those
+// subtrees are checked out via cone-mode
+// sparse-checkout.
 func Clone(
 	repo string,
 	dir string,
 	mirrorDir string,
 	primaryBranch string,
-	gitopsPath string,
+	gitopsPaths ...string,
+) (*Repo, error) {
+	return CloneWith(
+		CLIBackend{},
+		repo, dir, mirrorDir, primaryBranch,
+		gitopsPaths...,
+	)
+}
+
+// CloneWith is like Clone but performs the clone, and
+// every later operation on the returned Repo, with
+// backend.
+func CloneWith(
+	backend Backend,
+	repo string,
+	dir string,
+	mirrorDir string,
+	primaryBranch string,
+	gitopsPaths ...string,
 ) (*Repo, error) {
 	const errCtx = "cloning repository"
 
@@ -47,63 +68,38 @@ func Clone(
 
 	remoteName := "origin"
 
-	args := []string{
-		"clone",
-		"--no-checkout",
-		"--single-branch",
-		"--branch", primaryBranch,
-		"--filter=blob:none",
-		"--no-tags",
-		"--origin", remoteName,
+	if err := backend.Clone(CloneOptions{
+		URL:           repo,
+		Dir:           dir,
+		MirrorDir:     mirrorDir,
+		PrimaryBranch: primaryBranch,
+		RemoteName:    remoteName,
+		SparsePaths:   sparsePaths(gitopsPaths),
+	}); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
 	}
 
-	if mirrorDir != "" {
-		args = append(args, "--reference", mirrorDir)
-	}
-
-	args = append(args, repo, dir)
-	exec.MustEx("", "git", args...)
-
-	// Enable sparse-checkout when restricting to a
-	// subdirectory.
-	if !isRootPath(gitopsPath) {
-		exec.MustEx(
-			dir, "git",
-			"config", "--local",
-			"core.sparsecheckout", "true",
-		)
-
-		genPath := fmt.Sprintf("%s/\n", gitopsPath)
-		sparsePath := filepath.Join(
-			dir, ".git", "info", "sparse-checkout",
-		)
-
-		//nolint:gosec // mode 0644 is intentional
-		err := os.WriteFile(
-			sparsePath,
-			[]byte(genPath),
-			0o644,
-		)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"%s: write sparse-checkout: %w",
-				errCtx, err,
-			)
-		}
-	}
-
-	exec.MustEx(dir, "git", "checkout", primaryBranch)
-
 	return &Repo{
 		Dir:        dir,
 		RemoteName: remoteName,
+		Backend:    backend,
 	}, nil
 }
 
-// Clean removes the local clone directory.
+// Clean removes the local clone directory. For a Repo
+// obtained from Cache.Checkout it removes the worktree
+// and releases the cache lock instead.
 func (r *Repo) Clean() error {
 	const errCtx = "cleaning repository"
 
+	if r.release != nil {
+		if err := r.release(); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		return nil
+	}
+
 	if err := os.RemoveAll(r.Dir); err != nil {
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
@@ -114,17 +110,19 @@ func (r *Repo) Clean() error {
 // Fetch adds the given pattern to tracked remote
 // branches and fetches them.
 func (r *Repo) Fetch(pattern string) {
-	exec.MustEx(
-		r.Dir, "git",
-		"remote", "set-branches", "--add",
-		r.RemoteName, pattern,
-	)
-	exec.MustEx(
-		r.Dir, "git",
-		"fetch", "--force",
-		"--filter=blob:none", "--no-tags",
-		r.RemoteName,
-	)
+	mustSucceed(r.TryFetch(pattern))
+}
+
+// TryFetch is like Fetch but returns the error, e.g.
+// when pattern names a branch missing from the remote.
+func (r *Repo) TryFetch(pattern string) error {
+	if err := r.backend().Fetch(
+		r.Dir, r.RemoteName, pattern,
+	); err != nil {
+		return fmt.Errorf("fetching %s: %w", pattern, err)
+	}
+
+	return nil
 }
 
 // SwitchToBranch switches to branch, creating it from
@@ -134,23 +132,12 @@ func (r *Repo) SwitchToBranch(
 	branch string,
 	primaryBranch string,
 ) bool {
-	if _, err := exec.Ex(
-		r.Dir, "git", "checkout", branch,
-	); err != nil {
-		// Branch does not exist yet: create and
-		// check out.
-		exec.MustEx(
-			r.Dir, "git",
-			"branch", branch, primaryBranch,
-		)
-		exec.MustEx(
-			r.Dir, "git", "checkout", branch,
-		)
-
-		return true
-	}
+	created, err := r.backend().SwitchToBranch(
+		r.Dir, branch, primaryBranch,
+	)
+	mustSucceed(err)
 
-	return false
+	return created
 }
 
 // RecreateBranch discards the content of branch and
@@ -159,23 +146,16 @@ func (r *Repo) RecreateBranch(
 	branch string,
 	primaryBranch string,
 ) {
-	exec.MustEx(
-		r.Dir, "git", "checkout", primaryBranch,
-	)
-	exec.MustEx(
-		r.Dir, "git",
-		"branch", "-f", branch, primaryBranch,
-	)
-	exec.MustEx(r.Dir, "git", "checkout", branch)
+	mustSucceed(r.backend().RecreateBranch(
+		r.Dir, branch, primaryBranch,
+	))
 }
 
 // GetLastCommitMessage returns the most recent commit
 // message on the current branch. Returns empty string
 // on error.
 func (r *Repo) GetLastCommitMessage() string {
-	msg, err := exec.Ex(
-		r.Dir, "git", "log", "-1", "--pretty=%B",
-	)
+	msg, err := r.backend().LastCommitMessage(r.Dir)
 	if err != nil {
 		return ""
 	}
@@ -183,44 +163,33 @@ func (r *Repo) GetLastCommitMessage() string {
 	return msg
 }
 
-// Commit stages all changes under gitopsPath and
-// commits them. Returns true when changes were
-// committed, false when the tree was clean.
+// Commit stages all changes under gitopsPaths and
+// commits them. Only those paths are staged unless one
+// of them is the repository root. Returns true when
+// changes were committed, false when nothing was
+// staged.
 func (r *Repo) Commit(
 	message string,
-	gitopsPath string,
+	gitopsPaths ...string,
 ) bool {
-	if isRootPath(gitopsPath) {
-		exec.MustEx(r.Dir, "git", "add", ".")
-	} else {
-		exec.MustEx(r.Dir, "git", "add", gitopsPath)
-	}
-
-	if r.IsClean() {
-		return false
-	}
-
-	exec.MustEx(
-		r.Dir, "git", "commit", "-a", "-m", message,
+	committed, err := r.backend().Commit(
+		r.Dir, message, sparsePaths(gitopsPaths),
 	)
+	mustSucceed(err)
 
-	return true
+	return committed
 }
 
 // RestoreFile restores the specified file to its
 // last-committed state.
 func (r *Repo) RestoreFile(fileName string) {
-	exec.MustEx(
-		r.Dir, "git", "checkout", "--", fileName,
-	)
+	mustSucceed(r.backend().RestoreFile(r.Dir, fileName))
 }
 
 // GetChangedFiles returns file paths that differ from
 // the index (unstaged changes).
 func (r *Repo) GetChangedFiles() []string {
-	out, err := exec.Ex(
-		r.Dir, "git", "diff", "--name-only",
-	)
+	files, err := r.backend().ChangedFiles(r.Dir)
 	if err != nil {
 		slog.Error(
 			"failed to get changed files",
@@ -230,36 +199,13 @@ func (r *Repo) GetChangedFiles() []string {
 		return nil
 	}
 
-	var files []string
-
-	sc := bufio.NewScanner(strings.NewReader(out))
-	for sc.Scan() {
-		files = append(files, sc.Text())
-	}
-
-	if err := sc.Err(); err != nil {
-		slog.Error(
-			"failed to scan changed files",
-			"error", err,
-		)
-
-		return nil
-	}
-
 	return files
 }
 
 // IsClean reports whether the working tree has no
 // uncommitted changes.
 func (r *Repo) IsClean() bool {
-	//nolint:gosec // args are constants
-	cmd := oe.CommandContext(
-		context.Background(),
-		"git", "status", "--porcelain",
-	)
-	cmd.Dir = r.Dir
-
-	by, err := cmd.CombinedOutput()
+	clean, err := r.backend().IsClean(r.Dir)
 	if err != nil {
 		slog.Error(
 			"failed to check repo status",
@@ -269,20 +215,72 @@ func (r *Repo) IsClean() bool {
 		return false
 	}
 
-	return len(by) == 0
+	return clean
 }
 
 // Push force-pushes the given branches to the remote.
 // All changes should be committed before calling Push.
 func (r *Repo) Push(branches []string) {
-	args := append(
-		[]string{
-			"push", r.RemoteName,
-			"-f", "--set-upstream",
-		},
-		branches...,
+	mustSucceed(r.backend().Push(
+		r.Dir, r.RemoteName, branches,
+	))
+}
+
+// ReadFiles returns the content of the files below
+// gitopsPaths at ref, a branch, remote-tracking branch
+// or commit. The whole tree is read when no path is
+// given or one of them is the root. Keys are
+// slash-separated paths relative to the repository
+// root.
+func (r *Repo) ReadFiles(
+	ref string,
+	gitopsPaths ...string,
+) (map[string][]byte, error) {
+	const errCtx = "reading files"
+
+	files, err := r.backend().ReadFiles(
+		r.Dir, ref, sparsePaths(gitopsPaths),
 	)
-	exec.MustEx(r.Dir, "git", args...)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s at %s: %w", errCtx, ref, err,
+		)
+	}
+
+	return files, nil
+}
+
+// ReadCommit returns the hash and message of the
+// commit ref resolves to, e.g. "origin/deploy/dev".
+func (r *Repo) ReadCommit(ref string) (CommitInfo, error) {
+	const errCtx = "reading commit"
+
+	info, err := r.backend().ReadCommit(r.Dir, ref)
+	if err != nil {
+		return CommitInfo{}, fmt.Errorf(
+			"%s %s: %w", errCtx, ref, err,
+		)
+	}
+
+	return info, nil
+}
+
+// backend returns the configured Backend, defaulting
+// to the git CLI.
+func (r *Repo) backend() Backend {
+	if r.Backend == nil {
+		return CLIBackend{}
+	}
+
+	return r.Backend
+}
+
+// mustSucceed panics when a backend operation failed,
+// matching exec.MustEx for callers that cannot recover.
+func mustSucceed(err error) {
+	if err != nil {
+		panic(fmt.Sprintf("git operation failed: %v", err))
+	}
 }
 
 // isRootPath reports whether gitopsPath refers to the
@@ -290,3 +288,37 @@ func (r *Repo) Push(branches []string) {
 func isRootPath(gitopsPath string) bool {
 	return gitopsPath == "" || gitopsPath == "."
 }
+
+// sparsePaths normalises gitopsPaths for sparse-checkout
+// and staging. It returns nil when no path is given or
+// when any path is the repository root, meaning the
+// whole tree is used. Otherwise it returns the cleaned,
+// deduplicated and sorted paths.
+func sparsePaths(gitopsPaths []string) []string {
+	if len(gitopsPaths) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(gitopsPaths))
+
+	var paths []string
+
+	for _, pa := range gitopsPaths {
+		pa = strings.Trim(filepath.ToSlash(pa), "/")
+		if isRootPath(pa) {
+			return nil
+		}
+
+		pa = path.Clean(pa)
+		if _, ok := seen[pa]; ok {
+			continue
+		}
+
+		seen[pa] = struct{}{}
+		paths = append(paths, pa)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
@@ -53,6 +53,119 @@ func TestIsRootPath(t *testing.T) {
 	}
 }
 
+func TestSparsePaths(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{
+			name:  "no paths is whole tree",
+			paths: nil,
+			want:  nil,
+		},
+		{
+			name:  "root path is whole tree",
+			paths: []string{"cloud/dev", "."},
+			want:  nil,
+		},
+		{
+			name: "sorted and deduplicated",
+			paths: []string{
+				"cloud/prod",
+				"clusters/shared/",
+				"./cloud/dev",
+				"cloud/prod",
+			},
+			want: []string{
+				"cloud/dev",
+				"cloud/prod",
+				"clusters/shared",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := git.SparsePathsForTest(tt.paths)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClone_sparseMultiplePaths(t *testing.T) {
+	t.Parallel()
+
+	remote := initRemoteRepo(
+		t,
+		"cloud/dev/app.yaml",
+		"cloud/prod/app.yaml",
+		"clusters/shared/ns.yaml",
+		"other/readme.txt",
+	)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+
+	rp, err := git.Clone(
+		remote, dir, "", "main",
+		"cloud/dev", "clusters/shared",
+	)
+	require.NoError(t, err)
+
+	assert.FileExists(
+		t, filepath.Join(dir, "cloud/dev/app.yaml"),
+	)
+	assert.FileExists(
+		t,
+		filepath.Join(dir, "clusters/shared/ns.yaml"),
+	)
+	assert.NoFileExists(
+		t, filepath.Join(dir, "cloud/prod/app.yaml"),
+	)
+	assert.NoFileExists(
+		t, filepath.Join(dir, "other/readme.txt"),
+	)
+	assert.Equal(t, "origin", rp.RemoteName)
+}
+
+func TestRepo_Commit_stagesOnlyPaths(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	initGitRepo(t, dir)
+
+	for _, fn := range []string{
+		"cloud/dev/app.yaml",
+		"cloud/prod/app.yaml",
+		"outside.txt",
+	} {
+		fp := filepath.Join(dir, fn)
+		require.NoError(
+			t, os.MkdirAll(filepath.Dir(fp), 0o750),
+		)
+		require.NoError(
+			t, os.WriteFile(fp, []byte("x\n"), 0o600),
+		)
+	}
+
+	rp := &git.Repo{Dir: dir, RemoteName: "origin"}
+
+	committed := rp.Commit(
+		"deploy", "cloud/dev", "cloud/prod", "missing",
+	)
+	require.True(t, committed)
+
+	// The file outside the gitops paths stays
+	// untracked.
+	assert.False(t, rp.IsClean())
+	assert.False(t, rp.Commit("again", "cloud/dev"))
+}
+
 func TestRepo_IsClean(t *testing.T) {
 	t.Parallel()
 
@@ -232,3 +345,41 @@ func gitCmd(
 		)
 	}
 }
+
+// initRemoteRepo creates a bare repository whose main
+// branch contains the given files and returns its
+// path.
+func initRemoteRepo(
+	tb testing.TB,
+	files ...string,
+) string {
+	tb.Helper()
+
+	work := tb.TempDir()
+
+	initGitRepo(tb, work)
+
+	for _, fn := range files {
+		fp := filepath.Join(work, fn)
+
+		err := os.MkdirAll(filepath.Dir(fp), 0o750)
+		if err != nil {
+			tb.Fatalf("mkdir %s: %v", fp, err)
+		}
+
+		err = os.WriteFile(fp, []byte(fn+"\n"), 0o600)
+		if err != nil {
+			tb.Fatalf("write %s: %v", fp, err)
+		}
+	}
+
+	gitCmd(tb, work, "add", ".")
+	gitCmd(tb, work, "commit", "-m", "add files")
+
+	bare := filepath.Join(tb.TempDir(), "remote.git")
+	gitCmd(
+		tb, work, "clone", "--bare", work, bare,
+	)
+
+	return bare
+}
@@ -0,0 +1,168 @@
+package cmdflag
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/git/gogit"
+	"github.com/byte4ever/rules_gitops/gitops/secret"
+)
+
+// Slice implements flag.Value for multi-value string
+// flags (repeated --flag=val usage).
+type Slice []string
+
+// String returns the flag value as a comma-separated
+// string representation.
+func (s *Slice) String() string {
+	if s == nil {
+		return ""
+	}
+
+	return strings.Join(*s, ",")
+}
+
+// Set appends a value to the slice.
+func (s *Slice) Set(val string) error {
+	*s = append(*s, val)
+
+	return nil
+}
+
+// IsList marks the flag as a list for config files.
+func (*Slice) IsList() bool { return true }
+
+// Comma is a repeatable flag whose values may also be
+// comma-separated. Unlike Slice it takes a single
+// string in config files, so a flag can become
+// repeatable without breaking existing files.
+type Comma []string
+
+// String returns the values joined by commas.
+func (c *Comma) String() string {
+	if c == nil {
+		return ""
+	}
+
+	return strings.Join(*c, ",")
+}
+
+// Set appends the comma-separated values of val.
+func (c *Comma) Set(val string) error {
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*c = append(*c, v)
+		}
+	}
+
+	return nil
+}
+
+// GitBackend holds the flags selecting and configuring
+// the git implementation. The author and credentials
+// configure the go-git backend; the cli backend uses
+// the git configuration and credential helpers.
+type GitBackend struct {
+	// Name is "cli" or "go-git".
+	Name string
+
+	// AuthorName and AuthorEmail sign commits.
+	AuthorName  string
+	AuthorEmail string
+
+	// AuthUser and AuthTokenSource authenticate HTTP
+	// fetches and pushes; AuthTokenSource is a
+	// secret.Parse specification.
+	AuthUser        string
+	AuthTokenSource string
+}
+
+// Register defines the flags of g on fs.
+func (g *GitBackend) Register(fs *flag.FlagSet) {
+	fs.StringVar(
+		&g.Name, "git_backend", "cli",
+		"Git implementation: cli (git binary) "+
+			"or go-git (in process)",
+	)
+	fs.StringVar(
+		&g.AuthorName, "git_author_name", "",
+		"go-git: author name of commits (default: "+
+			"user.name of the git configuration)",
+	)
+	fs.StringVar(
+		&g.AuthorEmail, "git_author_email", "",
+		"go-git: author email of commits (default: "+
+			"user.email of the git configuration)",
+	)
+	fs.StringVar(
+		&g.AuthUser, "git_auth_user", "git",
+		"go-git: HTTP user name sent with "+
+			"--git_auth_token_source",
+	)
+	fs.StringVar(
+		&g.AuthTokenSource, "git_auth_token_source", "",
+		"go-git: read the HTTP token or password of "+
+			"fetches and pushes from env:NAME, file:PATH "+
+			"or cmd:COMMAND, re-reading it when it expires",
+	)
+}
+
+// New creates the git.Backend selected by the flags.
+// The token is read once, so that a broken source
+// fails before any clone.
+//
+// Pattern: Factory -- selects git implementation at
+// runtime.
+func (g *GitBackend) New() (git.Backend, error) {
+	const errCtx = "creating git backend"
+
+	switch g.Name {
+	case "cli":
+		if g.AuthorName != "" || g.AuthorEmail != "" ||
+			g.AuthTokenSource != "" {
+			return nil, fmt.Errorf(
+				"%s: --git_author_name, --git_author_email "+
+					"and --git_auth_token_source require "+
+					"--git_backend=go-git; configure git "+
+					"for the cli backend",
+				errCtx,
+			)
+		}
+
+		return git.CLIBackend{}, nil
+	case "go-git":
+		b := &gogit.Backend{
+			AuthorName:  g.AuthorName,
+			AuthorEmail: g.AuthorEmail,
+		}
+
+		if g.AuthTokenSource != "" {
+			src, err := secret.Parse(g.AuthTokenSource)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"%s: --git_auth_token_source: %w",
+					errCtx, err,
+				)
+			}
+
+			cache := secret.NewCache(src, 0)
+			if _, err := cache.Fetch(context.Background()); err != nil {
+				return nil, fmt.Errorf(
+					"%s: --git_auth_token_source: %w",
+					errCtx, err,
+				)
+			}
+
+			b.Auth = &gogit.TokenAuth{User: g.AuthUser, Cache: cache}
+		}
+
+		return b, nil
+	default:
+		return nil, fmt.Errorf(
+			"%s: unknown backend %q", errCtx, g.Name,
+		)
+	}
+}
@@ -0,0 +1,102 @@
+package cmdflag_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/git/gogit"
+	"github.com/byte4ever/rules_gitops/gitops/internal/cmdflag"
+)
+
+func TestSlice_and_Comma(t *testing.T) {
+	t.Parallel()
+
+	var (
+		s cmdflag.Slice
+		c cmdflag.Comma
+	)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&s, "s", "")
+	fs.Var(&c, "c", "")
+
+	require.NoError(t, fs.Parse([]string{
+		"--s=a,b", "--s=c", "--c=x, y", "--c=z,",
+	}))
+	assert.Equal(t, cmdflag.Slice{"a,b", "c"}, s)
+	assert.Equal(t, cmdflag.Comma{"x", "y", "z"}, c)
+	assert.Equal(t, "a,b,c", s.String())
+	assert.True(t, s.IsList())
+}
+
+func TestGitBackend_New(t *testing.T) {
+	t.Parallel()
+
+	var g cmdflag.GitBackend
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	g.Register(fs)
+
+	require.NoError(t, fs.Parse(nil))
+
+	b, err := g.New()
+	require.NoError(t, err)
+	assert.Equal(t, git.CLIBackend{}, b)
+
+	require.NoError(t, fs.Parse([]string{"--git_backend=go-git"}))
+
+	b, err = g.New()
+	require.NoError(t, err)
+	assert.IsType(t, &gogit.Backend{}, b)
+
+	g.Name = "svn"
+
+	_, err = g.New()
+	require.ErrorContains(t, err, `unknown backend "svn"`)
+}
+
+func TestGitBackend_New_authorAndAuth(t *testing.T) {
+	t.Parallel()
+
+	token := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(token, []byte("s3cr3t\n"), 0o600))
+
+	var g cmdflag.GitBackend
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	g.Register(fs)
+
+	require.NoError(t, fs.Parse([]string{
+		"--git_author_name=bot",
+		"--git_author_email=bot@example.com",
+	}))
+
+	_, err := g.New()
+	require.ErrorContains(t, err, "require --git_backend=go-git")
+
+	require.NoError(t, fs.Parse([]string{
+		"--git_backend=go-git",
+		"--git_auth_token_source=file:" + token,
+	}))
+
+	b, err := g.New()
+	require.NoError(t, err)
+	require.IsType(t, &gogit.Backend{}, b)
+
+	gb := b.(*gogit.Backend)
+	assert.Equal(t, "bot", gb.AuthorName)
+	assert.Equal(t, "bot@example.com", gb.AuthorEmail)
+	require.IsType(t, &gogit.TokenAuth{}, gb.Auth)
+	assert.Equal(t, "git", gb.Auth.(*gogit.TokenAuth).User)
+
+	g.AuthTokenSource = "file:" + filepath.Join(t.TempDir(), "missing")
+
+	_, err = g.New()
+	require.ErrorContains(t, err, "--git_auth_token_source")
+}
@@ -0,0 +1,3 @@
+// Package cmdflag holds the command line flags shared by the gitops commands:
+// repeatable list flags and the selection of the git backend.
+package cmdflag
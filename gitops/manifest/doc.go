@@ -0,0 +1,5 @@
+// Package manifest loads rendered Kubernetes manifests. It splits
+// multi-document YAML files into resources identified by apiVersion, kind,
+// namespace and name, and normalises them into JSON compatible values so
+// they can be compared with objects read from a cluster.
+package manifest
@@ -0,0 +1,476 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	json "github.com/goccy/go-json"
+	"github.com/goccy/go-yaml"
+)
+
+// Key identifies a Kubernetes resource. Namespace is
+// empty for cluster-scoped resources and for namespaced
+// resources that rely on the default namespace.
+type Key struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// String formats the key as "Kind namespace/name", or
+// "Kind name" when the namespace is empty.
+func (k Key) String() string {
+	if k.Namespace == "" {
+		return k.Kind + " " + k.Name
+	}
+
+	return k.Kind + " " + k.Namespace + "/" + k.Name
+}
+
+// Resource is a single YAML document of a manifest
+// file.
+type Resource struct {
+	// Key identifies the resource.
+	Key Key
+	// Object is the document decoded into JSON
+	// compatible values: maps, slices, strings,
+	// booleans, json.Number and nil.
+	Object map[string]any
+	// File is the path the document was read from.
+	File string
+	// Index is the zero-based position of the document
+	// in File.
+	Index int
+	// Line is the line of File the document starts on.
+	Line int
+}
+
+// Parse decodes every non-empty document of the
+// multi-document YAML stream in. file is recorded in
+// the returned resources and in error messages.
+func Parse(in io.Reader, file string) ([]Resource, error) {
+	const errCtx = "parsing manifest"
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s: %s: %w", errCtx, file, err,
+		)
+	}
+
+	docs, err := decodeDocuments(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", errCtx, file, err)
+	}
+
+	resources := make([]Resource, 0, len(docs))
+
+	for _, doc := range docs {
+		obj, ok := doc.value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf(
+				"%s: %s: document %d (line %d): "+
+					"not a mapping",
+				errCtx, file, doc.index, doc.line,
+			)
+		}
+
+		key, err := KeyOf(obj)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: %s: document %d (line %d): %w",
+				errCtx, file, doc.index, doc.line, err,
+			)
+		}
+
+		resources = append(resources, Resource{
+			Key:    key,
+			Object: obj,
+			File:   file,
+			Index:  doc.index,
+			Line:   doc.line,
+		})
+	}
+
+	return resources, nil
+}
+
+// Documents decodes every non-empty document of the
+// multi-document YAML data into JSON compatible
+// values, like Resource.Object, whatever their
+// content.
+func Documents(data []byte) ([]any, error) {
+	const errCtx = "decoding documents"
+
+	docs, err := decodeDocuments(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	values := make([]any, len(docs))
+	for i, doc := range docs {
+		values[i] = doc.value
+	}
+
+	return values, nil
+}
+
+// decoded is a non-empty document of a stream decoded
+// into JSON compatible values.
+type decoded struct {
+	value any
+	index int
+	line  int
+}
+
+// decodeDocuments decodes and normalises the
+// non-empty documents of data.
+func decodeDocuments(data []byte) ([]decoded, error) {
+	var docs []decoded
+
+	for index, doc := range splitDocuments(data) {
+		var raw any
+
+		if err := yaml.Unmarshal(doc.data, &raw); err != nil {
+			return nil, fmt.Errorf(
+				"document %d (line %d): %w",
+				index, doc.line, err,
+			)
+		}
+
+		if raw == nil {
+			continue
+		}
+
+		normalised, err := Normalize(raw)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"document %d (line %d): %w",
+				index, doc.line, err,
+			)
+		}
+
+		docs = append(docs, decoded{
+			value: normalised,
+			index: index,
+			line:  doc.line,
+		})
+	}
+
+	return docs, nil
+}
+
+// document is one YAML document of a stream together
+// with the line it starts on.
+type document struct {
+	data []byte
+	line int
+}
+
+// splitDocuments splits a YAML stream on "---"
+// separator lines. Documents are split by hand because
+// the YAML decoder stops at the first empty document.
+func splitDocuments(data []byte) []document {
+	var (
+		docs  []document
+		cur   bytes.Buffer
+		start = 1
+	)
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	for i, line := range lines {
+		if isSeparator(line) {
+			if i > 0 {
+				docs = append(docs, document{
+					data: bytes.Clone(cur.Bytes()),
+					line: start,
+				})
+			}
+
+			cur.Reset()
+
+			start = i + 2
+
+			continue
+		}
+
+		cur.Write(line)
+	}
+
+	return append(docs, document{
+		data: cur.Bytes(),
+		line: start,
+	})
+}
+
+// isSeparator reports whether line is a YAML document
+// separator.
+func isSeparator(line []byte) bool {
+	line = bytes.TrimRight(line, " \t\r\n")
+
+	return bytes.Equal(line, []byte("---")) ||
+		bytes.HasPrefix(line, []byte("--- "))
+}
+
+// ListFiles returns the .yaml and .yml files below
+// the given subdirectories of root, or below root
+// itself when no path is given, sorted and without
+// duplicates. Hidden directories such as .git are
+// skipped. File names are slash-separated and relative
+// to root.
+func ListFiles(root string, paths ...string) ([]string, error) {
+	const errCtx = "listing manifests"
+
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var files []string
+
+	for _, p := range paths {
+		dir := filepath.Join(root, filepath.FromSlash(p))
+
+		err := filepath.WalkDir(
+			dir,
+			func(fp string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if d.IsDir() {
+					if fp != dir &&
+						strings.HasPrefix(d.Name(), ".") {
+						return filepath.SkipDir
+					}
+
+					return nil
+				}
+
+				if !isManifestFile(d.Name()) {
+					return nil
+				}
+
+				rel, err := filepath.Rel(root, fp)
+				if err != nil {
+					return err
+				}
+
+				files = append(files, filepath.ToSlash(rel))
+
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: %s: %w", errCtx, p, err,
+			)
+		}
+	}
+
+	sort.Strings(files)
+
+	return slices.Compact(files), nil
+}
+
+// LoadDir parses every .yaml and .yml file below the
+// given subdirectories of root, or below root itself
+// when no path is given. Hidden directories such as
+// .git are skipped. File names in the returned
+// resources are slash-separated and relative to root.
+func LoadDir(root string, paths ...string) ([]Resource, error) {
+	const errCtx = "loading manifests"
+
+	files, err := ListFiles(root, paths...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var resources []Resource
+
+	for _, name := range files {
+		data, err := os.ReadFile( //nolint:gosec
+			filepath.Join(root, filepath.FromSlash(name)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		parsed, err := Parse(bytes.NewReader(data), name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		resources = append(resources, parsed...)
+	}
+
+	return resources, nil
+}
+
+// ParseFiles parses the .yaml and .yml entries of
+// files, keyed by path, in path order. It parses file
+// contents read from git, where LoadDir parses a
+// directory.
+func ParseFiles(files map[string][]byte) ([]Resource, error) {
+	const errCtx = "parsing manifest files"
+
+	names := make([]string, 0, len(files))
+
+	for name := range files {
+		if isManifestFile(path.Base(name)) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	var resources []Resource
+
+	for _, name := range names {
+		parsed, err := Parse(
+			bytes.NewReader(files[name]), name,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		resources = append(resources, parsed...)
+	}
+
+	return resources, nil
+}
+
+// KeyOf extracts the identifying fields of obj. It
+// fails when apiVersion, kind or metadata.name is
+// missing.
+func KeyOf(obj map[string]any) (Key, error) {
+	const errCtx = "reading resource key"
+
+	key := Key{
+		APIVersion: stringField(obj, "apiVersion"),
+		Kind:       stringField(obj, "kind"),
+	}
+
+	if meta, ok := obj["metadata"].(map[string]any); ok {
+		key.Namespace = stringField(meta, "namespace")
+		key.Name = stringField(meta, "name")
+	}
+
+	switch {
+	case key.APIVersion == "":
+		return Key{}, fmt.Errorf(
+			"%s: missing apiVersion", errCtx,
+		)
+	case key.Kind == "":
+		return Key{}, fmt.Errorf(
+			"%s: missing kind", errCtx,
+		)
+	case key.Name == "":
+		return Key{}, fmt.Errorf(
+			"%s: missing metadata.name in %s",
+			errCtx, key.Kind,
+		)
+	}
+
+	return key, nil
+}
+
+// Normalize converts a decoded YAML or Kubernetes
+// object into JSON compatible values, so that objects
+// from different decoders compare equal. Numbers
+// become json.Number.
+func Normalize(v any) (any, error) {
+	const errCtx = "normalising object"
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var out any
+	if err := decoder.Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return out, nil
+}
+
+// Images returns the values of every string "image"
+// field of the resource, e.g. of containers and init
+// containers, in document order.
+func (r Resource) Images() []string {
+	return appendImages(nil, r.Object)
+}
+
+// appendImages appends the image fields below v.
+func appendImages(out []string, v any) []string {
+	switch o := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if s, ok := o[k].(string); ok && k == "image" {
+				out = append(out, s)
+
+				continue
+			}
+
+			out = appendImages(out, o[k])
+		}
+	case []any:
+		for _, e := range o {
+			out = appendImages(out, e)
+		}
+	}
+
+	return out
+}
+
+// ImageName strips the tag and digest of an image
+// reference.
+func ImageName(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+
+	return ref
+}
+
+// isManifestFile reports whether name has a YAML
+// extension.
+func isManifestFile(name string) bool {
+	switch path.Ext(name) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// stringField returns obj[name] when it is a string.
+func stringField(obj map[string]any, name string) string {
+	s, _ := obj[name].(string)
+
+	return s
+}
@@ -0,0 +1,155 @@
+package manifest_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	json "github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+const twoDocs = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  namespace: app
+data:
+  replicas: "3"
+---
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+`
+
+func TestParse_splitsDocuments(t *testing.T) {
+	t.Parallel()
+
+	res, err := manifest.Parse(
+		strings.NewReader(twoDocs), "app.yaml",
+	)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+
+	assert.Equal(t, manifest.Key{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Namespace:  "app",
+		Name:       "cfg",
+	}, res[0].Key)
+	assert.Equal(t, 0, res[0].Index)
+	assert.Equal(t, 1, res[0].Line)
+	assert.Equal(t, "ConfigMap app/cfg", res[0].Key.String())
+
+	assert.Equal(t, "Deployment web", res[1].Key.String())
+	assert.Equal(t, 2, res[1].Index)
+	assert.Equal(t, 10, res[1].Line)
+	assert.Equal(t, "app.yaml", res[1].File)
+
+	spec, ok := res[1].Object["spec"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, json.Number("3"), spec["replicas"])
+}
+
+func TestParse_missingName(t *testing.T) {
+	t.Parallel()
+
+	_, err := manifest.Parse(
+		strings.NewReader("apiVersion: v1\nkind: Service\n"),
+		"svc.yaml",
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "svc.yaml: document 0")
+	assert.Contains(t, err.Error(), "missing metadata.name")
+}
+
+func TestLoadDir_walksPaths(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	files := map[string]string{
+		"cloud/dev/app.yaml":       twoDocs,
+		"cloud/dev/notes.txt":      "ignored",
+		"cloud/prod/app.yml":       twoDocs,
+		"cloud/dev/.hidden/x.yaml": "not: parsed",
+	}
+	for name, content := range files {
+		fp := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+		require.NoError(t, os.WriteFile(fp, []byte(content), 0o600))
+	}
+
+	res, err := manifest.LoadDir(root, "cloud/dev")
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, "cloud/dev/app.yaml", res[0].File)
+
+	res, err = manifest.LoadDir(root)
+	require.NoError(t, err)
+	assert.Len(t, res, 4)
+
+	names, err := manifest.ListFiles(root, "cloud/prod", "cloud", "cloud/dev")
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]string{"cloud/dev/app.yaml", "cloud/prod/app.yml"}, names)
+}
+
+func TestParseFiles_ordersAndFilters(t *testing.T) {
+	t.Parallel()
+
+	res, err := manifest.ParseFiles(map[string][]byte{
+		"b/app.yaml":   []byte(twoDocs),
+		"a/app.yml":    []byte(twoDocs),
+		"a/app.digest": []byte("abc"),
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 4)
+	assert.Equal(t, "a/app.yml", res[0].File)
+	assert.Equal(t, "b/app.yaml", res[3].File)
+}
+
+func TestResource_Images(t *testing.T) {
+	t.Parallel()
+
+	res, err := manifest.Parse(strings.NewReader(`apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  initContainers:
+  - name: init
+    image: reg.io/init:1.0
+  containers:
+  - name: web
+    image: reg.io/web@sha256:abcd
+`), "pod.yaml")
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	assert.Equal(t, []string{
+		"reg.io/web@sha256:abcd", "reg.io/init:1.0",
+	}, res[0].Images())
+}
+
+func TestImageName(t *testing.T) {
+	t.Parallel()
+
+	for ref, want := range map[string]string{
+		"reg.io/web":                   "reg.io/web",
+		"reg.io/web:1.2":               "reg.io/web",
+		"reg.io/web@sha256:abcd":       "reg.io/web",
+		"reg.io:5000/web:1.2@sha256:a": "reg.io:5000/web",
+		"localhost:5000/web":           "localhost:5000/web",
+	} {
+		assert.Equal(t, want, manifest.ImageName(ref), ref)
+	}
+}
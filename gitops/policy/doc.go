@@ -0,0 +1,7 @@
+// Package policy gates deployment pull requests. A Policy, loaded from a YAML
+// or JSON file, allows or denies trains and holds rules selecting trains by
+// glob pattern: freeze windows in a time zone, labels the pull request must
+// carry and a maximum number of changed resources. Evaluate reports every
+// violation of a change, and each violation either fails the run or skips
+// the pull request of the train.
+package policy
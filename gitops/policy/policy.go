@@ -0,0 +1,602 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Action is what happens to a pull request violating
+// a rule.
+type Action string
+
+const (
+	// ActionFail aborts the run before anything is
+	// pushed.
+	ActionFail Action = "fail"
+	// ActionSkip drops the pull request of the train
+	// and goes on with the others.
+	ActionSkip Action = "skip"
+)
+
+// ParseAction parses an action name. Empty means
+// ActionFail.
+func ParseAction(s string) (Action, error) {
+	switch Action(s) {
+	case "", ActionFail:
+		return ActionFail, nil
+	case ActionSkip:
+		return ActionSkip, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown action %q (expected fail or skip)", s,
+		)
+	}
+}
+
+// Policy holds the rules a deployment train must
+// satisfy before its pull request is pushed.
+type Policy struct {
+	// OnViolation is the action of the rules that do
+	// not set their own, and of AllowTrains and
+	// DenyTrains.
+	OnViolation Action
+
+	// AllowTrains lists glob patterns (path.Match) of
+	// the trains allowed to deploy. Empty allows
+	// every train.
+	AllowTrains []string
+
+	// DenyTrains lists glob patterns of the trains
+	// never allowed to deploy. It takes precedence
+	// over AllowTrains.
+	DenyTrains []string
+
+	// Rules are evaluated in order; every violation
+	// is reported.
+	Rules []Rule
+}
+
+// Rule constrains the pull requests of the trains it
+// selects. Every constraint set must hold.
+type Rule struct {
+	// Name identifies the rule in reports.
+	Name string
+
+	// Trains lists glob patterns of the trains the
+	// rule applies to. Empty means every train.
+	Trains []string
+
+	// OnViolation overrides Policy.OnViolation.
+	OnViolation Action
+
+	// Freeze lists windows during which no pull
+	// request may be pushed.
+	Freeze []Window
+
+	// Labels must all be applied to the pull
+	// request.
+	Labels []string
+
+	// MaxChangedResources caps the number of added,
+	// removed and modified resources of the pull
+	// request. Zero means no limit.
+	MaxChangedResources int
+}
+
+// Window is a freeze window: either a single period
+// from Start to End, or a period repeated on Days
+// from From to To, in Location. A repeated window
+// whose To is not after From ends the next day.
+type Window struct {
+	// Start and End bound a single period; End is
+	// excluded. Zero for repeated windows.
+	Start time.Time
+	End   time.Time
+
+	// Days are the weekdays a repeated window starts
+	// on. Empty means every day.
+	Days []time.Weekday
+
+	// From and To are wall-clock times, as offsets
+	// from midnight, so they hold on DST days too.
+	From time.Duration
+	To   time.Duration
+
+	// Location is the time zone of the window.
+	Location *time.Location
+}
+
+// Contains reports whether t is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	if !w.Start.IsZero() {
+		return !t.Before(w.Start) && t.Before(w.End)
+	}
+
+	t = t.In(w.Location)
+	hour, minute, sec := t.Clock()
+	offset := time.Duration(hour)*time.Hour +
+		time.Duration(minute)*time.Minute +
+		time.Duration(sec)*time.Second
+
+	// A window ending past midnight is entered either
+	// on its own day or the day after.
+	if w.To > w.From {
+		return w.onDay(t.Weekday()) &&
+			offset >= w.From && offset < w.To
+	}
+
+	if w.onDay(t.Weekday()) && offset >= w.From {
+		return true
+	}
+
+	return w.onDay((t.Weekday()+6)%7) && offset < w.To
+}
+
+// onDay reports whether the window starts on day.
+func (w Window) onDay(day time.Weekday) bool {
+	return len(w.Days) == 0 || slices.Contains(w.Days, day)
+}
+
+// String describes the window.
+func (w Window) String() string {
+	const layout = "2006-01-02 15:04"
+
+	if !w.Start.IsZero() {
+		return fmt.Sprintf(
+			"%s to %s %s",
+			w.Start.In(w.Location).Format(layout),
+			w.End.In(w.Location).Format(layout),
+			w.Location,
+		)
+	}
+
+	days := "every day"
+
+	if len(w.Days) > 0 {
+		names := make([]string, len(w.Days))
+		for i, d := range w.Days {
+			names[i] = d.String()[:3]
+		}
+
+		days = strings.Join(names, ",")
+	}
+
+	return fmt.Sprintf(
+		"%s %s to %s %s",
+		days, clock(w.From), clock(w.To), w.Location,
+	)
+}
+
+// clock formats an offset from midnight as HH:MM.
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Change describes the pull request of one
+// deployment train.
+type Change struct {
+	// Train is the deployment train.
+	Train string
+
+	// Labels are applied to the pull request.
+	Labels []string
+
+	// ChangedResources is the number of added,
+	// removed and modified resources.
+	ChangedResources int
+
+	// Time is when the pull request is pushed.
+	Time time.Time
+}
+
+// Violation is a rule a change breaks.
+type Violation struct {
+	// Rule names the rule.
+	Rule string
+
+	// Action is the action of the rule.
+	Action Action
+
+	// Reason tells why the change breaks the rule.
+	Reason string
+}
+
+// Report is the evaluation of a change.
+type Report struct {
+	// Train is the deployment train of the change.
+	Train string
+
+	// Violations lists the broken rules, in policy
+	// order.
+	Violations []Violation
+}
+
+// Action returns ActionFail when a violation fails,
+// ActionSkip when violations only skip, and "" when
+// the change complies.
+func (r Report) Action() Action {
+	var action Action
+
+	for _, v := range r.Violations {
+		if v.Action == ActionFail {
+			return ActionFail
+		}
+
+		action = ActionSkip
+	}
+
+	return action
+}
+
+// String lists the violations, one per line.
+func (r Report) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "train %s: %s", r.Train, r.Action())
+
+	for _, v := range r.Violations {
+		fmt.Fprintf(&sb, "\n  %s (%s): %s", v.Rule, v.Action, v.Reason)
+	}
+
+	return sb.String()
+}
+
+// LimitsResources reports whether a rule caps the
+// changed resources, so callers only count them when
+// needed.
+func (p *Policy) LimitsResources() bool {
+	for _, r := range p.Rules {
+		if r.MaxChangedResources > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Evaluate checks c against every rule of p.
+func (p *Policy) Evaluate(c Change) Report {
+	rep := Report{Train: c.Train}
+
+	add := func(rule string, action Action, format string, args ...any) {
+		if action == "" {
+			action = p.OnViolation
+		}
+
+		if action == "" {
+			action = ActionFail
+		}
+
+		rep.Violations = append(rep.Violations, Violation{
+			Rule:   rule,
+			Action: action,
+			Reason: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if pat, ok := matchAny(p.DenyTrains, c.Train); ok {
+		add("deny_trains", "", "train matches %q", pat)
+	}
+
+	if _, ok := matchAny(p.AllowTrains, c.Train); len(p.AllowTrains) > 0 && !ok {
+		add("allow_trains", "", "train matches none of %s",
+			strings.Join(p.AllowTrains, ", "))
+	}
+
+	for _, r := range p.Rules {
+		if _, ok := matchAny(r.Trains, c.Train); len(r.Trains) > 0 && !ok {
+			continue
+		}
+
+		for _, w := range r.Freeze {
+			if w.Contains(c.Time) {
+				add(r.Name, r.OnViolation,
+					"in freeze window %s", w)
+			}
+		}
+
+		var missing []string
+
+		for _, l := range r.Labels {
+			if !slices.Contains(c.Labels, l) {
+				missing = append(missing, l)
+			}
+		}
+
+		if len(missing) > 0 {
+			add(r.Name, r.OnViolation,
+				"missing labels %s", strings.Join(missing, ", "))
+		}
+
+		if r.MaxChangedResources > 0 &&
+			c.ChangedResources > r.MaxChangedResources {
+			add(r.Name, r.OnViolation,
+				"%d changed resources, at most %d allowed",
+				c.ChangedResources, r.MaxChangedResources)
+		}
+	}
+
+	return rep
+}
+
+// matchAny returns the first pattern matching train.
+func matchAny(patterns []string, train string) (string, bool) {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, train); ok {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+// file is the YAML form of a policy file.
+type file struct {
+	OnViolation string     `yaml:"on_violation"`
+	AllowTrains []string   `yaml:"allow_trains"`
+	DenyTrains  []string   `yaml:"deny_trains"`
+	Rules       []fileRule `yaml:"rules"`
+}
+
+// fileRule is the YAML form of a Rule.
+type fileRule struct {
+	Name                string       `yaml:"name"`
+	Trains              []string     `yaml:"trains"`
+	OnViolation         string       `yaml:"on_violation"`
+	Freeze              []fileWindow `yaml:"freeze"`
+	Labels              []string     `yaml:"labels"`
+	MaxChangedResources int          `yaml:"max_changed_resources"`
+}
+
+// fileWindow is the YAML form of a Window.
+type fileWindow struct {
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+	Days     []string `yaml:"days"`
+	From     string   `yaml:"from"`
+	To       string   `yaml:"to"`
+	Timezone string   `yaml:"timezone"`
+}
+
+// dateLayouts are the accepted formats of single
+// window bounds without a zone offset.
+var dateLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Load reads and parses the policy file at path.
+func Load(path string) (*Policy, error) {
+	const errCtx = "loading policy"
+
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	p, err := Parse(data, path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return p, nil
+}
+
+// Parse parses a YAML (or JSON) policy file. Unknown
+// keys are errors, and every invalid rule is
+// reported, not only the first one.
+func Parse(data []byte, filename string) (*Policy, error) {
+	var f file
+
+	if err := yaml.UnmarshalWithOptions(
+		data, &f, yaml.Strict(),
+	); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var errs []error
+
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(
+			"%s: "+format, append([]any{filename}, args...)...,
+		))
+	}
+
+	p := &Policy{
+		AllowTrains: f.AllowTrains,
+		DenyTrains:  f.DenyTrains,
+	}
+
+	var err error
+
+	if p.OnViolation, err = ParseAction(f.OnViolation); err != nil {
+		fail("on_violation: %v", err)
+	}
+
+	for _, pat := range append(f.AllowTrains, f.DenyTrains...) {
+		if _, err := path.Match(pat, ""); err != nil {
+			fail("invalid train pattern %q", pat)
+		}
+	}
+
+	for i, fr := range f.Rules {
+		name := fr.Name
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", i)
+		}
+
+		r := Rule{
+			Name:                name,
+			Trains:              fr.Trains,
+			Labels:              fr.Labels,
+			MaxChangedResources: fr.MaxChangedResources,
+		}
+
+		if fr.OnViolation != "" {
+			if r.OnViolation, err = ParseAction(fr.OnViolation); err != nil {
+				fail("%s: on_violation: %v", name, err)
+			}
+		}
+
+		for _, pat := range fr.Trains {
+			if _, err := path.Match(pat, ""); err != nil {
+				fail("%s: invalid train pattern %q", name, pat)
+			}
+		}
+
+		if fr.MaxChangedResources < 0 {
+			fail("%s: max_changed_resources must not be negative", name)
+		}
+
+		for j, fw := range fr.Freeze {
+			w, err := parseWindow(fw)
+			if err != nil {
+				fail("%s: freeze[%d]: %v", name, j, err)
+
+				continue
+			}
+
+			r.Freeze = append(r.Freeze, w)
+		}
+
+		if len(fr.Freeze) == 0 && len(r.Labels) == 0 &&
+			r.MaxChangedResources == 0 {
+			fail("%s: no constraint (expected freeze, labels "+
+				"or max_changed_resources)", name)
+		}
+
+		p.Rules = append(p.Rules, r)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// parseWindow converts fw to a Window.
+func parseWindow(fw fileWindow) (Window, error) {
+	loc := time.UTC
+
+	if fw.Timezone != "" {
+		var err error
+
+		if loc, err = time.LoadLocation(fw.Timezone); err != nil {
+			return Window{}, fmt.Errorf("timezone: %w", err)
+		}
+	}
+
+	w := Window{Location: loc}
+	single := fw.Start != "" || fw.End != ""
+	repeated := fw.From != "" || fw.To != "" || len(fw.Days) > 0
+
+	switch {
+	case single && repeated:
+		return Window{}, errors.New(
+			"start and end cannot be combined with days, from and to",
+		)
+	case single:
+		var err error
+
+		if w.Start, err = parseDate(fw.Start, loc); err != nil {
+			return Window{}, fmt.Errorf("start: %w", err)
+		}
+
+		if w.End, err = parseDate(fw.End, loc); err != nil {
+			return Window{}, fmt.Errorf("end: %w", err)
+		}
+
+		if !w.End.After(w.Start) {
+			return Window{}, errors.New("end must be after start")
+		}
+	case repeated:
+		var err error
+
+		if w.From, err = parseClock(fw.From); err != nil {
+			return Window{}, fmt.Errorf("from: %w", err)
+		}
+
+		if w.To, err = parseClock(fw.To); err != nil {
+			return Window{}, fmt.Errorf("to: %w", err)
+		}
+
+		for _, d := range fw.Days {
+			day, err := parseDay(d)
+			if err != nil {
+				return Window{}, fmt.Errorf("days: %w", err)
+			}
+
+			w.Days = append(w.Days, day)
+		}
+	default:
+		return Window{}, errors.New(
+			"expected start and end, or from and to",
+		)
+	}
+
+	return w, nil
+}
+
+// parseDate parses a single window bound. An RFC 3339
+// time keeps its offset; other formats are read in
+// loc.
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing")
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf(
+		"invalid time %q (expected e.g. 2026-12-24T18:00)", s,
+	)
+}
+
+// parseClock parses an HH:MM time of day. 24:00 is
+// the end of the day.
+func parseClock(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("missing")
+	}
+
+	var h, m int
+
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil ||
+		len(s) != 5 || h < 0 || m < 0 || m > 59 ||
+		h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", s)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseDay parses a weekday name or its first three
+// letters, in any case.
+func parseDay(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if l := strings.ToLower(s); l == name || l == name[:3] {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
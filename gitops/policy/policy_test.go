@@ -0,0 +1,245 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/policy"
+)
+
+const policyFile = `
+on_violation: skip
+allow_trains: ["prod*", "staging"]
+deny_trains: [prod-legacy]
+rules:
+  - name: holiday-freeze
+    trains: ["prod*"]
+    on_violation: fail
+    freeze:
+      - start: 2026-12-20T00:00
+        end: 2027-01-05
+        timezone: Europe/Paris
+  - name: weekend
+    freeze:
+      - days: [fri]
+        from: "18:00"
+        to: "08:00"
+        timezone: America/New_York
+  - name: approval
+    trains: [prod]
+    labels: [change-approved, owner-ok]
+  - name: size
+    max_changed_resources: 3
+`
+
+func parse(t *testing.T) *policy.Policy {
+	t.Helper()
+
+	p, err := policy.Parse([]byte(policyFile), "policy.yaml")
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestEvaluate_compliant(t *testing.T) {
+	t.Parallel()
+
+	p := parse(t)
+	rep := p.Evaluate(policy.Change{
+		Train:            "prod",
+		Labels:           []string{"owner-ok", "change-approved"},
+		ChangedResources: 3,
+		Time:             time.Date(2026, 6, 3, 12, 0, 0, 0, time.UTC),
+	})
+
+	assert.Empty(t, rep.Violations)
+	assert.Equal(t, policy.Action(""), rep.Action())
+	assert.True(t, p.LimitsResources())
+}
+
+func TestEvaluate_violations(t *testing.T) {
+	t.Parallel()
+
+	p := parse(t)
+	rep := p.Evaluate(policy.Change{
+		Train:            "prod",
+		Labels:           []string{"owner-ok"},
+		ChangedResources: 4,
+		// 2026-12-19 23:30 UTC is 00:30 on the 20th
+		// in Paris.
+		Time: time.Date(2026, 12, 19, 23, 30, 0, 0, time.UTC),
+	})
+
+	assert.Equal(t, policy.ActionFail, rep.Action())
+	assert.Equal(t, []policy.Violation{
+		{
+			Rule:   "holiday-freeze",
+			Action: policy.ActionFail,
+			Reason: "in freeze window 2026-12-20 00:00 to " +
+				"2027-01-05 00:00 Europe/Paris",
+		},
+		{
+			Rule:   "approval",
+			Action: policy.ActionSkip,
+			Reason: "missing labels change-approved",
+		},
+		{
+			Rule:   "size",
+			Action: policy.ActionSkip,
+			Reason: "4 changed resources, at most 3 allowed",
+		},
+	}, rep.Violations)
+	assert.Equal(t,
+		"train prod: fail\n"+
+			"  holiday-freeze (fail): in freeze window "+
+			"2026-12-20 00:00 to 2027-01-05 00:00 Europe/Paris\n"+
+			"  approval (skip): missing labels change-approved\n"+
+			"  size (skip): 4 changed resources, at most 3 allowed",
+		rep.String())
+}
+
+func TestEvaluate_trainLists(t *testing.T) {
+	t.Parallel()
+
+	p := parse(t)
+	now := time.Date(2026, 6, 3, 12, 0, 0, 0, time.UTC)
+
+	rep := p.Evaluate(policy.Change{Train: "prod-legacy", Time: now})
+	require.Len(t, rep.Violations, 1)
+	assert.Equal(t, "deny_trains", rep.Violations[0].Rule)
+	assert.Equal(t, policy.ActionSkip, rep.Action())
+
+	rep = p.Evaluate(policy.Change{Train: "dev", Time: now})
+	require.Len(t, rep.Violations, 1)
+	assert.Equal(t, "allow_trains", rep.Violations[0].Rule)
+	assert.Equal(t,
+		"train matches none of prod*, staging",
+		rep.Violations[0].Reason)
+
+	rep = p.Evaluate(policy.Change{Train: "staging", Time: now})
+	assert.Empty(t, rep.Violations)
+}
+
+func TestWindow_repeated(t *testing.T) {
+	t.Parallel()
+
+	p := parse(t)
+	w := p.Rules[1].Freeze[0]
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		time time.Time
+		want bool
+	}{
+		{"friday before", time.Date(2026, 6, 5, 17, 59, 0, 0, ny), false},
+		{"friday start", time.Date(2026, 6, 5, 18, 0, 0, 0, ny), true},
+		{"saturday morning", time.Date(2026, 6, 6, 7, 59, 0, 0, ny), true},
+		{"saturday end", time.Date(2026, 6, 6, 8, 0, 0, 0, ny), false},
+		{"thursday night", time.Date(2026, 6, 4, 19, 0, 0, 0, ny), false},
+		{"friday in utc", time.Date(2026, 6, 5, 23, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, w.Contains(tt.time))
+		})
+	}
+
+	assert.Equal(t, "Fri 18:00 to 08:00 America/New_York", w.String())
+}
+
+func TestWindow_repeatedOnDSTDays(t *testing.T) {
+	t.Parallel()
+
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	w := policy.Window{
+		From:     9 * time.Hour,
+		To:       17 * time.Hour,
+		Location: ny,
+	}
+
+	// Clocks go forward on 2026-03-08 and back on
+	// 2026-11-01: the window still opens at 09:00
+	// local time.
+	for _, d := range []struct {
+		month time.Month
+		day   int
+	}{{time.March, 8}, {time.November, 1}} {
+		month, day := d.month, d.day
+
+		assert.False(t, w.Contains(time.Date(2026, month, day, 8, 59, 0, 0, ny)))
+		assert.True(t, w.Contains(time.Date(2026, month, day, 9, 0, 0, 0, ny)))
+		assert.True(t, w.Contains(time.Date(2026, month, day, 16, 59, 0, 0, ny)))
+		assert.False(t, w.Contains(time.Date(2026, month, day, 17, 0, 0, 0, ny)))
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := policy.Parse([]byte(`
+on_violation: warn
+deny_trains: ["[prod"]
+rules:
+  - name: empty
+  - name: bad-window
+    freeze:
+      - start: 2026-12-20
+        end: 2026-12-01
+      - from: "25:00"
+        to: "08:00"
+      - start: 2026-12-20
+        from: "10:00"
+      - from: "10:00"
+        to: "11:00"
+        timezone: Mars/Olympus
+`), "policy.yaml")
+	require.Error(t, err)
+
+	for _, want := range []string{
+		`policy.yaml: on_violation: unknown action "warn"`,
+		`policy.yaml: invalid train pattern "[prod"`,
+		"policy.yaml: empty: no constraint",
+		"bad-window: freeze[0]: end must be after start",
+		`bad-window: freeze[1]: from: invalid time of day "25:00"`,
+		"bad-window: freeze[2]: start and end cannot be combined",
+		"bad-window: freeze[3]: timezone:",
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+
+	_, err = policy.Parse([]byte("freeze: []\n"), "policy.yaml")
+	require.Error(t, err, "unknown keys are rejected")
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	fp := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(fp, []byte(
+		`{"rules": [{"name": "labels", "labels": ["ok"]}]}`,
+	), 0o600))
+
+	p, err := policy.Load(fp)
+	require.NoError(t, err)
+	assert.Equal(t, policy.ActionFail, p.OnViolation)
+	assert.False(t, p.LimitsResources())
+	assert.Equal(t, []policy.Rule{{
+		Name:   "labels",
+		Labels: []string{"ok"},
+	}}, p.Rules)
+
+	_, err = policy.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.ErrorContains(t, err, "loading policy")
+}
@@ -0,0 +1,207 @@
+package prer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attribute types of the cquery jsonproto output, as
+// named by the Discriminator enum of Bazel's
+// build.proto.
+const (
+	attrInteger              = "INTEGER"
+	attrBoolean              = "BOOLEAN"
+	attrTristate             = "TRISTATE"
+	attrString               = "STRING"
+	attrLabel                = "LABEL"
+	attrOutput               = "OUTPUT"
+	attrStringList           = "STRING_LIST"
+	attrLabelList            = "LABEL_LIST"
+	attrOutputList           = "OUTPUT_LIST"
+	attrIntegerList          = "INTEGER_LIST"
+	attrStringDict           = "STRING_DICT"
+	attrStringListDict       = "STRING_LIST_DICT"
+	attrLabelDictUnary       = "LABEL_DICT_UNARY"
+	attrLabelListDict        = "LABEL_LIST_DICT"
+	attrLabelKeyedStringDict = "LABEL_KEYED_STRING_DICT"
+)
+
+// defaultTrainKey is the attribute naming the
+// deployment train of a target.
+const defaultTrainKey = "deployment_branch"
+
+// queryAttribute holds a single attribute of a Bazel
+// rule. Which value field is set depends on Type;
+// labels are carried in the string fields. Empty
+// fields are omitted when cached.
+type queryAttribute struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+
+	StringValue     string   `json:"stringValue,omitempty"`
+	IntValue        int64    `json:"intValue,omitempty"`
+	BooleanValue    bool     `json:"booleanValue,omitempty"`
+	TristateValue   string   `json:"tristateValue,omitempty"`
+	StringListValue []string `json:"stringListValue,omitempty"`
+	IntListValue    []int64  `json:"intListValue,omitempty"`
+
+	StringDictValue           []dictEntry     `json:"stringDictValue,omitempty"`
+	LabelDictUnaryValue       []dictEntry     `json:"labelDictUnaryValue,omitempty"`
+	LabelKeyedStringDictValue []dictEntry     `json:"labelKeyedStringDictValue,omitempty"`
+	StringListDictValue       []listDictEntry `json:"stringListDictValue,omitempty"`
+	LabelListDictValue        []listDictEntry `json:"labelListDictValue,omitempty"`
+
+	ExplicitlySpecified bool `json:"explicitlySpecified,omitempty"`
+}
+
+// dictEntry is one entry of a dict attribute.
+type dictEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// listDictEntry is one entry of a dict attribute with
+// list values.
+type listDictEntry struct {
+	Key   string   `json:"key"`
+	Value []string `json:"value"`
+}
+
+// Value returns the attribute value as a Go value:
+// string for strings and labels, int64, bool, []string
+// for string and label lists, []int64,
+// map[string]string for dicts and map[string][]string
+// for dicts of lists. An attribute without a type, as
+// in hand-written results, is read as a string.
+func (a queryAttribute) Value() any {
+	switch a.Type {
+	case attrInteger:
+		return a.IntValue
+	case attrBoolean:
+		return a.BooleanValue
+	case attrTristate:
+		return a.TristateValue
+	case attrStringList, attrLabelList, attrOutputList:
+		return a.StringListValue
+	case attrIntegerList:
+		return a.IntListValue
+	case attrStringDict:
+		return dict(a.StringDictValue)
+	case attrLabelDictUnary:
+		return dict(a.LabelDictUnaryValue)
+	case attrLabelKeyedStringDict:
+		return dict(a.LabelKeyedStringDictValue)
+	case attrStringListDict:
+		return listDict(a.StringListDictValue)
+	case attrLabelListDict:
+		return listDict(a.LabelListDictValue)
+	case attrString, attrLabel, attrOutput:
+		return a.StringValue
+	default:
+		return a.StringValue
+	}
+}
+
+// String renders the attribute value as text: lists
+// are joined with commas and dict entries rendered as
+// key=value, sorted by key.
+func (a queryAttribute) String() string {
+	switch v := a.Value().(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case []string:
+		return strings.Join(v, ",")
+	case []int64:
+		parts := make([]string, len(v))
+		for i, n := range v {
+			parts[i] = strconv.FormatInt(n, 10)
+		}
+
+		return strings.Join(parts, ",")
+	case map[string]string:
+		parts := make([]string, 0, len(v))
+		for k, val := range v {
+			parts = append(parts, k+"="+val)
+		}
+
+		sort.Strings(parts)
+
+		return strings.Join(parts, ",")
+	case map[string][]string:
+		parts := make([]string, 0, len(v))
+		for k, val := range v {
+			parts = append(parts, k+"="+strings.Join(val, ","))
+		}
+
+		sort.Strings(parts)
+
+		return strings.Join(parts, ";")
+	default:
+		return ""
+	}
+}
+
+// attr returns the attribute of r named name.
+func (r queryRule) attr(name string) (queryAttribute, bool) {
+	for _, a := range r.Attribute {
+		if a.Name == name {
+			return a, true
+		}
+	}
+
+	return queryAttribute{}, false
+}
+
+// trainName returns the deployment train of r: the
+// values of the keys attributes joined by "/". It
+// returns false when one of them is missing or empty.
+// No keys means deployment_branch.
+func (r queryRule) trainName(keys []string) (string, bool) {
+	if len(keys) == 0 {
+		keys = []string{defaultTrainKey}
+	}
+
+	parts := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		a, ok := r.attr(key)
+		if !ok {
+			return "", false
+		}
+
+		v := a.String()
+		if v == "" {
+			return "", false
+		}
+
+		parts = append(parts, v)
+	}
+
+	return strings.Join(parts, "/"), true
+}
+
+// dict converts dict entries to a map.
+func dict(entries []dictEntry) map[string]string {
+	m := make(map[string]string, len(entries))
+	for _, e := range entries {
+		m[e.Key] = e.Value
+	}
+
+	return m
+}
+
+// listDict converts dict entries with list values to
+// a map.
+func listDict(entries []listDictEntry) map[string][]string {
+	m := make(map[string][]string, len(entries))
+	for _, e := range entries {
+		m[e.Key] = e.Value
+	}
+
+	return m
+}
@@ -0,0 +1,273 @@
+package prer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	osexec "os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/exec"
+)
+
+// changeSet is the result of mapping the files
+// changed since a base commit to bazel labels.
+type changeSet struct {
+	// labels are the source file labels of the
+	// changed files that belong to a package.
+	labels []string
+
+	// all is set when the changes cannot be mapped to
+	// targets, e.g. a BUILD file changed, and every
+	// target must be processed. reason tells why.
+	all    bool
+	reason string
+}
+
+// changedFiles returns the files of the workspace
+// changed since base, committed or not, and the
+// untracked files that are not ignored, relative to
+// workspace. Renames are reported as a deletion and
+// an addition.
+func changedFiles(workspace string, base string) ([]string, error) {
+	const errCtx = "listing changed files"
+
+	changed, err := exec.Output(
+		workspace,
+		"git", "diff", "--name-only", "--no-renames", "-z",
+		"--relative", base, "--",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	untracked, err := exec.Output(
+		workspace,
+		"git", "ls-files", "--others", "--exclude-standard", "-z",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var files []string
+
+	for _, out := range [][]byte{changed, untracked} {
+		for _, name := range strings.Split(string(out), "\x00") {
+			if name != "" {
+				files = append(files, name)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// mapChanges maps files, relative to workspace, to the
+// labels of their source files. Files outside any
+// package cannot be inputs of a target and are
+// ignored; files of a package that no target declares
+// are dropped later by declaredTargets. A changed build graph file or a deleted
+// file, which has no label any more, requires all
+// targets.
+func mapChanges(workspace string, files []string) changeSet {
+	var cs changeSet
+
+	if workspace == "" {
+		workspace = "."
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, file := range files {
+		file = filepath.ToSlash(file)
+
+		if buildGraphFile(path.Base(file)) {
+			return changeSet{
+				all:    true,
+				reason: "build graph file " + file + " changed",
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(workspace, file)); err != nil {
+			return changeSet{
+				all:    true,
+				reason: file + " was deleted",
+			}
+		}
+
+		pkg, ok := packageOf(workspace, file)
+		if !ok {
+			continue
+		}
+
+		name := strings.TrimPrefix(file, pkg)
+		name = strings.TrimPrefix(name, "/")
+		label := "//" + pkg + ":" + name
+
+		if _, dup := seen[label]; !dup {
+			seen[label] = struct{}{}
+			cs.labels = append(cs.labels, label)
+		}
+	}
+
+	sort.Strings(cs.labels)
+
+	return cs
+}
+
+// packageOf returns the bazel package of file: the
+// closest directory above it holding a BUILD file.
+// The root package is "".
+func packageOf(workspace string, file string) (string, bool) {
+	for dir := path.Dir(file); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+
+		for _, build := range []string{"BUILD.bazel", "BUILD"} {
+			fi, err := os.Stat(filepath.Join(
+				workspace, filepath.FromSlash(dir), build,
+			))
+			if err == nil && !fi.IsDir() {
+				return dir, true
+			}
+		}
+
+		if dir == "" {
+			return "", false
+		}
+	}
+}
+
+// affectedTargets returns the gitops targets depending
+// on a file changed since cfg.ChangedSince, or nil and
+// false when every target must be processed.
+func affectedTargets(cfg Config) (map[string]struct{}, bool, error) {
+	const errCtx = "finding affected targets"
+
+	files, err := changedFiles(cfg.Workspace, cfg.ChangedSince)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	cs := mapChanges(cfg.Workspace, files)
+	if cs.all {
+		slog.Info(
+			"processing all targets",
+			"since", cfg.ChangedSince,
+			"reason", cs.reason,
+		)
+
+		return nil, false, nil
+	}
+
+	affected := make(map[string]struct{})
+
+	if len(cs.labels) == 0 {
+		return affected, true, nil
+	}
+
+	labels, err := declaredTargets(cfg, cs.labels)
+	if err != nil {
+		slog.Info(
+			"processing all targets",
+			"since", cfg.ChangedSince,
+			"reason", err.Error(),
+		)
+
+		return nil, false, nil
+	}
+
+	if len(labels) == 0 {
+		return affected, true, nil
+	}
+
+	universe, err := gitopsUniverse(cfg)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	cfg.Target = fmt.Sprintf(
+		"rdeps(%s, set(%s))",
+		universe, strings.Join(labels, " "),
+	)
+
+	qr, err := bazelQuery(cfg, buildKindQuery(cfg), nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	for _, name := range extractTargetNames(qr) {
+		affected[name] = struct{}{}
+	}
+
+	return affected, true, nil
+}
+
+// bazelPartialSuccess is the exit status of a bazel
+// query run with --keep_going when some of its targets
+// do not exist.
+const bazelPartialSuccess = 3
+
+// declaredTargets returns the labels that are targets
+// of the build graph, i.e. files a target declares, as
+// rdeps fails on any label that is not, such as a
+// README next to a BUILD file.
+func declaredTargets(cfg Config, labels []string) ([]string, error) {
+	const errCtx = "querying changed files"
+
+	out, err := exec.Output(
+		cfg.Workspace,
+		cfg.BazelCmd,
+		append(
+			bazelArgs(cfg, "query"),
+			"--keep_going", "--output=label",
+			"set("+strings.Join(labels, " ")+")",
+		)...,
+	)
+
+	var exitErr *osexec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) ||
+		exitErr.ExitCode() != bazelPartialSuccess) {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var declared []string
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			declared = append(declared, line)
+		}
+	}
+
+	sort.Strings(declared)
+
+	return declared, nil
+}
+
+// filterTrains keeps the trains with at least one
+// affected target. A train is kept whole: processing
+// part of it would drop the manifests of the other
+// targets from its deployment branch.
+func filterTrains(
+	trains map[string][]string,
+	affected map[string]struct{},
+) map[string][]string {
+	kept := make(map[string][]string)
+
+	for train, targets := range trains {
+		for _, t := range targets {
+			if _, ok := affected[t]; ok {
+				kept[train] = targets
+
+				break
+			}
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,204 @@
+package prer_test
+
+import (
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/prer"
+)
+
+// writeFiles creates files below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		fp := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+		require.NoError(t, os.WriteFile(fp, []byte(content), 0o600))
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	gitCmd := func(args ...string) {
+		t.Helper()
+
+		cmd := osexec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	gitCmd("init", "-b", "main")
+	gitCmd("config", "user.email", "test@test.com")
+	gitCmd("config", "user.name", "Test")
+	writeFiles(t, dir, map[string]string{
+		"ws/BUILD":        "",
+		"ws/web/main.go":  "package main\n",
+		"ws/web/old.go":   "package main\n",
+		"other/README.md": "",
+		"ws/jobs/BUILD":   "",
+	})
+	gitCmd("add", "-A")
+	gitCmd("commit", "-m", "init")
+
+	writeFiles(t, dir, map[string]string{
+		"ws/web/main.go":  "package main // changed\n",
+		"other/README.md": "changed",
+	})
+	gitCmd("mv", "ws/web/old.go", "ws/web/new.go")
+	writeFiles(t, dir, map[string]string{
+		".gitignore":         "*.log\n",
+		"ws/jobs/my job.go":  "package jobs\n",
+		"ws/jobs/run.log":    "ignored",
+		"other/untracked.go": "package other\n",
+	})
+
+	// Paths are relative to the workspace, which is a
+	// subdirectory of the repository, and files outside
+	// it are left out. Untracked files count unless
+	// ignored, and names are not split on spaces.
+	files, err := prer.ChangedFilesForTest(
+		filepath.Join(dir, "ws"), "HEAD",
+	)
+	require.NoError(t, err)
+	assert.ElementsMatch(t,
+		[]string{
+			"jobs/my job.go", "web/main.go", "web/new.go", "web/old.go",
+		}, files)
+}
+
+func TestMapChanges(t *testing.T) {
+	t.Parallel()
+
+	ws := t.TempDir()
+	writeFiles(t, ws, map[string]string{
+		"web/BUILD.bazel":         "",
+		"web/src/main.go":         "",
+		"web/src/util.go":         "",
+		"jobs/BUILD":              "",
+		"jobs/cron.yaml":          "",
+		"docs/guide.md":           "",
+		"web/deploy/BUILD.bazel":  "",
+		"web/deploy/values.yaml":  "",
+		"web/deploy/nested/a.txt": "",
+	})
+
+	labels, all := prer.MapChangesForTest(ws, []string{
+		"web/src/main.go",
+		"web/src/util.go",
+		"jobs/cron.yaml",
+		"docs/guide.md", // not in a package
+		"web/deploy/nested/a.txt",
+	})
+	assert.False(t, all)
+	assert.Equal(t, []string{
+		"//jobs:cron.yaml",
+		"//web/deploy:nested/a.txt",
+		"//web:src/main.go",
+		"//web:src/util.go",
+	}, labels)
+
+	for _, files := range [][]string{
+		{"web/src/main.go", "web/BUILD.bazel"},
+		{"tools/defs.bzl"},
+		{"MODULE.bazel"},
+		{"web/src/deleted.go"},
+	} {
+		_, all = prer.MapChangesForTest(ws, files)
+		assert.True(t, all, "%v requires all targets", files)
+	}
+}
+
+func TestAffectedTargets_undeclaredFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	gitCmd := func(args ...string) {
+		t.Helper()
+
+		cmd := osexec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	gitCmd("init", "-b", "main")
+	gitCmd("config", "user.email", "test@test.com")
+	gitCmd("config", "user.name", "Test")
+	writeFiles(t, dir, map[string]string{
+		"ws/app/BUILD":     "",
+		"ws/app/a.yaml":    "",
+		"ws/app/README.md": "",
+		"cquery.json":      cqueryOutput,
+	})
+	gitCmd("add", "-A")
+	gitCmd("commit", "-m", "init")
+
+	writeFiles(t, dir, map[string]string{
+		"ws/app/a.yaml":    "changed",
+		"ws/app/README.md": "changed",
+	})
+
+	// The fake bazel declares a.yaml only: like bazel,
+	// query --keep_going prints the targets that exist
+	// and exits with 3, and cquery fails on labels that
+	// are not targets.
+	bazel := filepath.Join(dir, "bazel")
+	require.NoError(t, os.WriteFile(bazel, []byte(`#!/bin/sh
+case "$1" in
+query)
+	case "$*" in *README.md*) ;; *) exit 7 ;; esac
+	echo //app:a.yaml
+	exit 3 ;;
+cquery)
+	case "$*" in *README.md*) echo "no such target" >&2; exit 7 ;; esac
+	cat `+filepath.Join(dir, "cquery.json")+` ;;
+esac
+`), 0o700))
+
+	cfg := prer.Config{
+		BazelCmd:     bazel,
+		Workspace:    filepath.Join(dir, "ws"),
+		Target:       "//...",
+		ChangedSince: "HEAD",
+	}
+
+	affected, ok, err := prer.AffectedTargetsForTest(cfg)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]struct{}{"//app:prod.gitops": {}}, affected)
+
+	// A failing query processes every target.
+	require.NoError(t, os.WriteFile(bazel, []byte("#!/bin/sh\nexit 2\n"), 0o700))
+
+	affected, ok, err = prer.AffectedTargetsForTest(cfg)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, affected)
+}
+
+func TestFilterTrains_keepsWholeTrains(t *testing.T) {
+	t.Parallel()
+
+	trains := map[string][]string{
+		"prod":    {"//jobs:prod.gitops", "//web:prod.gitops"},
+		"staging": {"//jobs:staging.gitops"},
+	}
+
+	got := prer.FilterTrainsForTest(trains, map[string]struct{}{
+		"//web:prod.gitops": {},
+	})
+	assert.Equal(t, map[string][]string{
+		"prod": {"//jobs:prod.gitops", "//web:prod.gitops"},
+	}, got)
+}
@@ -2,6 +2,11 @@
 // of gitops deployment pull requests. It queries Bazel
 // for gitops targets, runs them, pushes images, and
 // creates PRs on the configured git hosting platform.
+//
+// The promote subcommand applies the build deployed on
+// one deployment train to another:
+//
+//	create_gitops_prs promote --from=staging --to=prod [flags]
 package main
 
 import (
@@ -12,47 +17,37 @@ import (
 	"os"
 	"strings"
 
+	"github.com/byte4ever/rules_gitops/gitops/digester"
 	"github.com/byte4ever/rules_gitops/gitops/git"
 	"github.com/byte4ever/rules_gitops/gitops/git/bitbucket"
 	"github.com/byte4ever/rules_gitops/gitops/git/github"
 	"github.com/byte4ever/rules_gitops/gitops/git/gitlab"
+	"github.com/byte4ever/rules_gitops/gitops/internal/cmdflag"
+	"github.com/byte4ever/rules_gitops/gitops/policy"
 	"github.com/byte4ever/rules_gitops/gitops/prer"
+	"github.com/byte4ever/rules_gitops/gitops/prer/config"
+	"github.com/byte4ever/rules_gitops/gitops/schema"
+	"github.com/byte4ever/rules_gitops/gitops/secret"
+	"github.com/byte4ever/rules_gitops/gitops/validate"
+	"github.com/byte4ever/rules_gitops/stamp"
 )
 
-// sliceFlag implements flag.Value for multi-value
-// string flags (repeated --flag=val usage).
-type sliceFlag []string
-
 // providerFlags bundles provider-specific flag values
 // to keep newGitProvider under the 4-argument limit.
 type providerFlags struct {
-	ghRepoOwner  string
-	ghRepo       string
-	ghToken      string
-	ghEnterprise string
-	glHost       string
-	glRepo       string
-	glToken      string
-	bbEndpoint   string
-	bbUser       string
-	bbPassword   string
-}
-
-// String returns the flag value as a comma-separated
-// string representation.
-func (s *sliceFlag) String() string {
-	if s == nil {
-		return ""
-	}
-
-	return strings.Join(*s, ",")
-}
-
-// Set appends a value to the slice.
-func (s *sliceFlag) Set(val string) error {
-	*s = append(*s, val)
-
-	return nil
+	ghRepoOwner      string
+	ghRepo           string
+	ghToken          string
+	ghTokenSource    string
+	ghEnterprise     string
+	glHost           string
+	glRepo           string
+	glToken          string
+	glTokenSource    string
+	bbEndpoint       string
+	bbUser           string
+	bbPassword       string
+	bbPasswordSource string
 }
 
 func main() {
@@ -66,11 +61,50 @@ func main() {
 func run() error {
 	const errCtx = "running create_gitops_prs"
 
+	// The promote subcommand shares every other flag.
+	promote := len(os.Args) > 1 && os.Args[1] == "promote"
+	if promote {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	configPath := flag.String(
+		"config", "",
+		"YAML or JSON config file; flags on the "+
+			"command line override its values",
+	)
+
+	promoteFrom := flag.String(
+		"from", "",
+		"promote: deployment train to promote from",
+	)
+	promoteTo := flag.String(
+		"to", "",
+		"promote: deployment train to promote to",
+	)
+
 	// Bazel flags.
 	bazelCmd := flag.String(
 		"bazel_cmd", "bazel",
 		"Bazel command name or path",
 	)
+
+	var bazelStartupFlags cmdflag.Slice
+
+	flag.Var(
+		&bazelStartupFlags,
+		"bazel_startup_flag",
+		"Bazel startup flag, e.g. --output_base=DIR "+
+			"(repeatable)",
+	)
+
+	var bazelFlags cmdflag.Slice
+
+	flag.Var(
+		&bazelFlags,
+		"bazel_flag",
+		"Bazel cquery flag, e.g. --config=ci (repeatable)",
+	)
+
 	workspace := flag.String(
 		"workspace", "",
 		"Bazel workspace root directory",
@@ -79,6 +113,23 @@ func run() error {
 		"target", "",
 		"Bazel query target pattern",
 	)
+	queryCacheDir := flag.String(
+		"query_cache_dir", "",
+		"Cache cquery results in this directory, keyed "+
+			"by the BUILD, .bzl and MODULE files of "+
+			"the workspace",
+	)
+	bepFile := flag.String(
+		"bep_file", "",
+		"Build Event Protocol JSON file of an earlier "+
+			"bazel build; its gitops targets replace "+
+			"--target",
+	)
+	changedSince := flag.String(
+		"changed_since", "",
+		"Only process trains with a target depending on "+
+			"a file changed since this git commit",
+	)
 
 	// Git repository flags.
 	gitRepo := flag.String(
@@ -89,19 +140,58 @@ func run() error {
 		"git_mirror", "",
 		"Local git mirror for reference clones",
 	)
-	gitopsPath := flag.String(
-		"gitops_path", "",
-		"Subdirectory for sparse checkout",
+
+	var gitBackend cmdflag.GitBackend
+
+	gitBackend.Register(flag.CommandLine)
+
+	gitCacheDir := flag.String(
+		"git_cache_dir", "",
+		"Persistent clone cache directory "+
+			"(reused and locked across runs)",
 	)
 	tmpDir := flag.String(
 		"tmp_dir", os.TempDir(),
 		"Temporary directory for clones",
 	)
+	deriveGitopsPaths := flag.Bool(
+		"derive_gitops_paths", false,
+		"Add each target's gitops_path attribute "+
+			"to the sparse checkout",
+	)
+
+	var gitopsPaths cmdflag.Slice
+
+	flag.Var(
+		&gitopsPaths,
+		"gitops_path",
+		"Subdirectory for sparse checkout (repeatable)",
+	)
 
 	// Branch flags.
-	releaseBranch := flag.String(
-		"release_branch", "",
-		"Release branch to filter targets by",
+	var releaseBranches cmdflag.Comma
+
+	flag.Var(
+		&releaseBranches,
+		"release_branch",
+		"Release branch to filter targets by "+
+			"(repeatable or comma-separated)",
+	)
+
+	var trainKeys cmdflag.Slice
+
+	flag.Var(
+		&trainKeys,
+		"train_key",
+		"Rule attribute naming the deployment train, "+
+			"in order (repeatable; default "+
+			"deployment_branch)",
+	)
+
+	releaseMatch := flag.String(
+		"release_branch_match", "exact",
+		"How release_branch_prefix matches --release_branch: "+
+			"exact, prefix, glob or regex",
 	)
 	primaryBranch := flag.String(
 		"primary_branch", "main",
@@ -123,6 +213,32 @@ func run() error {
 		"git_commit", "",
 		"Source commit SHA for stamp context",
 	)
+	stableStatus := flag.String(
+		"stable_status_file", "",
+		"Bazel stable-status.txt whose variables the "+
+			"stamp context includes",
+	)
+	volatileStatus := flag.String(
+		"volatile_status_file", "",
+		"Bazel volatile-status.txt whose variables the "+
+			"stamp context includes, overriding "+
+			"--stable_status_file",
+	)
+	stampMode := flag.String(
+		"stamp_mode", string(stamp.Lenient),
+		"Parsing of the status files: lenient skips "+
+			"malformed lines; strict rejects them and keys "+
+			"set to different values",
+	)
+
+	var stampVars cmdflag.Slice
+
+	flag.Var(
+		&stampVars,
+		"stamp_var",
+		"Stamp variable KEY=VALUE overriding the status "+
+			"files (repeatable)",
+	)
 
 	// Push flags.
 	pushParallelism := flag.Int(
@@ -131,7 +247,7 @@ func run() error {
 	)
 
 	// Slice flags for rule matching.
-	var gitopsKinds sliceFlag
+	var gitopsKinds cmdflag.Slice
 
 	flag.Var(
 		&gitopsKinds,
@@ -139,7 +255,7 @@ func run() error {
 		"Rule kind to query (repeatable)",
 	)
 
-	var gitopsRuleNames sliceFlag
+	var gitopsRuleNames cmdflag.Slice
 
 	flag.Var(
 		&gitopsRuleNames,
@@ -147,7 +263,7 @@ func run() error {
 		"Rule name for push deps query (repeatable)",
 	)
 
-	var gitopsRuleAttrs sliceFlag
+	var gitopsRuleAttrs cmdflag.Slice
 
 	flag.Var(
 		&gitopsRuleAttrs,
@@ -164,14 +280,97 @@ func run() error {
 		"pr_body", "",
 		"Body for created pull requests",
 	)
+
+	var prReviewers cmdflag.Slice
+
+	flag.Var(
+		&prReviewers,
+		"pr_reviewer",
+		"Reviewer requested on created PRs (repeatable)",
+	)
+
+	var prLabels cmdflag.Slice
+
+	flag.Var(
+		&prLabels,
+		"pr_label",
+		"Label applied to created PRs (repeatable)",
+	)
+
+	prDiff := flag.Bool(
+		"pr_diff", false,
+		"Append a semantic manifest diff to PR bodies",
+	)
 	dryRun := flag.Bool(
 		"dry_run", false,
 		"Skip push and PR creation",
 	)
-	stamp := flag.Bool(
+	stampFiles := flag.Bool(
 		"stamp", false,
 		"Enable file stamping",
 	)
+	digestStore := flag.String(
+		"digest_store", "sidecar",
+		"Storage of the digests of stamped files: sidecar "+
+			"(a .digest file per manifest) or index (one "+
+			digester.IndexName+" file per directory)",
+	)
+	digestAlgorithm := flag.String(
+		"digest_algorithm", "",
+		"Algorithm of the digests saved by stamping: "+
+			"sha256 or sha256-yaml (default: unprefixed sha256)",
+	)
+	// Validation flags.
+	var validateChecks cmdflag.Comma
+
+	flag.Var(
+		&validateChecks,
+		"validate",
+		"Built-in check run on rendered manifests before "+
+			"commit (repeatable, comma-separated): "+
+			strings.Join(validate.Builtins, ", "),
+	)
+
+	var requiredLabels cmdflag.Slice
+
+	flag.Var(
+		&requiredLabels,
+		"required_label",
+		"Label every rendered resource must carry "+
+			"(repeatable)",
+	)
+
+	validateCmd := flag.String(
+		"validate_cmd", "",
+		"External validator command run on the rendered "+
+			"manifest files, split on white space",
+	)
+	kubernetesVersion := flag.String(
+		"kubernetes_version", "",
+		"Validate rendered manifests against the bundled "+
+			"schemas of this Kubernetes version ("+
+			strings.Join(schema.BundledVersions(), ", ")+")",
+	)
+
+	var schemaFiles cmdflag.Slice
+
+	flag.Var(
+		&schemaFiles,
+		"schema",
+		"CRD or OpenAPI document file validating rendered "+
+			"manifests (repeatable)",
+	)
+
+	ignoreMissingSchemas := flag.Bool(
+		"ignore_missing_schemas", false,
+		"Skip resources whose kind has no schema",
+	)
+
+	policyFile := flag.String(
+		"policy_file", "",
+		"YAML or JSON policy file gating the PRs of "+
+			"each train before push",
+	)
 
 	// Git provider selection.
 	gitServer := flag.String(
@@ -193,6 +392,12 @@ func run() error {
 		"github_access_token", "",
 		"GitHub personal access token",
 	)
+	ghTokenSource := flag.String(
+		"github_access_token_source", "",
+		"Read the GitHub token from env:NAME, "+
+			"file:PATH or cmd:COMMAND instead, "+
+			"re-reading it when it expires",
+	)
 	ghEnterprise := flag.String(
 		"github_enterprise_host", "",
 		"GitHub Enterprise hostname",
@@ -211,6 +416,12 @@ func run() error {
 		"gitlab_access_token", "",
 		"GitLab personal access token",
 	)
+	glTokenSource := flag.String(
+		"gitlab_access_token_source", "",
+		"Read the GitLab token from env:NAME, "+
+			"file:PATH or cmd:COMMAND instead, "+
+			"re-reading it when it expires",
+	)
 
 	// Bitbucket-specific flags.
 	bbEndpoint := flag.String(
@@ -225,23 +436,51 @@ func run() error {
 		"bitbucket_password", "",
 		"Bitbucket API password or token",
 	)
+	bbPasswordSource := flag.String(
+		"bitbucket_password_source", "",
+		"Read the Bitbucket password from env:NAME, "+
+			"file:PATH or cmd:COMMAND instead, "+
+			"re-reading it when it expires",
+	)
 
 	flag.Parse()
 
+	// Fill the flags not set on the command line from
+	// the config file.
+	var trainPRs map[string]prer.TrainPR
+
+	if *configPath != "" {
+		file, err := config.Load(*configPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		if err := file.Apply(flag.CommandLine); err != nil {
+			return fmt.Errorf(
+				"%s: invalid config:\n%w", errCtx, err,
+			)
+		}
+
+		trainPRs = file.Trains
+	}
+
 	// Build git provider from flags.
 	provider, err := newGitProvider(
 		*gitServer,
 		providerFlags{
-			ghRepoOwner:  *ghRepoOwner,
-			ghRepo:       *ghRepo,
-			ghToken:      *ghToken,
-			ghEnterprise: *ghEnterprise,
-			glHost:       *glHost,
-			glRepo:       *glRepo,
-			glToken:      *glToken,
-			bbEndpoint:   *bbEndpoint,
-			bbUser:       *bbUser,
-			bbPassword:   *bbPassword,
+			ghRepoOwner:      *ghRepoOwner,
+			ghRepo:           *ghRepo,
+			ghToken:          *ghToken,
+			ghTokenSource:    *ghTokenSource,
+			ghEnterprise:     *ghEnterprise,
+			glHost:           *glHost,
+			glRepo:           *glRepo,
+			glToken:          *glToken,
+			glTokenSource:    *glTokenSource,
+			bbEndpoint:       *bbEndpoint,
+			bbUser:           *bbUser,
+			bbPassword:       *bbPassword,
+			bbPasswordSource: *bbPasswordSource,
 		},
 	)
 	if err != nil {
@@ -250,40 +489,220 @@ func run() error {
 		)
 	}
 
+	backend, err := gitBackend.New()
+	if err != nil {
+		return fmt.Errorf(
+			"%s: create git backend: %w", errCtx, err,
+		)
+	}
+
+	matchMode, err := prer.ParseMatchMode(*releaseMatch)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	store, err := newDigestStore(*digestStore)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var digestAlg digester.Algorithm
+
+	if *digestAlgorithm != "" {
+		digestAlg, err = digester.ParseAlgorithm(*digestAlgorithm)
+		if err != nil {
+			return fmt.Errorf(
+				"%s: --digest_algorithm: %w", errCtx, err,
+			)
+		}
+	}
+
+	validator, err := newValidator(
+		validateChecks, requiredLabels, *validateCmd,
+		schemaOptions{
+			version:       *kubernetesVersion,
+			files:         schemaFiles,
+			ignoreMissing: *ignoreMissingSchemas,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var gate *policy.Policy
+
+	if *policyFile != "" {
+		if gate, err = policy.Load(*policyFile); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
+	mode, err := stamp.ParseMode(*stampMode)
+	if err != nil {
+		return fmt.Errorf("%s: --stamp_mode: %w", errCtx, err)
+	}
+
+	vars, err := prer.ParseStampVars(stampVars)
+	if err != nil {
+		return fmt.Errorf("%s: --stamp_var: %w", errCtx, err)
+	}
+
+	var statusFiles []string
+
+	for _, f := range []string{*stableStatus, *volatileStatus} {
+		if f != "" {
+			statusFiles = append(statusFiles, f)
+		}
+	}
+
 	cfg := prer.Config{
 		BazelCmd:               *bazelCmd,
+		BazelStartupFlags:      bazelStartupFlags,
+		BazelFlags:             bazelFlags,
 		Workspace:              *workspace,
 		Target:                 *target,
+		QueryCacheDir:          *queryCacheDir,
+		BEPFile:                *bepFile,
+		ChangedSince:           *changedSince,
 		GitRepo:                *gitRepo,
 		GitMirror:              *gitMirror,
-		GitopsPath:             *gitopsPath,
+		GitBackend:             backend,
+		GitCacheDir:            *gitCacheDir,
+		GitopsPaths:            gitopsPaths,
+		DeriveGitopsPaths:      *deriveGitopsPaths,
 		TmpDir:                 *tmpDir,
-		ReleaseBranch:          *releaseBranch,
+		ReleaseBranches:        releaseBranches,
+		ReleaseMatch:           matchMode,
+		TrainKeys:              trainKeys,
 		PrimaryBranch:          *primaryBranch,
 		DeploymentBranchPrefix: *depBranchPrefix,
 		DeploymentBranchSuffix: *depBranchSuffix,
 		BranchName:             *branchName,
 		GitCommit:              *gitCommit,
+		StampInfoFiles:         statusFiles,
+		StampMode:              mode,
+		StampVars:              vars,
 		PushParallelism:        *pushParallelism,
 		GitopsKinds:            gitopsKinds,
 		GitopsRuleNames:        gitopsRuleNames,
 		GitopsRuleAttrs:        gitopsRuleAttrs,
 		PRTitle:                *prTitle,
 		PRBody:                 *prBody,
+		PRReviewers:            prReviewers,
+		PRLabels:               prLabels,
+		TrainPRs:               trainPRs,
+		PRDiff:                 *prDiff,
 		DryRun:                 *dryRun,
-		Stamp:                  *stamp,
+		Stamp:                  *stampFiles,
+		DigestAlgorithm:        digestAlg,
+		DigestStore:            store,
+		Validator:              validator,
+		Policy:                 gate,
 		Provider:               provider,
 	}
 
-	if err := prer.Run(
-		context.Background(), cfg,
-	); err != nil {
+	if !promote {
+		if *promoteFrom != "" || *promoteTo != "" {
+			return fmt.Errorf(
+				"%s: --from and --to require the "+
+					"promote subcommand", errCtx,
+			)
+		}
+
+		err = prer.Run(context.Background(), cfg)
+	} else {
+		err = prer.Promote(
+			context.Background(), cfg, prer.Promotion{
+				From: *promoteFrom,
+				To:   *promoteTo,
+			},
+		)
+	}
+
+	if err != nil {
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
 	return nil
 }
 
+// schemaOptions select the schemas of the schema
+// check: the bundled ones of version, if set, and
+// those of files.
+type schemaOptions struct {
+	version       string
+	files         []string
+	ignoreMissing bool
+}
+
+// newValidator creates the manifest validator of the
+// named built-in checks, the required labels check,
+// the external command and the schema check. It
+// returns nil when no check is requested.
+func newValidator(
+	names []string,
+	labels []string,
+	cmd string,
+	schemas schemaOptions,
+) (*validate.Validator, error) {
+	v := &validate.Validator{}
+
+	for _, name := range names {
+		c, err := validate.Lookup(name)
+		if err != nil {
+			return nil, fmt.Errorf("--validate: %w", err)
+		}
+
+		v.Checks = append(v.Checks, c)
+	}
+
+	if len(labels) > 0 {
+		v.Checks = append(
+			v.Checks, validate.RequiredLabels{Labels: labels},
+		)
+	}
+
+	if args := strings.Fields(cmd); len(args) > 0 {
+		v.Checks = append(v.Checks, validate.Command(args))
+	}
+
+	if schemas.version != "" || len(schemas.files) > 0 {
+		set, err := schema.Load(schemas.version, schemas.files...)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Checks = append(v.Checks, schema.Check{
+			Set:           set,
+			IgnoreMissing: schemas.ignoreMissing,
+		})
+	}
+
+	if len(v.Checks) == 0 {
+		return nil, nil
+	}
+
+	return v, nil
+}
+
+// newDigestStore creates the digester.Store named
+// name. Pattern: Factory -- selects digest storage at
+// runtime.
+func newDigestStore(name string) (digester.Store, error) {
+	switch name {
+	case "sidecar":
+		return digester.SidecarStore{}, nil
+	case "index":
+		return &digester.IndexStore{}, nil
+	default:
+		return nil, fmt.Errorf(
+			"creating digest store: unknown store %q "+
+				"(expected sidecar or index)",
+			name,
+		)
+	}
+}
+
 // newGitProvider creates a git.GitProvider based on the
 // server name. Pattern: Factory -- selects platform
 // implementation at runtime.
@@ -295,11 +714,20 @@ func newGitProvider(
 
 	switch server {
 	case "github":
+		src, err := parseSource(pf.ghTokenSource)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: github_access_token_source: %w",
+				errCtx, err,
+			)
+		}
+
 		gp, err := github.NewProvider(github.Config{
-			RepoOwner:      pf.ghRepoOwner,
-			Repo:           pf.ghRepo,
-			AccessToken:    pf.ghToken,
-			EnterpriseHost: pf.ghEnterprise,
+			RepoOwner:         pf.ghRepoOwner,
+			Repo:              pf.ghRepo,
+			AccessToken:       pf.ghToken,
+			AccessTokenSource: src,
+			EnterpriseHost:    pf.ghEnterprise,
 		})
 		if err != nil {
 			return nil, fmt.Errorf(
@@ -310,10 +738,19 @@ func newGitProvider(
 		return gp, nil
 
 	case "gitlab":
+		src, err := parseSource(pf.glTokenSource)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: gitlab_access_token_source: %w",
+				errCtx, err,
+			)
+		}
+
 		gp, err := gitlab.NewProvider(gitlab.Config{
-			Host:        pf.glHost,
-			Repo:        pf.glRepo,
-			AccessToken: pf.glToken,
+			Host:              pf.glHost,
+			Repo:              pf.glRepo,
+			AccessToken:       pf.glToken,
+			AccessTokenSource: src,
 		})
 		if err != nil {
 			return nil, fmt.Errorf(
@@ -324,11 +761,20 @@ func newGitProvider(
 		return gp, nil
 
 	case "bitbucket":
+		src, err := parseSource(pf.bbPasswordSource)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: bitbucket_password_source: %w",
+				errCtx, err,
+			)
+		}
+
 		gp, err := bitbucket.NewProvider(
 			bitbucket.Config{
-				APIEndpoint: pf.bbEndpoint,
-				User:        pf.bbUser,
-				Password:    pf.bbPassword,
+				APIEndpoint:    pf.bbEndpoint,
+				User:           pf.bbUser,
+				Password:       pf.bbPassword,
+				PasswordSource: src,
 			},
 		)
 		if err != nil {
@@ -345,3 +791,13 @@ func newGitProvider(
 		)
 	}
 }
+
+// parseSource parses a *_source flag value; an empty
+// value means no source.
+func parseSource(spec string) (secret.Source, error) {
+	if spec == "" {
+		return nil, nil //nolint:nilnil // flag not set
+	}
+
+	return secret.Parse(spec)
+}
@@ -0,0 +1,635 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+
+	"github.com/byte4ever/rules_gitops/gitops/prer"
+	"github.com/byte4ever/rules_gitops/gitops/secret"
+)
+
+// trainsKey is the top-level key holding the per-train
+// pull request overrides; every other key names a flag.
+const trainsKey = "trains"
+
+// sourceSuffix names the companion flag of a
+// credential flag accepting a secret source
+// specification, e.g. github_access_token_source.
+const sourceSuffix = "_source"
+
+// ListValue is implemented by flag values accepting a
+// list, such as repeatable flags. Like the IsBoolFlag
+// convention of the flag package, it tells Apply to
+// expect a YAML sequence.
+type ListValue interface {
+	flag.Value
+	IsList() bool
+}
+
+// SecretRef reads a value from outside the
+// configuration file. Exactly one field is set.
+type SecretRef struct {
+	// Env names an environment variable.
+	Env string
+
+	// File is the path of a file, e.g. a mounted
+	// Kubernetes secret. Trailing newlines are
+	// removed.
+	File string
+
+	// Command is a credential helper command line,
+	// split on white space, printing the value.
+	Command string
+}
+
+// Source returns the secret source of r.
+func (r SecretRef) Source() secret.Source {
+	switch {
+	case r.Env != "":
+		return secret.Env(r.Env)
+	case r.File != "":
+		return secret.File(r.File)
+	default:
+		return secret.Command(strings.Fields(r.Command))
+	}
+}
+
+// Spec returns r in the syntax of secret.Parse, as
+// accepted by the *_source flags.
+func (r SecretRef) Spec() string {
+	switch {
+	case r.Env != "":
+		return "env:" + r.Env
+	case r.File != "":
+		return "file:" + r.File
+	default:
+		return "cmd:" + r.Command
+	}
+}
+
+// String describes the source without its content.
+func (r SecretRef) String() string {
+	return r.Source().String()
+}
+
+// Resolve reads the referenced value. Errors name the
+// source but never contain the value.
+func (r SecretRef) Resolve() (string, error) {
+	cred, err := r.Source().Fetch(context.Background())
+
+	return cred.Value, err
+}
+
+// File is a parsed create_gitops_prs configuration
+// file.
+type File struct {
+	// Path is the file name used in error messages.
+	Path string
+
+	// Trains holds the per-train pull request
+	// overrides of the "trains" section.
+	Trains map[string]prer.TrainPR
+
+	values []value
+}
+
+// value is one flag setting of the file.
+type value struct {
+	key    string
+	pos    position
+	scalar *string
+	list   []string
+	secret *SecretRef
+}
+
+// position is a line and column in the file.
+type position struct {
+	line   int
+	column int
+}
+
+// Load reads and parses the configuration file at
+// path.
+func Load(path string) (*File, error) {
+	const errCtx = "loading config"
+
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	f, err := Parse(data, path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return f, nil
+}
+
+// Parse parses a YAML (or JSON) configuration file.
+// The top level is a mapping from flag names to
+// values, plus the optional "trains" section. Every
+// structural error is reported with its line and
+// column.
+func Parse(data []byte, path string) (*File, error) {
+	f := &File{Path: path, Trains: map[string]prer.TrainPR{}}
+
+	tree, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(tree.Docs) > 1 {
+		return nil, fmt.Errorf(
+			"%s: expected a single document, got %d",
+			path, len(tree.Docs),
+		)
+	}
+
+	if len(tree.Docs) == 0 || tree.Docs[0].Body == nil {
+		return f, nil
+	}
+
+	entries, ok := mappingValues(tree.Docs[0].Body)
+	if !ok {
+		return nil, f.errorf(
+			tree.Docs[0].Body, "expected a mapping of flag names to values",
+		)
+	}
+
+	var errs []error
+
+	seen := make(map[string]struct{})
+
+	for _, mv := range entries {
+		key, err := f.key(mv)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if _, dup := seen[key]; dup {
+			errs = append(errs, f.errorf(
+				mv.Key, "%s: duplicate key", key,
+			))
+
+			continue
+		}
+
+		seen[key] = struct{}{}
+
+		if key == trainsKey {
+			errs = append(errs, f.parseTrains(mv.Value))
+
+			continue
+		}
+
+		v, err := f.parseValue(key, mv)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		f.values = append(f.values, v)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Apply validates the file against the flags of fs
+// and sets every flag that was not set on the command
+// line, so flags override file values. A secret
+// reference for a flag with a companion "<flag>_source"
+// flag sets that flag instead, so the value is read
+// again when it expires; other secret references are
+// resolved here. All errors are reported, not only the
+// first one.
+func (f *File) Apply(fs *flag.FlagSet) error {
+	explicit := make(map[string]struct{})
+
+	fs.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = struct{}{}
+	})
+
+	var errs []error
+
+	for _, v := range f.values {
+		fl := fs.Lookup(v.key)
+		if fl == nil {
+			errs = append(errs, f.posErrorf(
+				v.pos, "%s: unknown key%s",
+				v.key, suggest(fs, v.key),
+			))
+
+			continue
+		}
+
+		if _, ok := explicit[v.key]; ok {
+			continue
+		}
+
+		if v.secret != nil {
+			if src := fs.Lookup(v.key + sourceSuffix); src != nil {
+				if _, ok := explicit[src.Name]; !ok {
+					// The spec names the source, never
+					// the value.
+					if err := src.Value.Set(v.secret.Spec()); err != nil {
+						errs = append(errs, f.posErrorf(
+							v.pos, "%s: invalid source %s: %v",
+							src.Name, v.secret, err,
+						))
+					}
+				}
+
+				continue
+			}
+		}
+
+		if err := f.set(fl, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// set assigns v to fl after checking its shape.
+func (f *File) set(fl *flag.Flag, v value) error {
+	lv, isList := fl.Value.(ListValue)
+	isList = isList && lv.IsList()
+
+	switch {
+	case isList && v.list == nil:
+		return f.posErrorf(
+			v.pos, "%s: expected a list of strings", v.key,
+		)
+	case !isList && v.list != nil:
+		return f.posErrorf(
+			v.pos, "%s: expected a single value, got a list",
+			v.key,
+		)
+	}
+
+	vals := v.list
+
+	switch {
+	case v.secret != nil:
+		s, err := v.secret.Resolve()
+		if err != nil {
+			return f.posErrorf(
+				v.pos, "%s: secret from %s: %v",
+				v.key, v.secret, err,
+			)
+		}
+
+		vals = []string{s}
+	case v.scalar != nil:
+		vals = []string{*v.scalar}
+	}
+
+	for _, s := range vals {
+		err := fl.Value.Set(s)
+
+		switch {
+		case err == nil:
+			continue
+		case v.secret != nil:
+			// Neither the value nor the parse error,
+			// which may quote it, is echoed.
+			return f.posErrorf(
+				v.pos, "%s: invalid value from %s",
+				v.key, v.secret,
+			)
+		default:
+			return f.posErrorf(
+				v.pos, "%s: invalid value %q: %v",
+				v.key, s, err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// key decodes the key of mv.
+func (f *File) key(mv *ast.MappingValueNode) (string, error) {
+	var key any
+	if err := yaml.NodeToValue(mv.Key, &key); err != nil {
+		return "", f.errorf(mv.Key, "invalid key: %v", err)
+	}
+
+	s, ok := key.(string)
+	if !ok || s == "" {
+		return "", f.errorf(mv.Key, "key must be a non-empty string")
+	}
+
+	return s, nil
+}
+
+// parseValue decodes the value of a flag setting.
+func (f *File) parseValue(
+	key string,
+	mv *ast.MappingValueNode,
+) (value, error) {
+	v := value{key: key, pos: pos(mv.Key)}
+
+	if entries, ok := mappingValues(mv.Value); ok {
+		ref, err := f.parseSecretRef(key, mv.Value, entries)
+		if err != nil {
+			return value{}, err
+		}
+
+		v.secret = &ref
+
+		return v, nil
+	}
+
+	var raw any
+	if err := yaml.NodeToValue(mv.Value, &raw); err != nil {
+		return value{}, f.errorf(mv.Value, "%s: %v", key, err)
+	}
+
+	switch x := raw.(type) {
+	case nil:
+		return value{}, f.errorf(mv.Value, "%s: missing value", key)
+	case []any:
+		v.list = make([]string, 0, len(x))
+
+		for i, e := range x {
+			s, ok := scalar(e)
+			if !ok {
+				return value{}, f.errorf(
+					mv.Value, "%s[%d]: expected a string", key, i,
+				)
+			}
+
+			v.list = append(v.list, s)
+		}
+	default:
+		s, ok := scalar(x)
+		if !ok {
+			return value{}, f.errorf(
+				mv.Value, "%s: expected a string, number or boolean",
+				key,
+			)
+		}
+
+		v.scalar = &s
+	}
+
+	return v, nil
+}
+
+// parseSecretRef decodes {env: NAME}, {file: PATH} or
+// {cmd: COMMAND}.
+func (f *File) parseSecretRef(
+	key string,
+	node ast.Node,
+	entries []*ast.MappingValueNode,
+) (SecretRef, error) {
+	var ref SecretRef
+
+	for _, mv := range entries {
+		k, err := f.key(mv)
+		if err != nil {
+			return SecretRef{}, err
+		}
+
+		var s string
+		if err := yaml.NodeToValue(mv.Value, &s); err != nil || s == "" {
+			return SecretRef{}, f.errorf(
+				mv.Value, "%s.%s: expected a non-empty string", key, k,
+			)
+		}
+
+		switch k {
+		case "env":
+			ref.Env = s
+		case "file":
+			ref.File = s
+		case "cmd":
+			ref.Command = s
+		default:
+			return SecretRef{}, f.errorf(
+				mv.Key, "%s: unknown secret source %q "+
+					"(expected env, file or cmd)", key, k,
+			)
+		}
+	}
+
+	set := 0
+
+	for _, s := range []string{ref.Env, ref.File, ref.Command} {
+		if s != "" {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return SecretRef{}, f.errorf(
+			node, "%s: a secret reference needs exactly "+
+				"one of env, file or cmd", key,
+		)
+	}
+
+	return ref, nil
+}
+
+// parseTrains decodes the trains section.
+func (f *File) parseTrains(node ast.Node) error {
+	trains, ok := mappingValues(node)
+	if !ok {
+		return f.errorf(
+			node, "%s: expected a mapping of train names", trainsKey,
+		)
+	}
+
+	var errs []error
+
+	for _, tv := range trains {
+		name, err := f.key(tv)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		fields, ok := mappingValues(tv.Value)
+		if !ok {
+			errs = append(errs, f.errorf(
+				tv.Value, "%s.%s: expected a mapping", trainsKey, name,
+			))
+
+			continue
+		}
+
+		var pr prer.TrainPR
+
+		for _, fv := range fields {
+			field, err := f.key(fv)
+			if err != nil {
+				errs = append(errs, err)
+
+				continue
+			}
+
+			path := trainsKey + "." + name + "." + field
+
+			switch field {
+			case "title":
+				err = f.decode(fv.Value, path, &pr.Title)
+			case "reviewers":
+				err = f.decode(fv.Value, path, &pr.Reviewers)
+			case "labels":
+				err = f.decode(fv.Value, path, &pr.Labels)
+			default:
+				err = f.errorf(
+					fv.Key, "%s: unknown key (expected title, "+
+						"reviewers or labels)", path,
+				)
+			}
+
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		f.Trains[name] = pr
+	}
+
+	return errors.Join(errs...)
+}
+
+// decode decodes node into out, which is a *string or
+// a *[]string.
+func (f *File) decode(node ast.Node, path string, out any) error {
+	if err := yaml.NodeToValue(node, out, yaml.Strict()); err != nil {
+		want := "a string"
+		if _, ok := out.(*[]string); ok {
+			want = "a list of strings"
+		}
+
+		return f.errorf(node, "%s: expected %s", path, want)
+	}
+
+	return nil
+}
+
+// errorf formats an error located at node.
+func (f *File) errorf(node ast.Node, format string, args ...any) error {
+	return f.posErrorf(pos(node), format, args...)
+}
+
+// posErrorf formats an error located at p.
+func (f *File) posErrorf(p position, format string, args ...any) error {
+	return fmt.Errorf(
+		"%s:%d:%d: %s",
+		f.Path, p.line, p.column, fmt.Sprintf(format, args...),
+	)
+}
+
+// pos returns the position of node.
+func pos(node ast.Node) position {
+	if node == nil || node.GetToken() == nil {
+		return position{}
+	}
+
+	p := node.GetToken().Position
+
+	return position{line: p.Line, column: p.Column}
+}
+
+// mappingValues returns the entries of a mapping node.
+// A mapping with a single entry may be parsed as a
+// bare MappingValueNode.
+func mappingValues(node ast.Node) ([]*ast.MappingValueNode, bool) {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		return n.Values, true
+	case *ast.MappingValueNode:
+		return []*ast.MappingValueNode{n}, true
+	default:
+		return nil, false
+	}
+}
+
+// scalar formats a decoded YAML scalar as a flag
+// value.
+func scalar(v any) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(x), true
+	default:
+		return "", false
+	}
+}
+
+// suggest returns a hint naming the flag closest to an
+// unknown key, or an empty string.
+func suggest(fs *flag.FlagSet, key string) string {
+	best, bestDist := "", len(key)/3+1
+
+	var names []string
+
+	fs.VisitAll(func(fl *flag.Flag) {
+		names = append(names, fl.Name)
+	})
+
+	sort.Strings(names)
+
+	for _, name := range append(names, trainsKey) {
+		if d := distance(key, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// distance is the Levenshtein distance of a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,219 @@
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/prer"
+	"github.com/byte4ever/rules_gitops/gitops/prer/config"
+)
+
+// listFlag is a repeatable flag value.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+
+	return nil
+}
+
+func (l *listFlag) IsList() bool { return true }
+
+// flags mirrors a subset of the create_gitops_prs
+// flags.
+type flags struct {
+	fs          *flag.FlagSet
+	gitRepo     *string
+	token       *string
+	parallelism *int
+	dryRun      *bool
+	kinds       listFlag
+}
+
+func newFlags() *flags {
+	f := &flags{fs: flag.NewFlagSet("test", flag.ContinueOnError)}
+	f.gitRepo = f.fs.String("git_repo", "", "")
+	f.token = f.fs.String("github_access_token", "", "")
+	f.parallelism = f.fs.Int("push_parallelism", 4, "")
+	f.dryRun = f.fs.Bool("dry_run", false, "")
+	f.fs.Var(&f.kinds, "gitops_kind", "")
+
+	return f
+}
+
+func TestApply_flagsOverrideFile(t *testing.T) {
+	t.Setenv("TEST_GITHUB_TOKEN", "s3cr3t")
+
+	cfg, err := config.Parse([]byte(`
+git_repo: https://example.com/file.git
+github_access_token: {env: TEST_GITHUB_TOKEN}
+push_parallelism: 8
+dry_run: true
+gitops_kind: [gitops, k8s_deploy]
+trains:
+  prod:
+    title: Production deployment
+    reviewers: [alice, org/sre]
+    labels: [prod]
+`), "gitops.yaml")
+	require.NoError(t, err)
+
+	f := newFlags()
+	require.NoError(t, f.fs.Parse([]string{
+		"--git_repo=https://example.com/flag.git",
+	}))
+	require.NoError(t, cfg.Apply(f.fs))
+
+	assert.Equal(t, "https://example.com/flag.git", *f.gitRepo)
+	assert.Equal(t, "s3cr3t", *f.token)
+	assert.Equal(t, 8, *f.parallelism)
+	assert.True(t, *f.dryRun)
+	assert.Equal(t, listFlag{"gitops", "k8s_deploy"}, f.kinds)
+	assert.Equal(t, map[string]prer.TrainPR{
+		"prod": {
+			Title:     "Production deployment",
+			Reviewers: []string{"alice", "org/sre"},
+			Labels:    []string{"prod"},
+		},
+	}, cfg.Trains)
+}
+
+func TestApply_secretFile(t *testing.T) {
+	t.Parallel()
+
+	secret := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(secret, []byte("from-file\n"), 0o600))
+
+	cfg, err := config.Parse(
+		[]byte(`{"github_access_token": {"file": "`+secret+`"}}`),
+		"gitops.json",
+	)
+	require.NoError(t, err)
+
+	f := newFlags()
+	require.NoError(t, cfg.Apply(f.fs))
+	assert.Equal(t, "from-file", *f.token)
+}
+
+func TestApply_secretSourceFlag(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := config.Parse([]byte(`
+github_access_token: {cmd: gh auth token}
+gitlab_access_token: {env: TEST_GITLAB_TOKEN}
+`), "gitops.yaml")
+	require.NoError(t, err)
+
+	f := newFlags()
+	githubSource := f.fs.String("github_access_token_source", "", "")
+	gitlabSource := f.fs.String("gitlab_access_token_source", "", "")
+	f.fs.String("gitlab_access_token", "", "")
+
+	require.NoError(t, f.fs.Parse([]string{
+		"--gitlab_access_token_source=file:/run/token",
+	}))
+	require.NoError(t, cfg.Apply(f.fs))
+
+	// The helper is not run: the provider reads the
+	// token from the source when needed.
+	assert.Equal(t, "cmd:gh auth token", *githubSource)
+	assert.Empty(t, *f.token)
+	assert.Equal(t, "file:/run/token", *gitlabSource)
+}
+
+func TestApply_secretSourceFlagError(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := config.Parse([]byte(`
+github_access_token: {env: TEST_GITHUB_TOKEN}
+`), "gitops.yaml")
+	require.NoError(t, err)
+
+	f := newFlags()
+	f.fs.Func("github_access_token_source", "", func(string) error {
+		return errors.New("sources disabled")
+	})
+
+	err = cfg.Apply(f.fs)
+	require.ErrorContains(t, err,
+		"gitops.yaml:2:1: github_access_token_source: invalid "+
+			"source env TEST_GITHUB_TOKEN: sources disabled",
+	)
+}
+
+func TestApply_validationErrors(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := config.Parse([]byte(`git_rep: x
+push_parallelism: many
+gitops_kind: gitops
+github_access_token: {env: TEST_UNSET_TOKEN_VARIABLE}
+`), "gitops.yaml")
+	require.NoError(t, err)
+
+	err = cfg.Apply(newFlags().fs)
+	require.Error(t, err)
+
+	msg := err.Error()
+	assert.Contains(t, msg,
+		"gitops.yaml:1:1: git_rep: unknown key (did you mean git_repo?)")
+	assert.Contains(t, msg,
+		`gitops.yaml:2:1: push_parallelism: invalid value "many"`)
+	assert.Contains(t, msg,
+		"gitops.yaml:3:1: gitops_kind: expected a list of strings")
+	assert.Contains(t, msg,
+		"gitops.yaml:4:1: github_access_token: secret from env "+
+			"TEST_UNSET_TOKEN_VARIABLE: environment variable "+
+			"TEST_UNSET_TOKEN_VARIABLE is not set")
+}
+
+func TestParse_structureErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := config.Parse([]byte(`dry_run: true
+github_access_token: {env: A, file: b}
+trains:
+  prod:
+    title: [not, a, string]
+    owners: [alice]
+`), "gitops.yaml")
+	require.Error(t, err)
+
+	msg := err.Error()
+	assert.Contains(t, msg,
+		"github_access_token: a secret reference needs exactly "+
+			"one of env, file or cmd")
+	assert.Contains(t, msg,
+		"gitops.yaml:5:12: trains.prod.title: expected a string")
+	assert.Contains(t, msg,
+		"gitops.yaml:6:5: trains.prod.owners: unknown key")
+
+	_, err = config.Parse([]byte("- a\n- b\n"), "gitops.yaml")
+	assert.ErrorContains(t, err,
+		"gitops.yaml:1:1: expected a mapping of flag names to values")
+}
+
+func TestSecretRef_errorsDoNotLeak(t *testing.T) {
+	t.Setenv("TEST_PARALLELISM_SECRET", "hunter2")
+
+	cfg, err := config.Parse([]byte(
+		"push_parallelism: {env: TEST_PARALLELISM_SECRET}\n",
+	), "gitops.yaml")
+	require.NoError(t, err)
+
+	err = cfg.Apply(newFlags().fs)
+	require.ErrorContains(
+		t, err,
+		"push_parallelism: invalid value from env TEST_PARALLELISM_SECRET",
+	)
+	assert.NotContains(t, err.Error(), "hunter2")
+}
@@ -0,0 +1,6 @@
+// Package config loads the YAML or JSON configuration file of
+// create_gitops_prs. Top-level keys are flag names, so the file is validated
+// against the flag set and flags given on the command line override it. A
+// "trains" section holds per-train pull request overrides, and values can be
+// read from environment variables or files instead of the file itself.
+package config
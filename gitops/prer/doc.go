@@ -4,5 +4,6 @@
 // using a configurable worker pool, and creates PRs via a git.GitProvider.
 //
 // The main entry point is Run, which accepts a Config struct with all
-// parameters for the workflow.
+// parameters for the workflow. Promote applies the build deployed on one
+// deployment train to the next one and records the promotion lineage.
 package prer
@@ -27,6 +27,9 @@ var GetStampContextForTest = getStampContext
 // StampFileForTest exposes stampFile.
 var StampFileForTest = stampFile
 
+// StampChangedFilesForTest exposes stampChangedFiles.
+var StampChangedFilesForTest = stampChangedFiles
+
 // GroupByTrainForTest exposes groupByTrain.
 var GroupByTrainForTest = groupByTrain
 
@@ -39,3 +42,59 @@ var CollectAllTargetsForTest = collectAllTargets
 // ExtractTargetNamesForTest exposes
 // extractTargetNames.
 var ExtractTargetNamesForTest = extractTargetNames
+
+// CollectGitopsPathsForTest exposes collectGitopsPaths.
+var CollectGitopsPathsForTest = collectGitopsPaths
+
+// PRBodyForTest exposes prBody.
+var PRBodyForTest = prBody
+
+// ImagesByNameForTest exposes imagesByName.
+var ImagesByNameForTest = imagesByName
+
+// PinImagesForTest exposes pinImages.
+var PinImagesForTest = pinImages
+
+// UndeployedFilesForTest exposes undeployedFiles.
+var UndeployedFilesForTest = undeployedFiles
+
+// PromotionBodyForTest exposes promotionBody.
+var PromotionBodyForTest = promotionBody
+
+// PRSettingsForTest exposes Config.prSettings.
+var PRSettingsForTest = (*Config).prSettings
+
+// DecodeCqueryForTest exposes decodeCquery.
+var DecodeCqueryForTest = decodeCquery
+
+// BazelQueryForTest exposes bazelQuery.
+var BazelQueryForTest = bazelQuery
+
+// GitopsQueryForTest exposes gitopsQuery.
+var GitopsQueryForTest = gitopsQuery
+
+// ChangedFilesForTest exposes changedFiles.
+var ChangedFilesForTest = changedFiles
+
+// MapChangesForTest exposes mapChanges, returning the
+// labels and whether all targets are required.
+func MapChangesForTest(
+	workspace string,
+	files []string,
+) ([]string, bool) {
+	cs := mapChanges(workspace, files)
+
+	return cs.labels, cs.all
+}
+
+// AffectedTargetsForTest exposes affectedTargets.
+var AffectedTargetsForTest = affectedTargets
+
+// FilterTrainsForTest exposes filterTrains.
+var FilterTrainsForTest = filterTrains
+
+// GatePolicyForTest exposes gatePolicy.
+var GatePolicyForTest = gatePolicy
+
+// ValidateManifestsForTest exposes validateManifests.
+var ValidateManifestsForTest = validateManifests
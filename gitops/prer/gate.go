@@ -0,0 +1,134 @@
+package prer
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/byte4ever/rules_gitops/gitops/diff"
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+	"github.com/byte4ever/rules_gitops/gitops/policy"
+)
+
+// validateManifests runs cfg.Validator on the manifest
+// files below cfg.GitopsPaths of the working tree,
+// after the targets ran and before commit. The whole
+// tree is validated, not only the changed files, since
+// it is what the branch deploys. Findings fail the
+// train with a report per file and resource.
+func validateManifests(
+	repo *git.Repo,
+	cfg Config,
+	depBranch string,
+) error {
+	const errCtx = "validating manifests"
+
+	if cfg.Validator == nil {
+		return nil
+	}
+
+	files, err := manifest.ListFiles(repo.Dir, cfg.GitopsPaths...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	rep, err := cfg.Validator.Validate(repo.Dir, files)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if !rep.OK() {
+		return fmt.Errorf(
+			"%s: %s failed validation:\n%s",
+			errCtx, depBranch, rep,
+		)
+	}
+
+	slog.Info(
+		"manifests valid",
+		"branch", depBranch,
+		"files", len(files),
+	)
+
+	return nil
+}
+
+// gatePolicy evaluates cfg.Policy against the pull
+// request of every updated branch, after commit and
+// before push. It returns the branches to push,
+// without those skipped by a violation, or an error
+// listing every violation when one fails the run.
+func gatePolicy(
+	repo *git.Repo,
+	cfg Config,
+	branches []string,
+	trainOf map[string]string,
+	now time.Time,
+) ([]string, error) {
+	const errCtx = "evaluating policy"
+
+	var (
+		kept    []string
+		reports []string
+		failed  bool
+	)
+
+	for _, branch := range branches {
+		train := trainOf[branch]
+		_, opts := cfg.prSettings(train)
+
+		change := policy.Change{
+			Train:  train,
+			Labels: opts.Labels,
+			Time:   now,
+		}
+
+		if cfg.Policy.LimitsResources() {
+			res, err := diff.CompareRefs(
+				repo,
+				repo.RemoteName+"/"+cfg.PrimaryBranch,
+				branch,
+				cfg.GitopsPaths...,
+			)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"%s: count changed resources of %s: %w",
+					errCtx, branch, err,
+				)
+			}
+
+			change.ChangedResources = len(res.Resources) -
+				res.Count(diff.Unchanged)
+		}
+
+		rep := cfg.Policy.Evaluate(change)
+
+		switch rep.Action() {
+		case "":
+			kept = append(kept, branch)
+		case policy.ActionSkip:
+			slog.Warn(
+				"skipping train violating policy",
+				"branch", branch,
+				"report", rep.String(),
+			)
+		default:
+			failed = true
+		}
+
+		if len(rep.Violations) > 0 {
+			reports = append(reports, rep.String())
+		}
+	}
+
+	if failed {
+		return nil, fmt.Errorf(
+			"%s: policy violations:\n%s",
+			errCtx, strings.Join(reports, "\n"),
+		)
+	}
+
+	return kept, nil
+}
@@ -0,0 +1,139 @@
+package prer_test
+
+import (
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/policy"
+	"github.com/byte4ever/rules_gitops/gitops/prer"
+	"github.com/byte4ever/rules_gitops/gitops/validate"
+)
+
+func TestGatePolicy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	gitCmd := func(args ...string) {
+		t.Helper()
+
+		cmd := osexec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	writeManifest := func(name string) {
+		t.Helper()
+
+		fp := filepath.Join(dir, "cloud", name+".yaml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+		require.NoError(t, os.WriteFile(fp, []byte(
+			"apiVersion: v1\nkind: ConfigMap\n"+
+				"metadata:\n  name: "+name+"\n",
+		), 0o600))
+	}
+
+	gitCmd("init", "-b", "main")
+	gitCmd("config", "user.email", "test@test.com")
+	gitCmd("config", "user.name", "Test")
+	writeManifest("a")
+	gitCmd("add", ".")
+	gitCmd("commit", "-m", "init")
+	gitCmd("update-ref", "refs/remotes/origin/main", "main")
+	gitCmd("checkout", "-b", "deploy/dev")
+	writeManifest("b")
+	writeManifest("c")
+	gitCmd("add", ".")
+	gitCmd("commit", "-m", "deploy")
+	gitCmd("branch", "deploy/prod")
+
+	repo := &git.Repo{Dir: dir, RemoteName: "origin"}
+	branches := []string{"deploy/dev", "deploy/prod"}
+	trainOf := map[string]string{
+		"deploy/dev":  "dev",
+		"deploy/prod": "prod",
+	}
+	now := time.Date(2026, 12, 24, 12, 0, 0, 0, time.UTC)
+
+	p, err := policy.Parse([]byte(`
+on_violation: skip
+rules:
+  - name: size
+    trains: [dev]
+    max_changed_resources: 1
+`), "policy.yaml")
+	require.NoError(t, err)
+
+	cfg := prer.Config{
+		PrimaryBranch: "main",
+		GitopsPaths:   []string{"cloud"},
+		Policy:        p,
+	}
+
+	kept, err := prer.GatePolicyForTest(repo, cfg, branches, trainOf, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy/prod"}, kept)
+
+	p, err = policy.Parse([]byte(`
+rules:
+  - name: freeze
+    trains: [prod]
+    freeze:
+      - start: 2026-12-20
+        end: 2027-01-05
+  - name: approval
+    labels: [approved]
+`), "policy.yaml")
+	require.NoError(t, err)
+
+	cfg.Policy = p
+	cfg.PRLabels = []string{"approved"}
+
+	_, err = prer.GatePolicyForTest(repo, cfg, branches, trainOf, now)
+	require.EqualError(t, err,
+		"evaluating policy: policy violations:\n"+
+			"train prod: fail\n"+
+			"  freeze (fail): in freeze window "+
+			"2026-12-20 00:00 to 2027-01-05 00:00 UTC")
+}
+
+func TestValidateManifests(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cloud/dev/app.yaml": "apiVersion: v1\nkind: Pod\n" +
+			"metadata:\n  name: web\n" +
+			"spec:\n  containers:\n    - name: web\n" +
+			"      image: //app:image\n",
+		"cloud/prod/app.yaml": "kind: [",
+	})
+
+	repo := &git.Repo{Dir: dir}
+	cfg := prer.Config{
+		GitopsPaths: []string{"cloud/dev"},
+	}
+
+	require.NoError(t, prer.ValidateManifestsForTest(repo, cfg, "deploy/dev"))
+
+	cfg.Validator = &validate.Validator{Checks: []validate.Check{
+		validate.YAML{}, validate.ResolvedImages{},
+	}}
+
+	err := prer.ValidateManifestsForTest(repo, cfg, "deploy/dev")
+	require.EqualError(t, err,
+		"validating manifests: deploy/dev failed validation:\n"+
+			"cloud/dev/app.yaml:1: Pod web: resolved-images: "+
+			"image //app:image is an unresolved bazel label")
+
+	cfg.GitopsPaths = nil
+	err = prer.ValidateManifestsForTest(repo, cfg, "deploy/dev")
+	require.ErrorContains(t, err, "cloud/prod/app.yaml: yaml: parsing manifest")
+}
@@ -6,18 +6,23 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
-	json "github.com/goccy/go-json"
 	"github.com/valyala/fasttemplate"
 
 	"github.com/byte4ever/rules_gitops/gitops/bazel"
 	"github.com/byte4ever/rules_gitops/gitops/commitmsg"
+	"github.com/byte4ever/rules_gitops/gitops/diff"
 	"github.com/byte4ever/rules_gitops/gitops/digester"
 	"github.com/byte4ever/rules_gitops/gitops/exec"
 	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/policy"
+	"github.com/byte4ever/rules_gitops/gitops/validate"
+	"github.com/byte4ever/rules_gitops/stamp"
 )
 
 // Config holds all settings for a gitops PR creation
@@ -26,28 +31,80 @@ type Config struct {
 	// BazelCmd is the bazel binary name or path.
 	BazelCmd string
 
+	// BazelStartupFlags are passed to every bazel
+	// command before its name, e.g. --output_base.
+	BazelStartupFlags []string
+
+	// BazelFlags are passed to bazel cquery, e.g.
+	// --config=ci.
+	BazelFlags []string
+
 	// Workspace is the bazel workspace root.
 	Workspace string
 
 	// Target is the bazel query target pattern.
 	Target string
 
+	// QueryCacheDir enables caching of cquery results
+	// in this directory, keyed by a hash of the files
+	// defining the build graph below Workspace.
+	QueryCacheDir string
+
+	// ChangedSince is a commit of the workspace
+	// repository. When set, only the trains with a
+	// target depending on a file changed since then
+	// are processed; a changed BUILD, .bzl or MODULE
+	// file processes every train.
+	ChangedSince string
+
+	// BEPFile is a Build Event Protocol JSON file of
+	// an earlier bazel build. When set, the gitops
+	// targets are those built successfully by it,
+	// instead of those matching Target.
+	BEPFile string
+
 	// GitRepo is the remote repository URL.
 	GitRepo string
 
 	// GitMirror is an optional local mirror path.
 	GitMirror string
 
-	// GitopsPath restricts the git sparse checkout
-	// to a subdirectory (empty means root).
-	GitopsPath string
+	// GitBackend performs git operations on the
+	// clone. Nil means git.CLIBackend.
+	GitBackend git.Backend
+
+	// GitCacheDir enables the persistent clone cache
+	// rooted at this directory. Empty means a fresh
+	// clone under TmpDir on every run.
+	GitCacheDir string
+
+	// GitopsPaths restricts the git sparse checkout
+	// and the staged changes to these subdirectories
+	// (empty means root).
+	GitopsPaths []string
+
+	// DeriveGitopsPaths adds the gitops_path attribute
+	// of every selected target to GitopsPaths.
+	DeriveGitopsPaths bool
 
 	// TmpDir is the directory for temporary clones.
 	TmpDir string
 
-	// ReleaseBranch filters targets by this release
-	// branch attribute value.
-	ReleaseBranch string
+	// ReleaseBranches select the targets whose
+	// release_branch_prefix attribute matches one of
+	// them.
+	ReleaseBranches []string
+
+	// ReleaseMatch selects how release_branch_prefix
+	// is compared with ReleaseBranches. Empty means
+	// MatchExact.
+	ReleaseMatch MatchMode
+
+	// TrainKeys names the rule attributes whose values,
+	// joined by "/", name the deployment train of a
+	// target, e.g. cluster and deployment_branch.
+	// Empty means deployment_branch.
+	TrainKeys []string
 
 	// PrimaryBranch is the main branch (e.g. "main").
 	PrimaryBranch string
@@ -68,6 +125,23 @@ type Config struct {
 	// stamp context.
 	GitCommit string
 
+	// StampInfoFiles are Bazel workspace status files,
+	// e.g. stable-status.txt and volatile-status.txt,
+	// whose variables the stamp context includes.
+	// Later files override earlier ones.
+	StampInfoFiles []string
+
+	// StampMode selects how StampInfoFiles are
+	// parsed. Empty means stamp.Lenient: malformed
+	// lines are skipped and later files override
+	// earlier ones with a warning.
+	StampMode stamp.Mode
+
+	// StampVars are explicit stamp variables, which
+	// override those of StampInfoFiles (see
+	// ParseStampVars).
+	StampVars map[string]string
+
 	// PushParallelism is the number of concurrent
 	// image push workers.
 	PushParallelism int
@@ -91,17 +165,100 @@ type Config struct {
 	// PRBody is the body for created pull requests.
 	PRBody string
 
+	// PRReviewers lists the reviewers requested on
+	// created pull requests.
+	PRReviewers []string
+
+	// PRLabels lists the labels applied to created
+	// pull requests.
+	PRLabels []string
+
+	// TrainPRs overrides the pull request settings of
+	// individual deployment trains, keyed by train
+	// (the deployment_branch attribute).
+	TrainPRs map[string]TrainPR
+
+	// PRDiff appends a semantic diff of the manifests
+	// between the primary branch and the deployment
+	// branch to PR bodies.
+	PRDiff bool
+
 	// DryRun skips push and PR creation when true.
 	DryRun bool
 
 	// Stamp enables file stamping when true.
 	Stamp bool
 
+	// DigestAlgorithm is the algorithm of the digests
+	// saved by stamping, e.g. digester.SHA256YAML to
+	// ignore formatting, key order and comments. Empty
+	// keeps unprefixed SHA256 digests. Stored digests
+	// of any algorithm are understood.
+	DigestAlgorithm digester.Algorithm
+
+	// DigestStore keeps the digests saved by
+	// stamping. Nil means digester.SidecarStore, a
+	// .digest file next to every manifest.
+	DigestStore digester.Store
+
+	// Validator checks the rendered manifests of
+	// every train before commit. Nil means no
+	// validation.
+	Validator *validate.Validator
+
+	// Policy gates the pull request of every updated
+	// train after commit and before push. Nil means
+	// no gate.
+	Policy *policy.Policy
+
 	// Provider creates pull requests on a git
 	// hosting platform.
 	Provider git.GitProvider
 }
 
+// TrainPR holds the pull request settings of one
+// deployment train. Empty fields fall back to the
+// Config defaults.
+type TrainPR struct {
+	// Title replaces Config.PRTitle.
+	Title string
+
+	// Reviewers replaces Config.PRReviewers.
+	Reviewers []string
+
+	// Labels replaces Config.PRLabels.
+	Labels []string
+}
+
+// prSettings returns the pull request title and
+// options of train.
+func (c *Config) prSettings(train string) (string, git.PROptions) {
+	title := c.PRTitle
+	opts := git.PROptions{
+		Reviewers: c.PRReviewers,
+		Labels:    c.PRLabels,
+	}
+
+	override, ok := c.TrainPRs[train]
+	if !ok {
+		return title, opts
+	}
+
+	if override.Title != "" {
+		title = override.Title
+	}
+
+	if len(override.Reviewers) > 0 {
+		opts.Reviewers = override.Reviewers
+	}
+
+	if len(override.Labels) > 0 {
+		opts.Labels = override.Labels
+	}
+
+	return title, opts
+}
+
 // cqueryResult mirrors the JSON output of
 // bazel cquery --output=jsonproto.
 type cqueryResult struct {
@@ -127,24 +284,32 @@ type queryRule struct {
 	Attribute []queryAttribute `json:"attribute"`
 }
 
-// queryAttribute holds a single attribute name/value
-// pair from a Bazel rule.
-type queryAttribute struct {
-	Name        string `json:"name"`
-	StringValue string `json:"stringValue"`
-}
-
 // Run executes the full gitops PR creation workflow.
 // It queries Bazel, groups targets by deployment
 // train, clones the repo, runs targets, stamps files,
-// commits changes, pushes images, and creates PRs.
+// commits changes, gates them with the policy, pushes
+// images, and creates PRs.
 func Run(ctx context.Context, cfg Config) error {
 	const errCtx = "running gitops pr creation"
 
 	// Step 1: Query bazel for gitops targets.
-	query := buildKindQuery(cfg)
+	query, err := gitopsQuery(cfg)
+	if err != nil {
+		return fmt.Errorf(
+			"%s: query targets: %w", errCtx, err,
+		)
+	}
+
+	if query == "" {
+		slog.Info(
+			"no gitops targets in build events",
+			"file", cfg.BEPFile,
+		)
+
+		return nil
+	}
 
-	qr, err := bazelQuery(cfg.BazelCmd, query)
+	qr, err := bazelQuery(cfg, query, gitopsAttributes(cfg))
 	if err != nil {
 		return fmt.Errorf(
 			"%s: query targets: %w", errCtx, err,
@@ -152,26 +317,59 @@ func Run(ctx context.Context, cfg Config) error {
 	}
 
 	// Step 2: Group by deployment train.
-	trains := groupByTrain(qr, cfg.ReleaseBranch)
+	trains, releases, err := groupByTrain(qr, cfg)
+	if err != nil {
+		return fmt.Errorf(
+			"%s: group targets: %w", errCtx, err,
+		)
+	}
+
+	if cfg.ChangedSince != "" {
+		affected, ok, err := affectedTargets(cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		if ok {
+			trains = filterTrains(trains, affected)
+
+			slog.Info(
+				"processing trains affected by changes",
+				"since", cfg.ChangedSince,
+				"targets", len(affected),
+				"trains", len(trains),
+			)
+
+			if len(trains) == 0 {
+				return nil
+			}
+		}
+	}
+
 	if len(trains) == 0 {
 		slog.Info(
 			"no targets matching release branch",
-			"branch", cfg.ReleaseBranch,
+			"branches", cfg.ReleaseBranches,
+			"match", cfg.ReleaseMatch,
 		)
 
 		return nil
 	}
 
+	stampCtx, err := getStampContext(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
 	// Step 3: Clone git repository.
-	cloneDir := filepath.Join(cfg.TmpDir, "gitops")
-
-	repo, err := git.Clone(
-		cfg.GitRepo,
-		cloneDir,
-		cfg.GitMirror,
-		cfg.PrimaryBranch,
-		cfg.GitopsPath,
-	)
+	if cfg.DeriveGitopsPaths {
+		cfg.GitopsPaths = append(
+			cfg.GitopsPaths,
+			collectGitopsPaths(qr, trains)...,
+		)
+	}
+
+	repo, err := openRepo(cfg)
 	if err != nil {
 		return fmt.Errorf(
 			"%s: clone repo: %w", errCtx, err,
@@ -194,9 +392,8 @@ func Run(ctx context.Context, cfg Config) error {
 	// Step 4: Process each deployment train.
 	var updatedBranches []string
 
-	stampCtx := getStampContext(
-		cfg.GitCommit, cfg.BranchName,
-	)
+	bodies := make(map[string]string)
+	trainOf := make(map[string]string)
 
 	for branch, targets := range trains {
 		depBranch := cfg.DeploymentBranchPrefix +
@@ -204,7 +401,8 @@ func Run(ctx context.Context, cfg Config) error {
 			cfg.DeploymentBranchSuffix
 
 		updated, branchErr := processTrain(
-			repo, cfg, depBranch, targets, stampCtx,
+			repo, cfg, depBranch, targets,
+			withRelease(stampCtx, releases[branch]),
 		)
 		if branchErr != nil {
 			return fmt.Errorf(
@@ -217,6 +415,8 @@ func Run(ctx context.Context, cfg Config) error {
 			updatedBranches = append(
 				updatedBranches, depBranch,
 			)
+			bodies[depBranch] = prBody(repo, cfg, depBranch)
+			trainOf[depBranch] = branch
 		}
 	}
 
@@ -226,7 +426,31 @@ func Run(ctx context.Context, cfg Config) error {
 		return nil
 	}
 
-	// Step 5: Build deps query and push images.
+	// Step 5: Gate the pull requests with the policy.
+	if cfg.Policy != nil {
+		kept, err := gatePolicy(
+			repo, cfg, updatedBranches, trainOf, time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		for _, branch := range updatedBranches {
+			if !slices.Contains(kept, branch) {
+				delete(trains, trainOf[branch])
+			}
+		}
+
+		updatedBranches = kept
+
+		if len(updatedBranches) == 0 {
+			slog.Info("all branches skipped by policy")
+
+			return nil
+		}
+	}
+
+	// Step 6: Build deps query and push images.
 	allTargets := collectAllTargets(trains)
 
 	if err := pushImages(
@@ -237,7 +461,7 @@ func Run(ctx context.Context, cfg Config) error {
 		)
 	}
 
-	// Step 6: Push branches and create PRs.
+	// Step 7: Push branches and create PRs.
 	if cfg.DryRun {
 		slog.Info(
 			"dry run: skipping push and PR creation",
@@ -250,12 +474,16 @@ func Run(ctx context.Context, cfg Config) error {
 	repo.Push(updatedBranches)
 
 	for _, branch := range updatedBranches {
-		if err := cfg.Provider.CreatePR(
+		title, opts := cfg.prSettings(trainOf[branch])
+
+		if err := git.CreatePR(
 			ctx,
+			cfg.Provider,
 			branch,
 			cfg.PrimaryBranch,
-			cfg.PRTitle,
-			cfg.PRBody,
+			title,
+			bodies[branch],
+			opts,
 		); err != nil {
 			return fmt.Errorf(
 				"%s: create PR for %s: %w",
@@ -267,9 +495,102 @@ func Run(ctx context.Context, cfg Config) error {
 	return nil
 }
 
+// maxPRBodyLen keeps PR bodies below the size limits
+// of the hosting platforms (65536 characters on
+// GitHub).
+const maxPRBodyLen = 60000
+
+// prBody returns cfg.PRBody followed, when cfg.PRDiff
+// is set, by the semantic diff of the manifests of
+// depBranch against the primary branch. The diff is
+// informational: failures are logged and omitted.
+func prBody(
+	repo *git.Repo,
+	cfg Config,
+	depBranch string,
+) string {
+	body := cfg.PRBody
+	if !cfg.PRDiff {
+		return body
+	}
+
+	res, err := diff.CompareRefs(
+		repo,
+		repo.RemoteName+"/"+cfg.PrimaryBranch,
+		depBranch,
+		cfg.GitopsPaths...,
+	)
+	if err != nil {
+		slog.Warn(
+			"failed to compute manifest diff",
+			"branch", depBranch,
+			"error", err,
+		)
+
+		return body
+	}
+
+	if body != "" {
+		body += "\n\n"
+	}
+
+	return body + res.Markdown(maxPRBodyLen-len(body))
+}
+
+// openRepo returns a fresh clone of cfg.GitRepo, or a
+// worktree of the persistent cache when
+// cfg.GitCacheDir is set.
+func openRepo(cfg Config) (*git.Repo, error) {
+	const errCtx = "opening repository"
+
+	var (
+		repo *git.Repo
+		err  error
+	)
+
+	backend := cfg.GitBackend
+	if backend == nil {
+		backend = git.CLIBackend{}
+	}
+
+	if cfg.GitCacheDir != "" {
+		if _, ok := backend.(git.CLIBackend); !ok {
+			return nil, fmt.Errorf(
+				"%s: clone cache requires the git "+
+					"CLI backend", errCtx,
+			)
+		}
+
+		cache := &git.Cache{Dir: cfg.GitCacheDir}
+
+		repo, err = cache.Checkout(
+			cfg.GitRepo,
+			cfg.GitMirror,
+			cfg.PrimaryBranch,
+			cfg.GitopsPaths...,
+		)
+	} else {
+		repo, err = git.CloneWith(
+			backend,
+			cfg.GitRepo,
+			filepath.Join(cfg.TmpDir, "gitops"),
+			cfg.GitMirror,
+			cfg.PrimaryBranch,
+			cfg.GitopsPaths...,
+		)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return repo, nil
+}
+
 // processTrain handles a single deployment train:
-// switches branch, runs targets, stamps files, and
-// commits. Returns true if changes were committed.
+// switches branch, runs targets, stamps files,
+// validates the manifests, and commits. Returns true
+// if changes were committed.
 func processTrain(
 	repo *git.Repo,
 	cfg Config,
@@ -279,6 +600,42 @@ func processTrain(
 ) (bool, error) {
 	const errCtx = "processing deployment train"
 
+	renderTrain(repo, cfg, depBranch, targets)
+
+	// Stamp changed files if enabled.
+	if cfg.Stamp {
+		if err := stampChangedFiles(
+			repo, stampCtx, cfg.digester(),
+		); err != nil {
+			return false, fmt.Errorf(
+				"%s: stamp files: %w", errCtx, err,
+			)
+		}
+	}
+
+	if err := validateManifests(
+		repo, cfg, depBranch,
+	); err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	// Commit changes.
+	msg := commitmsg.Generate(targets)
+
+	committed := repo.Commit(msg, cfg.GitopsPaths...)
+
+	return committed, nil
+}
+
+// renderTrain checks out depBranch, recreating it from
+// the primary branch when previously deployed targets
+// were removed, and runs each gitops target executable.
+func renderTrain(
+	repo *git.Repo,
+	cfg Config,
+	depBranch string,
+	targets []string,
+) {
 	isNew := repo.SwitchToBranch(
 		depBranch, cfg.PrimaryBranch,
 	)
@@ -302,36 +659,31 @@ func processTrain(
 		}
 	}
 
-	// Run each gitops target executable.
+	runTargets(cfg, targets)
+}
+
+// runTargets runs the executable of each gitops
+// target, rendering its manifests into the checked out
+// deployment branch.
+func runTargets(cfg Config, targets []string) {
 	for _, target := range targets {
 		exe := bazel.TargetToExecutable(target)
 		exec.MustEx(cfg.Workspace, exe)
 	}
-
-	// Stamp changed files if enabled.
-	if cfg.Stamp {
-		if err := stampChangedFiles(
-			repo, stampCtx,
-		); err != nil {
-			return false, fmt.Errorf(
-				"%s: stamp files: %w", errCtx, err,
-			)
-		}
-	}
-
-	// Commit changes.
-	msg := commitmsg.Generate(targets)
-
-	committed := repo.Commit(msg, cfg.GitopsPath)
-
-	return committed, nil
 }
 
 // stampChangedFiles iterates changed files, verifies
 // digests, and applies stamp template substitution.
+// Files whose rendered content matches their stored
+// digest are restored, keeping their earlier stamps.
+// The digest of the rendered content, taken before
+// stamping, is saved with that of the stamped file,
+// so that renderings are compared without stamps and
+// gitops_digest can still audit the files.
 func stampChangedFiles(
 	repo *git.Repo,
 	stampCtx map[string]any,
+	d digester.Digester,
 ) error {
 	const errCtx = "stamping changed files"
 
@@ -340,7 +692,7 @@ func stampChangedFiles(
 	for _, fn := range changed {
 		absPath := filepath.Join(repo.Dir, fn)
 
-		ok, err := digester.VerifyDigest(absPath)
+		ok, err := d.Verify(absPath)
 		if err != nil {
 			return fmt.Errorf(
 				"%s: verify %s: %w",
@@ -356,6 +708,14 @@ func stampChangedFiles(
 			continue
 		}
 
+		rendered, err := d.Calculate(absPath)
+		if err != nil {
+			return fmt.Errorf(
+				"%s: digest %s: %w",
+				errCtx, fn, err,
+			)
+		}
+
 		if err := stampFile(
 			absPath, stampCtx,
 		); err != nil {
@@ -365,9 +725,7 @@ func stampChangedFiles(
 			)
 		}
 
-		if err := digester.SaveDigest(
-			absPath,
-		); err != nil {
+		if err := d.SaveStamped(absPath, rendered); err != nil {
 			return fmt.Errorf(
 				"%s: save digest %s: %w",
 				errCtx, fn, err,
@@ -378,53 +736,11 @@ func stampChangedFiles(
 	return nil
 }
 
-// bazelQuery runs a bazel cquery with jsonproto output
-// and parses the result into a cqueryResult.
-func bazelQuery(
-	bazelCmd string,
-	query string,
-) (*cqueryResult, error) {
-	const errCtx = "running bazel cquery"
-
-	out, err := exec.Ex(
-		"",
-		bazelCmd,
-		"cquery",
-		"--output=jsonproto",
-		query,
-	)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"%s: %w", errCtx, err,
-		)
-	}
-
-	var qr cqueryResult
-	if err := json.Unmarshal(
-		[]byte(out), &qr,
-	); err != nil {
-		return nil, fmt.Errorf(
-			"%s: parse json: %w", errCtx, err,
-		)
-	}
-
-	return &qr, nil
-}
-
-// getStampContext creates a map of template variables
-// used for file stamping. Keys are variable names and
-// values are their replacements.
-func getStampContext(
-	gitCommit string,
-	branchName string,
-) map[string]any {
-	return map[string]any{
-		"STABLE_GIT_COMMIT":  gitCommit,
-		"STABLE_GIT_BRANCH":  branchName,
-		"BUILD_TIMESTAMP":    "0",
-		"BUILD_EMBED_LABEL":  "",
-		"RANDOM_SEED":        "",
-		"STABLE_BUILD_LABEL": "",
+// digester returns the Digester of stamping.
+func (c *Config) digester() digester.Digester {
+	return digester.Digester{
+		Algorithm: c.DigestAlgorithm,
+		Store:     c.DigestStore,
 	}
 }
 
@@ -479,7 +795,7 @@ func pushImages(
 		return nil
 	}
 
-	qr, err := bazelQuery(cfg.BazelCmd, depsQuery)
+	qr, err := bazelQuery(cfg, depsQuery, nil)
 	if err != nil {
 		return fmt.Errorf(
 			"%s: query deps: %w", errCtx, err,
@@ -604,38 +920,51 @@ func buildDepsQuery(
 	return strings.Join(parts, " + ")
 }
 
-// groupByTrain groups cquery results by the
-// "deployment_branch" attribute value. Only targets
-// matching the release branch are included.
+// groupByTrain groups cquery results by deployment
+// train, named by the cfg.TrainKeys attributes
+// ("deployment_branch" by default). Only targets whose
+// "release_branch_prefix" attribute matches one of
+// cfg.ReleaseBranches are included. It also returns
+// the release each train was selected by; the targets
+// of a train must agree on it.
 func groupByTrain(
 	qr *cqueryResult,
-	releaseBranch string,
-) map[string][]string {
+	cfg Config,
+) (map[string][]string, map[string]Release, error) {
+	m := newReleaseMatcher(cfg.ReleaseBranches, cfg.ReleaseMatch)
 	trains := make(map[string][]string)
+	releases := make(map[string]Release)
 
 	for _, r := range qr.Results {
 		rule := r.Target.Rule
 
-		depBranch := ""
-		matchesBranch := false
-
-		for _, attr := range rule.Attribute {
-			switch attr.Name {
-			case "deployment_branch":
-				depBranch = attr.StringValue
-			case "release_branch_prefix":
-				if attr.StringValue == releaseBranch {
-					matchesBranch = true
-				}
-			default:
-				continue
-			}
+		prefix, hasPattern := rule.attr("release_branch_prefix")
+		depBranch, hasTrain := rule.trainName(cfg.TrainKeys)
+
+		if !hasPattern || !hasTrain {
+			continue
 		}
 
-		if !matchesBranch || depBranch == "" {
+		rel, ok, err := m.match(prefix.String())
+		if err != nil {
+			return nil, nil, fmt.Errorf(
+				"%s: %w", rule.Name, err,
+			)
+		}
+
+		if !ok {
 			continue
 		}
 
+		if prev, seen := releases[depBranch]; seen && prev != rel {
+			return nil, nil, fmt.Errorf(
+				"train %s: %s matches release branch %s, "+
+					"other targets match %s",
+				depBranch, rule.Name, rel.Branch, prev.Branch,
+			)
+		}
+
+		releases[depBranch] = rel
 		trains[depBranch] = append(
 			trains[depBranch], rule.Name,
 		)
@@ -647,7 +976,51 @@ func groupByTrain(
 		sort.Strings(trains[k])
 	}
 
-	return trains
+	return trains, releases, nil
+}
+
+// collectGitopsPaths returns the gitops_path attribute
+// values of all targets belonging to a train, sorted
+// and deduplicated. An empty attribute yields ".",
+// the repository root.
+func collectGitopsPaths(
+	qr *cqueryResult,
+	trains map[string][]string,
+) []string {
+	selected := make(map[string]struct{})
+
+	for _, targets := range trains {
+		for _, t := range targets {
+			selected[t] = struct{}{}
+		}
+	}
+
+	seen := make(map[string]struct{})
+
+	var paths []string
+
+	for _, r := range qr.Results {
+		rule := r.Target.Rule
+		if _, ok := selected[rule.Name]; !ok {
+			continue
+		}
+
+		gitopsPath := "."
+
+		if attr, ok := rule.attr("gitops_path"); ok &&
+			attr.String() != "" {
+			gitopsPath = attr.String()
+		}
+
+		if _, ok := seen[gitopsPath]; !ok {
+			seen[gitopsPath] = struct{}{}
+			paths = append(paths, gitopsPath)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths
 }
 
 // hasDeletedTargets returns true if any previously
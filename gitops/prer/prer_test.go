@@ -2,6 +2,7 @@ package prer_test
 
 import (
 	"os"
+	osexec "os/exec"
 	"path/filepath"
 	"testing"
 
@@ -9,6 +10,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/byte4ever/rules_gitops/gitops/digester"
+	"github.com/byte4ever/rules_gitops/gitops/git"
 	"github.com/byte4ever/rules_gitops/gitops/prer"
 )
 
@@ -79,6 +82,70 @@ func TestBazelQuery_parseJSON(t *testing.T) {
 	)
 }
 
+func TestQueryAttribute_types(t *testing.T) {
+	t.Parallel()
+
+	raw := `{"results": [{"target": {"rule": {
+		"name": "//pkg:deploy",
+		"attribute": [
+			{"name": "deployment_branch", "type": "STRING",
+				"stringValue": "prod"},
+			{"name": "cluster", "type": "LABEL",
+				"stringValue": "//clusters:eu1"},
+			{"name": "namespaces", "type": "STRING_LIST",
+				"stringListValue": ["web", "jobs"]},
+			{"name": "images", "type": "LABEL_LIST",
+				"stringListValue": ["//web:image"]},
+			{"name": "replicas", "type": "INTEGER",
+				"intValue": 3},
+			{"name": "ports", "type": "INTEGER_LIST",
+				"intListValue": [80, 443]},
+			{"name": "canary", "type": "BOOLEAN",
+				"intValue": 1, "booleanValue": true},
+			{"name": "stamp", "type": "TRISTATE",
+				"tristateValue": "AUTO"},
+			{"name": "labels", "type": "STRING_DICT",
+				"stringDictValue": [
+					{"key": "team", "value": "web"},
+					{"key": "env", "value": "prod"}
+				]},
+			{"name": "regions", "type": "STRING_LIST_DICT",
+				"stringListDictValue": [
+					{"key": "eu", "value": ["eu1", "eu2"]}
+				]}
+		]
+	}}}]}`
+
+	var qr prer.CqueryResult
+
+	require.NoError(t, json.Unmarshal([]byte(raw), &qr))
+
+	got := make(map[string]any)
+	text := make(map[string]string)
+
+	for _, a := range qr.Results[0].Target.Rule.Attribute {
+		got[a.Name] = a.Value()
+		text[a.Name] = a.String()
+	}
+
+	assert.Equal(t, map[string]any{
+		"deployment_branch": "prod",
+		"cluster":           "//clusters:eu1",
+		"namespaces":        []string{"web", "jobs"},
+		"images":            []string{"//web:image"},
+		"replicas":          int64(3),
+		"ports":             []int64{80, 443},
+		"canary":            true,
+		"stamp":             "AUTO",
+		"labels":            map[string]string{"team": "web", "env": "prod"},
+		"regions":           map[string][]string{"eu": {"eu1", "eu2"}},
+	}, got)
+	assert.Equal(t, "web,jobs", text["namespaces"])
+	assert.Equal(t, "80,443", text["ports"])
+	assert.Equal(t, "env=prod,team=web", text["labels"])
+	assert.Equal(t, "eu=eu1,eu2", text["regions"])
+}
+
 func TestBazelQuery_emptyResults(t *testing.T) {
 	t.Parallel()
 
@@ -158,9 +225,11 @@ func TestBuildDepsQuery(t *testing.T) {
 func TestGetStampContext(t *testing.T) {
 	t.Parallel()
 
-	ctx := prer.GetStampContextForTest(
-		"abc123", "feature/foo",
-	)
+	ctx, err := prer.GetStampContextForTest(prer.Config{
+		GitCommit:  "abc123",
+		BranchName: "feature/foo",
+	})
+	require.NoError(t, err)
 
 	assert.Equal(
 		t, "abc123", ctx["STABLE_GIT_COMMIT"],
@@ -190,6 +259,127 @@ func TestGetStampContext(t *testing.T) {
 	}
 }
 
+func TestGetStampContext_statusFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	stable := filepath.Join(dir, "stable-status.txt")
+	volatile := filepath.Join(dir, "volatile-status.txt")
+
+	require.NoError(t, os.WriteFile(stable, []byte(
+		"STABLE_BUILD_LABEL v1.2.3\r\n"+
+			"STABLE_GIT_COMMIT fromfile\n"+
+			"STABLE_TEAM platform\n",
+	), 0o600))
+	require.NoError(t, os.WriteFile(volatile, []byte(
+		"BUILD_TIMESTAMP 1700000000\n"+
+			"STABLE_TEAM overridden\n",
+	), 0o600))
+
+	files := []string{stable, volatile}
+
+	tests := []struct {
+		name    string
+		cfg     prer.Config
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "status files",
+			cfg:  prer.Config{StampInfoFiles: files},
+			want: map[string]any{
+				"STABLE_BUILD_LABEL": "v1.2.3",
+				"STABLE_GIT_COMMIT":  "fromfile",
+				"STABLE_TEAM":        "overridden",
+				"BUILD_TIMESTAMP":    "1700000000",
+			},
+		},
+		{
+			name: "flags and stamp vars override files",
+			cfg: prer.Config{
+				StampInfoFiles: files,
+				GitCommit:      "abc123",
+				StampVars: map[string]string{
+					"STABLE_TEAM": "cli",
+				},
+			},
+			want: map[string]any{
+				"STABLE_GIT_COMMIT": "abc123",
+				"STABLE_TEAM":       "cli",
+			},
+		},
+		{
+			name: "stamp var contradicting flag",
+			cfg: prer.Config{
+				GitCommit: "abc123",
+				StampVars: map[string]string{
+					"STABLE_GIT_COMMIT": "def456",
+				},
+			},
+			wantErr: "contradicts --git_commit",
+		},
+		{
+			name: "release variable",
+			cfg: prer.Config{
+				StampVars: map[string]string{
+					"STABLE_RELEASE_VERSION": "1.0",
+				},
+			},
+			wantErr: "takes precedence",
+		},
+		{
+			name: "missing status file",
+			cfg: prer.Config{
+				StampInfoFiles: []string{
+					filepath.Join(dir, "missing.txt"),
+				},
+			},
+			wantErr: "missing.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, err := prer.GetStampContextForTest(tt.cfg)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			for k, v := range tt.want {
+				assert.Equal(t, v, ctx[k], k)
+			}
+		})
+	}
+}
+
+func TestParseStampVars(t *testing.T) {
+	t.Parallel()
+
+	vars, err := prer.ParseStampVars([]string{
+		"STABLE_TEAM=platform", "EMPTY=", "URL=a=b",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"STABLE_TEAM": "platform",
+		"EMPTY":       "",
+		"URL":         "a=b",
+	}, vars)
+
+	for _, spec := range []string{"NOVALUE", "=x", "A B=c"} {
+		_, err := prer.ParseStampVars([]string{spec})
+		require.Error(t, err, spec)
+	}
+
+	_, err = prer.ParseStampVars([]string{"A=1", "A=2"})
+	require.ErrorContains(t, err, "set twice")
+}
+
 func TestStampFile(t *testing.T) {
 	t.Parallel()
 
@@ -260,6 +450,93 @@ func TestStampFile_missingFile(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestStampChangedFiles_digestsRenderedContent(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		store  digester.Store
+		stored string
+	}{
+		"sidecar": {store: nil, stored: "app.yaml.digest"},
+		"index":   {store: &digester.IndexStore{}, stored: digester.IndexName},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			fp := filepath.Join(dir, "app.yaml")
+			gitCmd := func(args ...string) {
+				t.Helper()
+
+				cmd := osexec.Command("git", args...)
+				cmd.Dir = dir
+
+				out, err := cmd.CombinedOutput()
+				require.NoError(t, err, string(out))
+			}
+			render := func(content string) {
+				t.Helper()
+
+				require.NoError(t, os.WriteFile(fp, []byte(content), 0o600))
+			}
+
+			gitCmd("init", "-b", "main")
+			gitCmd("config", "user.email", "test@test.com")
+			gitCmd("config", "user.name", "Test")
+			render("v: 1\n")
+			gitCmd("add", ".")
+			gitCmd("commit", "-m", "init")
+
+			repo := &git.Repo{Dir: dir}
+			d := digester.Digester{
+				Algorithm: digester.SHA256YAML,
+				Store:     tc.store,
+			}
+
+			render("commit: '{{STABLE_GIT_COMMIT}}'\nv: 2\n")
+			require.NoError(t, prer.StampChangedFilesForTest(
+				repo, map[string]any{"STABLE_GIT_COMMIT": "abc"}, d,
+			))
+
+			got, err := os.ReadFile(fp)
+			require.NoError(t, err)
+			assert.Equal(t, "commit: 'abc'\nv: 2\n", string(got))
+			assert.FileExists(t, filepath.Join(dir, tc.stored))
+
+			// gitops_digest audits the stamped file.
+			results, err := digester.Scan(dir, d)
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			assert.Equal(t, digester.StatusOK, results[0].Status)
+			assert.NotEmpty(t, results[0].Rendered)
+
+			render("commit: 'abc'\nv: 3\n")
+
+			results, err = digester.Scan(dir, d)
+			require.NoError(t, err)
+			assert.Equal(t, digester.StatusMismatch, results[0].Status)
+
+			render("commit: 'abc'\nv: 2\n")
+
+			gitCmd("add", ".")
+			gitCmd("commit", "-m", "deploy")
+
+			// The same manifest rendered again, in another
+			// layout and with another commit, keeps its
+			// stamps.
+			render("v:   2\ncommit: \"{{STABLE_GIT_COMMIT}}\"\n")
+			require.NoError(t, prer.StampChangedFilesForTest(
+				repo, map[string]any{"STABLE_GIT_COMMIT": "def"}, d,
+			))
+
+			got, err = os.ReadFile(fp)
+			require.NoError(t, err)
+			assert.Equal(t, "commit: 'abc'\nv: 2\n", string(got))
+			assert.True(t, repo.IsClean())
+		})
+	}
+}
+
 func TestGroupByTrain(t *testing.T) {
 	t.Parallel()
 
@@ -352,14 +629,169 @@ func TestGroupByTrain(t *testing.T) {
 				Results: tt.results,
 			}
 
-			got := prer.GroupByTrainForTest(
-				qr, tt.releaseBranch,
+			got, _, err := prer.GroupByTrainForTest(
+				qr, prer.Config{
+					ReleaseBranches: []string{tt.releaseBranch},
+				},
 			)
+			require.NoError(t, err)
 			assert.Equal(t, tt.want, got)
 		})
 	}
 }
 
+func TestGroupByTrain_matchModes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mode     prer.MatchMode
+		pattern  string
+		branches []string
+		want     prer.Release
+		match    bool
+	}{
+		{
+			name:     "exact does not match a prefix",
+			mode:     prer.MatchExact,
+			pattern:  "release/",
+			branches: []string{"release/2024.10"},
+		},
+		{
+			name:     "prefix",
+			mode:     prer.MatchPrefix,
+			pattern:  "release/",
+			branches: []string{"main", "release/2024.10"},
+			want:     prer.Release{Branch: "release/2024.10", Version: "2024.10"},
+			match:    true,
+		},
+		{
+			name:     "glob",
+			mode:     prer.MatchGlob,
+			pattern:  "release/v*",
+			branches: []string{"release/v2.1"},
+			want:     prer.Release{Branch: "release/v2.1", Version: "2.1"},
+			match:    true,
+		},
+		{
+			name:     "glob does not cross slashes",
+			mode:     prer.MatchGlob,
+			pattern:  "release/*",
+			branches: []string{"release/eu/v2"},
+		},
+		{
+			name:     "regex named group",
+			mode:     prer.MatchRegex,
+			pattern:  `(hotfix|release)/v(?P<version>\d+\.\d+)`,
+			branches: []string{"hotfix/v3.4"},
+			want:     prer.Release{Branch: "hotfix/v3.4", Version: "3.4"},
+			match:    true,
+		},
+		{
+			name:     "regex is anchored",
+			mode:     prer.MatchRegex,
+			pattern:  `release/\d+`,
+			branches: []string{"release/12-rc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			qr := &prer.CqueryResult{
+				Results: []prer.ConfiguredTarget{
+					makeTarget("//a:deploy", "prod", tt.pattern),
+				},
+			}
+
+			trains, releases, err := prer.GroupByTrainForTest(
+				qr, prer.Config{
+					ReleaseBranches: tt.branches,
+					ReleaseMatch:    tt.mode,
+				},
+			)
+			require.NoError(t, err)
+
+			if !tt.match {
+				assert.Empty(t, trains)
+
+				return
+			}
+
+			assert.Equal(t,
+				map[string][]string{"prod": {"//a:deploy"}}, trains)
+			assert.Equal(t, tt.want, releases["prod"])
+		})
+	}
+}
+
+func TestGroupByTrain_trainKeys(t *testing.T) {
+	t.Parallel()
+
+	withCluster := func(name, cluster string) prer.ConfiguredTarget {
+		ct := makeTarget(name, "prod", "release/v1")
+		ct.Target.Rule.Attribute = append(
+			ct.Target.Rule.Attribute,
+			prer.QueryAttribute{
+				Name: "cluster", Type: "STRING", StringValue: cluster,
+			},
+		)
+
+		return ct
+	}
+
+	qr := &prer.CqueryResult{
+		Results: []prer.ConfiguredTarget{
+			withCluster("//a:deploy", "eu1"),
+			withCluster("//b:deploy", "us1"),
+			withCluster("//c:deploy", "eu1"),
+			// Targets without a cluster are skipped.
+			makeTarget("//d:deploy", "prod", "release/v1"),
+		},
+	}
+
+	got, _, err := prer.GroupByTrainForTest(qr, prer.Config{
+		ReleaseBranches: []string{"release/v1"},
+		TrainKeys:       []string{"cluster", "deployment_branch"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"eu1/prod": {"//a:deploy", "//c:deploy"},
+		"us1/prod": {"//b:deploy"},
+	}, got)
+}
+
+func TestGroupByTrain_ambiguousRelease(t *testing.T) {
+	t.Parallel()
+
+	qr := &prer.CqueryResult{
+		Results: []prer.ConfiguredTarget{
+			makeTarget("//a:deploy", "prod", "release/"),
+		},
+	}
+
+	_, _, err := prer.GroupByTrainForTest(qr, prer.Config{
+		ReleaseBranches: []string{"release/1.0", "release/2.0"},
+		ReleaseMatch:    prer.MatchPrefix,
+	})
+	require.ErrorContains(t, err,
+		`//a:deploy: release_branch_prefix "release/" matches `+
+			"release branches release/1.0 and release/2.0")
+
+	qr.Results = []prer.ConfiguredTarget{
+		makeTarget("//a:deploy", "prod", "release/1.0"),
+		makeTarget("//b:deploy", "prod", "release/2.0"),
+	}
+
+	_, _, err = prer.GroupByTrainForTest(qr, prer.Config{
+		ReleaseBranches: []string{"release/1.0", "release/2.0"},
+	})
+	require.ErrorContains(t, err,
+		"train prod: //b:deploy matches release branch release/2.0, "+
+			"other targets match release/1.0")
+}
+
 func TestHasDeletedTargets(t *testing.T) {
 	t.Parallel()
 
@@ -467,6 +899,57 @@ func TestExtractTargetNames(t *testing.T) {
 	)
 }
 
+func TestCollectGitopsPaths(t *testing.T) {
+	t.Parallel()
+
+	withPath := func(
+		name string,
+		gitopsPath string,
+	) prer.ConfiguredTarget {
+		tg := makeTarget(name, "prod", "main")
+		tg.Target.Rule.Attribute = append(
+			tg.Target.Rule.Attribute,
+			prer.QueryAttribute{
+				Name:        "gitops_path",
+				StringValue: gitopsPath,
+			},
+		)
+
+		return tg
+	}
+
+	qr := &prer.CqueryResult{
+		Results: []prer.ConfiguredTarget{
+			withPath("//a:deploy", "cloud/prod"),
+			withPath("//b:deploy", "cloud/dev"),
+			withPath("//c:deploy", "cloud/prod"),
+			withPath("//d:deploy", "clusters/shared"),
+		},
+	}
+
+	trains := map[string][]string{
+		"prod": {"//a:deploy", "//c:deploy"},
+		"dev":  {"//b:deploy"},
+	}
+
+	got := prer.CollectGitopsPathsForTest(qr, trains)
+	assert.Equal(
+		t, []string{"cloud/dev", "cloud/prod"}, got,
+	)
+
+	// A target without gitops_path writes to the
+	// repository root.
+	qr.Results = append(
+		qr.Results, makeTarget("//e:deploy", "x", "main"),
+	)
+	trains["x"] = []string{"//e:deploy"}
+
+	got = prer.CollectGitopsPathsForTest(qr, trains)
+	assert.Equal(
+		t, []string{".", "cloud/dev", "cloud/prod"}, got,
+	)
+}
+
 // makeTarget is a test helper that builds a
 // ConfiguredTarget with deployment_branch and
 // release_branch_prefix attributes.
@@ -493,3 +976,88 @@ func makeTarget(
 		},
 	}
 }
+
+func TestPRBody_appendsManifestDiff(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	gitCmd := func(args ...string) {
+		t.Helper()
+
+		cmd := osexec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	writeManifest := func(replicas string) {
+		t.Helper()
+
+		fp := filepath.Join(dir, "cloud", "app.yaml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+		require.NoError(t, os.WriteFile(fp, []byte(
+			"apiVersion: apps/v1\nkind: Deployment\n"+
+				"metadata:\n  name: web\n"+
+				"spec:\n  replicas: "+replicas+"\n",
+		), 0o600))
+	}
+
+	gitCmd("init", "-b", "main")
+	gitCmd("config", "user.email", "test@test.com")
+	gitCmd("config", "user.name", "Test")
+	writeManifest("1")
+	gitCmd("add", ".")
+	gitCmd("commit", "-m", "init")
+	gitCmd("update-ref", "refs/remotes/origin/main", "main")
+	gitCmd("checkout", "-b", "deploy/dev")
+	writeManifest("2")
+	gitCmd("commit", "-am", "deploy")
+
+	repo := &git.Repo{Dir: dir, RemoteName: "origin"}
+	cfg := prer.Config{
+		PrimaryBranch: "main",
+		PRBody:        "Release notes",
+		GitopsPaths:   []string{"cloud"},
+	}
+
+	assert.Equal(
+		t, "Release notes",
+		prer.PRBodyForTest(repo, cfg, "deploy/dev"),
+	)
+
+	cfg.PRDiff = true
+	body := prer.PRBodyForTest(repo, cfg, "deploy/dev")
+
+	assert.Contains(t, body, "Release notes\n\n### Manifest changes")
+	assert.Contains(t, body, "~ spec.replicas: 1 -> 2")
+}
+
+func TestConfig_prSettings(t *testing.T) {
+	t.Parallel()
+
+	cfg := prer.Config{
+		PRTitle:     "GitOps deployment",
+		PRReviewers: []string{"alice"},
+		PRLabels:    []string{"gitops"},
+		TrainPRs: map[string]prer.TrainPR{
+			"prod": {
+				Title:  "Production deployment",
+				Labels: []string{"gitops", "prod"},
+			},
+		},
+	}
+
+	title, opts := prer.PRSettingsForTest(&cfg, "dev")
+	assert.Equal(t, "GitOps deployment", title)
+	assert.Equal(t, git.PROptions{
+		Reviewers: []string{"alice"},
+		Labels:    []string{"gitops"},
+	}, opts)
+
+	title, opts = prer.PRSettingsForTest(&cfg, "prod")
+	assert.Equal(t, "Production deployment", title)
+	assert.Equal(t, git.PROptions{
+		Reviewers: []string{"alice"},
+		Labels:    []string{"gitops", "prod"},
+	}, opts)
+}
@@ -0,0 +1,519 @@
+package prer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/byte4ever/rules_gitops/gitops/commitmsg"
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// Promotion selects the deployment trains of a
+// promotion, e.g. from "staging" to "prod".
+type Promotion struct {
+	// From is the deployment train whose committed
+	// images are promoted.
+	From string
+
+	// To is the deployment train receiving them.
+	To string
+}
+
+// Promote applies the build deployed on the From train
+// to the To train. The To targets are re-rendered, so
+// environment-specific values come from their own
+// configuration, then every image whose name is also
+// deployed on the From branch is pinned to the exact
+// reference committed there. The commit records the
+// promotion lineage and, when cfg.Policy allows it, a
+// PR is opened against the primary branch. Images are
+// not pushed again: they were pushed when the From
+// train was deployed.
+//
+// Since the To train is rendered from the workspace,
+// the From train is rendered from it too and must
+// match the manifests committed on the From branch,
+// images aside: a change of the workspace not yet
+// deployed on the From train fails the promotion
+// instead of reaching the To train unreviewed.
+func Promote(
+	ctx context.Context,
+	cfg Config,
+	p Promotion,
+) error {
+	const errCtx = "promoting deployment"
+
+	if p.From == "" || p.To == "" || p.From == p.To {
+		return fmt.Errorf(
+			"%s: invalid promotion %q to %q",
+			errCtx, p.From, p.To,
+		)
+	}
+
+	query, err := gitopsQuery(cfg)
+	if err != nil {
+		return fmt.Errorf(
+			"%s: query targets: %w", errCtx, err,
+		)
+	}
+
+	qr, err := bazelQuery(cfg, query, gitopsAttributes(cfg))
+	if err != nil {
+		return fmt.Errorf(
+			"%s: query targets: %w", errCtx, err,
+		)
+	}
+
+	trains, releases, err := groupByTrain(qr, cfg)
+	if err != nil {
+		return fmt.Errorf(
+			"%s: group targets: %w", errCtx, err,
+		)
+	}
+
+	for _, train := range []string{p.From, p.To} {
+		if len(trains[train]) == 0 {
+			return fmt.Errorf(
+				"%s: no targets for train %s on "+
+					"release branches %q",
+				errCtx, train, cfg.ReleaseBranches,
+			)
+		}
+	}
+
+	targets := trains[p.To]
+	fromPaths := collectGitopsPaths(
+		qr, map[string][]string{p.From: trains[p.From]},
+	)
+	toPaths := collectGitopsPaths(
+		qr, map[string][]string{p.To: targets},
+	)
+
+	stampCtx, err := getStampContext(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if cfg.DeriveGitopsPaths {
+		cfg.GitopsPaths = append(cfg.GitopsPaths, toPaths...)
+	}
+
+	repo, err := openRepo(cfg)
+	if err != nil {
+		return fmt.Errorf(
+			"%s: clone repo: %w", errCtx, err,
+		)
+	}
+
+	defer func() {
+		if cleanErr := repo.Clean(); cleanErr != nil {
+			slog.Error(
+				"failed to clean repo",
+				"error", cleanErr,
+			)
+		}
+	}()
+
+	repo.Fetch(cfg.DeploymentBranchPrefix + "*")
+
+	fromBranch := cfg.DeploymentBranchPrefix + p.From +
+		cfg.DeploymentBranchSuffix
+	toBranch := cfg.DeploymentBranchPrefix + p.To +
+		cfg.DeploymentBranchSuffix
+
+	// Step 1: Read the build deployed on the From
+	// branch.
+	src, images, err := readSource(
+		repo, repo.RemoteName+"/"+fromBranch, fromPaths,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	prev, _ := commitmsg.ExtractPromotion(src.Message)
+	promotion := commitmsg.Promotion{
+		Lineage: append(
+			prev.Lineage, fromBranch+"@"+src.Hash,
+		),
+	}
+
+	// Step 2: Check that the workspace renders the
+	// From train as deployed.
+	repo.SwitchToBranch(fromBranch, cfg.PrimaryBranch)
+	runTargets(cfg, trains[p.From])
+
+	undeployed, err := undeployedFiles(
+		repo, cfg, fromPaths, images,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if len(undeployed) > 0 {
+		return fmt.Errorf(
+			"%s: the workspace renders %s differently "+
+				"from %s, images aside: deploy the "+
+				"changes to %s before promoting them",
+			errCtx, strings.Join(undeployed, ", "),
+			fromBranch, p.From,
+		)
+	}
+
+	// Step 3: Re-render the To train and pin its
+	// images.
+	renderTrain(repo, cfg, toBranch, targets)
+
+	pinned, err := pinImages(repo.Dir, toPaths, images)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	promotion.Images = pinned
+
+	if cfg.Stamp {
+		if err := stampChangedFiles(
+			repo, withRelease(stampCtx, releases[p.To]),
+			cfg.digester(),
+		); err != nil {
+			return fmt.Errorf(
+				"%s: stamp files: %w", errCtx, err,
+			)
+		}
+	}
+
+	if err := validateManifests(repo, cfg, toBranch); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	msg := fmt.Sprintf(
+		"Promote %s to %s\n", p.From, p.To,
+	) + commitmsg.Generate(targets) +
+		commitmsg.GeneratePromotion(promotion)
+
+	if !repo.Commit(msg, cfg.GitopsPaths...) {
+		slog.Info(
+			"promotion target already up to date",
+			"branch", toBranch,
+		)
+
+		return nil
+	}
+
+	if cfg.Policy != nil {
+		kept, err := gatePolicy(
+			repo, cfg, []string{toBranch},
+			map[string]string{toBranch: p.To}, time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		if len(kept) == 0 {
+			return nil
+		}
+	}
+
+	// Step 4: Push the branch and open the PR.
+	if cfg.DryRun {
+		slog.Info(
+			"dry run: skipping push and PR creation",
+			"branch", toBranch,
+			"lineage", promotion.Lineage,
+		)
+
+		return nil
+	}
+
+	repo.Push([]string{toBranch})
+
+	body := promotionBody(promotion)
+	if extra := prBody(repo, cfg, toBranch); extra != "" {
+		body += "\n" + extra
+	}
+
+	// The promotion title is kept; reviewers and
+	// labels are those of the To train.
+	_, opts := cfg.prSettings(p.To)
+
+	if err := git.CreatePR(
+		ctx,
+		cfg.Provider,
+		toBranch,
+		cfg.PrimaryBranch,
+		fmt.Sprintf("Promote %s to %s", p.From, p.To),
+		body,
+		opts,
+	); err != nil {
+		return fmt.Errorf(
+			"%s: create PR for %s: %w",
+			errCtx, toBranch, err,
+		)
+	}
+
+	return nil
+}
+
+// undeployedFiles pins the images of the manifests
+// rendered below paths to images, those deployed on
+// the checked out branch, and returns the sorted
+// files that still differ from the branch, new ones
+// included. With cfg.Stamp, a file whose rendering
+// matches its stored digest is restored, keeping its
+// stamps.
+func undeployedFiles(
+	repo *git.Repo,
+	cfg Config,
+	paths []string,
+	images map[string]string,
+) ([]string, error) {
+	const errCtx = "comparing with deployed manifests"
+
+	if _, err := pinImages(repo.Dir, paths, images); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	d := cfg.digester()
+
+	var files []string
+
+	for _, fn := range repo.GetChangedFiles() {
+		if cfg.Stamp {
+			ok, err := d.Verify(filepath.Join(repo.Dir, fn))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", errCtx, err)
+			}
+
+			if ok {
+				repo.RestoreFile(fn)
+
+				continue
+			}
+		}
+
+		files = append(files, fn)
+	}
+
+	committed, err := repo.ReadFiles("HEAD", paths...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	rendered, err := manifest.LoadDir(repo.Dir, paths...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	for _, res := range rendered {
+		if _, ok := committed[res.File]; !ok &&
+			!slices.Contains(files, res.File) {
+			files = append(files, res.File)
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// readSource returns the commit ref resolves to and
+// the image references of the manifests below paths,
+// keyed by image name. An image deployed with two
+// different references is an error, since it is
+// ambiguous which one to promote.
+func readSource(
+	repo *git.Repo,
+	ref string,
+	paths []string,
+) (git.CommitInfo, map[string]string, error) {
+	const errCtx = "reading promotion source"
+
+	info, err := repo.ReadCommit(ref)
+	if err != nil {
+		return git.CommitInfo{}, nil, fmt.Errorf(
+			"%s: %w", errCtx, err,
+		)
+	}
+
+	files, err := repo.ReadFiles(ref, paths...)
+	if err != nil {
+		return git.CommitInfo{}, nil, fmt.Errorf(
+			"%s: %w", errCtx, err,
+		)
+	}
+
+	resources, err := manifest.ParseFiles(files)
+	if err != nil {
+		return git.CommitInfo{}, nil, fmt.Errorf(
+			"%s: %s: %w", errCtx, ref, err,
+		)
+	}
+
+	images, err := imagesByName(resources)
+	if err != nil {
+		return git.CommitInfo{}, nil, fmt.Errorf(
+			"%s: %s: %w", errCtx, ref, err,
+		)
+	}
+
+	return info, images, nil
+}
+
+// imagesByName maps the image name of every image
+// reference in resources to the reference.
+func imagesByName(
+	resources []manifest.Resource,
+) (map[string]string, error) {
+	images := make(map[string]string)
+
+	for _, res := range resources {
+		for _, ref := range res.Images() {
+			name := manifest.ImageName(ref)
+
+			if prev, ok := images[name]; ok && prev != ref {
+				return nil, fmt.Errorf(
+					"image %s has conflicting "+
+						"references %s and %s (%s)",
+					name, prev, ref, res.Key,
+				)
+			}
+
+			images[name] = ref
+		}
+	}
+
+	return images, nil
+}
+
+// pinImages rewrites the image fields of the manifests
+// below paths of dir whose image name is a key of
+// images to the mapped reference. It returns the
+// sorted references written. Only the image values are
+// replaced, so the formatting of the files is kept.
+func pinImages(
+	dir string,
+	paths []string,
+	images map[string]string,
+) ([]string, error) {
+	const errCtx = "pinning images"
+
+	resources, err := manifest.LoadDir(dir, paths...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	// Collect the replacements per file.
+	replace := make(map[string]map[string]string)
+	pinned := make(map[string]struct{})
+
+	for _, res := range resources {
+		for _, ref := range res.Images() {
+			to, ok := images[manifest.ImageName(ref)]
+			if !ok {
+				slog.Warn(
+					"image not deployed on promotion "+
+						"source, keeping rendered "+
+						"reference",
+					"resource", res.Key.String(),
+					"image", ref,
+				)
+
+				continue
+			}
+
+			pinned[to] = struct{}{}
+
+			if to == ref {
+				continue
+			}
+
+			if replace[res.File] == nil {
+				replace[res.File] = make(map[string]string)
+			}
+
+			replace[res.File][ref] = to
+		}
+	}
+
+	for file, refs := range replace {
+		if err := rewriteImages(
+			filepath.Join(dir, file), refs,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
+	refs := make([]string, 0, len(pinned))
+	for ref := range pinned {
+		refs = append(refs, ref)
+	}
+
+	sort.Strings(refs)
+
+	return refs, nil
+}
+
+// rewriteImages replaces the values of the image
+// fields of the file at path according to refs.
+func rewriteImages(path string, refs map[string]string) error {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	out := string(data)
+
+	for from, to := range refs {
+		re := regexp.MustCompile(
+			`(?m)(\bimage:[ \t]*["']?)` +
+				regexp.QuoteMeta(from) +
+				`(["']?[ \t]*(?:#.*)?$)`,
+		)
+		out = re.ReplaceAllString(
+			out, "${1}"+strings.ReplaceAll(to, "$", "$$")+"${2}",
+		)
+	}
+
+	//nolint:gosec // permissions match source
+	if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// promotionBody renders the lineage and pinned images
+// of a promotion for the PR body.
+func promotionBody(p commitmsg.Promotion) string {
+	var sb strings.Builder
+
+	sb.WriteString("### Promotion\n\n")
+
+	for i, src := range p.Lineage {
+		if i > 0 {
+			sb.WriteString(" → ")
+		}
+
+		sb.WriteString("`" + src + "`")
+	}
+
+	sb.WriteString("\n")
+
+	if len(p.Images) > 0 {
+		sb.WriteString("\n**Pinned images**\n\n")
+
+		for _, img := range p.Images {
+			sb.WriteString("- `" + img + "`\n")
+		}
+	}
+
+	return sb.String()
+}
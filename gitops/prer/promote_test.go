@@ -0,0 +1,196 @@
+package prer_test
+
+import (
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/commitmsg"
+	"github.com/byte4ever/rules_gitops/gitops/digester"
+	"github.com/byte4ever/rules_gitops/gitops/git"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+	"github.com/byte4ever/rules_gitops/gitops/prer"
+)
+
+const stagingManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: staging
+spec:
+  replicas: 2
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: "reg.io/migrate@sha256:1111"
+      containers:
+      - name: web
+        image: reg.io/web@sha256:2222
+`
+
+const prodManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+spec:
+  replicas: 10
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: "reg.io/migrate@sha256:aaaa"
+      containers:
+      - name: web
+        image: reg.io/web@sha256:bbbb # rendered
+      - name: proxy
+        image: reg.io/proxy:1.0
+`
+
+func TestPinImages_keepsEnvironmentValues(t *testing.T) {
+	t.Parallel()
+
+	src, err := manifest.Parse(
+		strings.NewReader(stagingManifest), "staging/app.yaml",
+	)
+	require.NoError(t, err)
+
+	images, err := prer.ImagesByNameForTest(src)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "prod", "app.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+	require.NoError(t, os.WriteFile(fp, []byte(prodManifest), 0o600))
+
+	pinned, err := prer.PinImagesForTest(
+		dir, []string{"prod"}, images,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"reg.io/migrate@sha256:1111", "reg.io/web@sha256:2222",
+	}, pinned)
+
+	data, err := os.ReadFile(fp)
+	require.NoError(t, err)
+	assert.Equal(t, strings.NewReplacer(
+		"sha256:aaaa", "sha256:1111",
+		"sha256:bbbb", "sha256:2222",
+	).Replace(prodManifest), string(data))
+}
+
+func TestUndeployedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "staging", "app.yaml")
+	gitCmd := func(args ...string) {
+		t.Helper()
+
+		cmd := osexec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	render := func(content string) {
+		t.Helper()
+
+		require.NoError(t, os.WriteFile(fp, []byte(content), 0o600))
+	}
+
+	// The rendering of the deployed build has stamps.
+	rendered := strings.Replace(stagingManifest,
+		"  namespace: staging\n",
+		"  namespace: staging\n  annotations:\n    commit: '{{C}}'\n", 1)
+
+	gitCmd("init", "-b", "deploy/staging")
+	gitCmd("config", "user.email", "test@test.com")
+	gitCmd("config", "user.name", "Test")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+	render("kind: Namespace\n")
+	gitCmd("add", "-A")
+	gitCmd("commit", "-m", "init")
+
+	repo := &git.Repo{Dir: dir}
+	cfg := prer.Config{Stamp: true}
+
+	render(rendered)
+	require.NoError(t, prer.StampChangedFilesForTest(
+		repo, map[string]any{"C": "abc"}, digester.Digester{},
+	))
+	gitCmd("add", "-A")
+	gitCmd("commit", "-m", "deploy")
+
+	src, err := manifest.Parse(
+		strings.NewReader(stagingManifest), "staging/app.yaml",
+	)
+	require.NoError(t, err)
+
+	images, err := prer.ImagesByNameForTest(src)
+	require.NoError(t, err)
+
+	paths := []string{"staging"}
+
+	// The workspace built other images only.
+	render(strings.ReplaceAll(rendered, "sha256:2222", "sha256:9999"))
+
+	files, err := prer.UndeployedFilesForTest(repo, cfg, paths, images)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	assert.True(t, repo.IsClean())
+
+	// The workspace changed the configuration too, and
+	// renders a new manifest.
+	render(strings.NewReplacer(
+		"sha256:2222", "sha256:9999",
+		"replicas: 2", "replicas: 3",
+	).Replace(rendered))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "staging", "new.yaml"),
+		[]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: new\n"),
+		0o600,
+	))
+
+	files, err = prer.UndeployedFilesForTest(repo, cfg, paths, images)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"staging/app.yaml", "staging/new.yaml"}, files)
+}
+
+func TestImagesByName_conflict(t *testing.T) {
+	t.Parallel()
+
+	src, err := manifest.Parse(strings.NewReader(
+		stagingManifest+"---\n"+
+			"apiVersion: v1\nkind: Pod\nmetadata:\n  name: debug\n"+
+			"spec:\n  containers:\n  - image: reg.io/web:2.0\n",
+	), "staging/app.yaml")
+	require.NoError(t, err)
+
+	_, err = prer.ImagesByNameForTest(src)
+	require.ErrorContains(
+		t, err, "image reg.io/web has conflicting references",
+	)
+}
+
+func TestPromotionBody(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(
+		t,
+		"### Promotion\n\n"+
+			"`deploy/dev@abc` → `deploy/staging@def`\n\n"+
+			"**Pinned images**\n\n"+
+			"- `reg.io/web@sha256:2222`\n",
+		prer.PromotionBodyForTest(commitmsg.Promotion{
+			Lineage: []string{"deploy/dev@abc", "deploy/staging@def"},
+			Images:  []string{"reg.io/web@sha256:2222"},
+		}),
+	)
+}
@@ -0,0 +1,509 @@
+package prer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/exec"
+)
+
+// bazelQuery runs a bazel cquery with jsonproto output
+// in cfg.Workspace and decodes it one target at a
+// time, keeping only the attributes named in keep.
+// When cfg.QueryCacheDir is set, results are cached by
+// the bazel flags and the content of the files
+// defining the build graph, so a run on an unchanged
+// workspace does not query bazel again.
+func bazelQuery(
+	cfg Config,
+	query string,
+	keep []string,
+) (*cqueryResult, error) {
+	const errCtx = "running bazel cquery"
+
+	var cachePath string
+
+	if cfg.QueryCacheDir != "" {
+		key, err := queryCacheKey(cfg, query, keep)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		cachePath = filepath.Join(cfg.QueryCacheDir, key+".json")
+
+		if qr, ok := readQueryCache(cachePath); ok {
+			slog.Info(
+				"using cached cquery result",
+				"query", query,
+				"targets", len(qr.Results),
+			)
+
+			return qr, nil
+		}
+	}
+
+	var qr *cqueryResult
+
+	args := bazelArgs(cfg, "cquery")
+	args = append(args, cfg.BazelFlags...)
+	args = append(args, "--output=jsonproto", query)
+
+	if err := exec.Stream(
+		cfg.Workspace,
+		func(r io.Reader) error {
+			var err error
+
+			qr, err = decodeCquery(r, keep)
+
+			return err
+		},
+		cfg.BazelCmd,
+		args...,
+	); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if cachePath != "" {
+		if err := writeQueryCache(cachePath, qr); err != nil {
+			// A cache failure only costs the next run
+			// a query.
+			slog.Warn(
+				"cannot cache cquery result",
+				"error", err,
+			)
+		}
+	}
+
+	return qr, nil
+}
+
+// decodeCquery decodes cquery jsonproto output from r
+// one configured target at a time, so only the kept
+// attributes of the targets are held in memory, never
+// the whole document. Other top-level fields, such as
+// configurations, are skipped.
+func decodeCquery(
+	r io.Reader,
+	keep []string,
+) (*cqueryResult, error) {
+	keepSet := make(map[string]struct{}, len(keep))
+	for _, name := range keep {
+		keepSet[name] = struct{}{}
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	qr := &cqueryResult{}
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("parse json: %w", err)
+		}
+
+		if tok != "results" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("parse json: %v: %w", tok, err)
+			}
+
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return nil, err
+		}
+
+		for dec.More() {
+			var ct configuredTarget
+			if err := dec.Decode(&ct); err != nil {
+				return nil, fmt.Errorf("parse json: %w", err)
+			}
+
+			rule := &ct.Target.Rule
+			kept := rule.Attribute[:0]
+
+			for _, a := range rule.Attribute {
+				if _, ok := keepSet[a.Name]; ok {
+					kept = append(kept, a)
+				}
+			}
+
+			// Copy, so the decoded attributes can be
+			// collected.
+			rule.Attribute = append([]queryAttribute(nil), kept...)
+			qr.Results = append(qr.Results, ct)
+		}
+
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+
+	return qr, nil
+}
+
+// expectDelim reads the delimiter d from dec.
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("parse json: %w", err)
+	}
+
+	if tok != d {
+		return fmt.Errorf("parse json: expected %v, got %v", d, tok)
+	}
+
+	return nil
+}
+
+// gitopsAttributes returns the rule attributes read
+// from the gitops targets.
+func gitopsAttributes(cfg Config) []string {
+	keys := cfg.TrainKeys
+	if len(keys) == 0 {
+		keys = []string{defaultTrainKey}
+	}
+
+	return append(
+		[]string{"release_branch_prefix", "gitops_path"},
+		keys...,
+	)
+}
+
+// buildGraphFile reports whether a file of the given
+// name can change the results of a query.
+func buildGraphFile(name string) bool {
+	switch name {
+	case "BUILD", "BUILD.bazel", "MODULE.bazel",
+		"MODULE.bazel.lock", "WORKSPACE",
+		"WORKSPACE.bazel", "WORKSPACE.bzlmod",
+		".bazelrc", ".bazelversion":
+		return true
+	}
+
+	return strings.HasSuffix(name, ".bzl") ||
+		strings.HasSuffix(name, ".bazelrc")
+}
+
+// bazelArgs returns the arguments of the bazel
+// command: cfg.BazelStartupFlags, then command.
+func bazelArgs(cfg Config, command string) []string {
+	args := append([]string(nil), cfg.BazelStartupFlags...)
+
+	return append(args, command)
+}
+
+// rcFiles returns the bazelrc files read from outside
+// the workspace: the system and home ones, and those
+// of --bazelrc startup flags, relative to the
+// workspace.
+func rcFiles(cfg Config) []string {
+	files := []string{"/etc/bazel.bazelrc"}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".bazelrc"))
+	}
+
+	for _, f := range cfg.BazelStartupFlags {
+		rc, ok := strings.CutPrefix(f, "--bazelrc=")
+		if !ok {
+			continue
+		}
+
+		if !filepath.IsAbs(rc) {
+			rc = filepath.Join(cfg.Workspace, rc)
+		}
+
+		files = append(files, rc)
+	}
+
+	return files
+}
+
+// queryCacheKey hashes the bazel command and flags,
+// the query, the kept attributes, the bazelrc files
+// of rcFiles and every file of the workspace that
+// defines the build graph: BUILD, .bzl, MODULE,
+// WORKSPACE and .bazelrc files. Output and VCS
+// directories are skipped.
+func queryCacheKey(
+	cfg Config,
+	query string,
+	keep []string,
+) (string, error) {
+	const errCtx = "hashing workspace"
+
+	root := cfg.Workspace
+	if root == "" {
+		root = "."
+	}
+
+	h := sha256.New()
+
+	attrs := append([]string(nil), keep...)
+	sort.Strings(attrs)
+
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00",
+		cfg.BazelCmd,
+		strings.Join(cfg.BazelStartupFlags, "\x01"),
+		strings.Join(cfg.BazelFlags, "\x01"),
+		query, strings.Join(attrs, ","))
+
+	for _, rc := range rcFiles(cfg) {
+		data, err := os.ReadFile(rc) //nolint:gosec // bazelrc paths
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(h, "%s\x00-\x00", rc)
+
+			continue
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		fmt.Fprintf(h, "%s\x00%d\x00", rc, len(data))
+		h.Write(data)
+	}
+
+	err := filepath.WalkDir(
+		root,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			name := d.Name()
+
+			if d.IsDir() {
+				if path != root && (name == ".git" ||
+					strings.HasPrefix(name, "bazel-")) {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if !d.Type().IsRegular() || !buildGraphFile(name) {
+				return nil
+			}
+
+			data, err := os.ReadFile(path) //nolint:gosec
+			if err != nil {
+				return err
+			}
+
+			rel, _ := filepath.Rel(root, path)
+			fmt.Fprintf(h, "%s\x00%d\x00", rel, len(data))
+			h.Write(data)
+
+			return nil
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readQueryCache reads a cached query result. A
+// missing or unreadable entry is a cache miss.
+func readQueryCache(path string) (*cqueryResult, bool) {
+	f, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, false
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	var qr cqueryResult
+	if err := json.NewDecoder(f).Decode(&qr); err != nil {
+		slog.Warn(
+			"ignoring invalid cquery cache entry",
+			"path", path,
+			"error", err,
+		)
+
+		return nil, false
+	}
+
+	return &qr, true
+}
+
+// writeQueryCache stores qr at path. The entry is
+// written to a temporary file first, so concurrent
+// runs never read a partial entry.
+func writeQueryCache(path string, qr *cqueryResult) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(qr)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cquery-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// bepEvent holds the fields of a Build Event Protocol
+// JSON event needed to find the built targets.
+type bepEvent struct {
+	ID struct {
+		TargetConfigured *struct {
+			Label string `json:"label"`
+		} `json:"targetConfigured"`
+		TargetCompleted *struct {
+			Label string `json:"label"`
+		} `json:"targetCompleted"`
+	} `json:"id"`
+	Configured *struct {
+		TargetKind string `json:"targetKind"`
+	} `json:"configured"`
+	Completed *struct {
+		Success bool `json:"success"`
+	} `json:"completed"`
+}
+
+// bepTargets reads a Build Event Protocol JSON file,
+// as written by bazel build --build_event_json_file,
+// and returns the sorted labels of the targets built
+// successfully whose rule kind is one of kinds. No
+// kinds means every kind.
+func bepTargets(path string, kinds []string) ([]string, error) {
+	const errCtx = "reading build events"
+
+	f, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	wanted := make(map[string]struct{}, len(kinds))
+	for _, k := range kinds {
+		wanted[k] = struct{}{}
+	}
+
+	kindOf := make(map[string]string)
+	built := make(map[string]bool)
+	dec := json.NewDecoder(bufio.NewReader(f))
+
+	for {
+		var ev bepEvent
+
+		err := dec.Decode(&ev)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: %s: %w", errCtx, path, err,
+			)
+		}
+
+		switch {
+		case ev.ID.TargetConfigured != nil && ev.Configured != nil:
+			kindOf[ev.ID.TargetConfigured.Label] = strings.TrimSuffix(
+				ev.Configured.TargetKind, " rule",
+			)
+		case ev.ID.TargetCompleted != nil && ev.Completed != nil:
+			label := ev.ID.TargetCompleted.Label
+			// A target built in several configurations
+			// must succeed in all of them.
+			ok, seen := built[label]
+			built[label] = ev.Completed.Success && (ok || !seen)
+		}
+	}
+
+	var labels []string
+
+	for label, ok := range built {
+		if !ok {
+			continue
+		}
+
+		if _, match := wanted[kindOf[label]]; len(wanted) > 0 && !match {
+			continue
+		}
+
+		labels = append(labels, label)
+	}
+
+	sort.Strings(labels)
+
+	return labels, nil
+}
+
+// gitopsQuery returns the cquery expression selecting
+// the gitops targets: the kinds of cfg.GitopsKinds in
+// the universe of gitopsUniverse. It returns an empty
+// query when the universe is empty.
+func gitopsQuery(cfg Config) (string, error) {
+	universe, err := gitopsUniverse(cfg)
+	if err != nil || universe == "" {
+		return "", err
+	}
+
+	cfg.Target = universe
+
+	return buildKindQuery(cfg), nil
+}
+
+// gitopsUniverse returns the target expression the
+// gitops targets are selected from: cfg.Target, or,
+// when cfg.BEPFile is set, the set of targets built
+// successfully by that build. It returns an empty
+// expression when the build produced no gitops
+// targets.
+func gitopsUniverse(cfg Config) (string, error) {
+	if cfg.BEPFile == "" {
+		return cfg.Target, nil
+	}
+
+	labels, err := bepTargets(cfg.BEPFile, cfg.GitopsKinds)
+	if err != nil || len(labels) == 0 {
+		return "", err
+	}
+
+	return "set(" + strings.Join(labels, " ") + ")", nil
+}
@@ -0,0 +1,215 @@
+package prer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/prer"
+)
+
+const cqueryOutput = `{
+  "results": [{
+    "target": {"type": "RULE", "rule": {
+      "name": "//app:prod.gitops",
+      "ruleClass": "gitops",
+      "attribute": [
+        {"name": "deployment_branch", "type": "STRING",
+          "stringValue": "prod"},
+        {"name": "release_branch_prefix", "type": "STRING",
+          "stringValue": "main"},
+        {"name": "srcs", "type": "LABEL_LIST",
+          "stringListValue": ["//app:a.yaml", "//app:b.yaml"]},
+        {"name": "visibility", "type": "STRING_LIST",
+          "stringListValue": ["//visibility:public"]}
+      ]
+    }},
+    "configuration": {"checksum": "abc"}
+  }],
+  "configurations": [{"checksum": "abc", "mnemonic": "k8-fastbuild"}]
+}`
+
+func TestDecodeCquery_keepsOnlyNamedAttributes(t *testing.T) {
+	t.Parallel()
+
+	qr, err := prer.DecodeCqueryForTest(
+		strings.NewReader(cqueryOutput),
+		[]string{"deployment_branch", "release_branch_prefix"},
+	)
+	require.NoError(t, err)
+	require.Len(t, qr.Results, 1)
+
+	rule := qr.Results[0].Target.Rule
+	assert.Equal(t, "//app:prod.gitops", rule.Name)
+	assert.Equal(t, []prer.QueryAttribute{
+		{Name: "deployment_branch", Type: "STRING", StringValue: "prod"},
+		{Name: "release_branch_prefix", Type: "STRING", StringValue: "main"},
+	}, rule.Attribute)
+
+	_, err = prer.DecodeCqueryForTest(
+		strings.NewReader(`{"results": [{"target": `), nil,
+	)
+	require.ErrorContains(t, err, "parse json")
+}
+
+func TestBazelQuery_cachedByBuildFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	workspace := filepath.Join(dir, "ws")
+	count := filepath.Join(dir, "count")
+	output := filepath.Join(dir, "cquery.json")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(workspace, "app"), 0o750))
+	require.NoError(t, os.WriteFile(output, []byte(cqueryOutput), 0o600))
+
+	build := filepath.Join(workspace, "app", "BUILD.bazel")
+	require.NoError(t, os.WriteFile(build, []byte("gitops()\n"), 0o600))
+
+	bazel := filepath.Join(dir, "bazel")
+	require.NoError(t, os.WriteFile(bazel, []byte(`#!/bin/sh
+echo run >> `+count+`
+cat `+output+`
+`), 0o700))
+
+	cfg := prer.Config{
+		BazelCmd:      bazel,
+		Workspace:     workspace,
+		QueryCacheDir: filepath.Join(dir, "cache"),
+	}
+	keep := []string{"deployment_branch"}
+
+	runs := func() int {
+		data, err := os.ReadFile(count)
+		require.NoError(t, err)
+
+		return strings.Count(string(data), "run")
+	}
+
+	for range 2 {
+		qr, err := prer.BazelQueryForTest(cfg, "//...", keep)
+		require.NoError(t, err)
+		require.Len(t, qr.Results, 1)
+		assert.Equal(t, "prod",
+			qr.Results[0].Target.Rule.Attribute[0].StringValue)
+	}
+
+	assert.Equal(t, 1, runs(), "second query is served from the cache")
+
+	// Source files do not change the build graph.
+	require.NoError(t, os.WriteFile(
+		filepath.Join(workspace, "app", "main.go"),
+		[]byte("package main\n"), 0o600,
+	))
+
+	_, err := prer.BazelQueryForTest(cfg, "//...", keep)
+	require.NoError(t, err)
+	assert.Equal(t, 1, runs())
+
+	require.NoError(t, os.WriteFile(build, []byte("gitops(x = 1)\n"), 0o600))
+
+	_, err = prer.BazelQueryForTest(cfg, "//...", keep)
+	require.NoError(t, err)
+	assert.Equal(t, 2, runs(), "a changed BUILD file invalidates the cache")
+}
+
+func TestBazelQuery_cachedByFlags(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	workspace := filepath.Join(dir, "ws")
+	runs := filepath.Join(dir, "runs")
+	output := filepath.Join(dir, "cquery.json")
+	rc := filepath.Join(dir, "ci.bazelrc") // outside the workspace
+
+	require.NoError(t, os.MkdirAll(workspace, 0o750))
+	require.NoError(t, os.WriteFile(output, []byte(cqueryOutput), 0o600))
+	require.NoError(t, os.WriteFile(rc, []byte("build:ci -c opt\n"), 0o600))
+
+	// The fake bazel logs its directory and arguments.
+	bazel := filepath.Join(dir, "bazel")
+	require.NoError(t, os.WriteFile(bazel, []byte(`#!/bin/sh
+echo "$(pwd) $*" >> `+runs+`
+cat `+output+`
+`), 0o700))
+
+	cfg := prer.Config{
+		BazelCmd:          bazel,
+		Workspace:         workspace,
+		QueryCacheDir:     filepath.Join(dir, "cache"),
+		BazelStartupFlags: []string{"--bazelrc=../ci.bazelrc"},
+	}
+
+	query := func(cfg prer.Config) []string {
+		t.Helper()
+
+		_, err := prer.BazelQueryForTest(cfg, "//...", nil)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(runs)
+		require.NoError(t, err)
+
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	got := query(cfg)
+	assert.Equal(t, []string{
+		workspace + " --bazelrc=../ci.bazelrc cquery --output=jsonproto //...",
+	}, got)
+	assert.Len(t, query(cfg), 1, "served from the cache")
+
+	cfg.BazelFlags = []string{"--config=ci"}
+	got = query(cfg)
+	require.Len(t, got, 2, "cquery flags change the key")
+	assert.Equal(t,
+		workspace+" --bazelrc=../ci.bazelrc cquery --config=ci "+
+			"--output=jsonproto //...",
+		got[1])
+
+	cfg.BazelStartupFlags = append(cfg.BazelStartupFlags, "--output_base=/tmp/ob")
+	assert.Len(t, query(cfg), 3, "startup flags change the key")
+	assert.Len(t, query(cfg), 3)
+
+	require.NoError(t, os.WriteFile(rc, []byte("build:ci -c dbg\n"), 0o600))
+	assert.Len(t, query(cfg), 4, "a changed bazelrc file changes the key")
+}
+
+func TestGitopsQuery_fromBuildEvents(t *testing.T) {
+	t.Parallel()
+
+	bep := filepath.Join(t.TempDir(), "bep.json")
+	require.NoError(t, os.WriteFile(bep, []byte(strings.Join([]string{
+		`{"id":{"started":{}},"started":{"command":"build"}}`,
+		`{"id":{"targetConfigured":{"label":"//app:prod.gitops"}},` +
+			`"configured":{"targetKind":"gitops rule"}}`,
+		`{"id":{"targetConfigured":{"label":"//app:image"}},` +
+			`"configured":{"targetKind":"oci_image rule"}}`,
+		`{"id":{"targetConfigured":{"label":"//app:dev.gitops"}},` +
+			`"configured":{"targetKind":"gitops rule"}}`,
+		`{"id":{"targetCompleted":{"label":"//app:prod.gitops",` +
+			`"configuration":{"id":"abc"}}},"completed":{"success":true}}`,
+		`{"id":{"targetCompleted":{"label":"//app:image",` +
+			`"configuration":{"id":"abc"}}},"completed":{"success":true}}`,
+		`{"id":{"targetCompleted":{"label":"//app:dev.gitops",` +
+			`"configuration":{"id":"abc"}}},"completed":{}}`,
+	}, "\n")+"\n"), 0o600))
+
+	query, err := prer.GitopsQueryForTest(prer.Config{
+		Target:      "//...",
+		GitopsKinds: []string{"gitops"},
+		BEPFile:     bep,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `kind("gitops", set(//app:prod.gitops))`, query)
+
+	query, err = prer.GitopsQueryForTest(prer.Config{
+		GitopsKinds: []string{"k8s_deploy"},
+		BEPFile:     bep,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, query, "no gitops targets were built")
+}
@@ -0,0 +1,222 @@
+package prer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MatchMode selects how the release_branch_prefix
+// attribute of a target is compared with the release
+// branches of a run.
+type MatchMode string
+
+// Match modes.
+const (
+	// MatchExact selects targets whose attribute equals
+	// a release branch. It is the default.
+	MatchExact MatchMode = "exact"
+
+	// MatchPrefix selects targets whose attribute is a
+	// prefix of a release branch, e.g. "release/" for
+	// "release/2024.10". The version is the rest of the
+	// branch name.
+	MatchPrefix MatchMode = "prefix"
+
+	// MatchGlob treats the attribute as a path.Match
+	// pattern, e.g. "release/*". The version is the
+	// branch name after the literal start of the
+	// pattern.
+	MatchGlob MatchMode = "glob"
+
+	// MatchRegex treats the attribute as a regular
+	// expression matching the whole branch name. The
+	// version is the "version" named group, or else the
+	// first group.
+	MatchRegex MatchMode = "regex"
+)
+
+// Stamp variables describing the release a deployment
+// train was selected by.
+const (
+	releaseBranchVar  = "STABLE_RELEASE_BRANCH"
+	releaseVersionVar = "STABLE_RELEASE_VERSION"
+)
+
+// ParseMatchMode parses the name of a match mode. The
+// empty string means MatchExact.
+func ParseMatchMode(s string) (MatchMode, error) {
+	switch m := MatchMode(s); m {
+	case "":
+		return MatchExact, nil
+	case MatchExact, MatchPrefix, MatchGlob, MatchRegex:
+		return m, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown release branch match mode %q "+
+				"(expected exact, prefix, glob or regex)",
+			s,
+		)
+	}
+}
+
+// Release is the release branch a target was selected
+// by and the version matched in its name.
+type Release struct {
+	Branch  string
+	Version string
+}
+
+// releaseMatcher matches release_branch_prefix
+// attributes against the release branches of a run.
+type releaseMatcher struct {
+	mode     MatchMode
+	branches []string
+
+	// regexps caches compiled attributes, which are
+	// shared by many targets.
+	regexps map[string]*regexp.Regexp
+}
+
+// newReleaseMatcher returns a matcher of branches. An
+// empty mode means MatchExact.
+func newReleaseMatcher(
+	branches []string,
+	mode MatchMode,
+) *releaseMatcher {
+	if mode == "" {
+		mode = MatchExact
+	}
+
+	return &releaseMatcher{
+		mode:     mode,
+		branches: branches,
+		regexps:  make(map[string]*regexp.Regexp),
+	}
+}
+
+// match returns the release selected by pattern. A
+// pattern matching several release branches is an
+// error, since the version would be ambiguous.
+func (m *releaseMatcher) match(pattern string) (Release, bool, error) {
+	var (
+		found Release
+		ok    bool
+	)
+
+	for _, branch := range m.branches {
+		version, matched, err := m.matchBranch(pattern, branch)
+		if err != nil {
+			return Release{}, false, err
+		}
+
+		if !matched {
+			continue
+		}
+
+		if ok {
+			return Release{}, false, fmt.Errorf(
+				"release_branch_prefix %q matches release "+
+					"branches %s and %s",
+				pattern, found.Branch, branch,
+			)
+		}
+
+		found = Release{Branch: branch, Version: version}
+		ok = true
+	}
+
+	return found, ok, nil
+}
+
+// matchBranch matches pattern against one branch and
+// returns the version.
+func (m *releaseMatcher) matchBranch(
+	pattern string,
+	branch string,
+) (string, bool, error) {
+	switch m.mode {
+	case MatchPrefix:
+		if pattern == "" || !strings.HasPrefix(branch, pattern) {
+			return "", false, nil
+		}
+
+		return strings.TrimPrefix(branch, pattern), true, nil
+
+	case MatchGlob:
+		ok, err := path.Match(pattern, branch)
+		if err != nil {
+			return "", false, fmt.Errorf(
+				"release_branch_prefix %q: %w", pattern, err,
+			)
+		}
+
+		if !ok {
+			return "", false, nil
+		}
+
+		literal := pattern
+		if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+			literal = pattern[:i]
+		}
+
+		return strings.TrimPrefix(branch, literal), true, nil
+
+	case MatchRegex:
+		re, err := m.regexp(pattern)
+		if err != nil {
+			return "", false, err
+		}
+
+		sub := re.FindStringSubmatch(branch)
+		if sub == nil {
+			return "", false, nil
+		}
+
+		if i := re.SubexpIndex("version"); i > 0 {
+			return sub[i], true, nil
+		}
+
+		if len(sub) > 1 {
+			return sub[1], true, nil
+		}
+
+		return "", true, nil
+
+	default:
+		return "", pattern == branch, nil
+	}
+}
+
+// regexp compiles pattern anchored at both ends.
+func (m *releaseMatcher) regexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := m.regexps[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"release_branch_prefix %q: %w", pattern, err,
+		)
+	}
+
+	m.regexps[pattern] = re
+
+	return re, nil
+}
+
+// withRelease returns a copy of the stamp context ctx
+// with the release variables of r.
+func withRelease(ctx map[string]any, r Release) map[string]any {
+	out := make(map[string]any, len(ctx)+2)
+	for k, v := range ctx {
+		out[k] = v
+	}
+
+	out[releaseBranchVar] = r.Branch
+	out[releaseVersionVar] = r.Version
+
+	return out
+}
@@ -0,0 +1,143 @@
+package prer
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/stamp"
+)
+
+// Stamp variables set from the Config.
+const (
+	gitCommitVar = "STABLE_GIT_COMMIT"
+	gitBranchVar = "STABLE_GIT_BRANCH"
+)
+
+// stampPrecedence lists the sources of stamp
+// variables from lowest to highest precedence, for
+// error messages.
+const stampPrecedence = "defaults < status files " +
+	"(in order) < --git_commit/--branch_name < " +
+	"--stamp_var < release variables of the train"
+
+// defaultStamps are the values of the well-known
+// Bazel stamp variables that no status file sets, so
+// that their placeholders never stay in manifests.
+//
+//nolint:gochecknoglobals // read-only defaults
+var defaultStamps = map[string]string{
+	gitCommitVar:         "",
+	gitBranchVar:         "",
+	"BUILD_TIMESTAMP":    "0",
+	"BUILD_EMBED_LABEL":  "",
+	"RANDOM_SEED":        "",
+	"STABLE_BUILD_LABEL": "",
+}
+
+// ParseStampVars parses "KEY=VALUE" specs, e.g. of
+// repeated --stamp_var flags, into a map. Keys must
+// be unique and non-empty, without spaces or braces.
+func ParseStampVars(specs []string) (map[string]string, error) {
+	const errCtx = "parsing stamp variables"
+
+	vars := make(map[string]string, len(specs))
+
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \t{}") {
+			return nil, fmt.Errorf(
+				"%s: invalid %q: expected KEY=VALUE",
+				errCtx, spec,
+			)
+		}
+
+		if prev, dup := vars[key]; dup && prev != value {
+			return nil, fmt.Errorf(
+				"%s: %s set twice, to %q and %q",
+				errCtx, key, prev, value,
+			)
+		}
+
+		vars[key] = value
+	}
+
+	return vars, nil
+}
+
+// getStampContext creates the map of template
+// variables used for file stamping, from lowest to
+// highest precedence: defaultStamps, the status files
+// of cfg.StampInfoFiles, later files overriding
+// earlier ones, cfg.GitCommit and cfg.BranchName when
+// set, and cfg.StampVars. The release variables are
+// added per train by withRelease and cannot be set
+// otherwise. Explicit values that contradict each
+// other are errors rather than silently overridden.
+func getStampContext(cfg Config) (map[string]any, error) {
+	const errCtx = "creating stamp context"
+
+	ctx := make(map[string]any, len(defaultStamps))
+	for k, v := range defaultStamps {
+		ctx[k] = v
+	}
+
+	stamps, conflicts, err := stamp.Loader{Mode: cfg.StampMode}.Load(
+		cfg.StampInfoFiles...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	for _, c := range conflicts {
+		slog.Warn("conflicting stamp", "conflict", c.String())
+	}
+
+	for k, v := range stamps {
+		ctx[k] = v
+	}
+
+	flags := map[string]struct{ flag, value string }{
+		gitCommitVar: {"--git_commit", cfg.GitCommit},
+		gitBranchVar: {"--branch_name", cfg.BranchName},
+	}
+
+	for k, f := range flags {
+		if f.value != "" {
+			ctx[k] = f.value
+		}
+	}
+
+	keys := make([]string, 0, len(cfg.StampVars))
+	for k := range cfg.StampVars {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := cfg.StampVars[k]
+
+		if k == releaseBranchVar || k == releaseVersionVar {
+			return nil, fmt.Errorf(
+				"%s: --stamp_var %s: set by the release "+
+					"branch of every train, which takes "+
+					"precedence (%s)",
+				errCtx, k, stampPrecedence,
+			)
+		}
+
+		if f, ok := flags[k]; ok && f.value != "" && f.value != v {
+			return nil, fmt.Errorf(
+				"%s: --stamp_var %s=%q contradicts %s=%q; "+
+					"set only one (%s)",
+				errCtx, k, v, f.flag, f.value, stampPrecedence,
+			)
+		}
+
+		ctx[k] = v
+	}
+
+	return ctx, nil
+}
@@ -0,0 +1,210 @@
+package schema
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	json "github.com/goccy/go-json"
+)
+
+// LatestBundledVersion is the latest Kubernetes minor
+// version of the bundled schemas.
+const LatestBundledVersion = "1.26"
+
+// refPrefix prefixes the $ref of definitions, as in
+// Kubernetes OpenAPI v3 documents.
+const refPrefix = "#/components/schemas/"
+
+// bundleExt ends the names of the bundles, after
+// their Kubernetes minor version.
+const bundleExt = ".json.gz"
+
+// bundles holds a bundle per Kubernetes minor version,
+// as written by Bundle.
+//
+//go:embed openapi/*.json.gz
+var bundles embed.FS
+
+// BundledVersions returns the Kubernetes minor
+// versions of the bundled schemas, oldest first.
+func BundledVersions() []string {
+	//nolint:errcheck // the embedded directory exists
+	entries, _ := bundles.ReadDir("openapi")
+
+	versions := make([]string, 0, len(entries))
+	for _, e := range entries {
+		versions = append(versions, strings.TrimSuffix(e.Name(), bundleExt))
+	}
+
+	slices.SortFunc(versions, compareVersions)
+
+	return versions
+}
+
+// AddBundled adds the schemas of the built-in kinds of
+// Kubernetes version, e.g. "1.26" or "v1.26.3", to s:
+// the OpenAPI v3 definitions the API server of that
+// version serves, required fields included. It fails
+// for versions not in BundledVersions.
+func (s *Set) AddBundled(version string) error {
+	const errCtx = "adding bundled schemas"
+
+	minor := minorVersion(version)
+
+	f, err := bundles.Open("openapi/" + minor + bundleExt)
+	if err != nil {
+		return fmt.Errorf(
+			"%s: Kubernetes %s not bundled (only %s): "+
+				"load its OpenAPI documents instead",
+			errCtx, version, strings.Join(BundledVersions(), ", "),
+		)
+	}
+
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", errCtx, minor, err)
+	}
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", errCtx, minor, err)
+	}
+
+	if err := s.loadOpenAPI(data); err != nil {
+		return fmt.Errorf("%s: %s: %w", errCtx, minor, err)
+	}
+
+	return nil
+}
+
+// Bundle merges the definitions of the Kubernetes
+// OpenAPI v3 documents files, e.g. those of
+// api/openapi-spec/v3 in the kubernetes repository,
+// drops their descriptions and writes them to w as a
+// gzipped OpenAPI document, the format of the bundles
+// of AddBundled.
+func Bundle(w io.Writer, files ...string) error {
+	const errCtx = "bundling schemas"
+
+	defs := make(map[string]any)
+
+	for _, f := range files {
+		data, err := os.ReadFile(f) //nolint:gosec // paths from CLI flags
+		if err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		var doc struct {
+			Components struct {
+				Schemas map[string]any `json:"schemas"`
+			} `json:"components"`
+		}
+
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s: %s: %w", errCtx, f, err)
+		}
+
+		for name, sc := range doc.Components.Schemas {
+			defs[name] = dropDescriptions(sc)
+		}
+	}
+
+	if len(defs) == 0 {
+		return fmt.Errorf("%s: no schema definitions", errCtx)
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"openapi":    "3.0.0",
+		"components": map[string]any{"schemas": defs},
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var b bytes.Buffer
+
+	zw, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if _, err := zw.Write(data); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if _, err := b.WriteTo(w); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
+
+// dropDescriptions removes the descriptions of the
+// schema v and of its subschemas. Properties named
+// description hold a schema and are kept.
+func dropDescriptions(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if _, ok := v["description"].(string); ok {
+			delete(v, "description")
+		}
+
+		for k, e := range v {
+			v[k] = dropDescriptions(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = dropDescriptions(e)
+		}
+	}
+
+	return v
+}
+
+// minorVersion trims the "v" prefix and the patch
+// number of a Kubernetes version.
+func minorVersion(version string) string {
+	version = strings.TrimPrefix(version, "v")
+
+	if parts := strings.SplitN(version, ".", 3); len(parts) == 3 {
+		return parts[0] + "." + parts[1]
+	}
+
+	return version
+}
+
+// compareVersions orders the minor versions a and b
+// numerically, e.g. 1.9 before 1.10.
+func compareVersions(a, b string) int {
+	pa := strings.Split(a, ".")
+	pb := strings.Split(b, ".")
+
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+
+		switch {
+		case errA != nil || errB != nil:
+			if c := strings.Compare(pa[i], pb[i]); c != 0 {
+				return c
+			}
+		case na != nb:
+			return na - nb
+		}
+	}
+
+	return len(pa) - len(pb)
+}
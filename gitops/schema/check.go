@@ -0,0 +1,66 @@
+package schema
+
+import (
+	"errors"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+	"github.com/byte4ever/rules_gitops/gitops/validate"
+)
+
+// CheckSchema is the name of the schema check.
+const CheckSchema = "schema"
+
+// Check validates resources against the schemas of a
+// Set. It implements validate.Check.
+type Check struct {
+	// Set holds the schemas.
+	Set *Set
+
+	// IgnoreMissing skips resources whose kind has no
+	// schema, which are reported otherwise.
+	IgnoreMissing bool
+}
+
+// Name implements validate.Check.
+func (Check) Name() string { return CheckSchema }
+
+// Check implements validate.Check.
+func (c Check) Check(in *validate.Input) ([]validate.Finding, error) {
+	return c.Findings(in.Resources), nil
+}
+
+// Findings validates resources and returns a finding
+// per mismatching field, in resource order.
+func (c Check) Findings(resources []manifest.Resource) []validate.Finding {
+	var findings []validate.Finding
+
+	for _, r := range resources {
+		errs, err := c.Set.Validate(r.Object)
+
+		switch {
+		case errors.Is(err, ErrMissingSchema):
+			if !c.IgnoreMissing {
+				findings = append(findings, finding(r, err.Error()))
+			}
+		case err != nil:
+			findings = append(findings, finding(r, err.Error()))
+		}
+
+		for _, e := range errs {
+			findings = append(findings, finding(r, e.String()))
+		}
+	}
+
+	return findings
+}
+
+// finding returns a schema finding about r.
+func finding(r manifest.Resource, message string) validate.Finding {
+	return validate.Finding{
+		Check:    CheckSchema,
+		File:     r.File,
+		Line:     r.Line,
+		Resource: r.Key,
+		Message:  message,
+	}
+}
@@ -0,0 +1,64 @@
+// Command openapi_bundle writes the bundle of a
+// Kubernetes version embedded by the schema package
+// from the OpenAPI v3 documents of that version, e.g.
+// api/openapi-spec/v3/*.json of the kubernetes
+// repository at its release tag.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/byte4ever/rules_gitops/gitops/schema"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("fatal", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run bundles the documents named on the command line.
+func run() error {
+	const errCtx = "running openapi_bundle"
+
+	output := flag.String(
+		"output", "",
+		"Bundle file to write, e.g. "+
+			"gitops/schema/openapi/1.26.json.gz",
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s --output FILE openapi_document...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *output == "" || flag.NArg() == 0 {
+		return fmt.Errorf(
+			"%s: --output and OpenAPI documents are required", errCtx,
+		)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := schema.Bundle(f, flag.Args()...); err != nil {
+		_ = f.Close() //nolint:errcheck // the bundle error wins
+
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,8 @@
+// Package schema validates Kubernetes resources against OpenAPI schemas,
+// offline. A Set holds the bundled schemas of the built-in kinds of a
+// Kubernetes version, embedded OpenAPI v3 definitions written by Bundle, and
+// schemas loaded from CustomResourceDefinition files or OpenAPI documents
+// downloaded from an API server. Validate reports unknown fields, mismatching
+// types, missing required fields and values outside an enum with the path of
+// the field; Check runs it as a validate.Check.
+package schema
@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// objectMetaRef is the definition of metadata, which
+// the API server validates for custom resources too.
+const objectMetaRef = refPrefix +
+	"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"
+
+// openAPIDocument is a Kubernetes OpenAPI document: v3
+// with components.schemas, or v2 with definitions.
+type openAPIDocument struct {
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+	Definitions map[string]*Schema `json:"definitions"`
+}
+
+// crd holds the fields of a CustomResourceDefinition
+// that describe its schemas.
+type crd struct {
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Versions []struct {
+			Name   string `json:"name"`
+			Schema struct {
+				OpenAPIV3Schema *Schema `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+// Load returns a Set with the bundled schemas of the
+// Kubernetes version, none when version is empty, and
+// the schemas of files, which override them.
+func Load(version string, files ...string) (*Set, error) {
+	set := &Set{}
+
+	if version != "" {
+		if err := set.AddBundled(version); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range files {
+		if err := set.LoadFile(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// LoadFile adds the schemas of the file at path to s:
+// either a JSON OpenAPI document, as served by the API
+// server at /openapi/v3/apis/<group>/<version> or
+// /openapi/v2, whose definitions declare their kinds
+// with x-kubernetes-group-version-kind, or YAML or
+// JSON CustomResourceDefinitions. Other documents of a
+// CRD file are ignored.
+func (s *Set) LoadFile(path string) error {
+	const errCtx = "loading schemas"
+
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if err := s.load(data, path); err != nil {
+		return fmt.Errorf("%s: %s: %w", errCtx, path, err)
+	}
+
+	return nil
+}
+
+// load adds the schemas of data, the content of file.
+func (s *Set) load(data []byte, file string) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var head struct {
+			Kind string `json:"kind"`
+		}
+
+		if err := json.Unmarshal(data, &head); err != nil {
+			return err
+		}
+
+		if head.Kind == "" {
+			return s.loadOpenAPI(data)
+		}
+	}
+
+	resources, err := manifest.Parse(bytes.NewReader(data), file)
+	if err != nil {
+		return err
+	}
+
+	found := false
+
+	for _, r := range resources {
+		if r.Key.Kind != "CustomResourceDefinition" {
+			continue
+		}
+
+		if err := s.loadCRD(r.Object); err != nil {
+			return fmt.Errorf("%s: %w", r.Key.Name, err)
+		}
+
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("no CustomResourceDefinition in %s", file)
+	}
+
+	return nil
+}
+
+// loadOpenAPI adds the definitions of an OpenAPI
+// document and the kinds they declare.
+func (s *Set) loadOpenAPI(data []byte) error {
+	var doc openAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	defs := doc.Components.Schemas
+	if len(defs) == 0 {
+		defs = doc.Definitions
+	}
+
+	if len(defs) == 0 {
+		return errors.New("no schema definitions")
+	}
+
+	for name, sc := range defs {
+		s.define(name, sc)
+
+		for _, gvk := range sc.GroupVersionKinds {
+			s.addKind(gvk, &Schema{Ref: refPrefix + name})
+		}
+	}
+
+	return nil
+}
+
+// loadCRD adds the schema of every version of the
+// CustomResourceDefinition obj. apiVersion, kind and
+// metadata are allowed whether the schema lists them
+// or not.
+func (s *Set) loadCRD(obj map[string]any) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	var def crd
+	if err := json.Unmarshal(data, &def); err != nil {
+		return err
+	}
+
+	for _, v := range def.Spec.Versions {
+		sc := v.Schema.OpenAPIV3Schema
+		if sc == nil {
+			sc = &Schema{Type: "object", PreserveUnknownFields: true}
+		}
+
+		if sc.Properties == nil {
+			sc.Properties = make(map[string]*Schema)
+		}
+
+		for _, name := range []string{"apiVersion", "kind"} {
+			if _, ok := sc.Properties[name]; !ok {
+				sc.Properties[name] = &Schema{Type: "string"}
+			}
+		}
+
+		if meta, ok := sc.Properties["metadata"]; !ok ||
+			len(meta.Properties) == 0 {
+			sc.Properties["metadata"] = &Schema{Ref: objectMetaRef}
+		}
+
+		s.addKind(GroupVersionKind{
+			Group:   def.Spec.Group,
+			Version: v.Name,
+			Kind:    def.Spec.Names.Kind,
+		}, sc)
+	}
+
+	return nil
+}
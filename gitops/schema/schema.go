@@ -0,0 +1,483 @@
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	json "github.com/goccy/go-json"
+)
+
+// ErrMissingSchema is returned by Set.Validate for an
+// object whose kind has no schema in the set.
+var ErrMissingSchema = errors.New("no schema")
+
+// GroupVersionKind identifies the kind of a resource,
+// as in the x-kubernetes-group-version-kind extension
+// of Kubernetes OpenAPI documents.
+type GroupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// ParseGVK returns the kind of an object from its
+// apiVersion ("apps/v1", or "v1" for the core group)
+// and kind.
+func ParseGVK(apiVersion, kind string) GroupVersionKind {
+	group, version, ok := strings.Cut(apiVersion, "/")
+	if !ok {
+		group, version = "", apiVersion
+	}
+
+	return GroupVersionKind{Group: group, Version: version, Kind: kind}
+}
+
+// String formats the kind as "apiVersion Kind", e.g.
+// "apps/v1 Deployment".
+func (k GroupVersionKind) String() string {
+	if k.Group == "" {
+		return k.Version + " " + k.Kind
+	}
+
+	return k.Group + "/" + k.Version + " " + k.Kind
+}
+
+// Schema is the subset of an OpenAPI v3 schema object
+// that Kubernetes uses to describe resources.
+type Schema struct {
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Enum       []any              `json:"enum,omitempty"`
+	Ref        string             `json:"$ref,omitempty"`
+	AllOf      []*Schema          `json:"allOf,omitempty"`
+	AnyOf      []*Schema          `json:"anyOf,omitempty"`
+	Nullable   bool               `json:"nullable,omitempty"`
+
+	// AdditionalProperties is the schema of the fields
+	// not in Properties: nil when they are not allowed,
+	// an empty schema when they may hold anything.
+	AdditionalProperties *Schema `json:"-"`
+
+	PreserveUnknownFields bool `json:"x-kubernetes-preserve-unknown-fields,omitempty"`
+	IntOrString           bool `json:"x-kubernetes-int-or-string,omitempty"`
+
+	GroupVersionKinds []GroupVersionKind `json:"x-kubernetes-group-version-kind,omitempty"`
+}
+
+// UnmarshalJSON decodes s, accepting a boolean or a
+// schema for additionalProperties.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	type plain Schema
+
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+
+	var raw struct {
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch string(bytes.TrimSpace(raw.AdditionalProperties)) {
+	case "", "false", "null":
+		s.AdditionalProperties = nil
+	case "true":
+		s.AdditionalProperties = &Schema{}
+	default:
+		s.AdditionalProperties = &Schema{}
+
+		return json.Unmarshal(
+			raw.AdditionalProperties, s.AdditionalProperties,
+		)
+	}
+
+	return nil
+}
+
+// FieldError is a field of an object that does not
+// match its schema.
+type FieldError struct {
+	// Path locates the field, e.g.
+	// spec.template.spec.containers[0].image.
+	Path string `json:"path"`
+
+	// Message describes the mismatch.
+	Message string `json:"message"`
+}
+
+// String formats the error as "path: message".
+func (e FieldError) String() string {
+	if e.Path == "" {
+		return e.Message
+	}
+
+	return e.Path + ": " + e.Message
+}
+
+// Set holds named schema definitions and the schema of
+// each kind. The zero value is empty; use AddBundled
+// and LoadFile to fill it.
+type Set struct {
+	defs  map[string]*Schema
+	kinds map[GroupVersionKind]*Schema
+}
+
+// define adds the definition name. A later definition
+// replaces an earlier one.
+func (s *Set) define(name string, sc *Schema) {
+	if s.defs == nil {
+		s.defs = make(map[string]*Schema)
+	}
+
+	s.defs[name] = sc
+}
+
+// addKind sets the schema of gvk. A later schema
+// replaces an earlier one, so that files loaded after
+// the bundled schemas override them.
+func (s *Set) addKind(gvk GroupVersionKind, sc *Schema) {
+	if s.kinds == nil {
+		s.kinds = make(map[GroupVersionKind]*Schema)
+	}
+
+	s.kinds[gvk] = sc
+}
+
+// Has reports whether the set holds a schema for gvk.
+func (s *Set) Has(gvk GroupVersionKind) bool {
+	_, ok := s.kinds[gvk]
+
+	return ok
+}
+
+// Kinds returns the kinds of the set, sorted.
+func (s *Set) Kinds() []GroupVersionKind {
+	kinds := make([]GroupVersionKind, 0, len(s.kinds))
+	for k := range s.kinds {
+		kinds = append(kinds, k)
+	}
+
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].String() < kinds[j].String()
+	})
+
+	return kinds
+}
+
+// Validate checks obj, a resource decoded into JSON
+// compatible values, against the schema of its kind
+// and returns the mismatching fields sorted by path.
+// It fails with ErrMissingSchema when the set has no
+// schema for the kind.
+func (s *Set) Validate(obj map[string]any) ([]FieldError, error) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	gvk := ParseGVK(apiVersion, kind)
+
+	sc, ok := s.kinds[gvk]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrMissingSchema, gvk)
+	}
+
+	var errs []FieldError
+
+	s.validate(&errs, "", obj, sc, 0)
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+
+	return errs, nil
+}
+
+// maxDepth bounds the recursion on self-referencing
+// schemas, e.g. JSONSchemaProps.
+const maxDepth = 64
+
+// validate appends to errs the mismatches of v, at
+// path p, with sc.
+func (s *Set) validate(
+	errs *[]FieldError,
+	p string,
+	v any,
+	sc *Schema,
+	depth int,
+) {
+	if sc == nil || v == nil || depth > maxDepth {
+		return
+	}
+
+	if sc.Ref != "" {
+		s.validate(errs, p, v, s.resolve(sc.Ref), depth+1)
+	}
+
+	for _, sub := range sc.AllOf {
+		s.validate(errs, p, v, sub, depth+1)
+	}
+
+	if len(sc.AnyOf) > 0 && !s.matchesAny(errs, p, v, sc.AnyOf, depth) {
+		return
+	}
+
+	if sc.IntOrString {
+		if _, ok := v.(string); !ok && !isInteger(v) {
+			addError(errs, p, "expected integer or string, got %s", typeOf(v))
+		}
+
+		return
+	}
+
+	switch sc.Type {
+	case "object":
+		s.validateObject(errs, p, v, sc, depth)
+	case "":
+		if len(sc.Properties) > 0 || sc.AdditionalProperties != nil {
+			s.validateObject(errs, p, v, sc, depth)
+		}
+	case "array":
+		list, ok := v.([]any)
+		if !ok {
+			addError(errs, p, "expected array, got %s", typeOf(v))
+
+			return
+		}
+
+		for i, e := range list {
+			s.validate(
+				errs, p+"["+strconv.Itoa(i)+"]", e, sc.Items, depth+1,
+			)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			addError(errs, p, "expected string, got %s", typeOf(v))
+
+			return
+		}
+	case "integer":
+		if !isInteger(v) {
+			addError(errs, p, "expected integer, got %s", typeOf(v))
+
+			return
+		}
+	case "number":
+		if !isNumber(v) {
+			addError(errs, p, "expected number, got %s", typeOf(v))
+
+			return
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			addError(errs, p, "expected boolean, got %s", typeOf(v))
+
+			return
+		}
+	}
+
+	if len(sc.Enum) > 0 && !inEnum(v, sc.Enum) {
+		addError(errs, p, "value %v is not one of %s", v, enumString(sc.Enum))
+	}
+}
+
+// matchesAny reports whether v matches one of the
+// schemas. Otherwise it appends the mismatches with the
+// first one, or a single error listing the allowed
+// types when the schemas only set a type.
+func (s *Set) matchesAny(
+	errs *[]FieldError,
+	p string,
+	v any,
+	schemas []*Schema,
+	depth int,
+) bool {
+	var (
+		first []FieldError
+		types []string
+	)
+
+	for i, sub := range schemas {
+		var sErrs []FieldError
+
+		s.validate(&sErrs, p, v, sub, depth+1)
+
+		if len(sErrs) == 0 {
+			return true
+		}
+
+		if i == 0 {
+			first = sErrs
+		}
+
+		types = append(types, sub.Type)
+	}
+
+	if slices.Contains(types, "") {
+		*errs = append(*errs, first...)
+
+		return false
+	}
+
+	addError(errs, p, "expected %s, got %s",
+		strings.Join(types, " or "), typeOf(v))
+
+	return false
+}
+
+// validateObject checks the fields of the mapping v.
+// Fields without schema are reported unless the schema
+// preserves unknown fields or is a free-form object.
+func (s *Set) validateObject(
+	errs *[]FieldError,
+	p string,
+	v any,
+	sc *Schema,
+	depth int,
+) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		addError(errs, p, "expected object, got %s", typeOf(v))
+
+		return
+	}
+
+	for _, name := range sc.Required {
+		if _, ok := obj[name]; !ok {
+			addError(errs, joinPath(p, name), "required field is missing")
+		}
+	}
+
+	freeForm := len(sc.Properties) == 0 ||
+		sc.PreserveUnknownFields
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fp := joinPath(p, k)
+
+		switch prop, ok := sc.Properties[k]; {
+		case ok:
+			s.validate(errs, fp, obj[k], prop, depth+1)
+		case sc.AdditionalProperties != nil:
+			s.validate(
+				errs, fp, obj[k], sc.AdditionalProperties, depth+1,
+			)
+		case !freeForm:
+			addError(errs, fp, "unknown field")
+		}
+	}
+}
+
+// resolve returns the definition a $ref points to, or
+// nil, which accepts anything, when it is unknown.
+func (s *Set) resolve(ref string) *Schema {
+	name := ref[strings.LastIndex(ref, "/")+1:]
+
+	return s.defs[name]
+}
+
+// addError appends a formatted FieldError to errs.
+func addError(errs *[]FieldError, p string, format string, args ...any) {
+	*errs = append(*errs, FieldError{
+		Path:    p,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// joinPath appends the field k to the path prefix,
+// quoting names holding dots or slashes, e.g. labels.
+func joinPath(prefix string, k string) string {
+	if strings.ContainsAny(k, "./") {
+		return prefix + "[" + strconv.Quote(k) + "]"
+	}
+
+	if prefix == "" {
+		return k
+	}
+
+	return prefix + "." + k
+}
+
+// typeOf names the JSON type of v for error messages.
+func typeOf(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number, float64, float32, int, int64, int32:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// isNumber reports whether v is a JSON number.
+func isNumber(v any) bool {
+	switch v.(type) {
+	case json.Number, float64, float32, int, int64, int32:
+		return true
+	default:
+		return false
+	}
+}
+
+// isInteger reports whether v is a JSON number without
+// fractional part.
+func isInteger(v any) bool {
+	switch n := v.(type) {
+	case json.Number:
+		_, err := strconv.ParseInt(string(n), 10, 64)
+
+		return err == nil
+	case float64:
+		return n == float64(int64(n))
+	case int, int64, int32:
+		return true
+	default:
+		return false
+	}
+}
+
+// inEnum reports whether v is one of values, comparing
+// their formatted forms so that numbers of different
+// decoders match.
+func inEnum(v any, values []any) bool {
+	s := fmt.Sprint(v)
+
+	for _, e := range values {
+		if fmt.Sprint(e) == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enumString lists values for error messages.
+func enumString(values []any) string {
+	parts := make([]string, len(values))
+	for i, e := range values {
+		parts[i] = fmt.Sprint(e)
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,330 @@
+package schema_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+	"github.com/byte4ever/rules_gitops/gitops/schema"
+	"github.com/byte4ever/rules_gitops/gitops/validate"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: app
+  labels:
+    app.kubernetes.io/name: web
+  creationTimestamp: null
+spec:
+  replicas: two
+  strategy:
+    rollingUpdate:
+      maxSurge: 25%
+      maxUnavailable: 0
+  template:
+    spec:
+      containers:
+        - name: web
+          image: registry.example.com/web:1.0
+          imagePullPolicy: Always
+          ports:
+            - containerPort: 8080
+              protocol: TCP
+          resources:
+            limits:
+              cpu: 0.5
+              memory: 128Mi
+          livenessProbe:
+            httpGet:
+              port: http
+          imagePullSecret: pull
+          env:
+            - name: MODE
+              value: true
+`
+
+const crd = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+    plural: backups
+  scope: Namespaced
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              required: [schedule]
+              properties:
+                schedule:
+                  type: string
+                retention:
+                  type: integer
+                mode:
+                  type: string
+                  enum: [full, incremental]
+                extra:
+                  type: object
+                  x-kubernetes-preserve-unknown-fields: true
+`
+
+const backup = `apiVersion: example.com/v1
+kind: Backup
+metadata:
+  name: nightly
+  labels:
+    team: ops
+spec:
+  retention: 7.5
+  mode: partial
+  target: s3
+  extra:
+    anything: [1, 2]
+`
+
+// parse decodes a manifest for tests.
+func parse(t *testing.T, data string) []manifest.Resource {
+	t.Helper()
+
+	res, err := manifest.Parse(bytes.NewReader([]byte(data)), "m.yaml")
+	require.NoError(t, err)
+
+	return res
+}
+
+func TestSet_bundled(t *testing.T) {
+	t.Parallel()
+
+	var set schema.Set
+	require.NoError(t, set.AddBundled("v1.26.3"))
+	assert.True(t, set.Has(schema.ParseGVK("apps/v1", "Deployment")))
+	assert.True(t, set.Has(schema.ParseGVK("v1", "ConfigMap")))
+
+	errs, err := set.Validate(parse(t, deployment)[0].Object)
+	require.NoError(t, err)
+	assert.Equal(t, []schema.FieldError{
+		{
+			Path:    "spec.replicas",
+			Message: "expected integer, got string",
+		},
+		{
+			Path:    "spec.selector",
+			Message: "required field is missing",
+		},
+		{
+			Path:    "spec.template.spec.containers[0].env[0].value",
+			Message: "expected string, got boolean",
+		},
+		{
+			Path:    "spec.template.spec.containers[0].imagePullSecret",
+			Message: "unknown field",
+		},
+	}, errs)
+}
+
+func TestSet_bundledVersion(t *testing.T) {
+	t.Parallel()
+
+	versions := schema.BundledVersions()
+	assert.Equal(t, []string{"1.24", "1.26"}, versions)
+	assert.Equal(t, schema.LatestBundledVersion, versions[len(versions)-1])
+
+	for _, v := range versions {
+		var set schema.Set
+		require.NoError(t, set.AddBundled(v), v)
+		assert.True(t, set.Has(schema.ParseGVK("apps/v1", "Deployment")), v)
+	}
+
+	var set schema.Set
+	require.ErrorContains(t, set.AddBundled("1.29"),
+		"Kubernetes 1.29 not bundled (only 1.24, 1.26)")
+}
+
+func TestSet_loadCRD(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "crds.yaml")
+	require.NoError(t, os.WriteFile(fp, []byte(crd), 0o600))
+
+	var set schema.Set
+	require.NoError(t, set.AddBundled(schema.LatestBundledVersion))
+	require.NoError(t, set.LoadFile(fp))
+
+	errs, err := set.Validate(parse(t, backup)[0].Object)
+	require.NoError(t, err)
+	assert.Equal(t, []schema.FieldError{
+		{Path: "spec.mode", Message: "value partial is not one of full, incremental"},
+		{Path: "spec.retention", Message: "expected integer, got number"},
+		{Path: "spec.schedule", Message: "required field is missing"},
+		{Path: "spec.target", Message: "unknown field"},
+	}, errs)
+
+	_, err = set.Validate(map[string]any{
+		"apiVersion": "example.com/v2", "kind": "Backup",
+	})
+	require.ErrorIs(t, err, schema.ErrMissingSchema)
+	require.ErrorContains(t, err, "no schema for example.com/v2 Backup")
+}
+
+func TestSet_loadOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "openapi.json")
+	require.NoError(t, os.WriteFile(fp, []byte(`{
+  "openapi": "3.0.0",
+  "components": {"schemas": {
+    "io.k8s.api.core.v1.ConfigMap": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"allOf": [{"$ref": "#/components/schemas/meta"}]},
+        "data": {"type": "object", "additionalProperties": {"type": "string"}}
+      },
+      "x-kubernetes-group-version-kind": [
+        {"group": "", "version": "v1", "kind": "ConfigMap"}
+      ]
+    },
+    "meta": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {"name": {"type": "string"}}
+    }
+  }}
+}`), 0o600))
+
+	var set schema.Set
+	require.NoError(t, set.LoadFile(fp))
+	assert.Equal(t, []schema.GroupVersionKind{
+		{Version: "v1", Kind: "ConfigMap"},
+	}, set.Kinds())
+
+	errs, err := set.Validate(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]any{"namespace": "app"},
+		"data":       map[string]any{"a": "1", "b": []any{}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []schema.FieldError{
+		{Path: "data.b", Message: "expected string, got array"},
+		{Path: "metadata.name", Message: "required field is missing"},
+		{Path: "metadata.namespace", Message: "unknown field"},
+	}, errs)
+
+	require.ErrorContains(t, set.LoadFile(filepath.Join(dir, "none")),
+		"loading schemas: open ")
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "app.yaml"),
+		[]byte(deployment+"---\n"+backup), 0o600,
+	))
+
+	var set schema.Set
+	require.NoError(t, set.AddBundled(schema.LatestBundledVersion))
+
+	v := &validate.Validator{Checks: []validate.Check{
+		schema.Check{Set: &set},
+	}}
+
+	rep, err := v.Validate(dir, []string{"app.yaml"})
+	require.NoError(t, err)
+	require.Len(t, rep.Findings, 5)
+	assert.Equal(t,
+		"app.yaml:1: Deployment app/web: schema: "+
+			"spec.replicas: expected integer, got string",
+		rep.Findings[0].String())
+	assert.Equal(t,
+		"app.yaml:36: Backup nightly: schema: "+
+			"no schema for example.com/v1 Backup",
+		rep.Findings[4].String())
+
+	v.Checks = []validate.Check{
+		schema.Check{Set: &set, IgnoreMissing: true},
+	}
+
+	rep, err = v.Validate(dir, []string{"app.yaml"})
+	require.NoError(t, err)
+	assert.Len(t, rep.Findings, 4)
+}
+
+func TestBundle(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "api__v1_openapi.json")
+	require.NoError(t, os.WriteFile(doc, []byte(`{
+  "openapi": "3.0.0",
+  "paths": {"/api/v1/notes": {}},
+  "components": {"schemas": {
+    "io.k8s.api.core.v1.Note": {
+      "description": "Note is a note.",
+      "type": "object",
+      "required": ["description"],
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "description": {"description": "The text.", "type": "string"}
+      },
+      "x-kubernetes-group-version-kind": [
+        {"group": "", "version": "v1", "kind": "Note"}
+      ]
+    }
+  }}
+}`), 0o600))
+
+	var b bytes.Buffer
+	require.NoError(t, schema.Bundle(&b, doc))
+
+	zr, err := gzip.NewReader(&b)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Note is a note")
+	assert.NotContains(t, string(data), "The text")
+	assert.NotContains(t, string(data), "/api/v1/notes")
+
+	bundle := filepath.Join(dir, "bundle.json")
+	require.NoError(t, os.WriteFile(bundle, data, 0o600))
+
+	var set schema.Set
+	require.NoError(t, set.LoadFile(bundle))
+
+	errs, err := set.Validate(map[string]any{
+		"apiVersion": "v1", "kind": "Note", "description": 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []schema.FieldError{
+		{Path: "description", Message: "expected string, got number"},
+	}, errs)
+
+	require.ErrorContains(t,
+		schema.Bundle(io.Discard, filepath.Join(dir, "none.json")),
+		"bundling schemas: open ")
+}
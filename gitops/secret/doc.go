@@ -0,0 +1,7 @@
+// Package secret reads credentials from outside the command line: environment
+// variables, files such as mounted Kubernetes secrets, and credential helper
+// commands. A Cache keeps a credential until it expires and reads it again
+// afterwards, and Transport authenticates HTTP requests with it, re-reading
+// the credential once when the server rejects it. Errors name the source of a
+// credential but never contain its value.
+package secret
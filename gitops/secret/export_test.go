@@ -0,0 +1,8 @@
+package secret
+
+import "time"
+
+// SetNowForTest replaces the clock of c.
+func SetNowForTest(c *Cache, now func() time.Time) {
+	c.now = now
+}
@@ -0,0 +1,286 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/exec"
+)
+
+// DefaultTTL is how long a Cache keeps a credential
+// that carries no expiry of its own.
+const DefaultTTL = 5 * time.Minute
+
+// expirySkew renews a credential this long before it
+// expires, so requests in flight do not use it late.
+const expirySkew = 30 * time.Second
+
+// Credential is a secret value and its expiry.
+type Credential struct {
+	// Value is the secret itself.
+	Value string
+
+	// Expiry is when Value stops being valid. The zero
+	// time means unknown.
+	Expiry time.Time
+}
+
+// Source supplies a credential. Implementations never
+// include the secret in errors or in String; callers
+// prefix errors with String.
+//
+// Pattern: Strategy -- env, file and helper command
+// sources are interchangeable.
+type Source interface {
+	// Fetch reads the current credential.
+	Fetch(ctx context.Context) (Credential, error)
+
+	// String names the source, e.g. "env GITHUB_TOKEN".
+	String() string
+}
+
+// Env reads the credential from the named environment
+// variable.
+type Env string
+
+// Fetch implements Source.
+func (e Env) Fetch(context.Context) (Credential, error) {
+	v, ok := os.LookupEnv(string(e))
+	if !ok {
+		return Credential{}, fmt.Errorf(
+			"environment variable %s is not set", string(e),
+		)
+	}
+
+	return check(v)
+}
+
+// String implements Source.
+func (e Env) String() string { return "env " + string(e) }
+
+// File reads the credential from a file, such as a
+// mounted Kubernetes secret, which may be rotated in
+// place. Trailing newlines are removed.
+type File string
+
+// Fetch implements Source.
+func (f File) Fetch(context.Context) (Credential, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		// The error names the path, never the content.
+		return Credential{}, fmt.Errorf("read file: %w", err)
+	}
+
+	return check(string(data))
+}
+
+// String implements Source.
+func (f File) String() string { return "file " + string(f) }
+
+// Command runs a credential helper and reads the
+// credential from its standard output: either the bare
+// secret, or a JSON object with a "secret" (or
+// "token") field and an optional RFC 3339 "expires_at"
+// field.
+type Command []string
+
+// Fetch implements Source.
+func (c Command) Fetch(context.Context) (Credential, error) {
+	if len(c) == 0 {
+		return Credential{}, errors.New("empty command")
+	}
+
+	out, err := exec.Output("", c[0], c[1:]...)
+	if err != nil {
+		// The error carries the helper's stderr, not
+		// its output.
+		return Credential{}, err
+	}
+
+	trimmed := bytes.TrimSpace(out)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		return check(string(out))
+	}
+
+	var payload struct {
+		Secret    string    `json:"secret"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	if err := json.Unmarshal(trimmed, &payload); err != nil {
+		// The decoder error may quote the output.
+		return Credential{}, errors.New(
+			"output is not a valid JSON credential",
+		)
+	}
+
+	value := payload.Secret
+	if value == "" {
+		value = payload.Token
+	}
+
+	cred, err := check(value)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	cred.Expiry = payload.ExpiresAt
+
+	return cred, nil
+}
+
+// String implements Source.
+func (c Command) String() string {
+	return "command " + strings.Join(c, " ")
+}
+
+// Literal is a credential given in plain text, e.g. by
+// a command line flag.
+type Literal string
+
+// Fetch implements Source.
+func (l Literal) Fetch(context.Context) (Credential, error) {
+	return check(string(l))
+}
+
+// String implements Source.
+func (Literal) String() string { return "literal value" }
+
+// Or returns src, or literal as a Literal when src is
+// nil. It returns nil when neither is set.
+func Or(src Source, literal string) Source {
+	switch {
+	case src != nil:
+		return src
+	case literal != "":
+		return Literal(literal)
+	default:
+		return nil
+	}
+}
+
+// Parse parses a source specification: "env:NAME",
+// "file:PATH" or "cmd:COMMAND [ARG...]", where the
+// command is split on white space.
+func Parse(spec string) (Source, error) {
+	const errCtx = "parsing secret source"
+
+	kind, arg, _ := strings.Cut(spec, ":")
+	if arg == "" {
+		return nil, fmt.Errorf(
+			"%s: %q: expected env:NAME, file:PATH "+
+				"or cmd:COMMAND", errCtx, kind,
+		)
+	}
+
+	switch kind {
+	case "env":
+		return Env(arg), nil
+	case "file":
+		return File(arg), nil
+	case "cmd":
+		return Command(strings.Fields(arg)), nil
+	default:
+		return nil, fmt.Errorf(
+			"%s: unknown kind %q (expected env, file "+
+				"or cmd)", errCtx, kind,
+		)
+	}
+}
+
+// check validates a fetched value, after removing
+// trailing newlines, without revealing it.
+func check(v string) (Credential, error) {
+	v = strings.TrimRight(v, "\r\n")
+
+	switch {
+	case v == "":
+		return Credential{}, errors.New("empty value")
+	case strings.ContainsAny(v, "\r\n"):
+		return Credential{}, errors.New(
+			"value spans several lines",
+		)
+	}
+
+	return Credential{Value: v}, nil
+}
+
+// Cache keeps the credential of a Source until it
+// expires, then fetches it again. Credentials without
+// an expiry are kept for the TTL. Cache is safe for
+// concurrent use.
+type Cache struct {
+	src Source
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	cred    Credential
+	expires time.Time
+}
+
+// NewCache returns a Cache of src. A ttl <= 0 means
+// DefaultTTL.
+func NewCache(src Source, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Cache{src: src, ttl: ttl, now: time.Now}
+}
+
+// Fetch returns the cached credential, fetching it
+// again when it expired or was invalidated.
+func (c *Cache) Fetch(ctx context.Context) (Credential, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.cred.Value != "" && now.Before(c.expires) {
+		return c.cred, nil
+	}
+
+	cred, err := c.src.Fetch(ctx)
+	if err != nil {
+		return Credential{}, fmt.Errorf(
+			"secret from %s: %w", c.src, err,
+		)
+	}
+
+	c.cred = cred
+	c.expires = now.Add(c.ttl)
+
+	if !cred.Expiry.IsZero() {
+		c.expires = cred.Expiry.Add(-expirySkew)
+	}
+
+	return cred, nil
+}
+
+// Secret returns the current secret value.
+func (c *Cache) Secret(ctx context.Context) (string, error) {
+	cred, err := c.Fetch(ctx)
+
+	return cred.Value, err
+}
+
+// Invalidate drops the cached credential, e.g. after
+// the server rejected it.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cred = Credential{}
+}
+
+// String names the cached source.
+func (c *Cache) String() string { return c.src.String() }
@@ -0,0 +1,193 @@
+package secret_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/secret"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		spec string
+		want secret.Source
+	}{
+		{"env:GITHUB_TOKEN", secret.Env("GITHUB_TOKEN")},
+		{"file:/var/run/secrets/token", secret.File("/var/run/secrets/token")},
+		{"cmd:vault read -field=token kv/ci", secret.Command{
+			"vault", "read", "-field=token", "kv/ci",
+		}},
+	}
+
+	for _, tt := range tests {
+		got, err := secret.Parse(tt.spec)
+		require.NoError(t, err, tt.spec)
+		assert.Equal(t, tt.want, got, tt.spec)
+	}
+
+	_, err := secret.Parse("vault:kv/ci")
+	require.ErrorContains(t, err, `unknown kind "vault"`)
+
+	_, err = secret.Parse("env:")
+	require.ErrorContains(t, err, "expected env:NAME")
+}
+
+func TestFile_rotation(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := secret.NewCache(secret.File(path), time.Minute)
+	secret.SetNowForTest(c, func() time.Time { return now })
+
+	got, err := c.Secret(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "first", got)
+
+	// The mounted secret is rotated in place.
+	require.NoError(t, os.WriteFile(path, []byte("second\n"), 0o600))
+
+	got, err = c.Secret(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "first", got, "cached until the TTL elapses")
+
+	now = now.Add(2 * time.Minute)
+
+	got, err = c.Secret(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "second", got)
+}
+
+func TestCommand_expiry(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	helper := filepath.Join(dir, "helper.sh")
+	require.NoError(t, os.WriteFile(helper, []byte(`#!/bin/sh
+n=$(cat "$1" 2>/dev/null || echo 0)
+n=$((n + 1))
+echo "$n" > "$1"
+echo '{"token": "token-'"$n"'", "expires_at": "2026-01-01T01:00:00Z"}'
+`), 0o700))
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := secret.NewCache(
+		secret.Command{helper, filepath.Join(dir, "count")}, 0,
+	)
+	secret.SetNowForTest(c, func() time.Time { return now })
+
+	cred, err := c.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", cred.Value)
+	assert.Equal(t,
+		time.Date(2026, 1, 1, 1, 0, 0, 0, time.UTC), cred.Expiry)
+
+	// Kept beyond the default TTL, up to the expiry.
+	now = now.Add(50 * time.Minute)
+
+	got, err := c.Secret(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", got)
+
+	now = now.Add(10 * time.Minute)
+
+	got, err = c.Secret(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", got)
+}
+
+func TestErrors_nameSourceNotValue(t *testing.T) {
+	t.Setenv("TEST_MULTILINE_SECRET", "hunter2\nhunter3")
+
+	_, err := secret.NewCache(
+		secret.Env("TEST_MULTILINE_SECRET"), 0,
+	).Fetch(context.Background())
+	require.EqualError(t, err,
+		"secret from env TEST_MULTILINE_SECRET: value spans several lines")
+
+	_, err = secret.NewCache(
+		secret.Env("TEST_UNSET_SECRET_VARIABLE"), 0,
+	).Fetch(context.Background())
+	require.EqualError(t, err,
+		"secret from env TEST_UNSET_SECRET_VARIABLE: environment "+
+			"variable TEST_UNSET_SECRET_VARIABLE is not set")
+
+	helper := filepath.Join(t.TempDir(), "helper.sh")
+	require.NoError(t, os.WriteFile(helper, []byte(
+		"#!/bin/sh\necho '{\"token\": hunter2'\n",
+	), 0o700))
+
+	_, err = secret.NewCache(secret.Command{helper}, 0).
+		Fetch(context.Background())
+	require.ErrorContains(t, err,
+		"output is not a valid JSON credential")
+	assert.NotContains(t, err.Error(), "hunter2")
+
+	_, err = secret.NewCache(secret.Literal(""), 0).
+		Fetch(context.Background())
+	require.EqualError(t, err, "secret from literal value: empty value")
+}
+
+func TestTransport_retriesWithRotatedSecret(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o600))
+
+	var seen []string
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			seen = append(seen, auth)
+
+			if auth != "Bearer new" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusCreated)
+		},
+	))
+	defer srv.Close()
+
+	c := secret.NewCache(secret.File(path), time.Hour)
+	client := &http.Client{Transport: &secret.Transport{
+		Cache: c,
+		Apply: func(req *http.Request, token string) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		},
+	}}
+
+	// Cache the old token, then rotate it.
+	_, err := c.Secret(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("new"), 0o600))
+
+	req, err := http.NewRequestWithContext(
+		context.Background(), http.MethodPost, srv.URL,
+		strings.NewReader(`{"title": "x"}`),
+	)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, []string{"Bearer old", "Bearer new"}, seen)
+}
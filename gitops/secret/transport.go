@@ -0,0 +1,76 @@
+package secret
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper authenticating
+// every request with the current credential of Cache.
+// When the server answers 401 Unauthorized, the
+// credential is read again from its source and the
+// request retried once, so rotated secrets are picked
+// up without a restart.
+type Transport struct {
+	// Cache supplies the credential.
+	Cache *Cache
+
+	// Apply sets the credential on a request, e.g. as
+	// an Authorization header.
+	Apply func(req *http.Request, secret string)
+
+	// Base performs the requests. Nil means
+	// http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The body was consumed: retry only when it can be
+	// replayed.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	t.Cache.Invalidate()
+
+	retry := req.Clone(req.Context())
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil //nolint:nilerr // keep the 401
+		}
+
+		retry.Body = body
+	}
+
+	_ = resp.Body.Close()
+
+	return t.send(retry)
+}
+
+// send authenticates a copy of req and performs it.
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	secret, err := t.Cache.Secret(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("authenticating request: %w", err)
+	}
+
+	// A RoundTripper must not modify the request it
+	// was given.
+	authed := req.Clone(req.Context())
+	t.Apply(authed, secret)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(authed)
+}
@@ -0,0 +1,283 @@
+package validate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// Names of the built-in checks.
+const (
+	CheckYAML            = "yaml"
+	CheckRequiredLabels  = "required-labels"
+	CheckNoLatestTag     = "no-latest-tag"
+	CheckResolvedImages  = "resolved-images"
+	CheckResourceLimits  = "resource-limits"
+	CheckUniqueResources = "unique-resources"
+)
+
+// Builtins lists the built-in checks that take no
+// parameter, as accepted by Lookup.
+var Builtins = []string{
+	CheckYAML,
+	CheckNoLatestTag,
+	CheckResolvedImages,
+	CheckResourceLimits,
+	CheckUniqueResources,
+}
+
+// Lookup returns the built-in check named name.
+// RequiredLabels and Command take parameters and are
+// built directly.
+func Lookup(name string) (Check, error) {
+	switch name {
+	case CheckYAML:
+		return YAML{}, nil
+	case CheckNoLatestTag:
+		return NoLatestTag{}, nil
+	case CheckResolvedImages:
+		return ResolvedImages{}, nil
+	case CheckResourceLimits:
+		return ResourceLimits{}, nil
+	case CheckUniqueResources:
+		return UniqueResources{}, nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown check %q (expected one of %s)",
+			name, strings.Join(Builtins, ", "),
+		)
+	}
+}
+
+// YAML reports the files that are not valid
+// multi-document YAML, or hold a document without
+// apiVersion, kind or metadata.name.
+type YAML struct{}
+
+// Name implements Check.
+func (YAML) Name() string { return CheckYAML }
+
+// Check implements Check.
+func (YAML) Check(in *Input) ([]Finding, error) {
+	var findings []Finding
+
+	for _, name := range in.Files {
+		if err, ok := in.ParseErrors[name]; ok {
+			findings = append(findings, Finding{
+				Check:   CheckYAML,
+				File:    name,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// RequiredLabels reports the resources missing one of
+// Labels in metadata.labels.
+type RequiredLabels struct {
+	Labels []string
+}
+
+// Name implements Check.
+func (RequiredLabels) Name() string { return CheckRequiredLabels }
+
+// Check implements Check.
+func (c RequiredLabels) Check(in *Input) ([]Finding, error) {
+	var findings []Finding
+
+	for _, r := range in.Resources {
+		meta, _ := r.Object["metadata"].(map[string]any)
+		labels, _ := meta["labels"].(map[string]any)
+
+		var missing []string
+
+		for _, l := range c.Labels {
+			if _, ok := labels[l]; !ok {
+				missing = append(missing, l)
+			}
+		}
+
+		if len(missing) > 0 {
+			findings = append(findings, resourceFinding(
+				CheckRequiredLabels, r,
+				"missing labels %s", strings.Join(missing, ", "),
+			))
+		}
+	}
+
+	return findings, nil
+}
+
+// NoLatestTag reports images tagged latest, or without
+// tag nor digest, which means latest.
+type NoLatestTag struct{}
+
+// Name implements Check.
+func (NoLatestTag) Name() string { return CheckNoLatestTag }
+
+// Check implements Check.
+func (NoLatestTag) Check(in *Input) ([]Finding, error) {
+	var findings []Finding
+
+	for _, r := range in.Resources {
+		for _, ref := range r.Images() {
+			if unresolved(ref) || strings.Contains(ref, "@") {
+				continue
+			}
+
+			tag := strings.TrimPrefix(ref, manifest.ImageName(ref))
+
+			switch tag {
+			case ":latest":
+				findings = append(findings, resourceFinding(
+					CheckNoLatestTag, r,
+					"image %s uses the latest tag", ref,
+				))
+			case "":
+				findings = append(findings, resourceFinding(
+					CheckNoLatestTag, r,
+					"image %s has no tag nor digest", ref,
+				))
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// ResolvedImages reports images that are still bazel
+// labels, e.g. //app:image, which the resolver did not
+// replace with a registry reference.
+type ResolvedImages struct{}
+
+// Name implements Check.
+func (ResolvedImages) Name() string { return CheckResolvedImages }
+
+// Check implements Check.
+func (ResolvedImages) Check(in *Input) ([]Finding, error) {
+	var findings []Finding
+
+	for _, r := range in.Resources {
+		for _, ref := range r.Images() {
+			if unresolved(ref) {
+				findings = append(findings, resourceFinding(
+					CheckResolvedImages, r,
+					"image %s is an unresolved bazel label", ref,
+				))
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// unresolved reports whether ref is a bazel label.
+func unresolved(ref string) bool {
+	return strings.HasPrefix(ref, "//") ||
+		(strings.HasPrefix(ref, "@") && strings.Contains(ref, "//"))
+}
+
+// ResourceLimits reports the containers and init
+// containers of pod templates without
+// resources.limits.
+type ResourceLimits struct{}
+
+// Name implements Check.
+func (ResourceLimits) Name() string { return CheckResourceLimits }
+
+// Check implements Check.
+func (ResourceLimits) Check(in *Input) ([]Finding, error) {
+	var findings []Finding
+
+	for _, r := range in.Resources {
+		for _, c := range containers(nil, r.Object) {
+			name, _ := c["name"].(string)
+			res, _ := c["resources"].(map[string]any)
+
+			if limits, _ := res["limits"].(map[string]any); len(limits) == 0 {
+				findings = append(findings, resourceFinding(
+					CheckResourceLimits, r,
+					"container %s has no resources.limits", name,
+				))
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// containers appends the containers and init
+// containers of every pod spec below v: the mappings
+// with a containers list, whatever the workload kind.
+func containers(out []map[string]any, v any) []map[string]any {
+	switch o := v.(type) {
+	case map[string]any:
+		if _, ok := o["containers"].([]any); ok {
+			for _, key := range []string{"initContainers", "containers"} {
+				list, _ := o[key].([]any)
+				for _, c := range list {
+					if m, ok := c.(map[string]any); ok {
+						out = append(out, m)
+					}
+				}
+			}
+
+			return out
+		}
+
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			out = containers(out, o[k])
+		}
+	case []any:
+		for _, e := range o {
+			out = containers(out, e)
+		}
+	}
+
+	return out
+}
+
+// UniqueResources reports resources defined more than
+// once with the same kind, namespace and name, which
+// the cluster would hold as a single object.
+type UniqueResources struct{}
+
+// Name implements Check.
+func (UniqueResources) Name() string { return CheckUniqueResources }
+
+// Check implements Check.
+func (UniqueResources) Check(in *Input) ([]Finding, error) {
+	var findings []Finding
+
+	first := make(map[manifest.Key]manifest.Resource)
+
+	for _, r := range in.Resources {
+		k := r.Key
+		k.APIVersion = ""
+
+		prev, dup := first[k]
+		if !dup {
+			first[k] = r
+
+			continue
+		}
+
+		findings = append(findings, resourceFinding(
+			CheckUniqueResources, r,
+			"also defined in %s:%d", prev.File, prev.Line,
+		))
+	}
+
+	return findings, nil
+}
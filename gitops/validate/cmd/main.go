@@ -0,0 +1,177 @@
+// Command gitops_validate checks manifest files
+// offline: against the bundled Kubernetes schemas and
+// CRD or OpenAPI schemas loaded from files, and with
+// the built-in checks of the validate package. It
+// exits with status 2 when a check finds a problem.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/gitops/internal/cmdflag"
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+	"github.com/byte4ever/rules_gitops/gitops/schema"
+	"github.com/byte4ever/rules_gitops/gitops/validate"
+)
+
+// exitInvalid is the exit status when a check finds a
+// problem.
+const exitInvalid = 2
+
+func main() {
+	invalid, err := run()
+	if err != nil {
+		slog.Error("fatal", "error", err)
+		os.Exit(1)
+	}
+
+	if invalid {
+		os.Exit(exitInvalid)
+	}
+}
+
+// run validates the files named on the command line
+// and reports whether a check found a problem.
+func run() (bool, error) {
+	const errCtx = "running gitops_validate"
+
+	kubernetesVersion := flag.String(
+		"kubernetes_version", schema.LatestBundledVersion,
+		"Kubernetes version of the bundled schemas ("+
+			strings.Join(schema.BundledVersions(), ", ")+
+			"); empty to only use --schema files",
+	)
+
+	var schemaFiles cmdflag.Slice
+
+	flag.Var(
+		&schemaFiles,
+		"schema",
+		"CRD or JSON OpenAPI document file (repeatable)",
+	)
+
+	ignoreMissing := flag.Bool(
+		"ignore_missing_schemas", false,
+		"Skip resources whose kind has no schema",
+	)
+
+	var checks cmdflag.Slice
+
+	flag.Var(
+		&checks,
+		"validate",
+		"Built-in check to run too (repeatable): "+
+			strings.Join(validate.Builtins, ", "),
+	)
+
+	output := flag.String(
+		"output", "text",
+		"Report format: text or json",
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] file_or_dir...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		return false, fmt.Errorf(
+			"%s: no manifest file or directory", errCtx,
+		)
+	}
+
+	set, err := schema.Load(*kubernetesVersion, schemaFiles...)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	v := &validate.Validator{Checks: []validate.Check{
+		validate.YAML{},
+		schema.Check{Set: set, IgnoreMissing: *ignoreMissing},
+	}}
+
+	for _, name := range checks {
+		c, err := validate.Lookup(name)
+		if err != nil {
+			return false, fmt.Errorf(
+				"%s: --validate: %w", errCtx, err,
+			)
+		}
+
+		v.Checks = append(v.Checks, c)
+	}
+
+	files, err := expand(flag.Args())
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	rep, err := v.Validate("", files)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if rep.Findings == nil {
+		rep.Findings = []validate.Finding{}
+	}
+
+	switch *output {
+	case "text":
+		if !rep.OK() {
+			_, err = fmt.Fprintln(os.Stdout, rep)
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rep)
+	default:
+		err = fmt.Errorf("unknown output %q", *output)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	return !rep.OK(), nil
+}
+
+// expand replaces the directories of args with the
+// manifest files below them.
+func expand(args []string) ([]string, error) {
+	var files []string
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, filepath.ToSlash(arg))
+
+			continue
+		}
+
+		names, err := manifest.ListFiles(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			files = append(files, path.Join(filepath.ToSlash(arg), name))
+		}
+	}
+
+	return files, nil
+}
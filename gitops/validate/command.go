@@ -0,0 +1,143 @@
+package validate
+
+import (
+	"errors"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/exec"
+)
+
+// CheckCommand is the name of the external validator
+// check.
+const CheckCommand = "command"
+
+// maxArgBytes bounds the size of the file names passed
+// to one run of a Command, well below the ARG_MAX of
+// common systems, which also holds the environment.
+const maxArgBytes = 128 << 10
+
+// Command is an external validator: the command line
+// is run in Input.Dir with the manifest files appended
+// as arguments, in as many runs as needed to keep the
+// command line short, as xargs does. A non-zero exit
+// status fails the validation, and every non-empty
+// output line is a finding. Lines starting with
+// "file:" or "file:line:" are attributed to that file.
+type Command []string
+
+// Name implements Check.
+func (Command) Name() string { return CheckCommand }
+
+// Check implements Check.
+func (c Command) Check(in *Input) ([]Finding, error) {
+	if len(c) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	if len(in.Files) == 0 {
+		return nil, nil
+	}
+
+	var findings []Finding
+
+	for _, files := range argBatches(in.Files, maxArgBytes) {
+		found, err := c.run(in.Dir, files)
+		if err != nil {
+			return nil, err
+		}
+
+		findings = append(findings, found...)
+	}
+
+	return findings, nil
+}
+
+// run runs the command on files and returns the
+// findings of a failed run.
+func (c Command) run(dir string, files []string) ([]Finding, error) {
+	args := append(append([]string(nil), c[1:]...), files...)
+
+	out, err := exec.Ex(dir, c[0], args...)
+
+	var exitErr *osexec.ExitError
+
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.As(err, &exitErr):
+		return nil, err
+	}
+
+	var findings []Finding
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			findings = append(findings, commandFinding(line, files))
+		}
+	}
+
+	if len(findings) == 0 {
+		findings = append(findings, Finding{
+			Check: CheckCommand,
+			Message: c[0] + " exited with status " +
+				strconv.Itoa(exitErr.ExitCode()),
+		})
+	}
+
+	return findings, nil
+}
+
+// argBatches splits files into batches whose names,
+// with their terminating NULs, take at most limit
+// bytes. A longer name is a batch of its own.
+func argBatches(files []string, limit int) [][]string {
+	var (
+		batches [][]string
+		size    int
+	)
+
+	start := 0
+
+	for i, name := range files {
+		n := len(name) + 1
+		if i > start && size+n > limit {
+			batches = append(batches, files[start:i])
+			start, size = i, 0
+		}
+
+		size += n
+	}
+
+	return append(batches, files[start:])
+}
+
+// commandFinding attributes an output line of the
+// validator to one of files when it starts with its
+// name.
+func commandFinding(line string, files []string) Finding {
+	f := Finding{Check: CheckCommand, Message: line}
+
+	for _, name := range files {
+		rest, ok := strings.CutPrefix(line, name+":")
+		if !ok {
+			continue
+		}
+
+		f.File = name
+		f.Message = strings.TrimSpace(rest)
+
+		if n, msg, ok := strings.Cut(f.Message, ":"); ok {
+			if l, err := strconv.Atoi(n); err == nil && l > 0 {
+				f.Line = l
+				f.Message = strings.TrimSpace(msg)
+			}
+		}
+
+		break
+	}
+
+	return f
+}
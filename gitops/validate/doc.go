@@ -0,0 +1,7 @@
+// Package validate checks rendered Kubernetes manifests before they are
+// committed. A Validator parses the manifest files and runs a list of Check
+// strategies on them: built-in checks for parseable YAML, required labels,
+// latest image tags, unresolved bazel image labels, missing resource limits
+// and duplicate resources, and Command, which runs an external validator.
+// Findings name the file, line and resource of each problem.
+package validate
@@ -0,0 +1,196 @@
+package validate
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+)
+
+// Check is a validation of rendered manifests. It is
+// the strategy interface of the validation step:
+// built-in checks and external commands implement it.
+type Check interface {
+	// Name identifies the check in findings.
+	Name() string
+
+	// Check validates in. A finding is a problem of
+	// the manifests; an error means the check itself
+	// could not run.
+	Check(in *Input) ([]Finding, error)
+}
+
+// Input holds the manifests under validation.
+type Input struct {
+	// Dir is the directory Files are relative to.
+	Dir string
+
+	// Files are the slash-separated names of the
+	// manifest files.
+	Files []string
+
+	// Resources are the documents of the files that
+	// parse, in file order.
+	Resources []manifest.Resource
+
+	// ParseErrors holds, by file, the error of the
+	// files that do not parse. Their documents are
+	// missing from Resources.
+	ParseErrors map[string]error
+}
+
+// Finding is a problem found by a check in a file,
+// and in one of its resources when Resource is set.
+type Finding struct {
+	// Check names the check.
+	Check string `json:"check"`
+
+	// File is the manifest file, empty when the
+	// finding is not about a single file.
+	File string `json:"file,omitempty"`
+
+	// Line is the line of File the finding is on,
+	// zero when unknown.
+	Line int `json:"line,omitempty"`
+
+	// Resource identifies the resource, zero for a
+	// finding about the whole file.
+	Resource manifest.Key `json:"resource"`
+
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// resourceFinding returns a finding about r.
+func resourceFinding(
+	check string,
+	r manifest.Resource,
+	format string,
+	args ...any,
+) Finding {
+	return Finding{
+		Check:    check,
+		File:     r.File,
+		Line:     r.Line,
+		Resource: r.Key,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// String formats f as "file:line: resource: check:
+// message", leaving out the parts that are not set.
+func (f Finding) String() string {
+	var sb strings.Builder
+
+	if f.File != "" {
+		sb.WriteString(f.File)
+
+		if f.Line > 0 {
+			fmt.Fprintf(&sb, ":%d", f.Line)
+		}
+
+		sb.WriteString(": ")
+	}
+
+	if f.Resource.Kind != "" {
+		sb.WriteString(f.Resource.String() + ": ")
+	}
+
+	sb.WriteString(f.Check + ": " + f.Message)
+
+	return sb.String()
+}
+
+// Report is the result of a validation.
+type Report struct {
+	// Findings are sorted by file, line and check.
+	Findings []Finding `json:"findings"`
+}
+
+// OK reports whether no check found a problem.
+func (r *Report) OK() bool {
+	return len(r.Findings) == 0
+}
+
+// String lists the findings, one per line.
+func (r *Report) String() string {
+	lines := make([]string, len(r.Findings))
+	for i, f := range r.Findings {
+		lines[i] = f.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Validator runs checks on manifest files.
+type Validator struct {
+	// Checks are run in order.
+	Checks []Check
+}
+
+// Validate parses files, relative to dir, and runs
+// every check on them. Files that do not parse are
+// only reported by the YAML check. It fails when a
+// file cannot be read or a check cannot run.
+func (v *Validator) Validate(
+	dir string,
+	files []string,
+) (*Report, error) {
+	const errCtx = "validating manifests"
+
+	in := &Input{
+		Dir:         dir,
+		Files:       files,
+		ParseErrors: make(map[string]error),
+	}
+
+	for _, name := range files {
+		data, err := os.ReadFile( //nolint:gosec
+			filepath.Join(dir, filepath.FromSlash(name)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		res, err := manifest.Parse(bytes.NewReader(data), name)
+		if err != nil {
+			in.ParseErrors[name] = err
+
+			continue
+		}
+
+		in.Resources = append(in.Resources, res...)
+	}
+
+	rep := &Report{}
+
+	for _, c := range v.Checks {
+		findings, err := c.Check(in)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: %s: %w", errCtx, c.Name(), err,
+			)
+		}
+
+		rep.Findings = append(rep.Findings, findings...)
+	}
+
+	sort.SliceStable(rep.Findings, func(i, j int) bool {
+		a, b := rep.Findings[i], rep.Findings[j]
+
+		switch {
+		case a.File != b.File:
+			return a.File < b.File
+		case a.Line != b.Line:
+			return a.Line < b.Line
+		default:
+			return a.Check < b.Check
+		}
+	})
+
+	return rep, nil
+}
@@ -0,0 +1,282 @@
+package validate_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+	"github.com/byte4ever/rules_gitops/gitops/validate"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: app
+  labels:
+    team: web
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: //app:migrate_image
+      containers:
+        - name: web
+          image: registry.example.com/web:latest
+          resources:
+            limits:
+              memory: 128Mi
+        - name: sidecar
+          image: registry.example.com/proxy
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: app
+`
+
+const cronJob = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+  namespace: app
+  labels:
+    team: ops
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: registry.example.com/backup@sha256:0123
+              resources:
+                limits:
+                  cpu: "1"
+`
+
+const service = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: app
+`
+
+// writeManifests creates files below a temporary
+// directory and returns it.
+func writeManifests(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, content := range files {
+		fp := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0o750))
+		require.NoError(t, os.WriteFile(fp, []byte(content), 0o600))
+	}
+
+	return dir
+}
+
+func TestValidate_builtins(t *testing.T) {
+	t.Parallel()
+
+	dir := writeManifests(t, map[string]string{
+		"cloud/app.yaml":    deployment,
+		"cloud/backup.yaml": cronJob + "---\n" + service,
+		"cloud/broken.yaml": "kind: [unterminated\n",
+	})
+
+	v := &validate.Validator{Checks: []validate.Check{
+		validate.RequiredLabels{Labels: []string{"team"}},
+	}}
+
+	for _, name := range validate.Builtins {
+		c, err := validate.Lookup(name)
+		require.NoError(t, err)
+
+		v.Checks = append(v.Checks, c)
+	}
+
+	rep, err := v.Validate(dir, []string{
+		"cloud/app.yaml", "cloud/backup.yaml", "cloud/broken.yaml",
+	})
+	require.NoError(t, err)
+	assert.False(t, rep.OK())
+
+	deploy := manifest.Key{
+		APIVersion: "apps/v1", Kind: "Deployment",
+		Namespace: "app", Name: "web",
+	}
+	svc := manifest.Key{
+		APIVersion: "v1", Kind: "Service",
+		Namespace: "app", Name: "web",
+	}
+
+	want := []validate.Finding{
+		{
+			Check: "no-latest-tag", File: "cloud/app.yaml", Line: 1,
+			Resource: deploy,
+			Message:  "image registry.example.com/proxy has no tag nor digest",
+		},
+		{
+			Check: "no-latest-tag", File: "cloud/app.yaml", Line: 1,
+			Resource: deploy,
+			Message:  "image registry.example.com/web:latest uses the latest tag",
+		},
+		{
+			Check: "resolved-images", File: "cloud/app.yaml", Line: 1,
+			Resource: deploy,
+			Message:  "image //app:migrate_image is an unresolved bazel label",
+		},
+		{
+			Check: "resource-limits", File: "cloud/app.yaml", Line: 1,
+			Resource: deploy,
+			Message:  "container migrate has no resources.limits",
+		},
+		{
+			Check: "resource-limits", File: "cloud/app.yaml", Line: 1,
+			Resource: deploy,
+			Message:  "container sidecar has no resources.limits",
+		},
+		{
+			Check: "required-labels", File: "cloud/app.yaml", Line: 23,
+			Resource: svc,
+			Message:  "missing labels team",
+		},
+		{
+			Check: "required-labels", File: "cloud/backup.yaml", Line: 20,
+			Resource: svc,
+			Message:  "missing labels team",
+		},
+		{
+			Check: "unique-resources", File: "cloud/backup.yaml", Line: 20,
+			Resource: svc,
+			Message:  "also defined in cloud/app.yaml:23",
+		},
+	}
+
+	require.Len(t, rep.Findings, len(want)+1)
+	assert.ElementsMatch(t, want, rep.Findings[:len(want)])
+
+	broken := rep.Findings[len(want)]
+	assert.Equal(t, "yaml", broken.Check)
+	assert.Equal(t, "cloud/broken.yaml", broken.File)
+
+	assert.Contains(t, rep.String(),
+		"cloud/app.yaml:1: Deployment app/web: no-latest-tag: "+
+			"image registry.example.com/web:latest uses the latest tag\n")
+	assert.Contains(t, rep.String(),
+		"cloud/backup.yaml:20: Service app/web: unique-resources: "+
+			"also defined in cloud/app.yaml:23\n")
+}
+
+func TestValidate_clean(t *testing.T) {
+	t.Parallel()
+
+	dir := writeManifests(t, map[string]string{
+		"backup.yaml": cronJob,
+	})
+
+	v := &validate.Validator{}
+
+	for _, name := range validate.Builtins {
+		c, err := validate.Lookup(name)
+		require.NoError(t, err)
+
+		v.Checks = append(v.Checks, c)
+	}
+
+	rep, err := v.Validate(dir, []string{"backup.yaml"})
+	require.NoError(t, err)
+	assert.True(t, rep.OK(), rep.String())
+}
+
+func TestLookup_unknown(t *testing.T) {
+	t.Parallel()
+
+	_, err := validate.Lookup("lint")
+	require.ErrorContains(t, err, `unknown check "lint" (expected one of yaml,`)
+}
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+
+	dir := writeManifests(t, map[string]string{
+		"a.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+		"b.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+		"check.sh": "#!/bin/sh\n" +
+			"for f in \"$@\"; do\n" +
+			"  grep -q 'name: b' \"$f\" && echo \"$f:4: name b is reserved\"\n" +
+			"done\n" +
+			"echo 'policy: 1 violation' >&2\n" +
+			"exit 1\n",
+		"ok.sh":     "#!/bin/sh\nexit 0\n",
+		"silent.sh": "#!/bin/sh\nexit 3\n",
+	})
+
+	files := []string{"a.yaml", "b.yaml"}
+
+	rep, err := (&validate.Validator{Checks: []validate.Check{
+		validate.Command{"sh", "check.sh"},
+	}}).Validate(dir, files)
+	require.NoError(t, err)
+	assert.Equal(t, []validate.Finding{
+		{Check: "command", Message: "policy: 1 violation"},
+		{
+			Check: "command", File: "b.yaml", Line: 4,
+			Message: "name b is reserved",
+		},
+	}, rep.Findings)
+
+	rep, err = (&validate.Validator{Checks: []validate.Check{
+		validate.Command{"sh", "ok.sh"},
+	}}).Validate(dir, files)
+	require.NoError(t, err)
+	assert.True(t, rep.OK())
+
+	rep, err = (&validate.Validator{Checks: []validate.Check{
+		validate.Command{"sh", "silent.sh"},
+	}}).Validate(dir, files)
+	require.NoError(t, err)
+	assert.Equal(t, "command: sh exited with status 3", rep.String())
+
+	// Long file lists are split across runs rather
+	// than exceeding ARG_MAX.
+	var many []string
+	for i := range 3000 {
+		many = append(many, fmt.Sprintf("%s/%04d.yaml", strings.Repeat("d", 80), i))
+	}
+
+	findings, err := validate.Command{
+		"sh", "-c", `echo "$# files"; exit 1`, "sh",
+	}.Check(&validate.Input{Dir: dir, Files: many})
+	require.NoError(t, err)
+	require.Len(t, findings, 3)
+
+	total := 0
+
+	for _, f := range findings {
+		var n int
+
+		_, err := fmt.Sscanf(f.Message, "%d files", &n)
+		require.NoError(t, err)
+
+		total += n
+	}
+
+	assert.Equal(t, len(many), total)
+
+	_, err = (&validate.Validator{Checks: []validate.Check{
+		validate.Command{filepath.Join(dir, "missing")},
+	}}).Validate(dir, files)
+	require.ErrorContains(t, err, "validating manifests: command:")
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/byte4ever/rules_gitops/gitops/manifest"
+	"github.com/byte4ever/rules_gitops/gitops/schema"
 	"github.com/byte4ever/rules_gitops/resolver"
 )
 
@@ -33,6 +36,18 @@ func (im *imagesFlags) Set(value string) error {
 	return nil
 }
 
+type schemaFlags []string
+
+func (sf *schemaFlags) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *schemaFlags) Set(value string) error {
+	*sf = append(*sf, value)
+
+	return nil
+}
+
 func run() error {
 	const errCtx = "resolver"
 
@@ -58,8 +73,43 @@ func run() error {
 		"imagename=imagevalue (repeatable)",
 	)
 
+	var (
+		kubernetesVersion string
+		schemas           schemaFlags
+		ignoreMissing     bool
+	)
+
+	flag.StringVar(
+		&kubernetesVersion, "kubernetes_version", "",
+		"validate the output against the bundled schemas "+
+			"of this Kubernetes version ("+
+			strings.Join(schema.BundledVersions(), ", ")+")",
+	)
+
+	flag.Var(
+		&schemas, "schema",
+		"CRD or OpenAPI document file validating the "+
+			"output (repeatable)",
+	)
+
+	flag.BoolVar(
+		&ignoreMissing, "ignore_missing_schemas", false,
+		"skip resources whose kind has no schema",
+	)
+
 	flag.Parse()
 
+	var check *schema.Check
+
+	if kubernetesVersion != "" || len(schemas) > 0 {
+		set, err := schema.Load(kubernetesVersion, schemas...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		check = &schema.Check{Set: set, IgnoreMissing: ignoreMissing}
+	}
+
 	inReader := os.Stdin
 
 	if inFile != "" {
@@ -76,6 +126,22 @@ func run() error {
 		inReader = fi
 	}
 
+	var resolved bytes.Buffer
+
+	if err := resolver.ResolveImages(
+		inReader, &resolved, images,
+	); err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if check != nil {
+		if err := validateOutput(
+			check, resolved.Bytes(), inFile,
+		); err != nil {
+			return fmt.Errorf("%s: %w", errCtx, err)
+		}
+	}
+
 	outWriter := os.Stdout
 
 	if outFile != "" {
@@ -92,15 +158,47 @@ func run() error {
 		outWriter = fo
 	}
 
-	if err := resolver.ResolveImages(
-		inReader, outWriter, images,
-	); err != nil {
-		return fmt.Errorf("%s: %w", errCtx, err)
+	if _, err := resolved.WriteTo(outWriter); err != nil {
+		return fmt.Errorf(
+			"%s: writing output: %w",
+			errCtx, err,
+		)
 	}
 
 	return nil
 }
 
+// validateOutput checks the resolved documents against
+// the schemas of check, so that invalid manifests are
+// not written. name identifies the input in findings.
+func validateOutput(check *schema.Check, data []byte, name string) error {
+	const errCtx = "validating output"
+
+	if name == "" {
+		name = "<stdin>"
+	}
+
+	res, err := manifest.Parse(bytes.NewReader(data), name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	findings := check.Findings(res)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(findings))
+	for i, f := range findings {
+		lines[i] = f.String()
+	}
+
+	return fmt.Errorf(
+		"%s: schema violations:\n%s",
+		errCtx, strings.Join(lines, "\n"),
+	)
+}
+
 func main() {
 	if err := run(); err != nil {
 		slog.Error(err.Error())
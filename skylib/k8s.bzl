@@ -209,6 +209,8 @@ def k8s_deploy(
         release_branch_prefix = "main",
         start_tag = "{{",
         end_tag = "}}",
+        kubernetes_version = "",
+        schemas = [],
         tags = [],
         visibility = None):
     """Macro to deploy Kubernetes manifests.
@@ -261,9 +263,16 @@ def k8s_deploy(
             individual namespace work.
         gitops_path: Path prefix for gitops output.
         deployment_branch: Git branch for deployment.
-        release_branch_prefix: Release branch prefix.
+        release_branch_prefix: Release branch name, prefix, glob or
+            regex matched against the create_gitops_prs release
+            branches, according to --release_branch_match.
         start_tag: Template start delimiter.
         end_tag: Template end delimiter.
+        kubernetes_version: Validate the rendered
+            manifests against the bundled schemas of
+            this Kubernetes version.
+        schemas: CRD or OpenAPI document files
+            validating the rendered manifests.
         tags: Bazel tags for all generated targets.
         visibility: Bazel visibility for targets.
     """
@@ -327,6 +336,8 @@ def k8s_deploy(
             objects = objects,
             image_name_patches = image_name_patches,
             image_tag_patches = image_tag_patches,
+            kubernetes_version = kubernetes_version,
+            schemas = schemas,
             tags = tags,
             visibility = visibility,
         )
@@ -401,6 +412,8 @@ def k8s_deploy(
             image_tag_patches = (
                 image_tag_patches
             ),
+            kubernetes_version = kubernetes_version,
+            schemas = schemas,
             tags = tags,
         )
         kubectl(
@@ -162,9 +162,15 @@ def _kustomize_impl(ctx):
 
     transitive_runfiles = []
     resolver_part = ""
-    if ctx.attr.images:
+    validate_schemas = ctx.attr.kubernetes_version or ctx.files.schemas
+    if ctx.attr.images or validate_schemas:
         resolver_part += " | {resolver} ".format(resolver = ctx.executable._resolver.path)
         tmpfiles.append(ctx.executable._resolver)
+        if ctx.attr.kubernetes_version:
+            resolver_part += " --kubernetes_version={}".format(ctx.attr.kubernetes_version)
+        for f in ctx.files.schemas:
+            resolver_part += " --schema={}".format(f.path)
+            tmpfiles.append(f)
         for img in ctx.attr.images:
             kpi = img[K8sPushInfo]
             regrepo = kpi.registry + "/" + kpi.repository
@@ -283,6 +289,8 @@ kustomize = rule(
         "patches": attr.label_list(allow_files = True, doc = "Kustomize patches to apply."),
         "image_name_patches": attr.string_dict(default = {}, doc = "set new names for selected images"),
         "image_tag_patches": attr.string_dict(default = {}, doc = "set new tags for selected images"),
+        "kubernetes_version": attr.string(default = "", doc = "Validate the rendered manifests against the bundled schemas of this Kubernetes version."),
+        "schemas": attr.label_list(allow_files = True, doc = "CRD or OpenAPI document files validating the rendered manifests."),
         "start_tag": attr.string(default = "{{", doc = "Start delimiter for template expansion."),
         "substitutions": attr.string_dict(default = {}, doc = "Template variable substitutions."),
         "deps": attr.label_list(default = [], allow_files = True, doc = "Additional template dependencies."),
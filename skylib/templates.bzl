@@ -23,6 +23,8 @@ def _expand_template_impl(ctx):
     stamps = [ctx.file._info_file]
     for sf in stamps:
         arguments.append("--stamp_info_file=%s" % sf.path)
+    for f in ctx.files.variable_files:
+        arguments.append("--variable_file=%s" % f.path)
     for k in ctx.attr.substitutions:
         arguments.append("--variable=%s=%s" % (k, ctx.attr.substitutions[k]))
     if ctx.attr.start_tag:
@@ -44,7 +46,7 @@ def _expand_template_impl(ctx):
     ctx.actions.run(
         executable = ctx.executable._engine,
         arguments = arguments,
-        inputs = [ctx.file.template] + ctx.files.deps + stamps,
+        inputs = [ctx.file.template] + ctx.files.deps + ctx.files.variable_files + stamps,
         outputs = [ctx.outputs.out],
         mnemonic = "Template",
     )
@@ -65,6 +67,9 @@ Args:
       imports[name] in the template environment.
   substitutions: a dictionary of key => values that will appear as variables.key
       in the template environment.
+  variable_files: YAML, JSON or .env files of variables, nested keys flattened
+      to dotted names, that will appear as variables.key in the template
+      environment. Later files and substitutions override earlier ones.
   out: the name of the output file to generate.
   executable: mark the result as excutable if set to True.
 """,
@@ -76,6 +81,10 @@ Args:
         # "escape_xml": attr.bool(default = True),
         "start_tag": attr.string(default = "{{"),
         "substitutions": attr.string_dict(mandatory = True),
+        "variable_files": attr.label_list(
+            default = [],
+            allow_files = [".yaml", ".yml", ".json", ".env"],
+        ),
         "template": attr.label(
             mandatory = True,
             allow_single_file = True,
@@ -0,0 +1,11 @@
+// Package stamp parses Bazel workspace status files, the stamps of the
+// stamper and templating tools and of gitops PR stamping. A Loader reads the
+// "KEY VALUE" lines Bazel writes, or a JSON object for workspace status
+// commands that emit JSON, into typed Stamps. Lenient mode skips malformed
+// lines; Strict mode rejects them and keys that files set to different
+// values, which Lenient mode returns as Conflicts. An UnresolvedMode keeps,
+// warns about or rejects the placeholders of a template without a value,
+// reporting their line and column. An Expander substitutes placeholders,
+// optionally honouring backslash escapes of the start tag and only tags that
+// name a variable.
+package stamp
@@ -0,0 +1,204 @@
+package stamp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Escape is the character that makes the start tag
+// following it literal when Expander.Escape is set.
+const Escape = '\\'
+
+// Expander substitutes the placeholders of templates
+// between StartTag and EndTag with variables. Its zero
+// options expand as fasttemplate.ExecuteStringStd
+// does: placeholders without a value are kept as-is
+// and an unterminated start tag ends the template.
+type Expander struct {
+	StartTag string
+	EndTag   string
+
+	// Escape makes a backslash before StartTag emit the
+	// start tag literally, e.g. \{{ gives {{ with the
+	// default tags of templating, and \{NAME} gives
+	// {NAME} with those of stamper. A doubled backslash
+	// before StartTag gives one backslash followed by
+	// the placeholder. Other backslashes are kept.
+	Escape bool
+
+	// KnownOnly makes only the tags naming a variable
+	// placeholders: other brace sequences, such as Go
+	// templates or JSON objects, are left untouched and
+	// scanned again after their start tag, so that
+	// {"a": {NAME}} substitutes NAME. Nothing is then
+	// unresolved.
+	KnownOnly bool
+
+	// Unresolved is UnresolvedKeep when empty.
+	Unresolved UnresolvedMode
+
+	// Format, when set, returns the text that replaces
+	// placeholder p of value, e.g. quoted for its
+	// position in a document.
+	Format func(p Placeholder, value string) (string, error)
+}
+
+// Expand substitutes the placeholders of tpl, the
+// content of file, with vars. Values are strings,
+// byte slices, or formatted with fmt.Sprint. In
+// UnresolvedWarn mode placeholders without a value are
+// logged; in UnresolvedStrict mode they give an
+// *UnresolvedError and no output. Errors of Format
+// are returned together, naming their placeholders.
+func (e Expander) Expand(
+	file, tpl string,
+	vars map[string]any,
+) (string, error) {
+	var (
+		b       strings.Builder
+		missing []Placeholder
+		errs    []error
+	)
+
+	e.scan(tpl, vars, func(text string, p *Placeholder, raw string) {
+		b.WriteString(text)
+
+		if p == nil {
+			return
+		}
+
+		v, ok := vars[p.Name]
+		if !ok {
+			missing = append(missing, *p)
+			b.WriteString(raw)
+
+			return
+		}
+
+		var s string
+
+		switch v := v.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			s = fmt.Sprint(v)
+		}
+
+		if e.Format != nil {
+			f, err := e.Format(*p, s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s:%s: %w", file, p, err))
+			}
+
+			s = f
+		}
+
+		b.WriteString(s)
+	})
+
+	if len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+
+	if err := e.Unresolved.report(file, missing); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// scan calls emit with the text of tpl up to each
+// placeholder, escapes applied, the placeholder and
+// its raw text, then with the text after the last
+// placeholder and a nil one.
+func (e Expander) scan(
+	tpl string,
+	vars map[string]any,
+	emit func(text string, p *Placeholder, raw string),
+) {
+	var text strings.Builder
+
+	pos, line, lineStart := 0, 1, 0
+
+	advance := func(to int) {
+		for k := pos; k < to; k++ {
+			if tpl[k] == '\n' {
+				line++
+				lineStart = k + 1
+			}
+		}
+
+		pos = to
+	}
+
+	for {
+		i := strings.Index(tpl[pos:], e.StartTag)
+		if i < 0 {
+			break
+		}
+
+		start := pos + i
+		nameStart := start + len(e.StartTag)
+
+		if e.Escape {
+			n := escapes(tpl[pos:start])
+			text.WriteString(tpl[pos : start-n])
+			text.WriteString(strings.Repeat(string(Escape), n/2))
+
+			if n%2 == 1 {
+				text.WriteString(e.StartTag)
+				advance(nameStart)
+
+				continue
+			}
+		} else {
+			text.WriteString(tpl[pos:start])
+		}
+
+		j := strings.Index(tpl[nameStart:], e.EndTag)
+		if j < 0 {
+			advance(start)
+
+			break
+		}
+
+		name := tpl[nameStart : nameStart+j]
+
+		if _, ok := vars[name]; e.KnownOnly && !ok {
+			text.WriteString(e.StartTag)
+			advance(nameStart)
+
+			continue
+		}
+
+		advance(start)
+
+		p := Placeholder{
+			Name:   name,
+			Line:   line,
+			Column: start - lineStart + 1,
+		}
+		end := nameStart + j + len(e.EndTag)
+
+		emit(text.String(), &p, tpl[start:end])
+		text.Reset()
+		advance(end)
+	}
+
+	text.WriteString(tpl[pos:])
+	emit(text.String(), nil, "")
+}
+
+// escapes returns the number of escape characters that
+// text ends with.
+func escapes(text string) int {
+	n := 0
+	for n < len(text) && text[len(text)-1-n] == Escape {
+		n++
+	}
+
+	return n
+}
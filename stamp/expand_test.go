@@ -0,0 +1,80 @@
+package stamp_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/stamp"
+)
+
+func TestExpander_Expand(t *testing.T) {
+	t.Parallel()
+
+	vars := map[string]any{"A": "1", "B": []byte("2"), "N": 3}
+
+	tests := []struct {
+		name      string
+		tpl       string
+		escape    bool
+		knownOnly bool
+		want      string
+	}{
+		{"substitutes", "{{A}}-{{B}}-{{N}}", false, false, "1-2-3"},
+		{"keeps unknown", "{{A}} {{X}} {{open", false, false, "1 {{X}} {{open"},
+		{"backslash without escape", `\{{A}}`, false, false, `\1`},
+		{"escaped tag", `\{{A}} {{A}}`, true, false, "{{A}} 1"},
+		{"escaped backslash", `\\{{A}}`, true, false, `\1`},
+		{"escaped backslash and tag", `\\\{{A}}`, true, false, `\{{A}}`},
+		{"other backslashes", `a\b \{ {{A}}\`, true, false, `a\b \{ 1\`},
+		{
+			"known only", "{{ .Values.x }} {{{{A}}}} {{X}}",
+			false, true, "{{ .Values.x }} {{1}} {{X}}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := stamp.Expander{
+				StartTag:  "{{",
+				EndTag:    "}}",
+				Escape:    tt.escape,
+				KnownOnly: tt.knownOnly,
+			}.Expand("f", tt.tpl, vars)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpander_Expand_unresolved(t *testing.T) {
+	t.Parallel()
+
+	tpl := "a: {A}\n\\{X} {Y}\n{\"k\": {A}}"
+	vars := map[string]any{"A": "1"}
+
+	e := stamp.Expander{
+		StartTag:   "{",
+		EndTag:     "}",
+		Escape:     true,
+		Unresolved: stamp.UnresolvedStrict,
+	}
+
+	_, err := e.Expand("f.json", tpl, vars)
+	require.Error(t, err)
+	assert.Equal(t,
+		"2 unresolved placeholders:\n"+
+			"f.json:2:6: Y\n"+
+			"f.json:3:1: \"k\": {A",
+		err.Error(),
+	)
+
+	e.KnownOnly = true
+
+	got, err := e.Expand("f.json", tpl, vars)
+	require.NoError(t, err)
+	assert.Equal(t, "a: 1\n{X} {Y}\n{\"k\": 1}", got)
+}
@@ -0,0 +1,152 @@
+package stamp
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// UnresolvedMode selects what happens to placeholders
+// of a template without a value.
+type UnresolvedMode string
+
+const (
+	// UnresolvedKeep leaves unresolved placeholders in
+	// the output as-is.
+	UnresolvedKeep UnresolvedMode = "keep"
+
+	// UnresolvedWarn keeps them and logs a warning for
+	// each.
+	UnresolvedWarn UnresolvedMode = "warn"
+
+	// UnresolvedStrict fails with an UnresolvedError
+	// listing all of them.
+	UnresolvedStrict UnresolvedMode = "strict"
+)
+
+// ParseUnresolvedMode returns the mode named name. The
+// empty string means UnresolvedKeep.
+func ParseUnresolvedMode(name string) (UnresolvedMode, error) {
+	switch m := UnresolvedMode(name); m {
+	case "":
+		return UnresolvedKeep, nil
+	case UnresolvedKeep, UnresolvedWarn, UnresolvedStrict:
+		return m, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown unresolved placeholder mode %q "+
+				"(expected %s, %s or %s)",
+			name, UnresolvedKeep, UnresolvedWarn, UnresolvedStrict,
+		)
+	}
+}
+
+// Placeholder is a tag of a template.
+type Placeholder struct {
+	// Name is the text between the tags.
+	Name string
+
+	// Line and Column are 1-based; Column counts
+	// bytes and points at the start tag.
+	Line   int
+	Column int
+}
+
+// String formats the placeholder as "line:column: name".
+func (p Placeholder) String() string {
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Name)
+}
+
+// Placeholders returns the tags of tpl between
+// startTag and endTag, in order, parsed as fasttemplate
+// does: an unterminated start tag ends the template.
+func Placeholders(tpl, startTag, endTag string) []Placeholder {
+	var found []Placeholder
+
+	e := Expander{StartTag: startTag, EndTag: endTag}
+	e.scan(tpl, nil, func(_ string, p *Placeholder, _ string) {
+		if p != nil {
+			found = append(found, *p)
+		}
+	})
+
+	return found
+}
+
+// Unresolved returns the placeholders of tpl without
+// a value in vars.
+func Unresolved(
+	tpl, startTag, endTag string,
+	vars map[string]any,
+) []Placeholder {
+	var missing []Placeholder
+
+	for _, p := range Placeholders(tpl, startTag, endTag) {
+		if _, ok := vars[p.Name]; !ok {
+			missing = append(missing, p)
+		}
+	}
+
+	return missing
+}
+
+// UnresolvedError lists the unresolved placeholders of
+// a file in strict mode.
+type UnresolvedError struct {
+	File         string
+	Placeholders []Placeholder
+}
+
+// Error implements error.
+func (e *UnresolvedError) Error() string {
+	lines := make([]string, 0, len(e.Placeholders))
+	for _, p := range e.Placeholders {
+		lines = append(lines, e.File+":"+p.String())
+	}
+
+	return fmt.Sprintf(
+		"%d unresolved placeholders:\n%s",
+		len(e.Placeholders), strings.Join(lines, "\n"),
+	)
+}
+
+// Check applies m to the placeholders of tpl, the
+// content of file, without a value in vars: it logs
+// them in UnresolvedWarn mode and returns an
+// UnresolvedError in UnresolvedStrict mode.
+func (m UnresolvedMode) Check(
+	file, tpl, startTag, endTag string,
+	vars map[string]any,
+) error {
+	if m == "" || m == UnresolvedKeep {
+		return nil
+	}
+
+	return m.report(
+		file, Unresolved(tpl, startTag, endTag, vars),
+	)
+}
+
+// report applies m to the placeholders of file
+// without a value.
+func (m UnresolvedMode) report(file string, missing []Placeholder) error {
+	if len(missing) == 0 || m == "" || m == UnresolvedKeep {
+		return nil
+	}
+
+	if m == UnresolvedStrict {
+		return &UnresolvedError{File: file, Placeholders: missing}
+	}
+
+	for _, p := range missing {
+		slog.Warn(
+			"unresolved placeholder",
+			"file", file,
+			"line", p.Line,
+			"column", p.Column,
+			"name", p.Name,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package stamp_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/stamp"
+)
+
+func TestPlaceholders(t *testing.T) {
+	t.Parallel()
+
+	tpl := "a: {{A}}\nb: x {{B}} {{A}}\n{{multi\nline}} {{C}}\n{{open"
+
+	assert.Equal(t, []stamp.Placeholder{
+		{Name: "A", Line: 1, Column: 4},
+		{Name: "B", Line: 2, Column: 6},
+		{Name: "A", Line: 2, Column: 12},
+		{Name: "multi\nline", Line: 3, Column: 1},
+		{Name: "C", Line: 4, Column: 8},
+	}, stamp.Placeholders(tpl, "{{", "}}"))
+
+	assert.Equal(t, []stamp.Placeholder{
+		{Name: "B", Line: 2, Column: 6},
+		{Name: "C", Line: 4, Column: 8},
+	}, stamp.Unresolved(tpl, "{{", "}}", map[string]any{
+		"A": "1", "multi\nline": "",
+	}))
+}
+
+func TestUnresolvedMode_Check(t *testing.T) {
+	t.Parallel()
+
+	tpl := "image: {IMAGE}\ntag: {TAG}\n"
+	vars := map[string]any{"IMAGE": "nginx"}
+
+	for _, m := range []stamp.UnresolvedMode{
+		"", stamp.UnresolvedKeep, stamp.UnresolvedWarn,
+	} {
+		require.NoError(t, m.Check("f.yaml", tpl, "{", "}", vars), m)
+	}
+
+	err := stamp.UnresolvedStrict.Check("f.yaml", tpl, "{", "}", vars)
+
+	var ue *stamp.UnresolvedError
+	require.True(t, errors.As(err, &ue))
+	assert.Equal(t, []stamp.Placeholder{
+		{Name: "TAG", Line: 2, Column: 6},
+	}, ue.Placeholders)
+	assert.Contains(t, err.Error(), "f.yaml:2:6: TAG")
+
+	_, err = stamp.ParseUnresolvedMode("fail")
+	require.Error(t, err)
+}
@@ -0,0 +1,229 @@
+package stamp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	json "github.com/goccy/go-json"
+)
+
+// Stamps are the variables of workspace status files
+// by name.
+type Stamps map[string]string
+
+// Context returns the stamps as the variable map of
+// fasttemplate.
+func (s Stamps) Context() map[string]any {
+	ctx := make(map[string]any, len(s))
+	for k, v := range s {
+		ctx[k] = v
+	}
+
+	return ctx
+}
+
+// Keys returns the names of the stamps, sorted.
+func (s Stamps) Keys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Mode selects how a Loader handles malformed input.
+type Mode string
+
+const (
+	// Lenient skips malformed lines and lets later
+	// files override earlier ones, as Bazel does.
+	Lenient Mode = "lenient"
+
+	// Strict fails on malformed lines and on keys that
+	// files set to different values.
+	Strict Mode = "strict"
+)
+
+// ParseMode returns the mode named name. The empty
+// string means Lenient.
+func ParseMode(name string) (Mode, error) {
+	switch m := Mode(name); m {
+	case "":
+		return Lenient, nil
+	case Lenient, Strict:
+		return m, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown stamp mode %q (expected %s or %s)",
+			name, Lenient, Strict,
+		)
+	}
+}
+
+// Conflict is a key that two files set to different
+// values. The later value wins in Lenient mode.
+type Conflict struct {
+	Key string
+
+	// File and Value are the earlier definition.
+	File  string
+	Value string
+
+	// OverrideFile and OverrideValue are the later
+	// definition.
+	OverrideFile  string
+	OverrideValue string
+}
+
+// String describes the conflict for warnings.
+func (c Conflict) String() string {
+	return fmt.Sprintf(
+		"%s: %q from %s overrides %q from %s",
+		c.Key, c.OverrideValue, c.OverrideFile, c.Value, c.File,
+	)
+}
+
+// Loader reads workspace status files: the "KEY VALUE"
+// lines written by Bazel from the output of
+// --workspace_status_command, the key ending at the
+// first space, or a JSON object of scalar values.
+// Trailing carriage returns and blank lines are
+// ignored.
+type Loader struct {
+	// Mode is Lenient when empty.
+	Mode Mode
+}
+
+// Load reads files in order into Stamps and returns
+// the keys that later files set to different values.
+func (l Loader) Load(files ...string) (Stamps, []Conflict, error) {
+	const errCtx = "loading stamps"
+
+	stamps := make(Stamps)
+	from := make(map[string]string)
+
+	var conflicts []Conflict
+
+	for _, f := range files {
+		data, err := os.ReadFile(f) //nolint:gosec // paths from CLI flags
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		vars, err := l.parse(data, f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
+
+		for _, kv := range vars {
+			if prev, ok := stamps[kv[0]]; ok && prev != kv[1] {
+				c := Conflict{
+					Key:           kv[0],
+					File:          from[kv[0]],
+					Value:         prev,
+					OverrideFile:  f,
+					OverrideValue: kv[1],
+				}
+
+				if l.Mode == Strict {
+					return nil, nil, fmt.Errorf(
+						"%s: conflicting values of %s",
+						errCtx, c,
+					)
+				}
+
+				conflicts = append(conflicts, c)
+			}
+
+			stamps[kv[0]] = kv[1]
+			from[kv[0]] = f
+		}
+	}
+
+	return stamps, conflicts, nil
+}
+
+// Load reads files in Lenient mode, ignoring
+// conflicts.
+func Load(files ...string) (Stamps, error) {
+	stamps, _, err := Loader{}.Load(files...)
+
+	return stamps, err
+}
+
+// parse returns the key-value pairs of the content of
+// file, in order.
+func (l Loader) parse(data []byte, file string) ([][2]string, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseJSON(data, file)
+	}
+
+	var vars [][2]string
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, " ")
+		if !ok || key == "" {
+			if l.Mode == Strict {
+				return nil, fmt.Errorf(
+					"%s:%d: expected \"KEY VALUE\", got %q",
+					file, n+1, line,
+				)
+			}
+
+			continue
+		}
+
+		vars = append(vars, [2]string{key, value})
+	}
+
+	return vars, nil
+}
+
+// parseJSON returns the members of a JSON object,
+// sorted by key. Strings are taken as is and other
+// scalars as their JSON text.
+func parseJSON(data []byte, file string) ([][2]string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	vars := make([][2]string, 0, len(obj))
+
+	for key, raw := range obj {
+		raw = bytes.TrimSpace(raw)
+
+		var value string
+
+		switch {
+		case len(raw) > 0 && raw[0] == '"':
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", file, key, err)
+			}
+		case len(raw) > 0 && (raw[0] == '{' || raw[0] == '['):
+			return nil, fmt.Errorf(
+				"%s: %s: expected a scalar value", file, key,
+			)
+		case string(raw) == "null":
+		default:
+			value = string(raw)
+		}
+
+		vars = append(vars, [2]string{key, value})
+	}
+
+	sort.Slice(vars, func(i, j int) bool { return vars[i][0] < vars[j][0] })
+
+	return vars, nil
+}
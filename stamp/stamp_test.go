@@ -0,0 +1,137 @@
+package stamp_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/stamp"
+)
+
+// writeTemp creates a temporary file with content and
+// returns its path.
+func writeTemp(
+	tb testing.TB,
+	name string,
+	content string,
+) string {
+	tb.Helper()
+
+	pa := filepath.Join(tb.TempDir(), name)
+	require.NoError(
+		tb,
+		os.WriteFile(pa, []byte(content), 0o600),
+	)
+
+	return pa
+}
+
+func TestLoader_Load(t *testing.T) {
+	t.Parallel()
+
+	stable := writeTemp(
+		t, "stable-status.txt",
+		"STABLE_GIT_COMMIT abc123\r\n"+
+			"STABLE_MSG hello world\r\n"+
+			"BADLINE\r\n\r\n"+
+			"STABLE_EMPTY \r\n",
+	)
+	volatile := writeTemp(
+		t, "volatile-status.txt",
+		"BUILD_TIMESTAMP 1700000000\nSTABLE_MSG overridden\n",
+	)
+
+	stamps, conflicts, err := stamp.Loader{}.Load(stable, volatile)
+	require.NoError(t, err)
+
+	assert.Equal(t, stamp.Stamps{
+		"STABLE_GIT_COMMIT": "abc123",
+		"STABLE_MSG":        "overridden",
+		"STABLE_EMPTY":      "",
+		"BUILD_TIMESTAMP":   "1700000000",
+	}, stamps)
+	assert.Equal(t, []stamp.Conflict{{
+		Key:           "STABLE_MSG",
+		File:          stable,
+		Value:         "hello world",
+		OverrideFile:  volatile,
+		OverrideValue: "overridden",
+	}}, conflicts)
+	assert.Equal(t, []string{
+		"BUILD_TIMESTAMP", "STABLE_EMPTY", "STABLE_GIT_COMMIT", "STABLE_MSG",
+	}, stamps.Keys())
+}
+
+func TestLoader_Load_strict(t *testing.T) {
+	t.Parallel()
+
+	strict := stamp.Loader{Mode: stamp.Strict}
+
+	malformed := writeTemp(t, "status.txt", "GOOD value\nBADLINE\n")
+
+	_, _, err := strict.Load(malformed)
+	require.ErrorContains(t, err, "status.txt:2")
+
+	a := writeTemp(t, "a.txt", "KEY one\nSAME x\n")
+	b := writeTemp(t, "b.txt", "KEY two\nSAME x\n")
+
+	_, _, err = strict.Load(a, b)
+	require.ErrorContains(t, err, "conflicting values of KEY")
+
+	c := writeTemp(t, "c.txt", "SAME x\n")
+
+	stamps, conflicts, err := strict.Load(a, c)
+	require.NoError(t, err, "equal values do not conflict")
+	assert.Empty(t, conflicts)
+	assert.Equal(t, "x", stamps["SAME"])
+}
+
+func TestLoader_Load_json(t *testing.T) {
+	t.Parallel()
+
+	f := writeTemp(
+		t, "status.json",
+		`{"STABLE_GIT_COMMIT": "abc123", "BUILD_NUMBER": 42, `+
+			`"DIRTY": false, "UNSET": null, "MSG": "a \"b\""}`,
+	)
+
+	stamps, err := stamp.Load(f)
+	require.NoError(t, err)
+	assert.Equal(t, stamp.Stamps{
+		"STABLE_GIT_COMMIT": "abc123",
+		"BUILD_NUMBER":      "42",
+		"DIRTY":             "false",
+		"UNSET":             "",
+		"MSG":               `a "b"`,
+	}, stamps)
+
+	nested := writeTemp(t, "nested.json", `{"A": {"B": "c"}}`)
+
+	_, err = stamp.Load(nested)
+	require.ErrorContains(t, err, "expected a scalar value")
+}
+
+func TestLoad_missing_file(t *testing.T) {
+	t.Parallel()
+
+	_, err := stamp.Load("/nonexistent/file.txt")
+	require.ErrorContains(t, err, "loading stamps")
+}
+
+func TestParseMode(t *testing.T) {
+	t.Parallel()
+
+	m, err := stamp.ParseMode("")
+	require.NoError(t, err)
+	assert.Equal(t, stamp.Lenient, m)
+
+	m, err = stamp.ParseMode("strict")
+	require.NoError(t, err)
+	assert.Equal(t, stamp.Strict, m)
+
+	_, err = stamp.ParseMode("loose")
+	require.Error(t, err)
+}
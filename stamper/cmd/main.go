@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/byte4ever/rules_gitops/stamp"
 	"github.com/byte4ever/rules_gitops/stamper"
 )
 
@@ -32,6 +33,10 @@ func run() error {
 		output     string
 		format     string
 		formatFile string
+		stampMode  string
+		unresolved string
+		escape     bool
+		knownOnly  bool
 	)
 
 	flag.Var(
@@ -55,8 +60,41 @@ func run() error {
 		"format string containing stamp variables",
 	)
 
+	flag.StringVar(
+		&stampMode, "stamp-mode", string(stamp.Lenient),
+		"lenient skips malformed status lines; strict "+
+			"rejects them and conflicting values",
+	)
+
+	flag.StringVar(
+		&unresolved, "unresolved", string(stamp.UnresolvedKeep),
+		"unknown {VAR} placeholders: keep them, warn "+
+			"about each, or strict to fail listing them",
+	)
+
+	flag.BoolVar(
+		&escape, "escape", false,
+		"treat \\{ as a literal {",
+	)
+
+	flag.BoolVar(
+		&knownOnly, "known-only", false,
+		"substitute only {VAR} naming a stamp and leave "+
+			"other brace sequences untouched",
+	)
+
 	flag.Parse()
 
+	mode, err := stamp.ParseMode(stampMode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	unresolvedMode, err := stamp.ParseUnresolvedMode(unresolved)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
 	if formatFile != "" && format != "" {
 		return fmt.Errorf(
 			"%s: only one of --format or"+
@@ -65,7 +103,11 @@ func run() error {
 		)
 	}
 
+	name := "--format"
+
 	if formatFile != "" {
+		name = formatFile
+
 		content, err := os.ReadFile( //nolint:gosec // path from CLI flag
 			formatFile,
 		)
@@ -79,7 +121,24 @@ func run() error {
 		format = string(content)
 	}
 
-	result, err := stamper.Stamp(stampInfoFiles, format)
+	stamps, conflicts, err := stamp.Loader{Mode: mode}.Load(
+		stampInfoFiles...,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	for _, c := range conflicts {
+		slog.Warn("conflicting stamp", "conflict", c.String())
+	}
+
+	f := stamper.Formatter{
+		Unresolved: unresolvedMode,
+		Escape:     escape,
+		KnownOnly:  knownOnly,
+	}
+
+	result, err := f.Format(name, stamps, format)
 	if err != nil {
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
@@ -1,5 +1,7 @@
 // Package stamper reads Bazel workspace status files and substitutes
 // single-brace {VAR} placeholders in format strings. LoadStamps parses one or
-// more status files into a variable map; Stamp combines loading and
-// substitution in a single call.
+// more status files into a variable map with the stamp package; a Formatter
+// substitutes stamps and Stamp combines loading and substitution in a single
+// call. A Formatter can also escape literal braces as \{ and substitute only
+// the placeholders that name a stamp.
 package stamper
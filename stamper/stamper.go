@@ -2,42 +2,22 @@ package stamper
 
 import (
 	"fmt"
-	"os"
-	"strings"
 
-	"github.com/valyala/fasttemplate"
+	"github.com/byte4ever/rules_gitops/stamp"
 )
 
 // LoadStamps reads workspace status files and merges them
-// into a single map. Each line is "KEY VALUE" with the
-// first space as delimiter. Lines without a space are
-// silently skipped.
+// into a single map with stamp.Load: later files override
+// earlier ones and malformed lines are skipped.
 func LoadStamps(
 	infoFiles []string,
 ) (map[string]interface{}, error) {
-	const errCtx = "loading stamps"
-
-	stamps := make(map[string]interface{})
-
-	for _, sf := range infoFiles {
-		content, err := os.ReadFile(sf) //nolint:gosec // paths from CLI flags
-		if err != nil {
-			return nil, fmt.Errorf(
-				"%s: %w", errCtx, err,
-			)
-		}
-
-		for _, line := range strings.Split(
-			string(content), "\n",
-		) {
-			parts := strings.SplitN(line, " ", 2)
-			if len(parts) == 2 {
-				stamps[parts[0]] = parts[1]
-			}
-		}
+	stamps, err := stamp.Load(infoFiles...)
+	if err != nil {
+		return nil, err
 	}
 
-	return stamps, nil
+	return stamps.Context(), nil
 }
 
 // Stamp loads workspace status variables from infoFiles
@@ -49,16 +29,60 @@ func Stamp(
 ) (string, error) {
 	const errCtx = "stamping"
 
-	stamps, err := LoadStamps(infoFiles)
+	stamps, err := stamp.Load(infoFiles...)
 	if err != nil {
 		return "", fmt.Errorf(
 			"%s: %w", errCtx, err,
 		)
 	}
 
-	result := fasttemplate.ExecuteStringStd(
-		format, "{", "}", stamps,
-	)
+	// The zero Formatter keeps unknown variables and
+	// cannot fail.
+	result, _ := Formatter{}.Format("", stamps, format)
+
+	return result, nil
+}
+
+// Formatter substitutes {VAR} placeholders with stamps,
+// handling unknown variables according to Unresolved.
+type Formatter struct {
+	// Unresolved is stamp.UnresolvedKeep when empty.
+	Unresolved stamp.UnresolvedMode
+
+	// Escape makes \{ a literal {, e.g. \{NAME} gives
+	// {NAME}.
+	Escape bool
+
+	// KnownOnly substitutes only the placeholders
+	// naming a stamp and leaves other brace sequences,
+	// such as JSON objects, untouched.
+	KnownOnly bool
+}
+
+// Format substitutes the {VAR} placeholders of format,
+// the content of the file name, with stamps. In
+// stamp.UnresolvedStrict mode it fails with a
+// *stamp.UnresolvedError listing the line and column
+// of every unknown variable.
+func (f Formatter) Format(
+	name string,
+	stamps stamp.Stamps,
+	format string,
+) (string, error) {
+	const errCtx = "formatting stamps"
+
+	e := stamp.Expander{
+		StartTag:   "{",
+		EndTag:     "}",
+		Escape:     f.Escape,
+		KnownOnly:  f.KnownOnly,
+		Unresolved: f.Unresolved,
+	}
+
+	result, err := e.Expand(name, format, stamps.Context())
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errCtx, err)
+	}
 
 	return result, nil
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/byte4ever/rules_gitops/stamp"
 	"github.com/byte4ever/rules_gitops/stamper"
 )
 
@@ -224,6 +225,30 @@ func TestLoadStamps_missing_file(t *testing.T) {
 	assert.Contains(t, err.Error(), "loading stamps")
 }
 
+func TestFormatter_Format(t *testing.T) {
+	t.Parallel()
+
+	stamps := stamp.Stamps{"BUILD_USER": "alice"}
+	format := "by {BUILD_USER}\nat {GIT_SHA}"
+
+	got, err := stamper.Formatter{}.Format("f.txt", stamps, format)
+	require.NoError(t, err)
+	assert.Equal(t, "by alice\nat {GIT_SHA}", got)
+
+	_, err = stamper.Formatter{
+		Unresolved: stamp.UnresolvedStrict,
+	}.Format("f.txt", stamps, format)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "f.txt:2:4: GIT_SHA")
+
+	got, err = stamper.Formatter{
+		Escape:    true,
+		KnownOnly: true,
+	}.Format("f.json", stamps, `{"user": {BUILD_USER}, "t": "\{BUILD_USER}"}`)
+	require.NoError(t, err)
+	assert.Equal(t, `{"user": alice, "t": "{BUILD_USER}"}`, got)
+}
+
 func FuzzStamp(f *testing.F) {
 	f.Add("Hello {name}!", "name", "World")
 	f.Add("{a}{b}", "a", "x")
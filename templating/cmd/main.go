@@ -5,7 +5,11 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 
+	json "github.com/goccy/go-json"
+
+	"github.com/byte4ever/rules_gitops/stamp"
 	"github.com/byte4ever/rules_gitops/templating"
 )
 
@@ -24,12 +28,21 @@ func main() {
 	var (
 		stampInfoFile arrayFlags
 		variable      arrayFlags
+		variableFile  arrayFlags
 		imports       arrayFlags
 		output        string
 		tpl           string
 		executable    bool
 		startTag      string
 		endTag        string
+		stampMode     string
+		unresolved    string
+		syntax        string
+		escape        bool
+		knownOnly     bool
+		yamlValues    bool
+		yamlTyped     arrayFlags
+		printContext  bool
 	)
 
 	flag.Var(
@@ -44,6 +57,13 @@ func main() {
 		"Variable in NAME=VALUE format (repeatable)",
 	)
 
+	flag.Var(
+		&variableFile,
+		"variable_file",
+		"YAML, JSON or .env file of variables, nested keys "+
+			"flattened to dotted names (repeatable)",
+	)
+
 	flag.Var(
 		&imports,
 		"imports",
@@ -75,12 +95,103 @@ func main() {
 		"End tag for template placeholders",
 	)
 
+	flag.StringVar(
+		&stampMode, "stamp_mode", string(stamp.Lenient),
+		"lenient skips malformed stamp info lines; strict "+
+			"rejects them and conflicting values",
+	)
+
+	flag.StringVar(
+		&unresolved, "unresolved", string(stamp.UnresolvedKeep),
+		"Placeholders without a value: keep them, warn "+
+			"about each, or strict to fail listing them",
+	)
+
+	flag.StringVar(
+		&syntax, "syntax", string(templating.SyntaxFlat),
+		"Template language: flat {{NAME}} substitution, or "+
+			"rich text/template with defaults, functions, if and range",
+	)
+
+	flag.BoolVar(
+		&escape, "escape", false,
+		"Treat a backslash before the start tag as a "+
+			"literal start tag",
+	)
+
+	flag.BoolVar(
+		&knownOnly, "known_only", false,
+		"Substitute only tags naming a variable, import "+
+			"or stamp and leave other tags untouched",
+	)
+
+	flag.BoolVar(
+		&yamlValues, "yaml", false,
+		"Quote, escape and indent values for their position "+
+			"in the YAML template",
+	)
+
+	flag.Var(
+		&yamlTyped,
+		"yaml_typed",
+		"With --yaml, placeholder whose value is written "+
+			"unquoted when it is a number, boolean or null "+
+			"(repeatable)",
+	)
+
+	flag.BoolVar(
+		&printContext, "print_context", false,
+		"Print the variables of the template context with "+
+			"their sources as JSON instead of expanding",
+	)
+
 	flag.Parse()
 
+	syntaxMode, err := templating.ParseSyntax(syntax)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mode, err := stamp.ParseMode(stampMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	unresolvedMode, err := stamp.ParseUnresolvedMode(unresolved)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	en := templating.Engine{
 		StartTag:       startTag,
 		EndTag:         endTag,
 		StampInfoFiles: stampInfoFile,
+		StampMode:      mode,
+		Unresolved:     unresolvedMode,
+		Syntax:         syntaxMode,
+		Escape:         escape,
+		KnownOnly:      knownOnly,
+		YAML:           yamlValues,
+		YAMLTyped:      yamlTyped,
+		VariableFiles:  variableFile,
+	}
+
+	if printContext {
+		variables, err := en.Context(variable, imports)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		data, err := json.MarshalIndent(variables, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if _, err := os.Stdout.Write(append(data, '\n')); err != nil {
+			log.Fatal(err)
+		}
+
+		return
 	}
 
 	if err := en.Expand(
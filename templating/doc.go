@@ -2,7 +2,15 @@
 // variables from stamp info files and explicit key-value pairs. It uses
 // valyala/fasttemplate with configurable delimiters (default "{{" and "}}").
 //
-// The Engine type holds configuration (start/end tags, stamp info files) and
-// expands templates via the Expand method, which reads a template file,
-// applies variable substitution and import expansion, and writes the result.
+// The Engine type holds configuration (start/end tags, stamp info files and
+// their stamp.Mode) and expands templates via the Expand method, which reads
+// a template file, applies variable substitution and import expansion, and
+// writes the result. SyntaxRich expands text/template templates instead,
+// with default values, a small function set and list and map variables.
+// Escape makes \{{ a literal start tag and KnownOnly leaves tags that name no
+// variable untouched, for manifests holding other templates. YAML quotes,
+// escapes and indents every value for the position of its placeholder in a
+// YAML template, turning multi-line values into block scalars. VariableFiles
+// adds the flattened variables of YAML, JSON and .env files, which --variable
+// flags override, and Context lists every variable with its source.
 package templating
@@ -1,12 +1,17 @@
 package templating
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/valyala/fasttemplate"
+
+	"github.com/byte4ever/rules_gitops/stamp"
 )
 
 // Engine expands templates using stamp info files and
@@ -15,6 +20,66 @@ type Engine struct {
 	StartTag       string
 	EndTag         string
 	StampInfoFiles []string
+
+	// VariableFiles are YAML, JSON or .env files of
+	// variables, later files overriding earlier ones.
+	// Nested keys are flattened to dotted names.
+	VariableFiles []string
+
+	// StampMode selects how stamp info files are
+	// parsed; empty means stamp.Lenient.
+	StampMode stamp.Mode
+
+	// Unresolved selects what happens to placeholders
+	// of the template and imports without a value;
+	// empty means stamp.UnresolvedKeep.
+	Unresolved stamp.UnresolvedMode
+
+	// Syntax selects the template language of the
+	// template and imports; empty means SyntaxFlat.
+	Syntax Syntax
+
+	// Escape makes a backslash before StartTag emit the
+	// start tag literally in the template and imports,
+	// e.g. \{{ .Values.x }} gives {{ .Values.x }}.
+	Escape bool
+
+	// KnownOnly substitutes only the placeholders
+	// naming a variable, import or stamp and leaves
+	// other tags untouched, such as those of Helm or
+	// Prometheus templates. It requires SyntaxFlat.
+	KnownOnly bool
+
+	// YAML injects the values of the template, a YAML
+	// document, for the position of their placeholder:
+	// quoted or escaped in scalars, as block scalars
+	// when they span lines, and indented on lines of
+	// their own. It requires SyntaxFlat.
+	YAML bool
+
+	// YAMLTyped names the placeholders whose whole
+	// values YAML writes unquoted when they read back
+	// as a number, boolean or null, e.g. a replica
+	// count. Other values are quoted unless they read
+	// back as the same string, so "1.10" stays a
+	// string.
+	YAMLTyped []string
+}
+
+// Sources of the variables of the context that are
+// not files.
+const (
+	sourceStamps   = "stamps"
+	sourceVariable = "--variable"
+)
+
+// Variable is a variable of the context of a template
+// and the source that set it: "stamps", a variable
+// file, "--variable" or an import file.
+type Variable struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
 }
 
 // Expand reads a template, substitutes variables, and
@@ -24,14 +89,23 @@ type Engine struct {
 //
 // Processing order mirrors the original algorithm:
 //  1. Load stamp files into a stamp map.
-//  2. For each variable NAME=VALUE, expand VALUE against
-//     stamps using single-brace tags, then store as both
+//  2. For each variable of the VariableFiles, then each
+//     variable NAME=VALUE, expand VALUE against stamps
+//     using single-brace tags, then store as both
 //     "NAME" and "variables.NAME" in context.
 //  3. For each import NAME=filename, read the file, expand
 //     it against context with the configured tags, then
 //     expand again against stamps with single-brace tags,
 //     and store as "imports.NAME" in context.
 //  4. Expand the template against context.
+//
+// Later steps override earlier ones, so --variable
+// flags take precedence over variable files, which take
+// precedence over stamps.
+//
+// In stamp.UnresolvedStrict mode nothing is written when
+// the template or an import has placeholders without a
+// value; the error lists all of them.
 func (en *Engine) Expand(
 	tplPath string,
 	outPath string,
@@ -41,32 +115,42 @@ func (en *Engine) Expand(
 ) error {
 	const errCtx = "expanding template"
 
-	stamps, err := en.loadStamps()
-	if err != nil {
-		return fmt.Errorf("%s: %w", errCtx, err)
+	if (en.KnownOnly || en.YAML) && en.Syntax == SyntaxRich {
+		return fmt.Errorf(
+			"%s: known-only and YAML expansion require "+
+				"the %s syntax",
+			errCtx, SyntaxFlat,
+		)
 	}
 
-	// Stamps form the base context; variables and
-	// imports override them.
-	ctx := make(map[string]interface{})
-	for key, val := range stamps {
-		ctx[key] = val
+	ctx, _, unresolved, err := en.context(vars, imports)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
-	if err := en.resolveVars(vars, stamps, ctx); err != nil {
+	tplContent, err := en.readTemplate(tplPath)
+	if err != nil {
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
-	if err := en.resolveImports(imports, stamps, ctx); err != nil {
-		return fmt.Errorf("%s: %w", errCtx, err)
+	tplName := tplPath
+	if tplName == "" {
+		tplName = "<stdin>"
 	}
 
-	tplContent, err := en.readTemplate(tplPath)
-	if err != nil {
+	result, err := en.expand(tplName, string(tplContent), ctx, en.YAML)
+	switch {
+	case isUnresolved(err):
+		unresolved = append(unresolved, err)
+	case err != nil:
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
-	startTag, endTag := en.tags()
+	if len(unresolved) > 0 {
+		return fmt.Errorf(
+			"%s: %w", errCtx, errors.Join(unresolved...),
+		)
+	}
 
 	out, closer, err := en.openOutput(outPath, executable)
 	if err != nil {
@@ -77,16 +161,148 @@ func (en *Engine) Expand(
 		defer closer()
 	}
 
-	_, err = fasttemplate.ExecuteStd(
-		string(tplContent), startTag, endTag, out, ctx,
-	)
-	if err != nil {
+	if _, err := io.WriteString(out, result); err != nil {
 		return fmt.Errorf("%s: %w", errCtx, err)
 	}
 
 	return nil
 }
 
+// Context returns the variables that Expand substitutes
+// in templates with vars and imports, sorted by name.
+// In stamp.UnresolvedStrict mode imports with
+// placeholders without a value are errors.
+func (en *Engine) Context(
+	vars []string,
+	imports []string,
+) ([]Variable, error) {
+	const errCtx = "creating template context"
+
+	ctx, sources, unresolved, err := en.context(vars, imports)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	if len(unresolved) > 0 {
+		return nil, fmt.Errorf(
+			"%s: %w", errCtx, errors.Join(unresolved...),
+		)
+	}
+
+	names := make([]string, 0, len(ctx))
+	for name := range ctx {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	variables := make([]Variable, 0, len(names))
+	for _, name := range names {
+		variables = append(variables, Variable{
+			Name:   name,
+			Value:  fmt.Sprint(ctx[name]),
+			Source: sources[name],
+		})
+	}
+
+	return variables, nil
+}
+
+// context returns the context of templates, the source
+// of each of its variables and the unresolved
+// placeholder errors of the imports.
+func (en *Engine) context(
+	vars []string,
+	imports []string,
+) (map[string]interface{}, map[string]string, []error, error) {
+	stamps, err := en.loadStamps()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Stamps form the base context; variables and
+	// imports override them.
+	ctx := make(map[string]interface{})
+	sources := make(map[string]string)
+
+	for key, val := range stamps {
+		ctx[key] = val
+		sources[key] = sourceStamps
+	}
+
+	if err := en.resolveVariableFiles(stamps, ctx, sources); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := en.resolveVars(vars, stamps, ctx, sources); err != nil {
+		return nil, nil, nil, err
+	}
+
+	unresolved, err := en.resolveImports(imports, stamps, ctx, sources)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return ctx, sources, unresolved, nil
+}
+
+// expand expands content, the file name, against ctx
+// with the configured tags and syntax, injecting values
+// as YAML when yaml is set. In stamp.UnresolvedStrict
+// mode placeholders without a value give a
+// *stamp.UnresolvedError.
+func (en *Engine) expand(
+	name string,
+	content string,
+	ctx map[string]interface{},
+	yaml bool,
+) (string, error) {
+	startTag, endTag := en.tags()
+
+	if en.Syntax == SyntaxRich {
+		return renderRich(
+			name, content, startTag, endTag, ctx,
+			en.Unresolved, en.Escape,
+		)
+	}
+
+	e := stamp.Expander{
+		StartTag:   startTag,
+		EndTag:     endTag,
+		Escape:     en.Escape,
+		KnownOnly:  en.KnownOnly,
+		Unresolved: en.Unresolved,
+	}
+
+	if yaml {
+		e.Format = newYAMLInjector(
+			content, startTag, endTag, en.YAMLTyped,
+		).format
+	}
+
+	return e.Expand(name, content, ctx)
+}
+
+// expandStamps expands s against stamps with
+// single-brace tags, honouring KnownOnly.
+func (en *Engine) expandStamps(
+	s string,
+	stamps map[string]interface{},
+) string {
+	if !en.KnownOnly {
+		return fasttemplate.ExecuteStringStd(s, "{", "}", stamps)
+	}
+
+	//nolint:errcheck // no errors in stamp.UnresolvedKeep mode
+	out, _ := stamp.Expander{
+		StartTag:  "{",
+		EndTag:    "}",
+		KnownOnly: true,
+	}.Expand("", s, stamps)
+
+	return out
+}
+
 // tags returns the configured start/end tags, falling
 // back to double-brace defaults.
 func (en *Engine) tags() (string, string) {
@@ -104,34 +320,61 @@ func (en *Engine) tags() (string, string) {
 }
 
 // loadStamps reads all stamp info files and merges them
-// into a single map. Each line is "KEY VALUE" with the
-// first space as delimiter.
+// into a single map with a stamp.Loader, warning about
+// keys that later files override.
 func (en *Engine) loadStamps() (
 	map[string]interface{}, error,
 ) {
-	const errCtx = "loading stamps"
+	stamps, conflicts, err := stamp.Loader{Mode: en.StampMode}.Load(
+		en.StampInfoFiles...,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	stamps := make(map[string]interface{})
+	for _, c := range conflicts {
+		slog.Warn("conflicting stamp", "conflict", c.String())
+	}
 
-	for _, sf := range en.StampInfoFiles {
-		content, err := os.ReadFile(sf) //nolint:gosec // paths from CLI flags
+	return stamps.Context(), nil
+}
+
+// resolveVariableFiles loads the VariableFiles in
+// order and sets their variables as resolveVars does.
+func (en *Engine) resolveVariableFiles(
+	stamps map[string]interface{},
+	ctx map[string]interface{},
+	sources map[string]string,
+) error {
+	for _, f := range en.VariableFiles {
+		vars, err := loadVariableFile(f)
 		if err != nil {
-			return nil, fmt.Errorf(
-				"%s: %w", errCtx, err,
-			)
+			return err
 		}
 
-		for _, line := range strings.Split(
-			string(content), "\n",
-		) {
-			parts := strings.SplitN(line, " ", 2)
-			if len(parts) == 2 {
-				stamps[parts[0]] = parts[1]
-			}
+		for _, kv := range vars {
+			en.setVar(kv[0], kv[1], f, stamps, ctx, sources)
 		}
 	}
 
-	return stamps, nil
+	return nil
+}
+
+// setVar expands value against stamps using
+// single-brace tags, then stores it as both "name" and
+// "variables.name", set by source.
+func (en *Engine) setVar(
+	name, value, source string,
+	stamps map[string]interface{},
+	ctx map[string]interface{},
+	sources map[string]string,
+) {
+	val := en.expandStamps(value, stamps)
+
+	for _, key := range []string{name, "variables." + name} {
+		ctx[key] = val
+		sources[key] = source
+	}
 }
 
 // resolveVars processes --variable flags. Each variable
@@ -141,6 +384,7 @@ func (en *Engine) resolveVars(
 	vars []string,
 	stamps map[string]interface{},
 	ctx map[string]interface{},
+	sources map[string]string,
 ) error {
 	const errCtx = "resolving variables"
 
@@ -153,12 +397,9 @@ func (en *Engine) resolveVars(
 			)
 		}
 
-		val := fasttemplate.ExecuteStringStd(
-			parts[1], "{", "}", stamps,
+		en.setVar(
+			parts[0], parts[1], sourceVariable, stamps, ctx, sources,
 		)
-
-		ctx[parts[0]] = val
-		ctx["variables."+parts[0]] = val
 	}
 
 	return nil
@@ -167,20 +408,23 @@ func (en *Engine) resolveVars(
 // resolveImports processes --imports flags. Each import
 // file is read, expanded against ctx with the configured
 // tags, then expanded against stamps with single-brace
-// tags, and stored as "imports.NAME".
+// tags, and stored as "imports.NAME". It returns the
+// unresolved placeholder errors of the imports apart, so
+// that those of the template are reported with them.
 func (en *Engine) resolveImports(
 	imports []string,
 	stamps map[string]interface{},
 	ctx map[string]interface{},
-) error {
+	sources map[string]string,
+) ([]error, error) {
 	const errCtx = "resolving imports"
 
-	startTag, endTag := en.tags()
+	var unresolved []error
 
 	for _, im := range imports {
 		parts := strings.SplitN(im, "=", 2)
 		if len(parts) != 2 {
-			return fmt.Errorf(
+			return nil, fmt.Errorf(
 				"%s: import must be NAME=filename, got %s",
 				errCtx, im,
 			)
@@ -188,7 +432,7 @@ func (en *Engine) resolveImports(
 
 		content, err := os.ReadFile(parts[1]) //nolint:gosec // paths from CLI flags
 		if err != nil {
-			return fmt.Errorf(
+			return nil, fmt.Errorf(
 				"%s: reading %s: %w",
 				errCtx, parts[1], err,
 			)
@@ -196,18 +440,29 @@ func (en *Engine) resolveImports(
 
 		// First pass: expand against context with
 		// configured tags.
-		val := fasttemplate.ExecuteStringStd(
-			string(content), startTag, endTag, ctx,
-		)
+		val, err := en.expand(parts[1], string(content), ctx, false)
+		switch {
+		case isUnresolved(err):
+			unresolved = append(unresolved, err)
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", errCtx, err)
+		}
 
 		// Second pass: expand against stamps with
 		// single-brace tags.
-		ctx["imports."+parts[0]] = fasttemplate.ExecuteStringStd(
-			val, "{", "}", stamps,
-		)
+		ctx["imports."+parts[0]] = en.expandStamps(val, stamps)
+		sources["imports."+parts[0]] = parts[1]
 	}
 
-	return nil
+	return unresolved, nil
+}
+
+// isUnresolved reports whether err lists unresolved
+// placeholders, which are gathered over all files.
+func isUnresolved(err error) bool {
+	var ue *stamp.UnresolvedError
+
+	return errors.As(err, &ue)
 }
 
 // readTemplate reads the template from a file path. If
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/byte4ever/rules_gitops/stamp"
 	"github.com/byte4ever/rules_gitops/templating"
 )
 
@@ -424,6 +425,100 @@ func TestExpand_missing_stamp_file(t *testing.T) {
 	assert.Contains(t, err.Error(), "expanding template")
 }
 
+func TestExpand_stamp_mode(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	sf1 := writeTemp(t, dir, "s1.txt", "K1 v1\r\nBADLINE\r\n")
+	sf2 := writeTemp(t, dir, "s2.txt", `{"K2": "v2"}`)
+	tplPath := writeTemp(t, dir, "tpl.txt", "{{K1}}-{{K2}}")
+	outPath := filepath.Join(dir, "out.txt")
+
+	en := templating.Engine{StampInfoFiles: []string{sf1, sf2}}
+
+	require.NoError(t, en.Expand(tplPath, outPath, nil, nil, false))
+
+	got, err := os.ReadFile(outPath) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Equal(t, "v1-v2", string(got))
+
+	en.StampMode = stamp.Strict
+
+	err = en.Expand(tplPath, outPath, nil, nil, false)
+	require.ErrorContains(t, err, "s1.txt:2")
+}
+
+func TestExpand_unresolved_strict(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	imp := writeTemp(t, dir, "imp.txt", "x\n  {{TYPO}}")
+	tplPath := writeTemp(
+		t, dir, "tpl.txt", "{{APP}}\n{{AP}} {{imports.cfg}}",
+	)
+	outPath := filepath.Join(dir, "out.txt")
+
+	en := templating.Engine{Unresolved: stamp.UnresolvedStrict}
+
+	err := en.Expand(
+		tplPath, outPath, []string{"APP=a"},
+		[]string{"cfg=" + imp}, false,
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), imp+":2:3: TYPO")
+	assert.Contains(t, err.Error(), tplPath+":2:1: AP")
+	assert.NoFileExists(t, outPath)
+
+	en.Unresolved = stamp.UnresolvedWarn
+
+	require.NoError(t, en.Expand(
+		tplPath, outPath, []string{"APP=a"},
+		[]string{"cfg=" + imp}, false,
+	))
+
+	got, err := os.ReadFile(outPath) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Equal(t, "a\n{{AP}} x\n  {{TYPO}}", string(got))
+}
+
+func TestExpand_escape_and_known_only(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	imp := writeTemp(t, dir, "rule.yaml", `expr: \{{ $value }} > {{LIMIT}}`)
+	tplPath := writeTemp(
+		t, dir, "tpl.yaml",
+		"{{imports.rule}}\nname: {{ .Release.Name }}-{{APP}}",
+	)
+	outPath := filepath.Join(dir, "out.yaml")
+
+	en := templating.Engine{
+		Escape:     true,
+		KnownOnly:  true,
+		Unresolved: stamp.UnresolvedStrict,
+	}
+
+	require.NoError(t, en.Expand(
+		tplPath, outPath, []string{"APP=web", "LIMIT=5"},
+		[]string{"rule=" + imp}, false,
+	))
+
+	got, err := os.ReadFile(outPath) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Equal(t,
+		"expr: {{ $value }} > 5\nname: {{ .Release.Name }}-web",
+		string(got),
+	)
+
+	en.Syntax = templating.SyntaxRich
+
+	err = en.Expand(tplPath, outPath, nil, nil, false)
+	require.ErrorContains(t, err, "require the flat syntax")
+}
+
 func FuzzExpand(f *testing.F) {
 	f.Add("Hello {{name}}!", "name", "World")
 	f.Add("{{a}}{{b}}", "a", "x")
@@ -0,0 +1,230 @@
+package templating
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// richWords are the keywords and functions of rich
+// templates, which bare names never shadow.
+//
+//nolint:gochecknoglobals // read-only word set
+var richWords = wordSet(
+	// Keywords.
+	"if else end range with define template block " +
+		"break continue nil true false " +
+		// Builtin functions.
+		"and call html index slice js len not or print " +
+		"printf println urlquery eq ge gt le lt ne " +
+		// Functions of richFuncs.
+		"default quote b64enc indent toYaml",
+)
+
+// wordSet returns the set of the space-separated
+// words of s.
+func wordSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+
+	return set
+}
+
+// shift is a change of length of a rewritten source:
+// from offset at of the new text on, offsets exceed
+// those of the original by delta.
+type shift struct {
+	at    int
+	delta int
+}
+
+// source is a rich template rewritten for
+// text/template, with the offsets of its original.
+type source struct {
+	text   string
+	shifts []shift
+}
+
+// orig returns the offset in the original of offset p
+// of the rewritten text.
+func (s source) orig(p int) int {
+	i := sort.Search(len(s.shifts), func(i int) bool {
+		return s.shifts[i].at > p
+	})
+	if i == 0 {
+		return p
+	}
+
+	return p - s.shifts[i-1].delta
+}
+
+// rewriter builds a source.
+type rewriter struct {
+	b        strings.Builder
+	shifts   []shift
+	consumed int
+}
+
+// copy writes s unchanged.
+func (w *rewriter) copy(s string) {
+	w.b.WriteString(s)
+	w.consumed += len(s)
+}
+
+// replace writes s in place of n bytes of the
+// original.
+func (w *rewriter) replace(n int, s string) {
+	w.b.WriteString(s)
+	w.consumed += n
+
+	if delta := w.b.Len() - w.consumed; len(w.shifts) == 0 ||
+		w.shifts[len(w.shifts)-1].delta != delta {
+		w.shifts = append(w.shifts, shift{at: w.b.Len(), delta: delta})
+	}
+}
+
+// rewriteRich rewrites tpl for text/template. Bare
+// names in actions, such as APP, variables.APP or
+// my-var, become variables of the root: $.APP or
+// (index $ "my-var") when they are no identifiers;
+// keywords, functions and names after a pipe are
+// kept. With escape, a start tag after an odd number of
+// backslashes becomes an action printing it, and the
+// backslashes before start tags are halved, as
+// stamp.Expander does.
+func rewriteRich(tpl, startTag, endTag string, escape bool) source {
+	var w rewriter
+
+	literal := startTag + strconv.Quote(startTag) + endTag
+
+	for {
+		i := strings.Index(tpl, startTag)
+		if i < 0 {
+			w.copy(tpl)
+
+			return source{text: w.b.String(), shifts: w.shifts}
+		}
+
+		text := tpl[:i]
+
+		if escape {
+			n := len(text) - len(strings.TrimRight(text, `\`))
+			w.copy(text[:len(text)-n])
+
+			if n%2 == 1 {
+				w.replace(n+len(startTag), strings.Repeat(`\`, n/2)+literal)
+				tpl = tpl[i+len(startTag):]
+
+				continue
+			}
+
+			w.replace(n, strings.Repeat(`\`, n/2))
+		} else {
+			w.copy(text)
+		}
+
+		w.copy(startTag)
+		tpl = tpl[i+len(startTag):]
+		tpl = w.action(tpl, endTag)
+	}
+}
+
+// action rewrites the bare names of the action at the
+// start of tpl up to its end tag, and returns the rest
+// of tpl.
+func (w *rewriter) action(tpl, endTag string) string {
+	for k := 0; k < len(tpl); {
+		c := tpl[k]
+
+		switch {
+		case strings.HasPrefix(tpl[k:], endTag):
+			w.copy(tpl[:k+len(endTag)])
+
+			return tpl[k+len(endTag):]
+		case c == '"' || c == '`' || c == '\'':
+			k = skipQuoted(tpl, k)
+		case strings.HasPrefix(tpl[k:], "/*"):
+			end := strings.Index(tpl[k+2:], "*/")
+			if end < 0 {
+				k = len(tpl)
+			} else {
+				k += end + 4
+			}
+		case c == '.' || c == '$' || isDigit(c):
+			// Fields, variables and numbers.
+			k++
+			for k < len(tpl) && (isWordByte(tpl[k]) || tpl[k] == '.') {
+				k++
+			}
+		case isLetter(c):
+			end := k + 1
+			for end < len(tpl) && (isWordByte(tpl[end]) ||
+				(tpl[end] == '-' || tpl[end] == '.') &&
+					end+1 < len(tpl) && isWordByte(tpl[end+1])) {
+				end++
+			}
+
+			name := tpl[k:end]
+			piped := strings.HasSuffix(strings.TrimRight(tpl[:k], " \t\r\n"), "|")
+
+			// Names after a pipe are functions.
+			if !richWords[name] && !piped {
+				w.copy(tpl[:k])
+				w.replace(len(name), rootVariable(name))
+				tpl = tpl[end:]
+				k = 0
+
+				continue
+			}
+
+			k = end
+		default:
+			k++
+		}
+	}
+
+	w.copy(tpl)
+
+	return ""
+}
+
+// rootVariable returns the expression of the variable
+// name of the root.
+func rootVariable(name string) string {
+	if strings.Contains(name, "-") {
+		return `(index $ ` + strconv.Quote(name) + `)`
+	}
+
+	return "$." + name
+}
+
+// skipQuoted returns the offset after the quoted
+// string or character starting at offset k of s.
+func skipQuoted(s string, k int) int {
+	q := s[k]
+
+	for k++; k < len(s); k++ {
+		switch {
+		case s[k] == '\\' && q != '`':
+			k++
+		case s[k] == q:
+			return k + 1
+		}
+	}
+
+	return k
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return isLetter(c) || isDigit(c)
+}
@@ -0,0 +1,524 @@
+package templating
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/goccy/go-yaml"
+
+	"github.com/byte4ever/rules_gitops/stamp"
+)
+
+// Syntax selects the template language of an Engine.
+type Syntax string
+
+const (
+	// SyntaxFlat substitutes {{NAME}} placeholders with
+	// fasttemplate and nothing else.
+	SyntaxFlat Syntax = "flat"
+
+	// SyntaxRich parses templates with text/template,
+	// adding default values, a few functions,
+	// conditionals and iteration over list variables.
+	SyntaxRich Syntax = "rich"
+)
+
+// ParseSyntax returns the syntax named name. The empty
+// string means SyntaxFlat.
+func ParseSyntax(name string) (Syntax, error) {
+	switch s := Syntax(name); s {
+	case "":
+		return SyntaxFlat, nil
+	case SyntaxFlat, SyntaxRich:
+		return s, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown template syntax %q (expected %s or %s)",
+			name, SyntaxFlat, SyntaxRich,
+		)
+	}
+}
+
+// richFuncs are the functions of rich templates on
+// top of the text/template builtins. The value piped
+// into a function is its last argument.
+//
+//nolint:gochecknoglobals // read-only function table
+var richFuncs = template.FuncMap{
+	"default": fnDefault,
+	"quote":   func(v any) string { return strconv.Quote(toString(v)) },
+	"b64enc": func(v any) string {
+		return base64.StdEncoding.EncodeToString([]byte(toString(v)))
+	},
+	"indent": fnIndent,
+	"toYaml": toYAML,
+}
+
+// Values of list and map variables, which print as
+// YAML flow collections.
+type (
+	list    []any
+	mapping map[string]any
+)
+
+// String implements fmt.Stringer.
+func (l list) String() string { return flowYAML(l) }
+
+// String implements fmt.Stringer.
+func (m mapping) String() string { return flowYAML(m) }
+
+// renderRich expands tpl, the content of file, against
+// ctx with text/template, once rewritten by
+// rewriteRich. Actions whose variables have no value,
+// and no default, are left as-is and handled according
+// to mode, as are variables without value of
+// conditions and range. With escape, start tags after
+// a backslash are text.
+func renderRich(
+	file, tpl, startTag, endTag string,
+	ctx map[string]any,
+	mode stamp.UnresolvedMode,
+	escape bool,
+) (string, error) {
+	src := rewriteRich(tpl, startTag, endTag, escape)
+
+	t, err := template.New(file).
+		Delims(startTag, endTag).
+		Option("missingkey=error").
+		Funcs(richFuncs).
+		Parse(src.text)
+	if err != nil {
+		return "", err
+	}
+
+	c := &checker{
+		src:      src,
+		tpl:      tpl,
+		file:     file,
+		startTag: startTag,
+		endTag:   endTag,
+		data:     richData(ctx),
+		mode:     mode,
+		filled:   make(map[string]bool),
+	}
+
+	c.list(t.Root, true)
+
+	var b strings.Builder
+	if err := t.Execute(&b, c.data); err != nil {
+		return "", err
+	}
+
+	if len(c.missing) > 0 && mode == stamp.UnresolvedStrict {
+		return "", &stamp.UnresolvedError{File: file, Placeholders: c.missing}
+	}
+
+	return b.String(), nil
+}
+
+// richData returns the data of rich templates: every
+// variable of ctx by its name, with dotted names also
+// nested, so that "variables.APP" is .variables.APP.
+// Strings holding a YAML list or map, other than
+// imports, are that value.
+func richData(ctx map[string]any) mapping {
+	names := make([]string, 0, len(ctx))
+	for name := range ctx {
+		names = append(names, name)
+	}
+
+	// Parents sort before their dotted children.
+	sort.Strings(names)
+
+	data := make(mapping, len(names))
+
+	for _, name := range names {
+		v := ctx[name]
+		if !strings.HasPrefix(name, "imports.") {
+			v = collection(v)
+		}
+
+		data[name] = v
+
+		keys := strings.Split(name, ".")
+		if len(keys) == 1 {
+			continue
+		}
+
+		m := data
+
+		for _, key := range keys[:len(keys)-1] {
+			if m[key] == nil {
+				m[key] = mapping{}
+			}
+
+			child, ok := m[key].(mapping)
+			if !ok {
+				// A plain variable is named like the
+				// parent; keep it.
+				m = nil
+
+				break
+			}
+
+			m = child
+		}
+
+		if m != nil {
+			m[keys[len(keys)-1]] = v
+		}
+	}
+
+	return data
+}
+
+// collection returns the list or map held by v, a
+// string of YAML, and otherwise v.
+func collection(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "{") {
+		return v
+	}
+
+	var parsed any
+	if err := yaml.Unmarshal([]byte(s), &parsed); err != nil {
+		return v
+	}
+
+	switch parsed.(type) {
+	case []any, map[string]any:
+		return wrap(parsed)
+	default:
+		return v
+	}
+}
+
+// wrap converts the lists and maps of v, parsed YAML,
+// to list and mapping.
+func wrap(v any) any {
+	switch v := v.(type) {
+	case []any:
+		l := make(list, len(v))
+		for i, e := range v {
+			l[i] = wrap(e)
+		}
+
+		return l
+	case map[string]any:
+		m := make(mapping, len(v))
+		for k, e := range v {
+			m[k] = wrap(e)
+		}
+
+		return m
+	default:
+		return v
+	}
+}
+
+// checker finds the variables without value that the
+// root template refers to. It reports them, gives
+// them a nil value so that defaults, conditions and
+// range see them empty, and turns the actions using
+// them without default back into text.
+type checker struct {
+	src      source
+	tpl      string
+	file     string
+	startTag string
+	endTag   string
+	data     mapping
+	mode     stamp.UnresolvedMode
+	missing  []stamp.Placeholder
+
+	// filled are the names given a nil value.
+	filled map[string]bool
+}
+
+// list checks the nodes of l; root is whether dot is
+// the data, as it is outside range and with.
+func (c *checker) list(l *parse.ListNode, root bool) {
+	if l == nil {
+		return
+	}
+
+	for i, n := range l.Nodes {
+		switch n := n.(type) {
+		case *parse.ActionNode:
+			if c.pipe(n, n.Pipe, root) {
+				l.Nodes[i] = &parse.TextNode{
+					NodeType: parse.NodeText,
+					Pos:      n.Pos,
+					Text:     []byte(c.raw(n.Pos)),
+				}
+			}
+		case *parse.IfNode:
+			c.pipe(n, n.Pipe, root)
+			c.list(n.List, root)
+			c.list(n.ElseList, root)
+		case *parse.RangeNode:
+			c.pipe(n, n.Pipe, root)
+			c.list(n.List, false)
+			c.list(n.ElseList, root)
+		case *parse.WithNode:
+			c.pipe(n, n.Pipe, root)
+			c.list(n.List, false)
+			c.list(n.ElseList, root)
+		case *parse.TemplateNode:
+			c.pipe(n, n.Pipe, root)
+		}
+	}
+}
+
+// pipe reports the variables without value of pipe,
+// in the tag of n, that no default replaces, and
+// whether there are any.
+func (c *checker) pipe(n parse.Node, pipe *parse.PipeNode, root bool) bool {
+	found := false
+
+	for _, name := range c.refs(pipe, root, false) {
+		found = true
+
+		c.report(n.Position(), name)
+	}
+
+	return found
+}
+
+// refs returns the names of the variables without
+// value of pipe, but those of commands followed by a
+// default when optional is false.
+func (c *checker) refs(pipe *parse.PipeNode, root, optional bool) []string {
+	if pipe == nil {
+		return nil
+	}
+
+	var names []string
+
+	for i, cmd := range pipe.Cmds {
+		opt := optional || hasDefault(pipe.Cmds[i:])
+
+		if name := c.resolve(indexPath(cmd, root)); name != "" && !opt {
+			names = append(names, name)
+		}
+
+		for _, arg := range cmd.Args {
+			var path []string
+
+			switch arg := arg.(type) {
+			case *parse.FieldNode:
+				if root {
+					path = arg.Ident
+				}
+			case *parse.VariableNode:
+				if arg.Ident[0] == "$" {
+					path = arg.Ident[1:]
+				}
+			case *parse.PipeNode:
+				names = append(names, c.refs(arg, root, opt)...)
+			case *parse.ChainNode:
+				if p, ok := arg.Node.(*parse.PipeNode); ok {
+					names = append(names, c.refs(p, root, opt)...)
+				}
+			}
+
+			if name := c.resolve(path); name != "" && !opt {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// resolve returns the dotted name of path, a field
+// chain from the data, when it has no value, after
+// giving it a nil one, and otherwise "".
+func (c *checker) resolve(path []string) string {
+	name := strings.Join(path, ".")
+	if c.filled[name] {
+		return name
+	}
+
+	m := c.data
+
+	for i, key := range path {
+		v, ok := m[key]
+		if !ok {
+			for _, k := range path[i : len(path)-1] {
+				m[k] = mapping{}
+				m = m[k].(mapping) //nolint:forcetypeassert // just set
+			}
+
+			m[path[len(path)-1]] = nil
+			c.filled[name] = true
+
+			return name
+		}
+
+		// Fields of other values fail when executed.
+		child, ok := v.(mapping)
+		if !ok {
+			return ""
+		}
+
+		m = child
+	}
+
+	return ""
+}
+
+// indexPath returns the variable of cmd when it is
+// index $ "NAME", or index . "NAME" at the root.
+func indexPath(cmd *parse.CommandNode, root bool) []string {
+	if len(cmd.Args) != 3 {
+		return nil
+	}
+
+	if id, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || id.Ident != "index" {
+		return nil
+	}
+
+	switch v := cmd.Args[1].(type) {
+	case *parse.VariableNode:
+		if len(v.Ident) != 1 || v.Ident[0] != "$" {
+			return nil
+		}
+	case *parse.DotNode:
+		if !root {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if s, ok := cmd.Args[2].(*parse.StringNode); ok {
+		return []string{s.Text}
+	}
+
+	return nil
+}
+
+// hasDefault reports whether one of cmds calls
+// default.
+func hasDefault(cmds []*parse.CommandNode) bool {
+	for _, cmd := range cmds {
+		if id, ok := cmd.Args[0].(*parse.IdentifierNode); ok && id.Ident == "default" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tagStart returns the offset in the rewritten
+// template of the start tag of the action, if or range
+// whose pipeline is at pos.
+func (c *checker) tagStart(pos parse.Pos) int {
+	return strings.LastIndex(c.src.text[:pos], c.startTag)
+}
+
+// raw returns the original source of the action whose
+// pipeline is at pos.
+func (c *checker) raw(pos parse.Pos) string {
+	start := c.src.orig(c.tagStart(pos))
+
+	end := strings.Index(c.src.text[pos:], c.endTag)
+	if end < 0 {
+		return c.tpl[start:]
+	}
+
+	return c.tpl[start : c.src.orig(int(pos)+end)+len(c.endTag)]
+}
+
+// report records or logs the variable name without
+// value of the tag whose pipeline is at pos, at its
+// line and column in the original template.
+func (c *checker) report(pos parse.Pos, name string) {
+	start := c.src.orig(c.tagStart(pos))
+	lineStart := strings.LastIndex(c.tpl[:start], "\n") + 1
+
+	p := stamp.Placeholder{
+		Name:   name,
+		Line:   strings.Count(c.tpl[:start], "\n") + 1,
+		Column: start - lineStart + 1,
+	}
+
+	switch c.mode {
+	case stamp.UnresolvedStrict:
+		c.missing = append(c.missing, p)
+	case stamp.UnresolvedWarn:
+		slog.Warn(
+			"unresolved placeholder",
+			"file", c.file,
+			"line", p.Line,
+			"column", p.Column,
+			"name", name,
+		)
+	}
+}
+
+// fnDefault returns v unless it is empty, as for
+// conditions, and otherwise d, a list or map when it
+// holds YAML one as variables do.
+func fnDefault(d, v any) any {
+	if ok, _ := template.IsTrue(v); !ok {
+		return collection(d)
+	}
+
+	return v
+}
+
+// fnIndent prefixes every line of v with n spaces.
+func fnIndent(n int, v any) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("invalid width %d", n)
+	}
+
+	pad := strings.Repeat(" ", n)
+
+	return pad + strings.ReplaceAll(toString(v), "\n", "\n"+pad), nil
+}
+
+// toYAML renders v as YAML without trailing newline.
+// Strings holding a YAML list or map are rendered as
+// that value.
+func toYAML(v any) (string, error) {
+	out, err := yaml.Marshal(collection(v))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// flowYAML renders v as a YAML flow collection.
+func flowYAML(v any) string {
+	out, err := yaml.MarshalWithOptions(v, yaml.Flow(true))
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// toString formats v for output, as text/template
+// does but for missing values.
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,267 @@
+package templating_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/stamp"
+	"github.com/byte4ever/rules_gitops/templating"
+)
+
+// expandRich expands tpl in rich syntax with vars.
+func expandRich(
+	t *testing.T,
+	en templating.Engine,
+	tpl string,
+	vars ...string,
+) (string, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	tplPath := writeTemp(t, dir, "tpl.yaml", tpl)
+	outPath := filepath.Join(dir, "out.yaml")
+
+	en.Syntax = templating.SyntaxRich
+
+	if err := en.Expand(tplPath, outPath, vars, nil, false); err != nil {
+		return "", err
+	}
+
+	got, err := os.ReadFile(outPath) //nolint:gosec // test file
+	require.NoError(t, err)
+
+	return string(got), nil
+}
+
+func TestExpand_rich(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		tpl  string
+		vars []string
+		want string
+	}{
+		{
+			name: "variables",
+			tpl:  `name: {{.APP}}-{{.variables.ENV}}-{{index . "variables.ENV"}}`,
+			vars: []string{"APP=web", "ENV=prod"},
+			want: "name: web-prod-prod",
+		},
+		{
+			name: "default",
+			tpl:  `r: {{.REPLICAS | default "2"}} e: {{.EMPTY | default "x"}} a: {{default "y" .APP}}`,
+			vars: []string{"EMPTY=", "APP=web"},
+			want: "r: 2 e: x a: web",
+		},
+		{
+			name: "functions",
+			tpl:  `{{.APP | quote}} {{.APP | b64enc}} {{"a\nb" | indent 2}}`,
+			vars: []string{"APP=web"},
+			want: "\"web\" d2Vi   a\n  b",
+		},
+		{
+			name: "if else",
+			tpl: "{{if eq .ENV \"prod\"}}big{{else if .DEBUG}}debug" +
+				"{{else}}small{{end}} {{if not .FLAG}}off{{end}}",
+			vars: []string{"ENV=dev", "DEBUG=true", "FLAG="},
+			want: "debug off",
+		},
+		{
+			name: "range with trim markers",
+			tpl: "regions:\n{{- range .REGIONS }}\n- {{ . | quote }}\n" +
+				"{{- else }}\n- none\n{{- end }}\n",
+			vars: []string{"REGIONS=[us-east-1, eu-west-1]"},
+			want: "regions:\n- \"us-east-1\"\n- \"eu-west-1\"\n",
+		},
+		{
+			name: "range over empty list",
+			tpl:  "{{range .REGIONS}}{{.}}{{else}}none{{end}}",
+			vars: []string{"REGIONS=[]"},
+			want: "none",
+		},
+		{
+			name: "range over maps",
+			tpl:  "{{range .PORTS}}{{.name}}={{.port}};{{end}} {{.PORTS}}",
+			vars: []string{"PORTS=[{name: http, port: 80}, {name: grpc, port: 9090}]"},
+			want: "http=80;grpc=9090; [{name: http, port: 80}, {name: grpc, port: 9090}]",
+		},
+		{
+			name: "toYaml",
+			tpl:  "labels:\n{{.LABELS | toYaml | indent 2}}\nteam: {{.LABELS.team}}",
+			vars: []string{"LABELS={team: web, tier: front}"},
+			want: "labels:\n  team: web\n  tier: front\nteam: web",
+		},
+		{
+			name: "unresolved kept",
+			tpl:  "{{.MISSING}} {{ .MISSING | quote }} {{.variables.NONE}}",
+			want: "{{.MISSING}} {{ .MISSING | quote }} {{.variables.NONE}}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := expandRich(t, templating.Engine{}, tt.tpl, tt.vars...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpand_rich_bareNames(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		tpl  string
+		vars []string
+		want string
+	}{
+		{
+			name: "variables",
+			tpl:  "name: {{APP}}-{{variables.ENV}}-{{my-var}}",
+			vars: []string{"APP=web", "ENV=prod", "my-var=x"},
+			want: "name: web-prod-x",
+		},
+		{
+			name: "default",
+			tpl:  `{{NAME | default "x"}} {{my-var | default "y"}} {{APP | default "z"}}`,
+			vars: []string{"APP=web"},
+			want: "x y web",
+		},
+		{
+			name: "if and range",
+			tpl: `{{if eq ENV "prod"}}big{{else}}small{{end}} ` +
+				`{{range REGIONS}}{{.}}-{{APP}}-{{my-var}};{{end}}`,
+			vars: []string{"ENV=prod", "APP=web", "my-var=x", "REGIONS=[a, b]"},
+			want: "big a-web-x;b-web-x;",
+		},
+		{
+			name: "strings untouched",
+			tpl:  `{{"APP" | quote}} {{/* APP */}}{{printf "%s-%s" APP "B"}}`,
+			vars: []string{"APP=web"},
+			want: `"APP" web-B`,
+		},
+		{
+			name: "unresolved kept",
+			tpl:  "{{MISSING}} {{ my-missing | quote }} {{variables.NONE}}",
+			want: "{{MISSING}} {{ my-missing | quote }} {{variables.NONE}}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := expandRich(t, templating.Engine{}, tt.tpl, tt.vars...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := expandRich(
+		t, templating.Engine{Unresolved: stamp.UnresolvedStrict},
+		"{{my-var}}\n  {{if my-flag}}{{end}}{{TYPO | quote}}",
+		"my-var=x",
+	)
+	require.ErrorContains(t, err, "2 unresolved placeholders")
+	require.ErrorContains(t, err, "tpl.yaml:2:3: my-flag")
+	require.ErrorContains(t, err, "tpl.yaml:2:24: TYPO")
+}
+
+func TestExpand_rich_errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		tpl     string
+		wantErr string
+	}{
+		{"missing end", "a\n  {{if .X}}b", "tpl.yaml:2: unexpected EOF"},
+		{"stray end", "{{end}}", "unexpected {{end}}"},
+		{"unknown function", "{{.X | upper}}", "function \"upper\" not defined"},
+		{"unknown piped function", "{{APP | upper}}", "function \"upper\" not defined"},
+		{"not a list", "{{range .APP}}{{end}}", "range can't iterate over web"},
+		{"missing field", "{{range .PORTS}}{{.nme}}{{end}}", "map has no entry for key \"nme\""},
+		{"unterminated", "{{.X", "unclosed action"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := expandRich(
+				t, templating.Engine{}, tt.tpl,
+				"APP=web", "PORTS=[{name: http}]",
+			)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	_, err := expandRich(
+		t, templating.Engine{Unresolved: stamp.UnresolvedStrict},
+		"{{if .OPTIONAL | default \"\"}}x{{end}}\n"+
+			"{{.A | default \"a\"}} {{.TYPO | quote}}",
+	)
+	require.ErrorContains(t, err, "1 unresolved placeholders")
+	require.ErrorContains(t, err, "tpl.yaml:2:22: TYPO")
+}
+
+func TestExpand_rich_strictConditions(t *testing.T) {
+	t.Parallel()
+
+	strict := templating.Engine{Unresolved: stamp.UnresolvedStrict}
+
+	for tpl, want := range map[string]string{
+		"{{if .ENABLD}}on{{else}}off{{end}}":         "tpl.yaml:1:1: ENABLD",
+		"{{if .X}}x{{else if .ENABLD}}on{{end}}":     "tpl.yaml:1:11: ENABLD",
+		"a\n{{range .LSTT}}{{.}}{{else}}none{{end}}": "tpl.yaml:2:1: LSTT",
+		"{{range .L}}{{$.NAM}}{{end}}":               "tpl.yaml:1:13: NAM",
+	} {
+		_, err := expandRich(t, strict, tpl, "X=", "L=[a]")
+		require.ErrorContains(t, err, want, tpl)
+	}
+
+	// Defaults make variables optional.
+	got, err := expandRich(t, strict,
+		`{{if .ENABLED | default ""}}on{{else}}off{{end}} `+
+			`{{range .LIST | default "[]"}}{{.}}{{else}}none{{end}}`,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "off none", got)
+}
+
+func TestExpand_rich_escape(t *testing.T) {
+	t.Parallel()
+
+	got, err := expandRich(
+		t, templating.Engine{Escape: true},
+		`{{if .APP}}\{{ .Values.{{.APP}} }}{{end}} \\{{.APP}}`,
+		"APP=web",
+	)
+	require.NoError(t, err)
+	assert.Equal(t, `{{ .Values.web }} \web`, got)
+
+	_, err = expandRich(
+		t, templating.Engine{Escape: true, Unresolved: stamp.UnresolvedStrict},
+		`\{{x}} {{MISSING}}`,
+	)
+	require.ErrorContains(t, err, "tpl.yaml:1:8: MISSING")
+}
+
+func TestParseSyntax(t *testing.T) {
+	t.Parallel()
+
+	s, err := templating.ParseSyntax("")
+	require.NoError(t, err)
+	assert.Equal(t, templating.SyntaxFlat, s)
+
+	_, err = templating.ParseSyntax("jinja")
+	require.Error(t, err)
+}
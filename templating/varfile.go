@@ -0,0 +1,179 @@
+package templating
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// loadVariableFile reads the variables of a YAML,
+// JSON or .env file, chosen by its extension, sorted
+// by name.
+func loadVariableFile(path string) ([][2]string, error) {
+	const errCtx = "loading variable file"
+
+	data, err := os.ReadFile(path) //nolint:gosec // paths from CLI flags
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errCtx, err)
+	}
+
+	var vars [][2]string
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml", ".json":
+		vars, err = parseStructured(data)
+	case ".env":
+		vars, err = parseDotEnv(data)
+	default:
+		err = fmt.Errorf(
+			"unknown format %q (expected .yaml, .yml, .json or .env)",
+			ext,
+		)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", errCtx, path, err)
+	}
+
+	return vars, nil
+}
+
+// parseStructured flattens a YAML or JSON object:
+// nested keys are joined with dots, and maps and lists
+// are also kept whole as YAML flow collections. A
+// dotted key naming the same variable as a nested one,
+// e.g. "a.b" and a: {b}, is an error.
+func parseStructured(data []byte) ([][2]string, error) {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc == nil {
+		return nil, nil
+	}
+
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %T", doc)
+	}
+
+	vars := make(map[string]string)
+	if err := flatten("", m, vars); err != nil {
+		return nil, err
+	}
+
+	return sortedVars(vars), nil
+}
+
+// flatten adds the values of m to vars, their names
+// prefixed by prefix, and fails when a name is already
+// set.
+func flatten(prefix string, m map[string]any, vars map[string]string) error {
+	for k, v := range m {
+		name := prefix + k
+
+		var value string
+
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flatten(name+".", v, vars); err != nil {
+				return err
+			}
+
+			flow, err := yaml.MarshalWithOptions(v, yaml.Flow(true))
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			value = strings.TrimSpace(string(flow))
+		case []any:
+			flow, err := yaml.MarshalWithOptions(v, yaml.Flow(true))
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			value = strings.TrimSpace(string(flow))
+		case nil:
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			value = fmt.Sprint(v)
+		}
+
+		if _, ok := vars[name]; ok {
+			return fmt.Errorf(
+				"%s: defined both by a dotted key and by nested keys",
+				name,
+			)
+		}
+
+		vars[name] = value
+	}
+
+	return nil
+}
+
+// parseDotEnv parses KEY=VALUE lines. Blank lines and
+// lines starting with # are skipped, an "export "
+// prefix is ignored, double-quoted values are unquoted
+// with Go escapes and single-quoted values are taken
+// literally.
+func parseDotEnv(data []byte) ([][2]string, error) {
+	vars := make(map[string]string)
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+
+		if !ok || key == "" {
+			return nil, fmt.Errorf(
+				"line %d: expected KEY=VALUE, got %q", n+1, line,
+			)
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", n+1, key, err)
+			}
+
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+
+		vars[key] = value
+	}
+
+	return sortedVars(vars), nil
+}
+
+// sortedVars returns vars as pairs sorted by name.
+func sortedVars(vars map[string]string) [][2]string {
+	pairs := make([][2]string, 0, len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, [2]string{k, v})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+	return pairs
+}
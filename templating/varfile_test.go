@@ -0,0 +1,120 @@
+package templating_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/templating"
+)
+
+func TestExpand_variable_files(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	sf := writeTemp(t, dir, "status.txt", "ENV stamp\nUSER alice\n")
+	yml := writeTemp(t, dir, "vars.yaml", ""+
+		"ENV: yaml\n"+
+		"app:\n"+
+		"  name: web-{USER}\n"+
+		"  replicas: 3\n"+
+		"  regions: [eu, us]\n")
+	jsn := writeTemp(t, dir, "vars.json", `{"app": {"replicas": 5}, "debug": true}`)
+	env := writeTemp(t, dir, "vars.env", ""+
+		"# comment\n"+
+		"export TOKEN=\"a\\nb\"\n"+
+		"RAW='x\\ny'\n")
+	tplPath := writeTemp(
+		t, dir, "tpl.txt",
+		"{{ENV}} {{variables.app.name}} {{app.replicas}} "+
+			"{{app.regions}} {{debug}} {{TOKEN}} {{RAW}} {{CLI}}",
+	)
+	outPath := filepath.Join(dir, "out.txt")
+
+	en := templating.Engine{
+		StampInfoFiles: []string{sf},
+		VariableFiles:  []string{yml, jsn, env},
+	}
+
+	require.NoError(t, en.Expand(
+		tplPath, outPath, []string{"CLI=1", "ENV=cli"}, nil, false,
+	))
+
+	got, err := os.ReadFile(outPath) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Equal(t,
+		"cli web-alice 5 [eu, us] true a\nb x\\ny 1",
+		string(got),
+	)
+}
+
+func TestExpand_variable_files_errors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tplPath := writeTemp(t, dir, "tpl.txt", "x")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"unknown format", "vars.toml", "a = 1", `unknown format ".toml"`},
+		{"not an object", "vars.yaml", "- a\n- b\n", "expected an object"},
+		{"bad env line", "vars.env", "A=1\nB\n", "line 2: expected KEY=VALUE"},
+		{
+			"dotted and nested key", "vars.yaml", "a.b: 1\na:\n  b: 2\n",
+			"a.b: defined both by a dotted key and by nested keys",
+		},
+		{
+			"dotted key and nested map", "vars.json", `{"a.b": 1, "a": {"b": {"c": 2}}}`,
+			"a.b: defined both by a dotted key and by nested keys",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			en := templating.Engine{
+				VariableFiles: []string{
+					writeTemp(t, t.TempDir(), tt.file, tt.content),
+				},
+			}
+
+			err := en.Expand(tplPath, "", nil, nil, false)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestEngine_Context(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	sf := writeTemp(t, dir, "status.txt", "A stamp\nB stamp\n")
+	vf := writeTemp(t, dir, "vars.env", "B=file\nC=file\n")
+	imp := writeTemp(t, dir, "imp.txt", "{{C}}")
+
+	en := templating.Engine{
+		StampInfoFiles: []string{sf},
+		VariableFiles:  []string{vf},
+	}
+
+	got, err := en.Context([]string{"C=cli"}, []string{"i=" + imp})
+	require.NoError(t, err)
+	assert.Equal(t, []templating.Variable{
+		{Name: "A", Value: "stamp", Source: "stamps"},
+		{Name: "B", Value: "file", Source: vf},
+		{Name: "C", Value: "cli", Source: "--variable"},
+		{Name: "imports.i", Value: "cli", Source: imp},
+		{Name: "variables.B", Value: "file", Source: vf},
+		{Name: "variables.C", Value: "cli", Source: "--variable"},
+	}, got)
+}
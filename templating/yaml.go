@@ -0,0 +1,328 @@
+package templating
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+
+	"github.com/byte4ever/rules_gitops/stamp"
+)
+
+// yamlPos is the position of a placeholder in a YAML
+// document.
+type yamlPos int
+
+const (
+	// posValue is a whole value: key: {{X}} or - {{X}}.
+	posValue yamlPos = iota
+
+	// posFragment is a line of its own, e.g. an import
+	// of YAML nodes.
+	posFragment
+
+	// posDouble and posSingle are in a double- or
+	// single-quoted scalar.
+	posDouble
+	posSingle
+
+	// posPlain is in a plain scalar with other text.
+	posPlain
+
+	// posComment is in a comment.
+	posComment
+)
+
+// blockHeader matches the lines starting a block
+// scalar, e.g. "key: |" or "- >-".
+var blockHeader = regexp.MustCompile(
+	`(^|[:-]\s)\s*[|>][-+0-9]*\s*(#.*)?$`,
+)
+
+// Errors of values that YAML cannot hold at their
+// position.
+var (
+	errSingleQuoted = errors.New(
+		"multi-line value in a single-quoted scalar; " +
+			"use double quotes or a whole value",
+	)
+	errPlain = errors.New(
+		"value cannot be part of a plain scalar; " +
+			"quote the scalar",
+	)
+)
+
+// yamlInjector formats the values of the placeholders
+// of a YAML template for their position.
+type yamlInjector struct {
+	lines   []string
+	inBlock []bool
+	tagLen  int
+	typed   map[string]bool
+}
+
+// newYAMLInjector analyses tpl, whose tags are
+// startTag and endTag. The whole values of the
+// placeholders named in typed keep their YAML type.
+func newYAMLInjector(tpl, startTag, endTag string, typed []string) *yamlInjector {
+	lines := strings.Split(tpl, "\n")
+	inBlock := make([]bool, len(lines))
+	parent := -1
+
+	for i, l := range lines {
+		content := strings.TrimLeft(l, " ")
+		indent := len(l) - len(content)
+
+		if parent >= 0 && (content == "" || indent > parent) {
+			inBlock[i] = true
+
+			continue
+		}
+
+		parent = -1
+
+		if blockHeader.MatchString(strings.TrimRight(l, " ")) {
+			parent = indent
+		}
+	}
+
+	y := &yamlInjector{
+		lines:   lines,
+		inBlock: inBlock,
+		tagLen:  len(startTag) + len(endTag),
+		typed:   make(map[string]bool, len(typed)),
+	}
+
+	for _, name := range typed {
+		y.typed[name] = true
+	}
+
+	return y
+}
+
+// format returns value as it must be written in
+// place of p.
+func (y *yamlInjector) format(p stamp.Placeholder, value string) (string, error) {
+	line := y.lines[p.Line-1]
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+
+	if y.inBlock[p.Line-1] {
+		return indentLines(value, indent), nil
+	}
+
+	before := line[:p.Column-1]
+
+	after := ""
+	if end := p.Column - 1 + y.tagLen + len(p.Name); end <= len(line) {
+		after = line[end:]
+	}
+
+	pos, start := yamlPosition(before, after)
+
+	switch pos {
+	case posValue:
+		return yamlScalar(
+			value, valueIndent(before), after != "", y.typed[p.Name],
+		), nil
+	case posFragment:
+		return indentLines(value, indent), nil
+	case posDouble:
+		q := strconv.Quote(value)
+
+		return q[1 : len(q)-1], nil
+	case posSingle:
+		if strings.Contains(value, "\n") {
+			return "", errSingleQuoted
+		}
+
+		return strings.ReplaceAll(value, "'", "''"), nil
+	case posPlain:
+		if !plainPart(value, start) {
+			return "", errPlain
+		}
+
+		return value, nil
+	default:
+		return value, nil
+	}
+}
+
+// yamlPosition returns the position of a placeholder
+// between before and after, the rest of its line, and
+// whether it starts a scalar.
+func yamlPosition(before, after string) (yamlPos, bool) {
+	const (
+		plain = iota
+		double
+		single
+	)
+
+	state, start := plain, true
+
+	for i := 0; i < len(before); i++ {
+		c := before[i]
+
+		// The placeholder follows the last byte.
+		next := byte('{')
+
+		if i+1 < len(before) {
+			next = before[i+1]
+		}
+
+		switch state {
+		case double:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				state, start = plain, false
+			}
+		case single:
+			if c == '\'' && next == '\'' {
+				i++
+			} else if c == '\'' {
+				state, start = plain, false
+			}
+		default:
+			switch {
+			case c == '#' && (i == 0 || before[i-1] == ' '):
+				return posComment, false
+			case c == ' ':
+			case c == '"' && start:
+				state = double
+			case c == '\'' && start:
+				state = single
+			case (c == '-' || c == ':') && next == ' ':
+				start = c == ':' || start
+			case c == '[' || c == '{' || c == ',':
+				start = true
+			default:
+				start = false
+			}
+		}
+	}
+
+	switch {
+	case state == double:
+		return posDouble, false
+	case state == single:
+		return posSingle, false
+	case !start:
+		return posPlain, false
+	}
+
+	rest := strings.TrimSpace(after)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return posPlain, true
+	}
+
+	if strings.TrimSpace(before) == "" {
+		return posFragment, true
+	}
+
+	return posValue, true
+}
+
+// valueIndent returns the indentation of the content
+// of a block scalar that is the value after before: two
+// more than the key, or that of the placeholder in a
+// sequence entry.
+func valueIndent(before string) int {
+	n := len(before) - len(strings.TrimLeft(before, " "))
+	for strings.HasPrefix(before[n:], "- ") {
+		n += 2
+		n += len(before[n:]) - len(strings.TrimLeft(before[n:], " "))
+	}
+
+	if n == len(before) {
+		return n
+	}
+
+	return n + 2
+}
+
+// yamlScalar returns value as a YAML scalar: unchanged
+// when it reads back as the same string, or, when
+// typed is set, as a number, boolean or null, a block
+// scalar indented by indent when it spans lines and
+// nothing follows it, and double-quoted otherwise.
+func yamlScalar(value string, indent int, followed, typed bool) string {
+	if strings.Contains(value, "\n") {
+		if followed || strings.ContainsAny(value, "\r\t") ||
+			strings.HasPrefix(value, " ") {
+			return strconv.Quote(value)
+		}
+
+		body := strings.TrimRight(value, "\n")
+		trailing := len(value) - len(body)
+
+		header := "|"
+
+		switch {
+		case trailing == 0:
+			header = "|-"
+		case trailing > 1:
+			header = "|+"
+		}
+
+		return header + "\n" + strings.Repeat(" ", indent) +
+			indentLines(body, indent) + strings.Repeat("\n", max(trailing-1, 0))
+	}
+
+	if value != "" && strings.TrimSpace(value) == value {
+		var v any
+		if err := yaml.Unmarshal([]byte(value), &v); err == nil {
+			switch v := v.(type) {
+			case string:
+				if v == value {
+					return value
+				}
+			case nil:
+				switch value {
+				case "null", "Null", "NULL", "~":
+					if typed {
+						return value
+					}
+				}
+			case map[string]any, []any:
+			default:
+				if typed {
+					return value
+				}
+			}
+		}
+	}
+
+	return strconv.Quote(value)
+}
+
+// plainPart reports whether value can be written
+// within a plain scalar, at its start when start is
+// set.
+func plainPart(value string, start bool) bool {
+	if strings.ContainsAny(value, "\n\r") ||
+		strings.Contains(value, ": ") ||
+		strings.Contains(value, " #") ||
+		strings.HasSuffix(value, ":") {
+		return false
+	}
+
+	return !start || value == "" ||
+		!strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`", rune(value[0]))
+}
+
+// indentLines prefixes the non-empty lines of value
+// after the first with indent spaces.
+func indentLines(value string, indent int) string {
+	lines := strings.Split(value, "\n")
+	prefix := strings.Repeat(" ", indent)
+
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
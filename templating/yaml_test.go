@@ -0,0 +1,173 @@
+package templating_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/byte4ever/rules_gitops/templating"
+)
+
+func TestExpand_yaml(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		tpl   string
+		vars  []string
+		typed []string
+		want  string
+	}{
+		{
+			name: "strings unchanged",
+			tpl:  "a: {{A}}\nb: {{B}}",
+			vars: []string{"A=web", "B=v1.10"},
+			want: "a: web\nb: v1.10",
+		},
+		{
+			name: "other types quoted",
+			tpl:  "v: {{V}}\nn: {{N}}\nb: {{B}}\nz: {{Z}}",
+			vars: []string{"V=1.10", "N=3", "B=true", "Z=null"},
+			want: "v: \"1.10\"\nn: \"3\"\nb: \"true\"\nz: \"null\"",
+		},
+		{
+			name:  "typed values unquoted",
+			tpl:   "n: {{N}}\nb: {{B}}\nz: {{Z}}\nv: {{V}}\nm: {{M}}",
+			vars:  []string{"N=3", "B=true", "Z=~", "V=1.10", "M={a: 1}"},
+			typed: []string{"N", "B", "Z", "M"},
+			want:  "n: 3\nb: true\nz: ~\nv: \"1.10\"\nm: \"{a: 1}\"",
+		},
+		{
+			name: "quoted when needed",
+			tpl:  "a: {{A}}\nb: {{B}} # note\n- {{C}}\ne: {{E}}",
+			vars: []string{"A=x: y", "B=#1", "C={z}", "E="},
+			want: "a: \"x: y\"\nb: \"#1\" # note\n- \"{z}\"\ne: \"\"",
+		},
+		{
+			name: "escaped in quoted scalars",
+			tpl:  `a: "say {{A}}"` + "\n" + `b: 'it''s {{A}}'`,
+			vars: []string{`A="hi" it's`},
+			want: `a: "say \"hi\" it's"` + "\n" + `b: 'it''s "hi" it''s'`,
+		},
+		{
+			name: "parts of plain scalars",
+			tpl:  "image: repo/{{APP}}:{{TAG}}",
+			vars: []string{"APP=web", "TAG=1.10"},
+			want: "image: repo/web:1.10",
+		},
+		{
+			name: "comments untouched",
+			tpl:  "a: 1 # {{A}}",
+			vars: []string{"A=x: y"},
+			want: "a: 1 # x: y",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			tplPath := writeTemp(t, dir, "tpl.yaml", tt.tpl)
+			outPath := filepath.Join(dir, "out.yaml")
+
+			en := templating.Engine{YAML: true, YAMLTyped: tt.typed}
+			require.NoError(t, en.Expand(tplPath, outPath, tt.vars, nil, false))
+
+			got, err := os.ReadFile(outPath) //nolint:gosec // test file
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestExpand_yaml_imports(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	cfg := writeTemp(t, dir, "cfg.ini", "[server]\nport: 80\n")
+	res := writeTemp(t, dir, "res.yaml", "limits:\n  cpu: 1\n")
+	tplPath := writeTemp(t, dir, "tpl.yaml", ""+
+		"data:\n"+
+		"  app.ini: {{imports.cfg}}\n"+
+		"  script: |\n"+
+		"    echo start\n"+
+		"    {{imports.cfg}}\n"+
+		"items:\n"+
+		"  - {{imports.cfg}}\n"+
+		"resources:\n"+
+		"  {{imports.res}}\n",
+	)
+	outPath := filepath.Join(dir, "out.yaml")
+
+	en := templating.Engine{YAML: true}
+	require.NoError(t, en.Expand(
+		tplPath, outPath, nil,
+		[]string{"cfg=" + cfg, "res=" + res}, false,
+	))
+
+	got, err := os.ReadFile(outPath) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Equal(t, ""+
+		"data:\n"+
+		"  app.ini: |\n"+
+		"    [server]\n"+
+		"    port: 80\n"+
+		"  script: |\n"+
+		"    echo start\n"+
+		"    [server]\n"+
+		"    port: 80\n"+
+		"\n"+
+		"items:\n"+
+		"  - |\n"+
+		"    [server]\n"+
+		"    port: 80\n"+
+		"resources:\n"+
+		"  limits:\n"+
+		"    cpu: 1\n"+
+		"\n",
+		string(got),
+	)
+
+	var doc struct {
+		Data      map[string]string `yaml:"data"`
+		Items     []string          `yaml:"items"`
+		Resources map[string]any    `yaml:"resources"`
+	}
+	require.NoError(t, yaml.Unmarshal(got, &doc))
+	assert.Equal(t, "[server]\nport: 80\n", doc.Data["app.ini"])
+	assert.Equal(t, []string{"[server]\nport: 80\n"}, doc.Items)
+	assert.Contains(t, doc.Resources, "limits")
+}
+
+func TestExpand_yaml_errors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tplPath := writeTemp(
+		t, dir, "tpl.yaml",
+		"a: x-{{A}}\nb: 'x{{B}}'\nc: {{A}}",
+	)
+	outPath := filepath.Join(dir, "out.yaml")
+
+	en := templating.Engine{YAML: true}
+
+	err := en.Expand(
+		tplPath, outPath, []string{"A=k: v", "B=1\n2"}, nil, false,
+	)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), tplPath+":1:6: A: value cannot be part")
+	assert.Contains(t, err.Error(), tplPath+":2:6: B: multi-line value")
+	assert.NotContains(t, err.Error(), ":3:")
+	assert.NoFileExists(t, outPath)
+
+	en.Syntax = templating.SyntaxRich
+
+	err = en.Expand(tplPath, outPath, nil, nil, false)
+	require.ErrorContains(t, err, "require the flat syntax")
+}